@@ -0,0 +1,72 @@
+package medorg
+
+import (
+	"time"
+)
+
+const day = 24 * time.Hour
+
+// AgeBucket holds the totals for files last modified within an age range
+type AgeBucket struct {
+	Name string
+	// MaxAge is the oldest a file can be and be in this bucket
+	// zero means no limit
+	MaxAge time.Duration
+	Bytes  int64
+	// BackedUpBytes is how much of Bytes is on at least one backup volume
+	BackedUpBytes int64
+}
+
+// Coverage is the percentage of the bucket's bytes that are backed up
+func (ab AgeBucket) Coverage() float64 {
+	if ab.Bytes == 0 {
+		return 0
+	}
+	return 100 * float64(ab.BackedUpBytes) / float64(ab.Bytes)
+}
+
+func newAgeBuckets() []AgeBucket {
+	return []AgeBucket{
+		{Name: "<7 days", MaxAge: 7 * day},
+		{Name: "7-30 days", MaxAge: 30 * day},
+		{Name: "30-365 days", MaxAge: 365 * day},
+		{Name: "1-5 years", MaxAge: 5 * 365 * day},
+		{Name: ">5 years"},
+	}
+}
+
+// add the file to the bucket its age puts it in
+func addToAgeBuckets(buckets []AgeBucket, fs FileStruct, now time.Time) {
+	age := now.Sub(time.Unix(fs.Mtime, 0))
+	for i := range buckets {
+		if buckets[i].MaxAge == 0 || age < buckets[i].MaxAge {
+			buckets[i].Bytes += fs.Size
+			if len(fs.BackupDest) > 0 {
+				buckets[i].BackedUpBytes += fs.Size
+			}
+			return
+		}
+	}
+}
+
+// CollectAgeStats totals up the files in the directories by how long ago they were modified
+// Only the .medorg.xml files are read, so run check_calc first for up to date results
+func CollectAgeStats(directories []string, opts MetadataOptions) ([]AgeBucket, error) {
+	return collectAgeStatsAt(directories, opts, time.Now())
+}
+
+func collectAgeStatsAt(directories []string, opts MetadataOptions, now time.Time) ([]AgeBucket, error) {
+	buckets := newAgeBuckets()
+	fc := func(dir string, dm DirectoryMap) error {
+		return dm.rangeMap(func(fn string, fs FileStruct) error {
+			addToAgeBuckets(buckets, fs, now)
+			return nil
+		})
+	}
+	for _, directory := range directories {
+		if err := walkDirectoryMaps(directory, opts, fc); err != nil {
+			return buckets, err
+		}
+	}
+	return buckets, nil
+}
@@ -0,0 +1,40 @@
+package medorg
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCollectAgeStats(t *testing.T) {
+	wkDir, err := os.MkdirTemp("", "ageStats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wkDir)
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	ago := func(d time.Duration) int64 { return now.Add(-d).Unix() }
+
+	dm := NewDirectoryMap()
+	dm.Add(FileStruct{Name: "new", Size: 10, Mtime: ago(day), directory: wkDir})
+	dm.Add(FileStruct{Name: "month", Size: 20, Mtime: ago(10 * day), BackupDest: []string{"vol"}, directory: wkDir})
+	dm.Add(FileStruct{Name: "old", Size: 100, Mtime: ago(10 * 365 * day), BackupDest: []string{"vol"}, directory: wkDir})
+	dm.Add(FileStruct{Name: "older", Size: 300, Mtime: ago(20 * 365 * day), directory: wkDir})
+	if err := dm.Persist(wkDir); err != nil {
+		t.Fatal(err)
+	}
+
+	buckets, err := collectAgeStatsAt([]string{wkDir}, DefaultMetadataOptions(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct{ bytes, backedUp int64 }{{10, 0}, {20, 20}, {0, 0}, {0, 0}, {400, 100}}
+	for i, exp := range expected {
+		if buckets[i].Bytes != exp.bytes || buckets[i].BackedUpBytes != exp.backedUp {
+			t.Error(buckets[i].Name, "expected", exp, "got", buckets[i])
+		}
+	}
+	if buckets[4].Coverage() != 25 {
+		t.Error("Expected 25% coverage, got", buckets[4].Coverage())
+	}
+}
@@ -1,13 +1,18 @@
 package medorg
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
+
+	bytesize "github.com/inhies/go-bytesize"
 )
 
 // ErrMissingEntry You are copying a file that there is no directory entry for. Probably need to rerun a visit on the directory
@@ -27,65 +32,88 @@ var ErrIOError = syscall.Errno(5) // I don't like this, but don't know a better
 // Export of no space left on device from syscall
 var ErrNoSpace = syscall.Errno(28)
 
+// ErrVerifyFailed the copy's checksum does not match the source's
+var ErrVerifyFailed = errors.New("copy does not match the source checksum")
+
+// ErrLowSpace the destination has less free space than the configured threshold
+var ErrLowSpace = errors.New("destination is low on space")
+
 type backupKey struct {
 	size     int64
 	checksum string
 }
-type backupDupeMap struct {
-	sync.Mutex
+
+// DuplicateIndex finds files with the same contents, by size and checksum
+// e.g. populate it from a backup destination to see which source
+// files are already backed up, whatever they are called there
+// The zero value is ready to use
+type DuplicateIndex struct {
+	lock    sync.Mutex
 	dupeMap map[backupKey]Fpath
 }
 
+// backupDupeMap is the old name for DuplicateIndex
+//
+// Deprecated: use DuplicateIndex
+type backupDupeMap = DuplicateIndex
+
+// NewDuplicateIndex returns an empty index
+func NewDuplicateIndex() *DuplicateIndex {
+	return &DuplicateIndex{dupeMap: make(map[backupKey]Fpath)}
+}
+
 // Add an entry to the map
-func (bdm *backupDupeMap) Add(fs FileStruct) {
+func (bdm *DuplicateIndex) Add(fs FileStruct) {
 	key := backupKey{fs.Size, fs.Checksum}
-	bdm.Lock()
+	bdm.lock.Lock()
 	if bdm.dupeMap == nil {
 		bdm.dupeMap = make(map[backupKey]Fpath)
 	}
 	bdm.dupeMap[key] = Fpath(fs.Path())
-	bdm.Unlock()
+	bdm.lock.Unlock()
 }
-func (bdm *backupDupeMap) Len() int {
-	if bdm.dupeMap == nil {
-		return 0
-	}
-	bdm.Lock()
-	defer bdm.Unlock()
+
+// Len is the number of distinct files in the index
+func (bdm *DuplicateIndex) Len() int {
+	bdm.lock.Lock()
+	defer bdm.lock.Unlock()
 	return len(bdm.dupeMap)
 }
 
-// Remove an entry from the dumap
-func (bdm *backupDupeMap) Remove(key backupKey) {
-	if bdm.dupeMap == nil {
-		return
-	}
-	bdm.Lock()
+// Remove an entry from the index
+func (bdm *DuplicateIndex) Remove(checksum string, size int64) {
+	bdm.remove(backupKey{size, checksum})
+}
+
+func (bdm *DuplicateIndex) remove(key backupKey) {
+	bdm.lock.Lock()
 	delete(bdm.dupeMap, key)
-	bdm.Unlock()
+	bdm.lock.Unlock()
 }
 
-// Get an item from the map
-func (bdm *backupDupeMap) Get(key backupKey) (Fpath, bool) {
-	if bdm.dupeMap == nil {
-		return "", false
-	}
-	bdm.Lock()
-	defer bdm.Unlock()
+// Lookup finds a file with this checksum and size
+func (bdm *DuplicateIndex) Lookup(checksum string, size int64) (Fpath, bool) {
+	return bdm.get(backupKey{size, checksum})
+}
+
+// get an item from the map
+func (bdm *DuplicateIndex) get(key backupKey) (Fpath, bool) {
+	bdm.lock.Lock()
+	defer bdm.lock.Unlock()
 	v, ok := bdm.dupeMap[key]
 	return v, ok
 }
-func (bdm *backupDupeMap) AddVisit(dm DirectoryEntryInterface, dir, fn string, fileStruct FileStruct) error {
+func (bdm *DuplicateIndex) AddVisit(dm DirectoryEntryInterface, dir, fn string, fileStruct FileStruct) error {
 	bdm.Add(fileStruct)
 	return nil
 }
-func (bdm *backupDupeMap) NewSrcVisitor(
+func (bdm *DuplicateIndex) NewSrcVisitor(
 	lookupFunc func(Fpath, bool) error,
-	backupDestination *backupDupeMap, volumeName string) func(dm DirectoryEntryInterface, dir, fn string, fileStruct FileStruct) error {
+	backupDestination *DuplicateIndex, volumeName string) func(dm DirectoryEntryInterface, dir, fn string, fileStruct FileStruct) error {
 
 	return func(dm DirectoryEntryInterface, dir, fn string, fileStruct FileStruct) error {
 		// If it exists in the destination already
-		path, ok := backupDestination.Get(fileStruct.Key())
+		path, ok := backupDestination.get(fileStruct.Key())
 		if lookupFunc != nil {
 			err := lookupFunc(path, ok)
 			if err != nil {
@@ -94,13 +122,17 @@ func (bdm *backupDupeMap) NewSrcVisitor(
 		}
 		if ok {
 			// Then mark in the source as already backed up
-			_ = fileStruct.AddTag(volumeName)
+			if _, err := fileStruct.AddTag(volumeName); err != nil {
+				return err
+			}
 		}
-		if !ok && fileStruct.HasTag(volumeName) {
+		if !ok && fileStruct.HasBackupOn(volumeName) {
 			// FIXME add testcase for this
 			// The case where the file is not present at the dest
 			// but the tag says that it is
-			fileStruct.RemoveTag(volumeName)
+			if _, err := fileStruct.RemoveTag(volumeName); err != nil {
+				return err
+			}
 		}
 
 		bdm.Add(fileStruct)
@@ -111,8 +143,57 @@ func (bdm *backupDupeMap) NewSrcVisitor(
 }
 
 type backScanner struct {
-	dupeFunc   func(path string) error
+	// orphanFunc is told of each file on destination dest that is not in the source
+	// An error from it stops the scan.
+	orphanFunc func(dest int, path Fpath) error
 	lookupFunc func(Fpath, bool) error
+	// destIndexes are the loaded indexes of the destinations, in the same order
+	// A destination without one (nil) is walked instead
+	destIndexes []*DuplicateIndex
+	// detectMoves renames files on the destinations the source has moved
+	detectMoves bool
+	// walkOpts are how the source and destinations are walked
+	walkOpts DirTrackerOptions
+}
+
+func (bs backScanner) destIndex(i int) *DuplicateIndex {
+	if i < len(bs.destIndexes) {
+		return bs.destIndexes[i]
+	}
+	return nil
+}
+
+// loadDestinationIndexes reads the index of each destination, unless we've been asked to rebuild them
+func loadDestinationIndexes(opts BackupOptions, destDirs []string, logFunc func(msg string)) []*DuplicateIndex {
+	indexes := make([]*DuplicateIndex, len(destDirs))
+	if opts.Reindex {
+		return indexes
+	}
+	for i, destDir := range destDirs {
+		di, err := LoadDestinationIndex(destDir)
+		switch {
+		case err == nil:
+			logFunc(fmt.Sprint("Using the index of ", destDir, " rather than walking it"))
+			indexes[i] = di
+		case !errors.Is(err, os.ErrNotExist):
+			logFunc(fmt.Sprint("Unable to read the index of ", destDir, ", walking it instead:", err))
+		}
+	}
+	return indexes
+}
+
+// rebuildDestinationIndexes writes a fresh index for each destination, if asked to
+func rebuildDestinationIndexes(opts BackupOptions, destDirs []string, metadata MetadataOptions, logFunc func(msg string)) error {
+	if !opts.Reindex {
+		return nil
+	}
+	for _, destDir := range destDirs {
+		logFunc(fmt.Sprint("Rebuilding the index of ", destDir))
+		if err := BuildDestinationIndex(destDir, metadata); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (dm DirectoryMap) updateAndGo(dir, fn string) (fs FileStruct, err error) {
@@ -134,20 +215,50 @@ func (dm DirectoryMap) updateAndGo(dir, fn string) (fs FileStruct, err error) {
 // scanBackupDirectories will mark srcDir's ArchiveAt
 // tag, with any files that are already found in the destination
 func (bs backScanner) scanBackupDirectories(
+	ctx context.Context,
 	destDir, srcDir, volumeName string,
 	registerFunc func(*DirTracker),
 	logFunc func(msg string),
-	shutdownChan chan struct{},
+) ([]*DirTracker, error) {
+	return bs.scanBackupDirectoriesMulti(ctx, []string{destDir}, srcDir, []string{volumeName}, registerFunc, logFunc)
+}
+
+// scanBackupDirectoriesMulti is scanBackupDirectories for several destinations
+// The source is only walked once, no matter how many destinations there are.
+// The returned trackers are in the order of destDirs, followed by srcDir
+// A destination we have an index for is not walked, and has a nil tracker
+func (bs backScanner) scanBackupDirectoriesMulti(
+	ctx context.Context,
+	destDirs []string, srcDir string, volumeNames []string,
+	registerFunc func(*DirTracker),
+	logFunc func(msg string),
 ) ([]*DirTracker, error) {
 	if logFunc == nil {
 		logFunc = func(msg string) {
 			log.Println(msg)
 		}
 	}
-	dta := AutoVisitFilesInDirectories([]string{destDir, srcDir}, nil)
-	for err := range errHandler(dta, registerFunc) {
+	walkDirs := []string{}
+	for i, destDir := range destDirs {
+		if bs.destIndex(i) == nil {
+			walkDirs = append(walkDirs, destDir)
+		}
+	}
+	walkDirs = append(walkDirs, srcDir)
+	walked := autoVisitFilesInDirectories(ctx, walkDirs, bs.walkOpts, nil)
+	for err := range errHandler(walked, registerFunc) {
 		return nil, err
 	}
+	dta := make([]*DirTracker, len(destDirs)+1)
+	j := 0
+	for i := range destDirs {
+		if bs.destIndex(i) == nil {
+			dta[i] = walked[j]
+			j++
+		}
+	}
+	dta[len(destDirs)] = walked[j]
+	srcDt := dta[len(destDirs)]
 
 	// panic("yes we finished scanning")
 	calcCnt := 2
@@ -165,25 +276,56 @@ func (bs backScanner) scanBackupDirectories(
 		return err
 	}
 
-	logFunc("Computing Checksum Phase dest")
-	dta[0].Revisit(destDir, registerFunc, visitFunc, shutdownChan)
+	// Each destination is independent of the others, and of the source
+	// so bring them all up to date, and index the destinations, at once
+	// Only this goroutine calls logFunc, so it need not be safe for concurrent use
+	backupDestinations := make([]*DuplicateIndex, len(destDirs))
+	var wg sync.WaitGroup
+	for i, destDir := range destDirs {
+		backupDestinations[i] = bs.destIndex(i)
+		if backupDestinations[i] != nil {
+			continue
+		}
+		backupDestinations[i] = NewDuplicateIndex()
+		logFunc(fmt.Sprint("Computing Checksum Phase, and indexing, dest ", destDir))
+		wg.Add(1)
+		go func(i int, destDir string) {
+			defer wg.Done()
+			dta[i].Revisit(destDir, registerFunc, visitFunc, ctx.Done())
+			dta[i].Revisit(destDir, registerFunc, backupDestinations[i].AddVisit, ctx.Done())
+		}(i, destDir)
+	}
 	logFunc("Computing Checksum Phase src")
-	dta[1].Revisit(destDir, registerFunc, visitFunc, shutdownChan)
-
-	var backupDestination backupDupeMap
-	var backupSource backupDupeMap
+	srcDt.Revisit(srcDir, registerFunc, visitFunc, ctx.Done())
+	wg.Wait()
 
-	logFunc("Initial scan for anything that needs building")
-	dta[0].Revisit(destDir, registerFunc, backupDestination.AddVisit, shutdownChan)
-	logFunc("Scanning Source for Files already at destination")
-	dta[1].Revisit(srcDir, registerFunc, backupSource.NewSrcVisitor(bs.lookupFunc, &backupDestination, volumeName), shutdownChan)
-	logFunc("Dealing with duplicates")
-	if (bs.dupeFunc != nil) && (backupDestination.Len() > 0) {
-		// There's stuff on the backup that's not in the Source
-		// We'll need to do something about this soon!
-		// log.Println("Unexpected items left in backup destination")
-		for _, v := range backupDestination.dupeMap {
-			bs.dupeFunc(string(v))
+	for i, destDir := range destDirs {
+		var backupSource DuplicateIndex
+		backupDestination := backupDestinations[i]
+		logFunc("Scanning Source for Files already at destination")
+		srcDt.Revisit(srcDir, registerFunc, backupSource.NewSrcVisitor(bs.lookupFunc, backupDestination, volumeNames[i]), ctx.Done())
+		if bs.detectMoves {
+			logFunc("Looking for files moved in the source")
+			moved, err := moveDestinationFiles(ctx, srcDir, destDir, srcDt, backupDestination, registerFunc, bs.walkOpts.Metadata, logFunc)
+			if err != nil {
+				return nil, err
+			}
+			logFunc(fmt.Sprint("Moved ", moved, " files on ", volumeNames[i], " to follow the source"))
+		}
+		if (bs.orphanFunc != nil) && (backupDestination.Len() > 0) {
+			logFunc("Looking for files only on the destination")
+			// There's stuff on the backup that's not in the Source
+			for key, v := range backupDestination.dupeMap {
+				if filepath.Base(string(v)) == volumeLabelFileName {
+					// Our label, not an orphan
+					continue
+				}
+				if _, ok := backupSource.get(key); !ok {
+					if err := bs.orphanFunc(i, v); err != nil {
+						return nil, err
+					}
+				}
+			}
 		}
 	}
 	return dta, nil
@@ -192,35 +334,135 @@ func (bs backScanner) scanBackupDirectories(
 // extractCopyFiles will look for files that are not backed up
 // i.e. walk through src file system looking for files
 // That don't have the volume name as an archived at
-func extractCopyFiles(srcDir string, dt *DirTracker, volumeName string, registerFunc func(*DirTracker), maxNumBackups int, shutdownChan chan struct{}) (fpathListList, error) {
+// Within each group, files are ordered according to the priority weights
+// Also returns how many files are already backed up to volumeName
+func extractCopyFiles(ctx context.Context, srcDir string, dt *DirTracker, volumeName string, registerFunc func(*DirTracker), maxNumBackups int, pw PriorityWeights) (fpathListList, int64, error) {
 	var lk sync.Mutex
-	remainingFiles := fpathListList{}
+	var skipped int64
+	candidates := [][]FileStruct{}
 	visitFunc := func(dm DirectoryEntryInterface, dir, fn string, fileStruct FileStruct) error {
-		if fileStruct.HasTag(volumeName) {
+		if fileStruct.HasBackupOn(volumeName) {
+			atomic.AddInt64(&skipped, 1)
 			return nil
 		}
-		fp := NewFpath(dir, fn)
 		lenArchive := len(fileStruct.BackupDest)
 		if lenArchive > maxNumBackups {
 			return nil
 		}
+		fileStruct.directory = dir
+		fileStruct.Name = fn
 		lk.Lock()
-		remainingFiles.Add(lenArchive, fp)
+		for len(candidates) <= lenArchive {
+			candidates = append(candidates, []FileStruct{})
+		}
+		candidates[lenArchive] = append(candidates[lenArchive], fileStruct)
 		lk.Unlock()
 		return nil
 	}
-	dt.Revisit(srcDir, registerFunc, visitFunc, nil)
-	return remainingFiles, nil
+	dt.Revisit(srcDir, registerFunc, visitFunc, ctx.Done())
+	remainingFiles := fpathListList{}
+	for lenArchive, fss := range candidates {
+		sortByPriority(fss, pw)
+		for _, fs := range fss {
+			remainingFiles.Add(lenArchive, NewFpath(fs.directory, fs.Name))
+		}
+	}
+	return remainingFiles, skipped, ctx.Err()
 }
 
 type FileCopier func(src, dst Fpath) error
 
+// copyRetries is how many times we retry a copy that failed with a network error
+const copyRetries = 2
+
+// persistConcurrency is how many directory maps we write out at once after copying
+const persistConcurrency = 8
+
+// fanOutConcurrency is how many destinations a fan out backup copies to at once
+const fanOutConcurrency = 4
+
+// metadataFlushFiles and metadataFlushInterval are how often, in copies
+// and time, a run of copies writes out the metadata changed so far,
+// so that a run that is killed only loses what it did since
+var (
+	metadataFlushFiles    = 100
+	metadataFlushInterval = 30 * time.Second
+)
+
+// dirtyMaps holds the directory maps changed by a run of copies
+// so they can all be written out together at the end.
+// The lock protects the read-modify-write of the maps
+// as several copies record themselves at once
+type dirtyMaps struct {
+	sync.Mutex
+	mp   map[string]*DirectoryMap
+	opts MetadataOptions
+}
+
+func newDirtyMaps(opts MetadataOptions) *dirtyMaps {
+	return &dirtyMaps{mp: make(map[string]*DirectoryMap), opts: opts}
+}
+
+// get the map for the directory, reading it in if this is the first time
+// Call with the lock held
+func (dms *dirtyMaps) get(dir string) (*DirectoryMap, error) {
+	if dm, ok := dms.mp[dir]; ok {
+		return dm, nil
+	}
+	dm, err := DirectoryMapFromDirWithOptions(dir, "", dms.opts)
+	if err != nil {
+		return nil, err
+	}
+	dms.mp[dir] = &dm
+	return &dm, nil
+}
+
+// persist writes out the maps changed so far
+// Anything that fails is still stale, so is tried again next time
+func (dms *dirtyMaps) persist() error {
+	dms.Lock()
+	defer dms.Unlock()
+	return BatchPersist(dms.mp, persistConcurrency)
+}
+
+// BatchPersist writes out the directory maps, up to maxConcurrent at once
+// Every map is attempted, any errors are returned joined together
+func BatchPersist(dirtyMaps map[string]*DirectoryMap, maxConcurrent int) error {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	tokens := makeTokenChan(maxConcurrent)
+	var wg sync.WaitGroup
+	var lk sync.Mutex
+	var errs []error
+	for dir, dm := range dirtyMaps {
+		<-tokens
+		wg.Add(1)
+		go func(dir string, dm *DirectoryMap) {
+			defer func() {
+				tokens <- struct{}{}
+				wg.Done()
+			}()
+			if err := dm.Persist(dir); err != nil {
+				lk.Lock()
+				errs = append(errs, err)
+				lk.Unlock()
+			}
+		}(dir, dm)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
 func doACopy(
 	srcDir, // The source of the backup as specified on the command line
 	destDir, // The destination directory as specified...
 	backupLabelName string, // the tag we should add to the sorce
 	file Fpath, // The full path of the file
-	fc FileCopier) error {
+	fc FileCopier,
+	dms *dirtyMaps, // Where to record the changes to the metadata
+	verify bool, // Check the copy's checksum before tagging the source
+) error {
 	if fc == nil {
 		fc = CopyFile
 	}
@@ -234,21 +476,42 @@ func doACopy(
 	}
 
 	// Actually copy the file
-	err = fc(file, NewFpath(destDir, rel))
+	dstFile := NewFpath(destDir, rel)
+	err = fc(file, dstFile)
+	// Network problems are usually transient, so have another go
+	for retries := 0; retries < copyRetries && ClassifyIOError(err) == IOErrNetwork; retries++ {
+		err = fc(file, dstFile)
+	}
 	if errors.Is(err, ErrDummyCopy) {
 		return nil
 	}
-	if errors.Is(err, ErrNoSpace) {
-		_ = rmFilename(NewFpath(destDir, rel))
+	switch ClassifyIOError(err) {
+	case IOErrDiskFull:
+		_ = SafeRmFilename(dstFile, []string{destDir})
 		return ErrNoSpace
+	case IOErrBadSector, IOErrNetwork:
+		// Don't leave a partial file at the destination
+		_ = SafeRmFilename(dstFile, []string{destDir})
 	}
 	// Update the srcDir .md5 file with the fact we've backed this up now
 	basename := filepath.Base(string(file))
 	sd := filepath.Dir(string(file))
 	if err != nil {
-		return err
+		return fmt.Errorf("%w::%s", err, file)
+	}
+	var copyChecksum string
+	if verify {
+		// Calculated before taking the lock, so other copies can carry on
+		dir, fn := filepath.Split(string(dstFile))
+		copyChecksum, err = CalcMd5File(dir, fn)
+		if err != nil {
+			return fmt.Errorf("%w::%s", err, dstFile)
+		}
 	}
-	dmSrc, err := DirectoryMapFromDir(sd)
+	// Several copies run at once, don't let them trample each other's xml
+	dms.Lock()
+	defer dms.Unlock()
+	dmSrc, err := dms.get(sd)
 	if err != nil {
 		return err
 	}
@@ -256,37 +519,108 @@ func doACopy(
 	if !ok {
 		return fmt.Errorf("%w: %s, \"%s\" \"%s\"", ErrMissingEntry, file, sd, basename)
 	}
-	_ = src.AddTag(backupLabelName)
+	if verify && copyChecksum != src.Checksum {
+		_ = SafeRmFilename(dstFile, []string{destDir})
+		return fmt.Errorf("%w::%s", ErrVerifyFailed, dstFile)
+	}
+	if _, err := src.AddTag(backupLabelName); err != nil {
+		return err
+	}
+	src.LastBackup = time.Now().Unix()
 	dmSrc.Add(src)
-	dmSrc.Persist(srcDir)
-	_ = src.RemoveTag(backupLabelName)
-	// Update the destDir with the checksum from the srcDir
-	dmDst, err := DirectoryMapFromDir(destDir)
+	// Having just added it, removing it cannot fail
+	_, _ = src.RemoveTag(backupLabelName)
+	// Update the destination's directory with the checksum from the source
+	dd := filepath.Dir(string(dstFile))
+	dmDst, err := dms.get(dd)
 	if err != nil {
 		return err
 	}
-	fs, err := os.Stat(filepath.Join(destDir, rel))
+	fs, err := os.Stat(string(dstFile))
 	if err != nil {
 		return err
 	}
-	src.directory = destDir
+	src.directory = dd
 	src.Mtime = fs.ModTime().Unix()
 	dmDst.Add(src)
-	dmDst.Persist(destDir)
+	// Should this fail, the worst that happens is we copy the file again
+	_ = appendDestinationIndex(destDir, rel, src)
 	return nil
 }
 
+// checksumOf looks up the recorded checksum of a file
+func checksumOf(file Fpath, opts MetadataOptions) string {
+	dm, err := DirectoryMapFromDirWithOptions(filepath.Dir(string(file)), "", opts)
+	if err != nil {
+		return ""
+	}
+	fs, _ := dm.Get(filepath.Base(string(file)))
+	return fs.Checksum
+}
+
+// retryFailedCopies has another go at copies that failed last time
+// Files that have changed since are dropped from the queue, as
+// the normal scan will pick them up
+func retryFailedCopies(
+	srcDir, destDir string,
+	backupLabelName string,
+	fc FileCopier,
+	rq *RetryQueue,
+	verify bool,
+	metadata MetadataOptions,
+	logFunc func(msg string),
+) {
+	dms := newDirtyMaps(metadata)
+	defer func() {
+		if err := dms.persist(); err != nil {
+			logFunc(fmt.Sprint("Unable to record retried copies:", err))
+		}
+	}()
+	for _, re := range rq.Due(destDir) {
+		if checksumOf(re.Path, metadata) != re.Checksum {
+			rq.Remove(re.Path, destDir)
+			continue
+		}
+		err := doACopy(srcDir, destDir, backupLabelName, re.Path, fc, dms, verify)
+		if err == nil {
+			logFunc(fmt.Sprint("Retried copy of ", re.Path, " succeeded"))
+			rq.Remove(re.Path, destDir)
+			continue
+		}
+		if rq.Add(re.Path, destDir, re.Checksum) {
+			logFunc(fmt.Sprint("Giving up on copying ", re.Path, " after ", maxRetryAttempts, " attempts:", err))
+		}
+	}
+}
+
 func doCopies(
+	ctx context.Context,
 	srcDir, destDir string,
 	backupLabelName string,
 	fc FileCopier,
 	copyFilesArray fpathListList, maxNumBackups int,
-	logFunc func(msg string), shutdownChan chan struct{},
-) error {
+	rq *RetryQueue,
+	verify bool,
+	report *BackupReport,
+	dms *dirtyMaps, // Where to record the copies, the caller persists it once we're done
+	logFunc func(msg string),
+) (err error) {
+	// Record what we've copied in batches, rather than rewriting the xml after every file
+	copiesSinceFlush := 0
+	lastFlush := time.Now()
+	flush := func() {
+		if err := dms.persist(); err != nil {
+			logFunc(fmt.Sprint("Unable to record copies so far:", err))
+		}
+		copiesSinceFlush = 0
+		lastFlush = time.Now()
+	}
 	// I don't like this pattern as it's not a clean pipeline - but the alternatives feel worse
 	copyTokens := makeTokenChan(2)
 	copyErrChan := make(chan error)
 	var cwg sync.WaitGroup
+	// Once the destination has filled, we only try the files that fit in what is left
+	var destFull int32
 	go func() {
 		defer func() {
 			cwg.Wait()
@@ -299,8 +633,14 @@ func doCopies(
 				return
 			}
 			for _, file := range copyFiles {
+				if atomic.LoadInt32(&destFull) != 0 && !fitsOnDestination(destDir, file) {
+					if report != nil {
+						atomic.AddInt64(&report.SpaceSkipped, 1)
+					}
+					continue
+				}
 				select {
-				case <-shutdownChan:
+				case <-ctx.Done():
 					logFunc("Seen shutdown request")
 					return
 
@@ -312,7 +652,20 @@ func doCopies(
 
 				cwg.Add(1)
 				go func(file Fpath) {
-					copyErrChan <- doACopy(srcDir, destDir, backupLabelName, file, fc)
+					err := doACopy(srcDir, destDir, backupLabelName, file, fc, dms, verify)
+					if err != nil && report != nil {
+						if ClassifyIOError(err) == IOErrDiskFull {
+							atomic.AddInt64(&report.SpaceSkipped, 1)
+						} else {
+							atomic.AddInt64(&report.Errors, 1)
+						}
+					}
+					if err != nil && rq != nil && ClassifyIOError(err) != IOErrDiskFull {
+						if rq.Add(file, destDir, checksumOf(file, dms.opts)) {
+							logFunc(fmt.Sprint("Giving up on copying ", file, " after ", maxRetryAttempts, " attempts"))
+						}
+					}
+					copyErrChan <- err
 					cwg.Done()
 				}(file)
 			}
@@ -320,74 +673,461 @@ func doCopies(
 	}()
 	defer func() { close(copyTokens) }()
 	for err := range copyErrChan {
-		if errors.Is(err, ErrNoSpace) {
-			// FIXME in the ideal world, we'd look at how much space there is left on the volume
-			// and look for a file with a size smaller than that
-			// and copy that.
-			// For now, that optimization is not too bad.
-			logFunc("Destination full")
-			return nil
+		switch ClassifyIOError(err) {
+		case IOErrDiskFull:
+			// Smaller files may still fit, so carry on with those
+			if atomic.CompareAndSwapInt32(&destFull, 0, 1) {
+				logFunc("Destination full, only copying files that fit in the space left")
+			}
+			err = nil
+		case IOErrBadSector:
+			// One bad file should not stop the rest being backed up
+			logFunc(fmt.Sprint("Bad sector, skipping:", err))
+			err = nil
+		case IOErrPermission:
+			logFunc(fmt.Sprint("Permission denied, please check access:", err))
+		case IOErrNetwork:
+			logFunc(fmt.Sprint("Network error after ", copyRetries, " retries:", err))
+			if rq != nil {
+				// It will be tried again next time, so carry on with the rest
+				err = nil
+			}
+		}
+		if errors.Is(err, ErrVerifyFailed) {
+			// The bad copy has gone, and the retry queue will have another go
+			logFunc(fmt.Sprint("Copy did not match its source:", err))
+			err = nil
 		}
 		if err != nil {
 			return fmt.Errorf("copy failed, %w::%s, %s, %s", err, srcDir, destDir, backupLabelName)
 		}
+		copiesSinceFlush++
+		if copiesSinceFlush >= metadataFlushFiles || time.Since(lastFlush) >= metadataFlushInterval {
+			flush()
+		}
 		copyTokens <- struct{}{}
 	}
+	return ctx.Err()
+}
+
+// volumeUsageFunc finds the destination's free space; tests swap it out to fake a full volume
+var volumeUsageFunc = volumeUsage
+
+// fitsOnDestination reports if file is no larger than the free space on destDir
+// If we can't tell, we say it fits and let the copy find out
+func fitsOnDestination(destDir string, file Fpath) bool {
+	total, used, err := volumeUsageFunc(destDir)
+	if err != nil || total == 0 {
+		return true
+	}
+	info, err := os.Stat(string(file))
+	if err != nil {
+		return true
+	}
+	return info.Size() <= total-used
+}
+
+// checkBackupWillFit compares the size of the files we want to copy, plus headroom,
+// with the free space on the destination volume. Not fitting is only a warning
+// as we fill the destination in priority order, unless we have been asked to abort
+// when low on space, in which case it is ErrNoSpace before anything is copied.
+func checkBackupWillFit(xc *XMLCfg, opts BackupOptions, destDir string, copyFilesArray fpathListList, maxNumBackups int, logFunc func(msg string)) error {
+	var free int64
+	total, used, err := volumeUsageFunc(destDir)
+	if err == nil && total > 0 {
+		free = total - used
+	} else {
+		// Fall back on what we saw last time
+		vc, err := xc.VolumeCfgFromDir(destDir)
+		if err != nil || vc.TotalCapacityBytes == 0 {
+			return nil
+		}
+		free = vc.FreeBytes()
+	}
+	var required int64
+	for numBackups, copyFiles := range copyFilesArray {
+		if numBackups >= maxNumBackups {
+			break
+		}
+		for _, file := range copyFiles {
+			if info, err := os.Stat(string(file)); err == nil {
+				required += info.Size()
+			}
+		}
+	}
+	need := required + int64(float64(required)*opts.spaceHeadroom(xc)/100)
+	if need <= free {
+		return nil
+	}
+	msg := fmt.Sprint("destination has ", bytesize.New(float64(free)), " free, need ", bytesize.New(float64(need)))
+	if opts.AbortOnLowSpace {
+		return fmt.Errorf("%w::%s %s", ErrNoSpace, destDir, msg)
+	}
+	logFunc(fmt.Sprint("Warning: ", msg, " on ", destDir, ". Not everything will be backed up"))
+	return nil
+}
+
+// checkLowSpace warns if the destination's free space is below the threshold
+// returning ErrLowSpace instead if we have been asked to abort
+func checkLowSpace(xc *XMLCfg, opts BackupOptions, destDir string, logFunc func(msg string)) error {
+	total, used, err := volumeUsageFunc(destDir)
+	if err != nil || total == 0 {
+		// Not knowing is no reason to stop
+		return nil
+	}
+	free := total - used
+	threshold := opts.lowSpaceThreshold(xc)
+	if float64(free) >= threshold*float64(total)/100 {
+		return nil
+	}
+	if opts.AbortOnLowSpace {
+		return fmt.Errorf("%w::%s has %d of %d bytes free", ErrLowSpace, destDir, free, total)
+	}
+	logFunc(fmt.Sprintf("Warning: %s has only %.1f%% free space", destDir, 100*float64(free)/float64(total)))
 	return nil
 }
 
+// BackupRunner backs up srcDir to destDir, tagging the source files copied
+// The trees are scanned at the same time, so registerFunc, which is
+// passed each DirTracker as it is walked, must be safe for concurrent use.
 func BackupRunner(
+	ctx context.Context,
 	xc *XMLCfg,
+	opts BackupOptions,
 	maxNumBackups int,
 	fc FileCopier,
 	srcDir, destDir string,
 	orphanFunc func(path string) error,
 	logFunc func(msg string),
 	registerFunc func(*DirTracker),
-	shutdownChan chan struct{},
 ) error {
+	_, err := BackupRunnerWithReport(ctx, xc, opts, maxNumBackups, fc, srcDir, destDir, orphanFunc, logFunc, registerFunc)
+	return err
+}
 
+// BackupRunnerWithReport is BackupRunner, also reporting what it did
+func BackupRunnerWithReport(
+	ctx context.Context,
+	xc *XMLCfg,
+	opts BackupOptions,
+	maxNumBackups int,
+	fc FileCopier,
+	srcDir, destDir string,
+	orphanFunc func(path string) error,
+	logFunc func(msg string),
+	registerFunc func(*DirTracker),
+) (report BackupReport, err error) {
+	start := time.Now()
+	defer func() { report.finish(start, err) }()
 	if logFunc == nil {
 		logFunc = func(msg string) {
 			log.Println(msg)
 		}
 	}
+	// Check the destination before we spend a long time scanning
+	if !opts.CreateLabelIfMissing && !hasVolumeLabel(destDir) {
+		return report, fmt.Errorf("%w::%s", ErrNoVolumeLabel, destDir)
+	}
 	backupLabelName, err := xc.getVolumeLabel(destDir)
 	if err != nil {
-		return err
+		return report, err
+	}
+	report.DestLabel = backupLabelName
+	if fc != nil {
+		due, reason, err := xc.backupDue(opts, destDir, backupLabelName, time.Now())
+		if err != nil {
+			return report, err
+		}
+		if !due {
+			logFunc(reason)
+			report.Status = BackupReportNotDue
+			return report, nil
+		}
+		fc = report.countCopies(fc)
+	}
+	if err := checkLowSpace(xc, opts, destDir, logFunc); err != nil {
+		return report, err
+	}
+	pw, err := ParsePriorityWeights(opts.priorityWeights(xc))
+	if err != nil {
+		return report, err
 	}
 	logFunc(fmt.Sprint("Determined label as: \"", backupLabelName, "\" :now scanning directories"))
 
 	// Go ahead and run a check_calc style scan of the directories and make sure
 	// they have all their existing md5s up to date
 	// First of all get the srcDir updated with files that are already in destDir
-	var bs backScanner
-	dt, err := bs.scanBackupDirectories(destDir, srcDir, backupLabelName, registerFunc, logFunc, shutdownChan)
+	rq, err := NewRetryQueue(srcDir)
 	if err != nil {
-		return err
+		return report, err
+	}
+	defer func() {
+		if err := rq.Persist(); err != nil {
+			logFunc(fmt.Sprint("Unable to save retry queue:", err))
+		}
+	}()
+	walkOpts := opts.DirTrackerOptions(xc)
+	if fc != nil && rq.Len() > 0 {
+		logFunc("Retrying previously failed copies")
+		retryFailedCopies(srcDir, destDir, backupLabelName, fc, rq, opts.VerifyAfterCopy, walkOpts.Metadata, logFunc)
+	}
+	bs := backScanner{
+		destIndexes: loadDestinationIndexes(opts, []string{destDir}, logFunc),
+		detectMoves: opts.DetectMoves && fc != nil,
+		walkOpts:    walkOpts,
+		orphanFunc: func(dest int, path Fpath) error {
+			return report.handleOrphan(path, orphanFunc)
+		},
+	}
+	dt, err := bs.scanBackupDirectories(ctx, destDir, srcDir, backupLabelName, registerFunc, logFunc)
+	if err != nil {
+		return report, err
+	}
+	if err := rebuildDestinationIndexes(opts, []string{destDir}, walkOpts.Metadata, logFunc); err != nil {
+		return report, err
 	}
 	if fc == nil {
 		logFunc("Scan only. Going no further")
 		// If we've not supplied a copier, when we clearly don't want to run the copy
-		return nil
+		return report, nil
 	}
 	logFunc("Looking for files to  copy")
 
-	copyFilesArray, err := extractCopyFiles(srcDir, dt[1], backupLabelName, registerFunc, maxNumBackups, shutdownChan)
+	copyFilesArray, skipped, err := extractCopyFiles(ctx, srcDir, dt[1], backupLabelName, registerFunc, maxNumBackups, pw)
 	if err != nil {
-		return fmt.Errorf("BackupRunner cannot extract files, %w", err)
+		return report, fmt.Errorf("BackupRunner cannot extract files, %w", err)
 	}
+	report.FilesSkipped = skipped
 
+	if err := checkBackupWillFit(xc, opts, destDir, copyFilesArray, maxNumBackups, logFunc); err != nil {
+		return report, err
+	}
 	logFunc("Now starting Copy")
 
+	dms := newDirtyMaps(walkOpts.Metadata)
 	err = doCopies(
+		ctx,
 		srcDir, destDir,
 		backupLabelName,
 		fc,
 		copyFilesArray, maxNumBackups,
-		logFunc, shutdownChan,
+		rq,
+		opts.VerifyAfterCopy,
+		&report,
+		dms,
+		logFunc,
 	)
+	// If we stopped early, copies may still be in flight
+	if persistErr := dms.persist(); err == nil {
+		err = persistErr
+	}
 
 	logFunc("Finished Copy")
+	if err != nil {
+		return report, err
+	}
+	if !report.complete() {
+		logFunc("Backup incomplete, so not recording it as done")
+		return report, nil
+	}
+	return report, xc.recordLastBackup(destDir, time.Now())
+}
+
+// BackupRunnerFanOut backs up one source to several destinations
+// The source is scanned once, then the destinations are copied to at once.
+// Files already on a destination are not copied there again.
+// As with BackupRunner, registerFunc must be safe for concurrent use.
+func BackupRunnerFanOut(
+	ctx context.Context,
+	xc *XMLCfg,
+	opts BackupOptions,
+	maxNumBackups int,
+	fc FileCopier,
+	srcDir string, destDirs []string,
+	orphanFunc func(path string) error,
+	logFunc func(msg string),
+	registerFunc func(*DirTracker),
+) error {
+	_, err := BackupRunnerFanOutWithReport(ctx, xc, opts, maxNumBackups, fc, srcDir, destDirs, orphanFunc, logFunc, registerFunc)
 	return err
 }
+
+// BackupRunnerFanOutWithReport is BackupRunnerFanOut, also reporting
+// what it did to each destination, in the order of destDirs
+func BackupRunnerFanOutWithReport(
+	ctx context.Context,
+	xc *XMLCfg,
+	opts BackupOptions,
+	maxNumBackups int,
+	fc FileCopier,
+	srcDir string, destDirs []string,
+	orphanFunc func(path string) error,
+	logFunc func(msg string),
+	registerFunc func(*DirTracker),
+) (reports []BackupReport, err error) {
+	start := time.Now()
+	reports = make([]BackupReport, len(destDirs))
+	defer func() {
+		for i := range reports {
+			reports[i].finish(start, err)
+		}
+	}()
+	if logFunc == nil {
+
+		logFunc = func(msg string) {
+			log.Println(msg)
+		}
+	}
+	backupLabelNames := make([]string, len(destDirs))
+	for i, destDir := range destDirs {
+		if !opts.CreateLabelIfMissing && !hasVolumeLabel(destDir) {
+			return reports, fmt.Errorf("%w::%s", ErrNoVolumeLabel, destDir)
+		}
+		backupLabelNames[i], err = xc.getVolumeLabel(destDir)
+		if err != nil {
+			return reports, err
+		}
+		reports[i].DestLabel = backupLabelNames[i]
+		if err := checkLowSpace(xc, opts, destDir, logFunc); err != nil {
+			return reports, err
+		}
+	}
+	pw, err := ParsePriorityWeights(opts.priorityWeights(xc))
+	if err != nil {
+		return reports, err
+	}
+	logFunc(fmt.Sprint("Determined labels as: ", backupLabelNames, " :now scanning directories"))
+
+	rq, err := NewRetryQueue(srcDir)
+	if err != nil {
+		return reports, err
+	}
+	defer func() {
+		if err := rq.Persist(); err != nil {
+			logFunc(fmt.Sprint("Unable to save retry queue:", err))
+		}
+	}()
+	// Destinations that are not due are left alone, retries and all
+	due := make([]bool, len(destDirs))
+	if fc != nil {
+		for i, destDir := range destDirs {
+			var reason string
+			due[i], reason, err = xc.backupDue(opts, destDir, backupLabelNames[i], time.Now())
+			if err != nil {
+				return reports, err
+			}
+			if !due[i] {
+				logFunc(reason)
+				reports[i].Status = BackupReportNotDue
+			}
+		}
+	}
+	walkOpts := opts.DirTrackerOptions(xc)
+	if fc != nil && rq.Len() > 0 {
+		logFunc("Retrying previously failed copies")
+		for i, destDir := range destDirs {
+			if due[i] {
+				retryFailedCopies(srcDir, destDir, backupLabelNames[i], reports[i].countCopies(fc), rq, opts.VerifyAfterCopy, walkOpts.Metadata, logFunc)
+			}
+		}
+	}
+	bs := backScanner{
+		destIndexes: loadDestinationIndexes(opts, destDirs, logFunc),
+		detectMoves: opts.DetectMoves && fc != nil,
+		walkOpts:    walkOpts,
+		orphanFunc: func(dest int, path Fpath) error {
+			return reports[dest].handleOrphan(path, orphanFunc)
+		},
+	}
+	dt, err := bs.scanBackupDirectoriesMulti(ctx, destDirs, srcDir, backupLabelNames, registerFunc, logFunc)
+	if err != nil {
+		return reports, err
+	}
+	if err := rebuildDestinationIndexes(opts, destDirs, walkOpts.Metadata, logFunc); err != nil {
+		return reports, err
+	}
+	if fc == nil {
+		logFunc("Scan only. Going no further")
+		return reports, nil
+	}
+	srcDt := dt[len(destDirs)]
+	// Work out what each destination needs first, as that walks the source
+	type copyJob struct {
+		dest           int
+		copyFilesArray fpathListList
+	}
+	var jobs []copyJob
+	for i, destDir := range destDirs {
+		if !due[i] {
+			continue
+		}
+		logFunc(fmt.Sprint("Looking for files to copy to ", backupLabelNames[i]))
+		copyFilesArray, skipped, err := extractCopyFiles(ctx, srcDir, srcDt, backupLabelNames[i], registerFunc, maxNumBackups, pw)
+		if err != nil {
+			return reports, fmt.Errorf("BackupRunnerFanOut cannot extract files, %w", err)
+		}
+		reports[i].FilesSkipped = skipped
+		if err := checkBackupWillFit(xc, opts, destDir, copyFilesArray, maxNumBackups, logFunc); err != nil {
+			return reports, err
+		}
+		jobs = append(jobs, copyJob{dest: i, copyFilesArray: copyFilesArray})
+	}
+
+	// Then copy to the destinations at once, they are usually separate disks.
+	// The copies all tag the same source files, so share the maps
+	// that record them, else one destination's tags would overwrite another's
+	dms := newDirtyMaps(walkOpts.Metadata)
+	var logLock sync.Mutex
+	copyLogFunc := func(msg string) {
+		logLock.Lock()
+		defer logLock.Unlock()
+		logFunc(msg)
+	}
+	copyErrs := make([]error, len(destDirs))
+	tokens := makeTokenChan(fanOutConcurrency)
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		<-tokens
+		wg.Add(1)
+		go func(i int, copyFilesArray fpathListList) {
+			defer func() {
+				tokens <- struct{}{}
+				wg.Done()
+			}()
+			copyLogFunc(fmt.Sprint("Now starting Copy to ", backupLabelNames[i]))
+			copyErrs[i] = doCopies(
+				ctx,
+				srcDir, destDirs[i],
+				backupLabelNames[i],
+				reports[i].countCopies(fc),
+				copyFilesArray, maxNumBackups,
+				rq,
+				opts.VerifyAfterCopy,
+				&reports[i],
+				dms,
+				copyLogFunc,
+			)
+			copyLogFunc(fmt.Sprint("Copied ", atomic.LoadInt64(&reports[i].FilesCopied), " files to ", backupLabelNames[i]))
+		}(job.dest, job.copyFilesArray)
+	}
+	wg.Wait()
+	// If we stopped early, copies may still be in flight
+	if err := dms.persist(); err != nil {
+		return reports, err
+	}
+	for _, job := range jobs {
+		i := job.dest
+		if copyErrs[i] != nil {
+			return reports, copyErrs[i]
+		}
+		if !reports[i].complete() {
+			logFunc(fmt.Sprint("Backup to ", backupLabelNames[i], " incomplete, so not recording it as done"))
+			continue
+		}
+		if err := xc.recordLastBackup(destDirs[i], time.Now()); err != nil {
+			return reports, err
+		}
+	}
+	logFunc("Finished Copy")
+	return reports, nil
+}
@@ -0,0 +1,134 @@
+package medorg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// destMove is a file on the destination that should be somewhere else
+// to keep the same layout as the source
+type destMove struct {
+	from, to Fpath
+}
+
+// findDestinationMoves looks for files the source has moved since they were backed up
+// i.e. the destination has the file, but not where the source now has it,
+// and nothing in the source is left where the destination has it.
+// Files that have been copied in the source, rather than moved, are left alone.
+func findDestinationMoves(
+	ctx context.Context,
+	srcDir, destDir string,
+	srcDt *DirTracker,
+	destIndex *DuplicateIndex,
+	registerFunc func(*DirTracker),
+) ([]destMove, error) {
+	var lk sync.Mutex
+	var moves []destMove
+	claimed := make(map[Fpath]struct{})
+	visitFunc := func(dm DirectoryEntryInterface, dir, fn string, fileStruct FileStruct) error {
+		from, ok := destIndex.get(fileStruct.Key())
+		if !ok {
+			return nil
+		}
+		rel, err := filepath.Rel(srcDir, filepath.Join(dir, fn))
+		if err != nil {
+			return err
+		}
+		to := NewFpath(destDir, rel)
+		if from == to {
+			return nil
+		}
+		oldRel, err := filepath.Rel(destDir, string(from))
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(filepath.Join(srcDir, oldRel)); !errors.Is(err, os.ErrNotExist) {
+			// Still in the source where it was, so this is a copy not a move
+			return nil
+		}
+		if _, err := os.Stat(string(to)); !errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		lk.Lock()
+		defer lk.Unlock()
+		// Several identical files may have been moved, only one gets the backup
+		if _, ok := claimed[from]; ok {
+			return nil
+		}
+		claimed[from] = struct{}{}
+		moves = append(moves, destMove{from: from, to: to})
+		return nil
+	}
+	srcDt.Revisit(srcDir, registerFunc, visitFunc, ctx.Done())
+	return moves, ctx.Err()
+}
+
+// moveDestinationFiles renames files on destDir to follow the source
+// moving them, rather than copying them again.
+// Returns the number of files moved.
+func moveDestinationFiles(
+	ctx context.Context,
+	srcDir, destDir string,
+	srcDt *DirTracker,
+	destIndex *DuplicateIndex,
+	registerFunc func(*DirTracker),
+	metadata MetadataOptions,
+	logFunc func(msg string),
+) (int, error) {
+	moves, err := findDestinationMoves(ctx, srcDir, destDir, srcDt, destIndex, registerFunc)
+	if err != nil {
+		return 0, err
+	}
+	dms := newDirtyMaps(metadata)
+	moved := 0
+	for _, mv := range moves {
+		if err := moveDestinationFile(destDir, mv, dms, destIndex); err != nil {
+			logFunc(fmt.Sprint("Unable to move ", mv.from, " to ", mv.to, ": ", err))
+			continue
+		}
+		moved++
+	}
+	return moved, dms.persist()
+}
+
+func moveDestinationFile(destDir string, mv destMove, dms *dirtyMaps, destIndex *DuplicateIndex) error {
+	dms.Lock()
+	defer dms.Unlock()
+	fromDir, fromName := filepath.Split(string(mv.from))
+	toDir, toName := filepath.Split(string(mv.to))
+	fromDir, toDir = filepath.Clean(fromDir), filepath.Clean(toDir)
+	dmFrom, err := dms.get(fromDir)
+	if err != nil {
+		return err
+	}
+	fs, ok := dmFrom.Get(fromName)
+	if !ok {
+		return fmt.Errorf("%w::%s", ErrMissingEntry, mv.from)
+	}
+	if err := os.MkdirAll(toDir, 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(string(mv.from), string(mv.to)); err != nil {
+		return err
+	}
+	dmTo, err := dms.get(toDir)
+	if err != nil {
+		return err
+	}
+	dmFrom.Rm(fromName)
+	fs.directory = toDir
+	fs.Name = toName
+	dmTo.Add(fs)
+	destIndex.Add(fs)
+	rel, err := filepath.Rel(destDir, string(mv.to))
+	if err != nil {
+		return err
+	}
+	// Should this fail, the worst that happens is we look for the file in the old place
+	_ = appendDestinationIndex(destDir, rel, fs)
+	return nil
+}
@@ -0,0 +1,83 @@
+package medorg
+
+// BackupOptions are the settings for a single backup run
+// Unlike the XMLCfg, none of these are saved to disk.
+type BackupOptions struct {
+	// CreateLabelIfMissing lets a backup give a new destination a label
+	// rather than refusing to run
+	CreateLabelIfMissing bool
+	// AbortOnLowSpace stops the backup, rather than warning, when
+	// the destination is low on space, or what we want to copy
+	// will not fit
+	AbortOnLowSpace bool
+	// Reindex walks the destinations, ignoring any index,
+	// and then writes a new index
+	Reindex bool
+	// DetectMoves renames files on the destination that have been moved
+	// in the source, rather than copying them again
+	DetectMoves bool
+	// IgnoreSchedules backs up to every destination, due or not
+	IgnoreSchedules bool
+	// VerifyAfterCopy re-reads each copy, and only tags the source
+	// as backed up if its checksum matches
+	VerifyAfterCopy bool
+	// SymlinkPolicy is what the backup does with symlinks to directories
+	SymlinkPolicy SymlinkPolicy
+	// IgnoreSizeLimit lets directories with more than DefaultMaxEntries
+	// files have their metadata written
+	IgnoreSizeLimit bool
+	// The remaining fields take the place of the saved setting
+	// of the same name in the XMLCfg, when not zero
+	PriorityWeights      string
+	LowSpaceThresholdPct float64
+	SpaceHeadroomPct     float64
+	MaxBytesPerSecond    int64
+	// If WebhookURL is set, all three are used
+	WebhookURL       string
+	WebhookOnSuccess bool
+	WebhookOnFailure bool
+}
+
+// DirTrackerOptions are how a backup should walk its directories
+func (opts BackupOptions) DirTrackerOptions(xc *XMLCfg) DirTrackerOptions {
+	dto := DefaultDirTrackerOptions()
+	dto.SymlinkPolicy = opts.SymlinkPolicy
+	dto.IgnorePatterns = xc.IgnorePatterns
+	dto.Metadata = xc.MetadataOptions()
+	if opts.IgnoreSizeLimit {
+		dto.Metadata.MaxEntries = 0
+	}
+	return dto
+}
+
+// MaxRate returns how many bytes a second the backup may copy, zero for no limit
+func (opts BackupOptions) MaxRate(xc *XMLCfg) int64 {
+	if opts.MaxBytesPerSecond > 0 {
+		return opts.MaxBytesPerSecond
+	}
+	return xc.MaxBytesPerSecond
+}
+
+// lowSpaceThreshold returns the percentage free space we warn below
+func (opts BackupOptions) lowSpaceThreshold(xc *XMLCfg) float64 {
+	if opts.LowSpaceThresholdPct > 0 {
+		return opts.LowSpaceThresholdPct
+	}
+	return xc.LowSpaceThreshold()
+}
+
+// spaceHeadroom returns the percentage margin a destination needs on top of the files to copy
+func (opts BackupOptions) spaceHeadroom(xc *XMLCfg) float64 {
+	if opts.SpaceHeadroomPct > 0 {
+		return opts.SpaceHeadroomPct
+	}
+	return xc.SpaceHeadroom()
+}
+
+// priorityWeights returns the weights to parse for this run
+func (opts BackupOptions) priorityWeights(xc *XMLCfg) string {
+	if opts.PriorityWeights != "" {
+		return opts.PriorityWeights
+	}
+	return xc.PriorityWeights
+}
@@ -0,0 +1,46 @@
+package medorg
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupOptionsDirTrackerOptions(t *testing.T) {
+	xc := XMLCfg{IgnorePatterns: []string{"*.tmp"}}
+	opts := BackupOptions{SymlinkPolicy: SymlinkFollow}
+	dto := opts.DirTrackerOptions(&xc)
+	if dto.SymlinkPolicy != SymlinkFollow || len(dto.IgnorePatterns) != 1 {
+		t.Error("Options do not match the config", dto)
+	}
+	if dto.MaxDepth >= 0 || dto.SplitWarnings {
+		t.Error("Expected the defaults for everything else, got", dto)
+	}
+}
+
+func TestBackupOptionsOverrides(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "cfg.xml")
+	xc := NewXMLCfg(fn)
+	xc.MaxBytesPerSecond = 1000
+	xc.PriorityWeights = "age=1.0"
+	var opts BackupOptions
+	if opts.MaxRate(xc) != 1000 || opts.priorityWeights(xc) != "age=1.0" ||
+		opts.lowSpaceThreshold(xc) != DefaultLowSpaceThresholdPct || opts.spaceHeadroom(xc) != DefaultSpaceHeadroomPct {
+		t.Error("Expected the saved settings without overrides")
+	}
+	opts = BackupOptions{
+		PriorityWeights:      "size=1.0",
+		LowSpaceThresholdPct: 5,
+		SpaceHeadroomPct:     20,
+		MaxBytesPerSecond:    2000,
+	}
+	if opts.MaxRate(xc) != 2000 || opts.lowSpaceThreshold(xc) != 5 || opts.spaceHeadroom(xc) != 20 || opts.priorityWeights(xc) != "size=1.0" {
+		t.Error("Overrides not used")
+	}
+	if err := xc.WriteXmlCfg(); err != nil {
+		t.Fatal(err)
+	}
+	readBack := NewXMLCfg(fn)
+	if readBack.MaxBytesPerSecond != 1000 || readBack.PriorityWeights != "age=1.0" {
+		t.Error("Expected the saved settings, got", readBack)
+	}
+}
@@ -0,0 +1,99 @@
+package medorg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	bytesize "github.com/inhies/go-bytesize"
+)
+
+// BackupReportFileName is where, at the top of a backup destination,
+// we write the report of the last backup to it, if asked to
+const BackupReportFileName = ".mdbackup-report.json"
+
+// BackupReportNotDue is the status of a destination skipped as not yet due
+const BackupReportNotDue = "not due"
+
+// countCopies wraps fc to count the files, and bytes, it copies
+func (br *BackupReport) countCopies(fc FileCopier) FileCopier {
+	if fc == nil {
+		fc = CopyFile
+	}
+	return func(src, dst Fpath) error {
+		err := fc(src, dst)
+		if err == nil {
+			atomic.AddInt64(&br.FilesCopied, 1)
+			if fi, err := os.Stat(string(src)); err == nil {
+				atomic.AddInt64(&br.BytesCopied, fi.Size())
+			}
+		}
+		return err
+	}
+}
+
+// handleOrphan counts path, a file on the destination that is not in the source,
+// and passes it to orphanFunc, if any, counting it as deleted if it has gone after
+func (br *BackupReport) handleOrphan(path Fpath, orphanFunc func(path string) error) error {
+	br.OrphansFound++
+	if orphanFunc == nil {
+		return nil
+	}
+	_, err := os.Stat(string(path))
+	existed := err == nil
+	if err := orphanFunc(string(path)); err != nil {
+		return err
+	}
+	if _, err := os.Stat(string(path)); existed && errors.Is(err, os.ErrNotExist) {
+		br.OrphansDeleted++
+	}
+	return nil
+}
+
+// complete is true if every file that needed copying was copied
+// Only then should the destination count as backed up for the schedule
+func (br *BackupReport) complete() bool {
+	return atomic.LoadInt64(&br.Errors) == 0 && atomic.LoadInt64(&br.SpaceSkipped) == 0
+}
+
+// finish fills in how the backup, started at start, went
+func (br *BackupReport) finish(start time.Time, err error) {
+	br.DurationSeconds = time.Since(start).Seconds()
+	switch {
+	case err != nil:
+		br.Status = "failure"
+		br.ErrorMessage = err.Error()
+	case br.Status == "":
+		br.Status = "success"
+	}
+}
+
+// WriteJSON writes the report to the top of destDir
+func (br BackupReport) WriteJSON(destDir string) error {
+	ba, err := json.MarshalIndent(br, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(destDir, BackupReportFileName), ba, 0644)
+}
+
+// WriteBackupReports writes a table of the reports, one line per destination
+func WriteBackupReports(w io.Writer, reports []BackupReport) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEST\tSTATUS\tCOPIED\tBYTES\tSKIPPED\tNO SPACE\tORPHANS\tDELETED\tERRORS\tTIME")
+	for _, br := range reports {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%d\t%d\t%d\t%d\t%d\t%s\n",
+			br.DestLabel, br.Status,
+			br.FilesCopied, bytesize.New(float64(br.BytesCopied)),
+			br.FilesSkipped, br.SpaceSkipped, br.OrphansFound, br.OrphansDeleted, br.Errors,
+			(time.Duration(br.DurationSeconds * float64(time.Second))).Round(time.Second),
+		)
+	}
+	return tw.Flush()
+}
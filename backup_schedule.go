@@ -0,0 +1,104 @@
+package medorg
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrBadSchedule the schedule string is not one we understand
+var ErrBadSchedule = errors.New("unknown backup schedule")
+
+// DestSchedule says how often a destination should be backed up to
+// Every is daily, weekly, monthly, or a duration such as 36h
+type DestSchedule struct {
+	Label string `xml:"label,attr"`
+	Every string `xml:"every,attr"`
+}
+
+// ParseSchedule turns daily, weekly, monthly or a duration into
+// how long to leave between backups
+func ParseSchedule(str string) (time.Duration, error) {
+	switch str {
+	case "daily":
+		return 24 * time.Hour, nil
+	case "weekly":
+		return 7 * 24 * time.Hour, nil
+	case "monthly":
+		return 30 * 24 * time.Hour, nil
+	}
+	every, err := time.ParseDuration(str)
+	if err != nil || every <= 0 {
+		return 0, fmt.Errorf("%w::%s", ErrBadSchedule, str)
+	}
+	return every, nil
+}
+
+// Schedule returns how often the volume should be backed up to
+// ok is false if it has no schedule, i.e. every run
+func (xc *XMLCfg) Schedule(label string) (every string, ok bool) {
+	for _, ds := range xc.Schedules {
+		if ds.Label == label {
+			return ds.Every, true
+		}
+	}
+	return "", false
+}
+
+// SetSchedule sets (or with "" removes) how often the volume should be backed up to
+func (xc *XMLCfg) SetSchedule(label, every string) error {
+	if every != "" {
+		if _, err := ParseSchedule(every); err != nil {
+			return err
+		}
+	}
+	schedules := xc.Schedules[:0]
+	for _, ds := range xc.Schedules {
+		if ds.Label != label {
+			schedules = append(schedules, ds)
+		}
+	}
+	if every != "" {
+		schedules = append(schedules, DestSchedule{Label: label, Every: every})
+	}
+	xc.Schedules = schedules
+	return nil
+}
+
+// backupDue reports if destDir should be backed up to now
+// If not, the reason says why not
+func (xc *XMLCfg) backupDue(opts BackupOptions, destDir, label string, now time.Time) (due bool, reason string, err error) {
+	if opts.IgnoreSchedules {
+		return true, "", nil
+	}
+	str, ok := xc.Schedule(label)
+	if !ok {
+		return true, "", nil
+	}
+	every, err := ParseSchedule(str)
+	if err != nil {
+		return false, "", err
+	}
+	vc, err := xc.VolumeCfgFromDir(destDir)
+	if err != nil {
+		return false, "", err
+	}
+	if vc.LastBackup == 0 {
+		return true, "", nil
+	}
+	since := now.Sub(time.Unix(vc.LastBackup, 0))
+	if since >= every {
+		return true, "", nil
+	}
+	return false, fmt.Sprint("skipping dest ", label, ": last backed up ", since.Round(time.Minute), " ago, schedule is ", str), nil
+}
+
+// recordLastBackup notes in the volume's label file that a backup has just finished
+func (xc *XMLCfg) recordLastBackup(destDir string, now time.Time) error {
+	vc, err := xc.VolumeCfgFromDir(destDir)
+	if err != nil {
+		return err
+	}
+	vc.LastBackup = now.Unix()
+	return vc.Persist()
+}
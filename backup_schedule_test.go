@@ -0,0 +1,147 @@
+package medorg
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		str   string
+		every time.Duration
+		err   error
+	}{
+		{"daily", 24 * time.Hour, nil},
+		{"weekly", 7 * 24 * time.Hour, nil},
+		{"monthly", 30 * 24 * time.Hour, nil},
+		{"36h", 36 * time.Hour, nil},
+		{"fortnightly", 0, ErrBadSchedule},
+		{"-1h", 0, ErrBadSchedule},
+	}
+	for _, tt := range tests {
+		t.Run(tt.str, func(t *testing.T) {
+			every, err := ParseSchedule(tt.str)
+			if !errors.Is(err, tt.err) {
+				t.Fatal("Expected", tt.err, "got", err)
+			}
+			if every != tt.every {
+				t.Error("Expected", tt.every, "got", every)
+			}
+		})
+	}
+}
+
+func TestBackupSchedule(t *testing.T) {
+	dirs, err := createTestBackupDirectories(4, 0)
+	if err != nil {
+		t.Fatal("Failed to create test Directories", err)
+	}
+	defer func() {
+		for i := range dirs {
+			os.RemoveAll(dirs[i])
+		}
+	}()
+	xc := XMLCfg{}
+	opts := BackupOptions{CreateLabelIfMissing: true}
+	vc, err := xc.VolumeCfgFromDir(dirs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := xc.SetSchedule(vc.Label, "daily"); err != nil {
+		t.Fatal(err)
+	}
+	var callCount uint32
+	fc := func(src, dst Fpath) error {
+		atomic.AddUint32(&callCount, 1)
+		return CopyFile(src, dst)
+	}
+	var msgs []string
+	logFunc := func(msg string) { msgs = append(msgs, msg) }
+
+	// Never backed up, so it is due
+	err = BackupRunner(context.Background(), &xc, opts, 2, fc, dirs[0], dirs[1], nil, logFunc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadUint32(&callCount) != 4 {
+		t.Error("Expected 4 copies, got", callCount)
+	}
+	vc, err = ReadVolumeCfg(dirs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(time.Unix(vc.LastBackup, 0)) > time.Minute {
+		t.Error("Last backup not recorded", vc.LastBackup)
+	}
+
+	// A new file, but we backed up less than a day ago
+	makeFile(dirs[0])
+	atomic.StoreUint32(&callCount, 0)
+	msgs = nil
+	err = BackupRunner(context.Background(), &xc, opts, 2, fc, dirs[0], dirs[1], nil, logFunc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadUint32(&callCount) != 0 {
+		t.Error("Backed up when not due")
+	}
+	if len(msgs) == 0 || !strings.HasPrefix(msgs[len(msgs)-1], "skipping dest "+vc.Label) {
+		t.Error("Expected a skipping message, got", msgs)
+	}
+
+	opts.IgnoreSchedules = true
+	err = BackupRunner(context.Background(), &xc, opts, 2, fc, dirs[0], dirs[1], nil, logFunc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadUint32(&callCount) != 1 {
+		t.Error("Expected the new file copied, got", callCount)
+	}
+}
+
+func TestBackupScheduleIncomplete(t *testing.T) {
+	dirs, err := createTestBackupDirectories(4, 0)
+	if err != nil {
+		t.Fatal("Failed to create test Directories", err)
+	}
+	defer func() {
+		for i := range dirs {
+			os.RemoveAll(dirs[i])
+		}
+	}()
+	xc := XMLCfg{}
+	opts := BackupOptions{CreateLabelIfMissing: true}
+	vc, err := xc.VolumeCfgFromDir(dirs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := xc.SetSchedule(vc.Label, "daily"); err != nil {
+		t.Fatal(err)
+	}
+	var callCount uint32
+	fc := func(src, dst Fpath) error {
+		if atomic.AddUint32(&callCount, 1) == 1 {
+			return syscall.ENOSPC
+		}
+		return CopyFile(src, dst)
+	}
+	logFunc := func(msg string) {}
+
+	err = BackupRunner(context.Background(), &xc, opts, 2, fc, dirs[0], dirs[1], nil, logFunc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vc, err = ReadVolumeCfg(dirs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vc.LastBackup != 0 {
+		t.Error("A backup that left a file behind was recorded as done")
+	}
+}
@@ -1,6 +1,8 @@
 package medorg
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/fs"
@@ -12,6 +14,7 @@ import (
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 )
 
 var errMissingTestFile = errors.New("missing file")
@@ -40,7 +43,9 @@ func createTestBackupDirectories(numberOfFiles, numberOfDuplicates int) ([]strin
 		stem := filepath.Base(selectedFilename)
 		dstFile := NewFpath(directoriesCreated[1], stem)
 		log.Println("Pretending to backup", dstFile)
-		err := CopyFile(Fpath(selectedFilename), dstFile)
+		// A real copy, not the hard link CopyFile would make,
+		// as a hard link is the same file rather than a duplicate
+		err := copyFileContents(selectedFilename, string(dstFile), nil)
 		if err != nil {
 			return nil, err
 		}
@@ -48,7 +53,7 @@ func createTestBackupDirectories(numberOfFiles, numberOfDuplicates int) ([]strin
 	return directoriesCreated, nil
 }
 func recalcForTest(dm DirectoryMap, directory, fn string, d fs.DirEntry) error {
-	if fn == Md5FileName {
+	if fn == GetMetadataFilename() {
 		return nil
 	}
 	err := dm.UpdateValues(directory, d)
@@ -62,6 +67,10 @@ func recalcForTest(dm DirectoryMap, directory, fn string, d fs.DirEntry) error {
 	return nil
 }
 func recalcTestDirectory(dir string) error {
+	return recalcTestDirectoryWithOptions(dir, DefaultDirTrackerOptions())
+}
+
+func recalcTestDirectoryWithOptions(dir string, opts DirTrackerOptions) error {
 	makerFunc := func(dir string) (DirectoryTrackerInterface, error) {
 		mkFk := func(dir string) (DirectoryEntryInterface, error) {
 			dm, err := DirectoryMapFromDir(dir)
@@ -70,14 +79,14 @@ func recalcTestDirectory(dir string) error {
 		}
 		return NewDirectoryEntry(dir, mkFk)
 	}
-	for err := range NewDirTracker(false, dir, makerFunc).ErrChan() {
+	for err := range NewDirTrackerWithOptions(context.Background(), false, dir, makerFunc, opts).ErrChan() {
 		return fmt.Errorf("Error received on closing:%w", err)
 	}
 	return nil
 }
 
 func (bdm *backupDupeMap) aFile(dm DirectoryMap, dir, fn string, d fs.DirEntry) error {
-	if fn == Md5FileName {
+	if fn == GetMetadataFilename() {
 		return nil
 	}
 	fs, ok := dm.Get(fn)
@@ -150,7 +159,7 @@ func TestDuplicateDetect(t *testing.T) {
 			return nil
 		},
 	}
-	_, _ = bs.scanBackupDirectories(srcDir, destDir, "wibble", nil, nil, nil)
+	_, _ = bs.scanBackupDirectories(context.Background(), srcDir, destDir, "wibble", nil, nil)
 	if expectedDuplicates != 0 {
 		t.Error("Expected 0 duplicates left, got:", expectedDuplicates)
 	}
@@ -174,7 +183,7 @@ func TestDuplicateArchivedAtPopulation(t *testing.T) {
 	backupLabelName := "tstBackup"
 	t.Log("Created Test Directories:", dirs)
 	var bs backScanner
-	_, err = bs.scanBackupDirectories(dirs[1], dirs[0], backupLabelName, nil, nil, nil)
+	_, err = bs.scanBackupDirectories(context.Background(), dirs[1], dirs[0], backupLabelName, nil, nil)
 	if err != nil {
 		t.Error(err)
 	}
@@ -182,7 +191,7 @@ func TestDuplicateArchivedAtPopulation(t *testing.T) {
 	expectedDuplicates := 10
 	var lk sync.Mutex
 	archiveWalkFunc := func(dm DirectoryMap, dir, fn string, d fs.DirEntry) error {
-		if fn == Md5FileName {
+		if fn == GetMetadataFilename() {
 			return nil
 		}
 		fs, ok := dm.Get(fn)
@@ -241,7 +250,7 @@ func TestBackupExtract(t *testing.T) {
 
 	// FIXME error handling
 	var bs backScanner
-	_, _ = bs.scanBackupDirectories(dirs[1], dirs[0], backupLabelName, nil, nil, nil)
+	_, _ = bs.scanBackupDirectories(context.Background(), dirs[1], dirs[0], backupLabelName, nil, nil)
 
 	// Now hack it about so that we pretend  n of the files
 	// are additionally backed up to an alternate location
@@ -252,7 +261,7 @@ func TestBackupExtract(t *testing.T) {
 	extraMap := make(map[Fpath]struct{})
 	var lk sync.Mutex
 	directoryWalker := func(dm DirectoryMap, dir, fn string, d fs.DirEntry) error {
-		if fn == Md5FileName {
+		if fn == GetMetadataFilename() {
 			return nil
 		}
 		fs, ok := dm.Get(fn)
@@ -264,7 +273,7 @@ func TestBackupExtract(t *testing.T) {
 			defer lk.Unlock()
 			if numDuplicates > 0 {
 				numDuplicates--
-				fs.AddTag(altBackupLabelName)
+				_, _ = fs.AddTag(altBackupLabelName)
 				t.Log("Pretending", fn, "has additionally been backed up to alt location")
 				dm.Add(fs)
 				return nil
@@ -274,7 +283,7 @@ func TestBackupExtract(t *testing.T) {
 			defer lk.Unlock()
 			if numExtra > 0 {
 				numExtra--
-				fs.AddTag(altBackupLabelName)
+				_, _ = fs.AddTag(altBackupLabelName)
 				extraMap[fs.Path()] = struct{}{}
 				t.Log("Pretending", fn, "has been backed up to alternate location")
 				dm.Add(fs)
@@ -303,7 +312,7 @@ func TestBackupExtract(t *testing.T) {
 			t.Errorf("extractCopyFiles::%v", err)
 		}
 	}
-	copyFilesArray, err := extractCopyFiles(dirs[0], dt[0], backupLabelName, nil, 2, nil)
+	copyFilesArray, _, err := extractCopyFiles(context.Background(), dirs[0], dt[0], backupLabelName, nil, 2, DefaultPriorityWeights)
 	if err != nil {
 		t.Error(err)
 	}
@@ -361,14 +370,15 @@ func TestBackupMain(t *testing.T) {
 	var callCount uint32
 
 	// FIXME Provide a proper dummy object here for testing
-	var xc XMLCfg
+	xc := XMLCfg{}
+	opts := BackupOptions{CreateLabelIfMissing: true}
 	fc := func(src, dst Fpath) error {
 		t.Log("Copy", src, "to", dst)
 		CopyFile(src, dst)
 		atomic.AddUint32(&callCount, 1)
 		return nil
 	}
-	err = BackupRunner(&xc, 2, fc, dirs[0], dirs[1], nil, nil, nil, nil)
+	report, err := BackupRunnerWithReport(context.Background(), &xc, opts, 2, fc, dirs[0], dirs[1], nil, nil, nil)
 	if err != nil {
 		t.Error(err)
 	}
@@ -376,6 +386,176 @@ func TestBackupMain(t *testing.T) {
 	if int(cc) != (srcFiles - numberBackedUp) {
 		t.Error("Incorrect call count:", cc, srcFiles-numberBackedUp)
 	}
+	if report.Status != "success" {
+		t.Error("Unexpected status:", report.Status, report.ErrorMessage)
+	}
+	if report.FilesCopied != int64(srcFiles-numberBackedUp) {
+		t.Error("Report has wrong files copied:", report.FilesCopied, srcFiles-numberBackedUp)
+	}
+	if report.FilesSkipped != int64(numberBackedUp) {
+		t.Error("Report has wrong files skipped:", report.FilesSkipped, numberBackedUp)
+	}
+	if report.BytesCopied == 0 || report.Errors != 0 {
+		t.Error("Unexpected report:", report)
+	}
+	if err := report.WriteJSON(dirs[1]); err != nil {
+		t.Fatal(err)
+	}
+	ba, err := os.ReadFile(filepath.Join(dirs[1], BackupReportFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var readBack BackupReport
+	if err := json.Unmarshal(ba, &readBack); err != nil {
+		t.Fatal(err)
+	}
+	if readBack != report {
+		t.Error("Report did not survive a round trip:", readBack, report)
+	}
+	// Only the files we copied have a record of when
+	dm, err := DirectoryMapFromDir(dirs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lastBackups int
+	_ = dm.rangeMap(func(fn string, fs FileStruct) error {
+		if fs.LastBackup != 0 {
+			lastBackups++
+		}
+		return nil
+	})
+	if lastBackups != srcFiles-numberBackedUp {
+		t.Error("Expected", srcFiles-numberBackedUp, "files to record their last backup, got", lastBackups)
+	}
+}
+
+func TestBackupDestinationFull(t *testing.T) {
+	// Once the destination is full, files small enough to fit
+	// in what is left should still be copied
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+	sizes := []int{1000, 50, 1000, 50, 1000, 50, 50}
+	for i, size := range sizes {
+		ba := make([]byte, size)
+		rand.Read(ba)
+		if err := os.WriteFile(filepath.Join(srcDir, fmt.Sprint("file", i)), ba, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = recalcTestDirectory(srcDir)
+
+	// A destination with room for the small files, but none of the large
+	var lk sync.Mutex
+	free := int64(350)
+	defer func(orig func(string) (int64, int64, error)) { volumeUsageFunc = orig }(volumeUsageFunc)
+	volumeUsageFunc = func(dir string) (int64, int64, error) {
+		lk.Lock()
+		defer lk.Unlock()
+		return 1 << 20, 1<<20 - free, nil
+	}
+	fc := func(src, dst Fpath) error {
+		info, err := os.Stat(string(src))
+		if err != nil {
+			return err
+		}
+		lk.Lock()
+		defer lk.Unlock()
+		if info.Size() > free {
+			return ErrNoSpace
+		}
+		free -= info.Size()
+		return CopyFile(src, dst)
+	}
+	xc := XMLCfg{}
+	opts := BackupOptions{CreateLabelIfMissing: true}
+	report, err := BackupRunnerWithReport(context.Background(), &xc, opts, 2, fc, srcDir, destDir, nil, func(string) {}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.FilesCopied != 4 {
+		t.Error("Expected the 4 small files to be copied, got", report.FilesCopied)
+	}
+	if report.SpaceSkipped != 3 {
+		t.Error("Expected the 3 large files to not fit, got", report.SpaceSkipped)
+	}
+	if report.Errors != 0 {
+		t.Error("Running out of space is not an error, got", report.Errors)
+	}
+}
+
+// As TestBackupMain, but with a second, empty, destination
+// Everything should end up on that, and only the missing files on the first
+func TestBackupFanOut(t *testing.T) {
+	srcFiles := 20
+	numberBackedUp := 11
+	dirs, err := createTestBackupDirectories(srcFiles, numberBackedUp)
+	if err != nil {
+		t.Error("Failed to create test Directories", err)
+	}
+	emptyDir, err := ioutil.TempDir("", "tstDir")
+	if err != nil {
+		t.Error("Failed to create test Directories", err)
+	}
+	dirs = append(dirs, emptyDir)
+	defer func() {
+		for i := range dirs {
+			os.RemoveAll(dirs[i])
+		}
+	}()
+
+	_ = recalcTestDirectory(dirs[0])
+	_ = recalcTestDirectory(dirs[1])
+	var lk sync.Mutex
+	callCount := make(map[string]int)
+
+	xc := XMLCfg{}
+	opts := BackupOptions{CreateLabelIfMissing: true}
+	fc := func(src, dst Fpath) error {
+		lk.Lock()
+		defer lk.Unlock()
+		for _, dir := range dirs[1:] {
+			if filepath.Dir(string(dst)) == dir {
+				callCount[dir]++
+			}
+		}
+		return CopyFile(src, dst)
+	}
+	// However many destinations, the source should only be walked once
+	srcTrackers := make(map[*DirTracker]struct{})
+	registerFunc := func(dt *DirTracker) {
+		lk.Lock()
+		defer lk.Unlock()
+		if dt.root == dirs[0] {
+			srcTrackers[dt] = struct{}{}
+		}
+	}
+	reports, err := BackupRunnerFanOutWithReport(context.Background(), &xc, opts, 2, fc, dirs[0], dirs[1:], nil, nil, registerFunc)
+	if err != nil {
+		t.Error(err)
+	}
+	if callCount[dirs[1]] != (srcFiles - numberBackedUp) {
+		t.Error("Incorrect call count for first destination:", callCount[dirs[1]], srcFiles-numberBackedUp)
+	}
+	if callCount[dirs[2]] != srcFiles {
+		t.Error("Incorrect call count for second destination:", callCount[dirs[2]], srcFiles)
+	}
+	if len(srcTrackers) != 1 {
+		t.Error("Expected the source to be walked once, got", len(srcTrackers))
+	}
+	if len(reports) != 2 {
+		t.Fatal("Expected a report per destination, got", len(reports))
+	}
+	for i, dir := range dirs[1:] {
+		if reports[i].FilesCopied != int64(callCount[dir]) {
+			t.Error("Report has wrong files copied for", dir, reports[i].FilesCopied, callCount[dir])
+		}
+		if reports[i].Status != "success" || reports[i].DestLabel == "" {
+			t.Error("Unexpected report for", dir, reports[i])
+		}
+	}
+	if reports[0].FilesSkipped != int64(numberBackedUp) || reports[1].FilesSkipped != 0 {
+		t.Error("Reports have wrong files skipped:", reports[0].FilesSkipped, reports[1].FilesSkipped)
+	}
 }
 
 // Our source directory has 2 files that are the same, just a different name
@@ -456,7 +636,7 @@ func TestBackupSrcHasDuplicateFiles(t *testing.T) {
 		},
 	}
 	backupLabelName := "wibble"
-	_, _ = bs.scanBackupDirectories(srcDir, destDir, backupLabelName, nil, nil, nil)
+	_, _ = bs.scanBackupDirectories(context.Background(), srcDir, destDir, backupLabelName, nil, nil)
 	if expectedDuplicates != 0 {
 		t.Error("Expected 0 duplicates left, got:", expectedDuplicates)
 	}
@@ -469,7 +649,7 @@ func TestBackupSrcHasDuplicateFiles(t *testing.T) {
 			t.Errorf("extractCopyFiles::%v", err)
 		}
 	}
-	copyFilesArray, err := extractCopyFiles(dirs[0], dt[0], backupLabelName, nil, 2, nil)
+	copyFilesArray, _, err := extractCopyFiles(context.Background(), dirs[0], dt[0], backupLabelName, nil, 2, DefaultPriorityWeights)
 	if err != nil {
 		t.Error(err)
 	}
@@ -481,3 +661,401 @@ func TestBackupSrcHasDuplicateFiles(t *testing.T) {
 
 // FIXME Add Test that the checksum/filestamp are up-to-date in the new file
 // FIXME add test that files in dest but not in src are reported correctly.
+
+func TestDuplicateIndex(t *testing.T) {
+	index := NewDuplicateIndex()
+	index.Add(FileStruct{directory: "here", Name: "bob", Checksum: "abc", Size: 10})
+	index.Add(FileStruct{directory: "there", Name: "fred", Checksum: "abc", Size: 10})
+	index.Add(FileStruct{directory: "here", Name: "alice", Checksum: "def", Size: 10})
+	if index.Len() != 2 {
+		t.Error("Files with the same contents should only be indexed once, got", index.Len())
+	}
+	if _, ok := index.Lookup("abc", 11); ok {
+		t.Error("Size should be part of the lookup")
+	}
+	path, ok := index.Lookup("def", 10)
+	if !ok || path != NewFpath("here", "alice") {
+		t.Error("Unexpected lookup", path, ok)
+	}
+	index.Remove("def", 10)
+	if _, ok := index.Lookup("def", 10); ok {
+		t.Error("Entry should have been removed")
+	}
+}
+
+func TestBackupNeedsVolumeLabel(t *testing.T) {
+	dirs, err := createTestBackupDirectories(2, 0)
+	if err != nil {
+		t.Fatal("Failed to create test Directories", err)
+	}
+	defer func() {
+		for i := range dirs {
+			os.RemoveAll(dirs[i])
+		}
+	}()
+	var xc XMLCfg
+	var opts BackupOptions
+	err = BackupRunner(context.Background(), &xc, opts, 2, CopyFile, dirs[0], dirs[1], nil, nil, nil)
+	if !errors.Is(err, ErrNoVolumeLabel) {
+		t.Error("Expected a missing label error, got", err)
+	}
+	// Having created a label, all is good
+	_, err = xc.VolumeCfgFromDir(dirs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = BackupRunner(context.Background(), &xc, opts, 2, nil, dirs[0], dirs[1], nil, nil, nil)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBatchPersist(t *testing.T) {
+	wkDir, err := os.MkdirTemp("", "batchPersist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wkDir)
+	dms := make(map[string]*DirectoryMap)
+	for i := 0; i < 20; i++ {
+		dir := filepath.Join(wkDir, fmt.Sprint("dir", i))
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		dm := NewDirectoryMap()
+		dm.Add(FileStruct{Name: "file", Checksum: fmt.Sprint(i), directory: dir})
+		dms[dir] = dm
+	}
+	// One map that does not belong where we are writing it
+	badDir := filepath.Join(wkDir, "bad")
+	if err := os.Mkdir(badDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	badDm := NewDirectoryMap()
+	badDm.Add(FileStruct{Name: "file", Checksum: "bad", directory: wkDir})
+	dms[badDir] = badDm
+
+	err = BatchPersist(dms, 3)
+	if !errors.Is(err, errSelfCheckProblem) {
+		t.Error("Expected the bad map to be reported, got", err)
+	}
+	for dir, dm := range dms {
+		if dir == badDir {
+			continue
+		}
+		if dm.Stale() {
+			t.Error(dir, "was not written")
+		}
+	}
+}
+
+func TestBackupFlushesMetadata(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+	for i := 0; i < 6; i++ {
+		if err := os.WriteFile(filepath.Join(srcDir, fmt.Sprint("file", i)), []byte(fmt.Sprint("contents", i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(files int) { metadataFlushFiles = files }(metadataFlushFiles)
+	metadataFlushFiles = 1
+
+	// How many sources are recorded as backed up on disk, part way through
+	var lk sync.Mutex
+	var recorded int
+	fc := func(src, dst Fpath) error {
+		dm, err := DirectoryMapFromDir(srcDir)
+		if err != nil {
+			return err
+		}
+		tagged := 0
+		_ = dm.rangeMap(func(fn string, fs FileStruct) error {
+			if len(fs.BackupDest) > 0 {
+				tagged++
+			}
+			return nil
+		})
+		lk.Lock()
+		if tagged > recorded {
+			recorded = tagged
+		}
+		lk.Unlock()
+		return CopyFile(src, dst)
+	}
+	xc := XMLCfg{}
+	opts := BackupOptions{CreateLabelIfMissing: true}
+	if err := BackupRunner(context.Background(), &xc, opts, 2, fc, srcDir, destDir, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if recorded == 0 {
+		t.Error("Nothing was recorded until the backup finished")
+	}
+}
+
+func TestBackupNestedDirectories(t *testing.T) {
+	srcDir, err := os.MkdirTemp("", "nestedSrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(srcDir)
+	destDir, err := os.MkdirTemp("", "nestedDst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+	subDir := filepath.Join(srcDir, "sub")
+	if err := os.Mkdir(subDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(subDir, "file"), []byte("nested"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	xc := XMLCfg{}
+	opts := BackupOptions{CreateLabelIfMissing: true}
+	err = BackupRunner(context.Background(), &xc, opts, 2, CopyFile, srcDir, destDir, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	label, err := xc.getVolumeLabel(destDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dmSrc, err := DirectoryMapFromDir(subDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs, _ := dmSrc.Get("file"); !fs.HasTag(label) {
+		t.Error("Source not tagged as backed up", fs)
+	}
+	dmDst, err := DirectoryMapFromDir(filepath.Join(destDir, "sub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs, ok := dmDst.Get("file"); !ok || fs.Checksum == "" {
+		t.Error("Destination not recorded", fs)
+	}
+}
+
+func TestBackupStopsAtDeadline(t *testing.T) {
+	const numFiles = 20
+	dirs, err := createTestBackupDirectories(numFiles, 0)
+	if err != nil {
+		t.Fatal("Failed to create test Directories", err)
+	}
+	defer func() {
+		for i := range dirs {
+			os.RemoveAll(dirs[i])
+		}
+	}()
+	var callCount uint32
+	slowCopier := func(src, dst Fpath) error {
+		atomic.AddUint32(&callCount, 1)
+		time.Sleep(100 * time.Millisecond)
+		return CopyFile(src, dst)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	xc := XMLCfg{}
+	opts := BackupOptions{CreateLabelIfMissing: true}
+	err = BackupRunner(ctx, &xc, opts, 2, slowCopier, dirs[0], dirs[1], nil, nil, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("Expected the deadline to stop the backup, got", err)
+	}
+	cc := atomic.LoadUint32(&callCount)
+	if cc == 0 || cc >= numFiles {
+		t.Error("Expected the backup to stop part way through, copied", cc)
+	}
+	// The copies that were made are recorded at the destination
+	dmDst, err := DirectoryMapFromDir(dirs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded := dmDst.Len()
+	if _, ok := dmDst.Get(volumeLabelFileName); ok {
+		recorded--
+	}
+	if recorded != int(cc) {
+		t.Error("Expected", cc, "files recorded at the destination, got", recorded)
+	}
+}
+
+func TestBackupVerifyAfterCopy(t *testing.T) {
+	dirs, err := createTestBackupDirectories(5, 0)
+	if err != nil {
+		t.Fatal("Failed to create test Directories", err)
+	}
+	defer func() {
+		for i := range dirs {
+			os.RemoveAll(dirs[i])
+		}
+	}()
+	srcDir, destDir := dirs[0], dirs[1]
+	var lk sync.Mutex
+	var corrupted Fpath
+	corruptingCopier := func(src, dst Fpath) error {
+		if err := copyFileContents(string(src), string(dst), nil); err != nil {
+			return err
+		}
+		lk.Lock()
+		defer lk.Unlock()
+		if corrupted == "" {
+			corrupted = src
+			return os.WriteFile(string(dst), []byte("bit flipped in transit"), 0644)
+		}
+		return nil
+	}
+	xc := XMLCfg{}
+	opts := BackupOptions{CreateLabelIfMissing: true, VerifyAfterCopy: true}
+	err = BackupRunner(context.Background(), &xc, opts, 2, corruptingCopier, srcDir, destDir, nil, func(string) {}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	label, err := xc.getVolumeLabel(destDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dm, err := DirectoryMapFromDir(srcDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagged := 0
+	_ = dm.rangeMap(func(fn string, fs FileStruct) error {
+		if fs.HasTag(label) {
+			tagged++
+			if fs.Path() == corrupted {
+				t.Error("The corrupted copy was marked as backed up", fn)
+			}
+		}
+		return nil
+	})
+	if tagged != 4 {
+		t.Error("Expected the 4 good copies to be tagged, got", tagged)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, filepath.Base(string(corrupted)))); !os.IsNotExist(err) {
+		t.Error("The corrupted copy should have been removed", err)
+	}
+}
+
+func TestBackupDetectMoves(t *testing.T) {
+	const numberOfFiles = 4
+	dirs, err := createTestBackupDirectories(numberOfFiles, numberOfFiles)
+	if err != nil {
+		t.Fatal("Failed to create test Directories", err)
+	}
+	defer func() {
+		for i := range dirs {
+			os.RemoveAll(dirs[i])
+		}
+	}()
+	srcDir, destDir := dirs[0], dirs[1]
+	xc := XMLCfg{}
+	opts := BackupOptions{CreateLabelIfMissing: true, DetectMoves: true}
+	// Everything is already on the destination, so get it tagged
+	err = BackupRunner(context.Background(), &xc, opts, 2, CopyFile, srcDir, destDir, nil, func(string) {}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(srcDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var name string
+	for _, e := range entries {
+		if e.Name() != GetMetadataFilename() && !e.IsDir() {
+			name = e.Name()
+			break
+		}
+	}
+	movedDir := filepath.Join(srcDir, "moved")
+	if err := os.Mkdir(movedDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(srcDir, name), filepath.Join(movedDir, name)); err != nil {
+		t.Fatal(err)
+	}
+
+	var callCount uint32
+	fc := func(src, dst Fpath) error {
+		atomic.AddUint32(&callCount, 1)
+		return CopyFile(src, dst)
+	}
+	err = BackupRunner(context.Background(), &xc, opts, 2, fc, srcDir, destDir, nil, func(string) {}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cc := atomic.LoadUint32(&callCount); cc != 0 {
+		t.Error("Expected no copies, got", cc)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, name)); !os.IsNotExist(err) {
+		t.Error("The old copy is still there", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "moved", name)); err != nil {
+		t.Error("The copy was not moved", err)
+	}
+	dm, err := DirectoryMapFromDir(destDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dm.Get(name); ok {
+		t.Error("The old copy is still in the metadata")
+	}
+	dm, err = DirectoryMapFromDir(filepath.Join(destDir, "moved"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs, ok := dm.Get(name); !ok || fs.Checksum == "" {
+		t.Error("The moved copy is not in the metadata", fs)
+	}
+}
+
+func TestBackupDeletesOrphans(t *testing.T) {
+	dirs, err := createTestBackupDirectories(4, 0)
+	if err != nil {
+		t.Fatal("Failed to create test Directories", err)
+	}
+	defer func() {
+		for i := range dirs {
+			os.RemoveAll(dirs[i])
+		}
+	}()
+	srcDir, destDir := dirs[0], dirs[1]
+	xc := XMLCfg{}
+	opts := BackupOptions{CreateLabelIfMissing: true}
+	err = BackupRunner(context.Background(), &xc, opts, 2, CopyFile, srcDir, destDir, nil, func(string) {}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orphan := filepath.Join(destDir, "orphan")
+	if err := os.WriteFile(orphan, []byte("Only on the destination"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	errOrphan := errors.New("orphan error")
+	_, err = BackupRunnerWithReport(context.Background(), &xc, opts, 2, CopyFile, srcDir, destDir, func(path string) error {
+		return errOrphan
+	}, func(string) {}, nil)
+	if !errors.Is(err, errOrphan) {
+		t.Error("Expected the orphan callback's error, got", err)
+	}
+
+	var orphans []string
+	deleteFunc := func(path string) error {
+		orphans = append(orphans, path)
+		return os.Remove(path)
+	}
+	report, err := BackupRunnerWithReport(context.Background(), &xc, opts, 2, CopyFile, srcDir, destDir, deleteFunc, func(string) {}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 1 || orphans[0] != orphan {
+		t.Error("Expected to be told of the orphan, got", orphans)
+	}
+	if report.OrphansFound != 1 || report.OrphansDeleted != 1 {
+		t.Error("Expected one orphan found and deleted, got", report.OrphansFound, report.OrphansDeleted)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Error("The orphan is still there", err)
+	}
+}
@@ -0,0 +1,94 @@
+package medorg
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// ValidationError is a problem with a directory we have been asked to use
+type ValidationError struct {
+	Field   string // source or destination
+	Value   string
+	Message string
+}
+
+func (ve ValidationError) String() string {
+	return ve.Field + " " + ve.Value + ": " + ve.Message
+}
+
+// absPath is path made absolute, or as given if that fails
+func absPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	return abs
+}
+
+// checkWritable tries to create, then remove, a file in dir
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".medorg_validate")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// ValidateBackupDirs checks everything we can about the directories
+// of a backup before starting it, so all the problems are reported at once.
+// Sources must be directories, destinations writable directories,
+// and no directory may be inside another.
+// A readOnly run copies nothing, so its destinations need not be writable.
+func ValidateBackupDirs(srcDirs, destDirs []string, readOnly bool) []ValidationError {
+	var problems []ValidationError
+	checkDir := func(field, dir string) bool {
+		stat, err := os.Stat(dir)
+		switch {
+		case err != nil:
+			problems = append(problems, ValidationError{Field: field, Value: dir, Message: err.Error()})
+			return false
+		case !stat.IsDir():
+			problems = append(problems, ValidationError{Field: field, Value: dir, Message: "not a directory"})
+			return false
+		}
+		return true
+	}
+	for _, dir := range srcDirs {
+		checkDir("source", dir)
+	}
+	for _, dir := range destDirs {
+		if !checkDir("destination", dir) || readOnly {
+			continue
+		}
+		if err := checkWritable(dir); err != nil {
+			problems = append(problems, ValidationError{Field: "destination", Value: dir, Message: "not writable, " + err.Error()})
+		}
+	}
+
+	type entry struct{ field, dir, abs string }
+	var all []entry
+	for _, dir := range srcDirs {
+		all = append(all, entry{"source", dir, absPath(dir)})
+	}
+	for _, dir := range destDirs {
+		all = append(all, entry{"destination", dir, absPath(dir)})
+	}
+	for i, inner := range all {
+		for j, outer := range all {
+			if i == j || !isWithin(inner.abs, outer.abs) {
+				continue
+			}
+			if j > i && inner.abs == outer.abs {
+				// The same directory twice; report it once
+				continue
+			}
+			problems = append(problems, ValidationError{
+				Field:   inner.field,
+				Value:   inner.dir,
+				Message: "is within " + outer.field + " " + outer.dir,
+			})
+		}
+	}
+	return problems
+}
@@ -0,0 +1,74 @@
+package medorg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateBackupDirs(t *testing.T) {
+	wkDir := t.TempDir()
+	src := filepath.Join(wkDir, "src")
+	dst := filepath.Join(wkDir, "dst")
+	for _, dir := range []string{src, dst} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if problems := ValidateBackupDirs([]string{src}, []string{dst}, false); len(problems) != 0 {
+		t.Error("Expected no problems, got:", problems)
+	}
+
+	aFile := filepath.Join(wkDir, "file")
+	if err := os.WriteFile(aFile, []byte("file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(wkDir, "missing")
+	nested := filepath.Join(src, "backup")
+	if err := os.Mkdir(nested, 0755); err != nil {
+		t.Fatal(err)
+	}
+	problems := ValidateBackupDirs([]string{src, aFile}, []string{missing, nested}, false)
+	expected := map[string]string{
+		aFile:   "source",
+		missing: "destination",
+		nested:  "destination",
+	}
+	if len(problems) != len(expected) {
+		t.Fatal("Expected", len(expected), "problems, got:", problems)
+	}
+	for _, problem := range problems {
+		if expected[problem.Value] != problem.Field {
+			t.Error("Unexpected problem:", problem)
+		}
+	}
+
+	problems = ValidateBackupDirs([]string{src, src}, nil, false)
+	if len(problems) != 1 {
+		t.Error("Expected the repeated source once, got:", problems)
+	}
+}
+
+func TestValidateBackupDirsReadOnly(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can write anywhere")
+	}
+	wkDir := t.TempDir()
+	src := filepath.Join(wkDir, "src")
+	dst := filepath.Join(wkDir, "dst")
+	for _, dir := range []string{src, dst} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chmod(dst, 0555); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(dst, 0755)
+	if problems := ValidateBackupDirs([]string{src}, []string{dst}, false); len(problems) != 1 {
+		t.Error("Expected the destination to be unwritable, got:", problems)
+	}
+	if problems := ValidateBackupDirs([]string{src}, []string{dst}, true); len(problems) != 0 {
+		t.Error("A scan should not need to write, got:", problems)
+	}
+}
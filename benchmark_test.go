@@ -0,0 +1,204 @@
+package medorg
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// benchFile writes a file of size random bytes into a fresh directory
+func benchFile(b *testing.B, size int) (dir, fn string) {
+	b.Helper()
+	dir = b.TempDir()
+	fn = "bench.bin"
+	buf := make([]byte, size)
+	_, _ = rand.Read(buf)
+	if err := os.WriteFile(filepath.Join(dir, fn), buf, 0644); err != nil {
+		b.Fatal(err)
+	}
+	return dir, fn
+}
+
+func benchmarkCopyFile(b *testing.B, size int) {
+	srcDir, fn := benchFile(b, size)
+	src := NewFpath(srcDir, fn)
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// CopyFile would hard link within the temp directory,
+		// so time the copy it falls back to across file systems
+		dst := NewFpath(b.TempDir(), fn)
+		if err := copyFileContents(string(src), string(dst), nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkCopyFile_Throttled copies 100MB at 10MB/s, which must take at least 9s
+// (the first second's worth goes straight through)
+func BenchmarkCopyFile_Throttled(b *testing.B) {
+	const size = 100 << 20
+	srcDir, fn := benchFile(b, size)
+	src := NewFpath(srcDir, fn)
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dst := NewFpath(b.TempDir(), fn)
+		th := NewThrottle(10 << 20)
+		start := time.Now()
+		if err := copyFileContents(string(src), string(dst), func(n int64) { th.Wait(int(n)) }); err != nil {
+			b.Fatal(err)
+		}
+		if elapsed := time.Since(start); elapsed < 9*time.Second {
+			b.Fatal("Throttled copy took only", elapsed)
+		}
+	}
+}
+
+func BenchmarkCopyFile_1MB(b *testing.B)   { benchmarkCopyFile(b, 1<<20) }
+func BenchmarkCopyFile_100MB(b *testing.B) { benchmarkCopyFile(b, 100<<20) }
+
+// BenchmarkCopyFile_100MBWithCallback should be within 1% of BenchmarkCopyFile_100MB
+func BenchmarkCopyFile_100MBWithCallback(b *testing.B) {
+	const size = 100 << 20
+	srcDir, fn := benchFile(b, size)
+	src := NewFpath(srcDir, fn)
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dst := NewFpath(b.TempDir(), fn)
+		var calls int
+		if err := copyFileContents(string(src), string(dst), func(int64) { calls++ }); err != nil {
+			b.Fatal(err)
+		}
+		if calls < size/progressInterval {
+			b.Fatal("Only", calls, "progress callbacks")
+		}
+	}
+}
+
+func BenchmarkUpdateChecksumMD5_1MB(b *testing.B) {
+	const size = 1 << 20
+	dir, fn := benchFile(b, size)
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fs := FileStruct{directory: dir, Name: fn}
+		if err := fs.UpdateChecksum(true); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchDirectoryMap(dir string, entries int) *DirectoryMap {
+	dm := NewDirectoryMap()
+	for i := 0; i < entries; i++ {
+		dm.Add(FileStruct{Name: fmt.Sprint("file", i), Checksum: fmt.Sprint("cks", i), Size: int64(i), directory: dir})
+	}
+	return dm
+}
+
+func benchmarkDirectoryMapPersist(b *testing.B, entries int) {
+	dir := b.TempDir()
+	dm := benchDirectoryMap(dir, entries)
+	dm.opts.MaxEntries = 0
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Persist does nothing unless the map has changed
+		*dm.stale = true
+		if err := dm.Persist(dir); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDirectoryMapPersist_100Entries(b *testing.B) {
+	benchmarkDirectoryMapPersist(b, 100)
+}
+func BenchmarkDirectoryMapPersist_10000Entries(b *testing.B) {
+	benchmarkDirectoryMapPersist(b, 10000)
+}
+
+// BenchmarkDirectoryMapPersist_50000Entries checks that writing a large
+// directory doesn't need memory in proportion to the size of the xml
+func BenchmarkDirectoryMapPersist_50000Entries(b *testing.B) {
+	const maxAlloc = 50 << 20
+	dir := b.TempDir()
+	dm := benchDirectoryMap(dir, 50000)
+	dm.opts.MaxEntries = 0
+	var before, after runtime.MemStats
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		*dm.stale = true
+		runtime.ReadMemStats(&before)
+		if err := dm.Persist(dir); err != nil {
+			b.Fatal(err)
+		}
+		runtime.ReadMemStats(&after)
+		// Everything allocated, freed or not, bounds how much more we needed at peak
+		allocated := after.TotalAlloc - before.TotalAlloc
+		if allocated > maxAlloc {
+			b.Fatal("Persist allocated", allocated, "bytes, more than", maxAlloc)
+		}
+		b.ReportMetric(float64(allocated)/(1<<20), "MB/persist")
+	}
+}
+
+func BenchmarkDirectoryMapFromDir_10000Entries(b *testing.B) {
+	oldWarning := MaxEntriesWarning
+	defer func() { MaxEntriesWarning = oldWarning }()
+	MaxEntriesWarning = 0
+	dir := b.TempDir()
+	if err := benchDirectoryMap(dir, 10000).Persist(dir); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := DirectoryMapFromDir(dir); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBackupRunner_100Files(b *testing.B) {
+	benchmarkBackupRunner(b, false)
+}
+
+// Compare with BenchmarkBackupRunner_100Files for the cost of verifying
+func BenchmarkBackupRunner_100FilesVerified(b *testing.B) {
+	benchmarkBackupRunner(b, true)
+}
+
+func benchmarkBackupRunner(b *testing.B, verify bool) {
+	const numFiles = 100
+	srcDir := b.TempDir()
+	buf := make([]byte, 4096)
+	for i := 0; i < numFiles; i++ {
+		_, _ = rand.Read(buf)
+		if err := os.WriteFile(filepath.Join(srcDir, fmt.Sprint("file", i)), buf, 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	logFunc := func(string) {}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		// Each run backs up to an empty destination, and forgets the last one
+		destDir := b.TempDir()
+		if err := os.Remove(filepath.Join(srcDir, GetMetadataFilename())); err != nil && !os.IsNotExist(err) {
+			b.Fatal(err)
+		}
+		xc := XMLCfg{}
+		opts := BackupOptions{CreateLabelIfMissing: true, VerifyAfterCopy: verify}
+		b.StartTimer()
+		err := BackupRunner(context.Background(), &xc, opts, 2, CopyFile, srcDir, destDir, nil, logFunc, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package medorg
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// Version and Commit are set at build time with
+// -ldflags "-X github.com/cbehopkins/medorg.Version=... -X github.com/cbehopkins/medorg.Commit=..."
+// See the Makefile
+var (
+	Version = "dev"
+	Commit  = ""
+)
+
+// VersionString describes the build of the named tool
+func VersionString(tool string) string {
+	commit := Commit
+	if commit == "" {
+		// Fall back to what go build recorded, if anything
+		if bi, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range bi.Settings {
+				if setting.Key == "vcs.revision" {
+					commit = setting.Value
+				}
+			}
+		}
+	}
+	if commit == "" {
+		commit = "unknown"
+	}
+	return fmt.Sprintf("%s %s (commit %s)", tool, Version, commit)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// errorRecord is a single line in the error log
+type errorRecord struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// errorLog records the files we failed to process
+// so that we can come back and retry just those
+type errorLog struct {
+	sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+func newErrorLog(fn string) (*errorLog, error) {
+	f, err := os.Create(fn)
+	if err != nil {
+		return nil, err
+	}
+	return &errorLog{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Record an error against a path
+func (el *errorLog) Record(path string, err error) error {
+	el.Lock()
+	defer el.Unlock()
+	return el.enc.Encode(errorRecord{Path: path, Error: err.Error()})
+}
+
+func (el *errorLog) Close() error {
+	return el.f.Close()
+}
+
+// loadErrorLog reads in a previously written error log
+// returning the files to retry, grouped by directory
+func loadErrorLog(fn string) (map[string][]string, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	retries := make(map[string][]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec errorRecord
+		err := json.Unmarshal(scanner.Bytes(), &rec)
+		if err != nil {
+			return nil, err
+		}
+		dir, file := filepath.Split(rec.Path)
+		dir = filepath.Clean(dir)
+		retries[dir] = append(retries[dir], file)
+	}
+	return retries, scanner.Err()
+}
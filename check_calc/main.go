@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"io/fs"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/cbehopkins/medorg"
+	bytesize "github.com/inhies/go-bytesize"
 )
 
 func isDir(fn string) bool {
@@ -18,6 +24,51 @@ func isDir(fn string) bool {
     }
     return stat.IsDir()
 }
+
+// retryDirectory runs the visitor on just the requested files in a directory
+// rather than walking the whole tree
+func retryDirectory(dir string, files []string, mdOpts medorg.MetadataOptions, visitor func(dm medorg.DirectoryMap, directory, file string, d fs.DirEntry) error) error {
+	dm, err := medorg.DirectoryMapFromDirWithOptions(dir, "", mdOpts)
+	if err != nil {
+		return err
+	}
+	err = dm.DeleteMissingFiles()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		info, err := os.Stat(filepath.Join(dir, file))
+		if errors.Is(err, os.ErrNotExist) {
+			// Gone since we last looked
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = visitor(dm, dir, file, fs.FileInfoToDirEntry(info))
+		if err != nil {
+			return err
+		}
+	}
+	return dm.Persist(dir)
+}
+
+// parseExcludes splits a comma separated list of globs, checking each is valid
+func parseExcludes(str string) ([]string, error) {
+	var patterns []string
+	for _, pattern := range strings.Split(str, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%w::%s", err, pattern)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
 func main() {
 	var directories []string
 
@@ -28,10 +79,95 @@ func main() {
 	var mvdflg = flag.Bool("mvd", false, "Move Detect")
 	var rnmflg = flag.Bool("rename", false, "Auto Rename Files")
 	var rclflg = flag.Bool("recalc", false, "Recalculate all checksums")
+	var onlymissingflg = flag.Bool("only-missing", false, "Only calculate checksums that have never been calculated, without checking for changed files")
 	var valflg = flag.Bool("validate", false, "Validate all checksums")
+	var algoflg = flag.String("algo", medorg.ChecksumMD5.String(), "Checksums to calculate: md5, sha256 or both. The md5 is always calculated")
 
 	var conflg = flag.Bool("conc", false, "Concentrate files together in same directory")
+	var errlogflg = flag.String("error-log", "", "Record files we fail to process in this file and carry on")
+	var symlinkflg = flag.String("symlinks", medorg.DefaultDirTrackerOptions().SymlinkPolicy.String(), "What to do with symlinks to directories: follow, skip or report")
+	var scanorderflg = flag.String("scan-order", medorg.DefaultDirTrackerOptions().ScanOrder.String(), "Order to walk directories in: name, or mtime-desc for the most recently modified first")
+	var excludeflg = flag.String("exclude", "", "Comma separated globs of file names not to checksum, e.g. .DS_Store,Thumbs.db,*.tmp")
+	var maxdepthflg = flag.Int("max-depth", medorg.DefaultDirTrackerOptions().MaxDepth, "Only walk this many directories down, 0 for just the directories given; negative for no limit")
+	var excludedirflg = flag.String("exclude-dir", "", "Comma separated globs of directory names not to descend into")
+	var ignoresizeflg = flag.Bool("ignore-size-limit", false, "Allow directories with more than "+fmt.Sprint(medorg.DefaultMaxEntries)+" files to be updated")
+	var strictflg = flag.Bool("strict", false, "Stop on warnings too, such as permission denied, files vanishing or unreadable metadata")
+	var collectflg = flag.Bool("collect-errors", false, "Carry on walking after an error, and report them all at the end")
+	var maxerrorsflg = flag.Int("max-errors", 100, "With -collect-errors, give up after this many errors")
+	var retryflg = flag.String("retry-errors", "", "Only process the files listed in a previous error log")
+	var timeoutflg = flag.Duration("file-timeout", 0, "Give up on the checksum of any file that takes longer than this, e.g. on a hung network mount")
+	var migrateflg = flag.Bool("migrate-xml", false, "Rewrite every metadata file in the current format, then stop")
+	var exportcsvflg = flag.String("export-csv", "", "Once the walk is finished, write the details of every file to this csv file")
+	var snapshotflg = flag.String("snapshot", "", "Once the walk is finished, save the metadata of every file to this json file")
+	var diffflg = flag.Bool("diff", false, "With -snapshot, first print what has changed since the snapshot already there was saved")
+	var verboseflg = flag.Bool("v", false, "Print statistics about the walk when finished")
+	var versionflg = flag.Bool("version", false, "Print version")
+	var metadataflg = flag.String("metadata-file", medorg.GetMetadataFilename(), "Name of the file in each directory the metadata is kept in")
 	flag.Parse()
+	if *versionflg {
+		fmt.Println(medorg.VersionString("check_calc"))
+		return
+	}
+	if err := medorg.SetMetadataFilename(*metadataflg); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	var xc *medorg.XMLCfg
+	if xmcf := medorg.XmConfig(); xmcf != "" {
+		// FIXME should we be casting to string here or fixing the interfaces?
+		xc = medorg.NewXMLCfg(string(xmcf))
+	} else {
+		fn := filepath.Join(string(medorg.HomeDir()), medorg.Md5FileName)
+		xc = medorg.NewXMLCfg(fn)
+	}
+	if *diffflg && *snapshotflg == "" {
+		fmt.Println("-diff needs -snapshot to say where the previous snapshot is")
+		os.Exit(1)
+	}
+	if *onlymissingflg && (*rclflg || *valflg || *scrubflg) {
+		fmt.Println("-only-missing cannot be used with -recalc, -validate or -scrub")
+		os.Exit(1)
+	}
+	walkOpts := medorg.DefaultDirTrackerOptions()
+	walkOpts.SplitWarnings = !*strictflg
+	walkOpts.MaxDepth = *maxdepthflg
+	walkOpts.ContinueOnError = *collectflg
+	walkOpts.Metadata = xc.MetadataOptions()
+	if *ignoresizeflg {
+		walkOpts.Metadata.MaxEntries = 0
+	}
+	if sp, err := medorg.ParseSymlinkPolicy(*symlinkflg); err == nil {
+		walkOpts.SymlinkPolicy = sp
+	} else {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	algo, err := medorg.ParseChecksumAlgo(*algoflg)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if so, err := medorg.ParseScanOrder(*scanorderflg); err == nil {
+		walkOpts.ScanOrder = so
+	} else {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	excludes, err := parseExcludes(*excludeflg)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	excludeDirs, err := parseExcludes(*excludedirflg)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	walkOpts.IgnorePatterns = append(walkOpts.IgnorePatterns, xc.IgnorePatterns...)
+	walkOpts.IgnorePatterns = append(walkOpts.IgnorePatterns, excludes...)
+	for _, pattern := range excludeDirs {
+		walkOpts.IgnorePatterns = append(walkOpts.IgnorePatterns, pattern+"/")
+	}
 	if flag.NArg() > 0 {
 		for _, fl := range flag.Args() {
 			if isDir(fl) {
@@ -42,23 +178,27 @@ func main() {
 		directories = []string{"."}
 	}
 
+	if *migrateflg {
+		count, err := medorg.MigrateMetadata(directories, walkOpts.Metadata)
+		if err != nil {
+			fmt.Println("Error migrating metadata", err)
+			os.Exit(7)
+		}
+		fmt.Println("Rewrote", count, "metadata files as version", medorg.MetadataVersion)
+		return
+	}
+
 	var AF *medorg.AutoFix
 	if *rnmflg {
-		var xc *medorg.XMLCfg
-		if xmcf := medorg.XmConfig(); xmcf != "" {
-			// FIXME should we be casting to string here or fixing the interfaces?
-			xc = medorg.NewXMLCfg(string(xmcf))
-		} else {
+		if medorg.XmConfig() == "" {
 			fmt.Println("no config file found")
-			fn := filepath.Join(string(medorg.HomeDir()), medorg.Md5FileName)
-			xc = medorg.NewXMLCfg(fn)
 		}
 		AF = medorg.NewAutoFix(xc.Af)
 		AF.DeleteFiles = *delflg
 	}
 
 	if *mvdflg {
-		err := medorg.RunMoveDetect(directories)
+		err := medorg.RunMoveDetectWithOptions(directories, walkOpts.Metadata)
 		if err != nil {
 			fmt.Println("Error! In move detect", err)
 			os.Exit(4)
@@ -66,8 +206,34 @@ func main() {
 		fmt.Println("Finished move detection")
 	}
 
+	// Load the retries before we (possibly) overwrite the same file with new errors
+	var retries map[string][]string
+	if *retryflg != "" {
+		var err error
+		retries, err = loadErrorLog(*retryflg)
+		if err != nil {
+			fmt.Println("Unable to read error log", err)
+			os.Exit(5)
+		}
+	}
+	var errLog *errorLog
+	if *errlogflg != "" {
+		var err error
+		errLog, err = newErrorLog(*errlogflg)
+		if err != nil {
+			fmt.Println("Unable to create error log", err)
+			os.Exit(5)
+		}
+		defer errLog.Close()
+	}
+
 	var con *medorg.Concentrator
 
+	// On Ctrl-C stop hashing as soon as we can, rather than finishing
+	// whatever huge file we happen to be in the middle of
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
 	// Have a buffer of compute tokens
 	// to ensure we're not doing too much at once
 	tokenBuffer := make(chan struct{}, *calcCnt)
@@ -76,12 +242,21 @@ func main() {
 		tokenBuffer <- struct{}{}
 	}
 
+	// Hard linked files only need their checksum calculating once
+	checksumCache := medorg.NewChecksumCache()
+	checksumCache.Timeout = *timeoutflg
+
 	visitor := func(dm medorg.DirectoryMap, directory, file string, d fs.DirEntry) error {
-		if file == medorg.Md5FileName {
+		if medorg.IsMedorgFile(file) {
 			return nil
 		}
 
 		fc := func(fs *medorg.FileStruct) error {
+			missing := (algo.UsesMD5() && fs.Checksum == "") || (algo.UsesSHA256() && fs.Checksum256 == "")
+			if *onlymissingflg && !missing {
+				// Don't even look to see if it has changed
+				return nil
+			}
 			info, err := d.Info()
 			if err != nil {
 				return err
@@ -108,7 +283,7 @@ func main() {
 				return err
 			}
 
-			if !(changed || *rclflg || fs.Checksum == "") {
+			if !(changed || *rclflg || missing) {
 				// if we have no reason to recalculate
 				return nil
 			}
@@ -117,14 +292,26 @@ func main() {
 			// Grab a compute token
 			<-tokenBuffer
 			defer func() { tokenBuffer <- struct{}{} }()
-			err = fs.UpdateChecksum(*rclflg)
+			if algo.UsesMD5() {
+				err = checksumCache.UpdateChecksum(ctx, fs, info, *rclflg)
+			}
+			if err == nil && algo.UsesSHA256() {
+				err = checksumCache.UpdateChecksum256(ctx, fs, *rclflg)
+			}
 			if errors.Is(err, medorg.ErrIOError) {
 				fmt.Println("Received an IO error calculating checksum ", fs.Name, err)
 				return nil
 			}
+			if errors.Is(err, medorg.ErrChecksumTimeout) {
+				fmt.Println("Gave up calculating checksum ", fs.Name, err)
+				return nil
+			}
 			return err
 		}
 		err := dm.RunFsFc(directory, file, fc)
+		if err != nil && errLog != nil {
+			return errLog.Record(filepath.Join(directory, file), err)
+		}
 		if err != nil {
 			return err
 		}
@@ -137,35 +324,145 @@ func main() {
 		return err
 	}
 
-	makerFunc := func(dir string) (medorg.DirectoryTrackerInterface, error) {
-		mkFk := func(dir string) (medorg.DirectoryEntryInterface, error) {
-			dm, err := medorg.DirectoryMapFromDir(dir)
-			if err != nil {
-				return dm, err
-			}
-			dm.VisitFunc = visitor
-			if con != nil {
-				err := con.DirectoryVisit(dm, dir)
+	makerFunc := func(root string) func(dir string) (medorg.DirectoryTrackerInterface, error) {
+		return func(dir string) (medorg.DirectoryTrackerInterface, error) {
+			mkFk := func(dir string) (medorg.DirectoryEntryInterface, error) {
+				dm, err := medorg.DirectoryMapFromDirWithOptions(dir, root, walkOpts.Metadata)
 				if err != nil {
-					fmt.Println("Received error from concentrate", err)
-					os.Exit(3)
+					return dm, err
 				}
+				dm.VisitFunc = visitor
+				if con != nil {
+					err := con.DirectoryVisit(dm, dir)
+					if err != nil {
+						fmt.Println("Received error from concentrate", err)
+						os.Exit(3)
+					}
+				}
+				// Drop the files the walk now ignores, as it won't visit them
+				if err := dm.DeleteIgnoredFiles(root, dir, walkOpts.IgnorePatterns); err != nil {
+					return dm, err
+				}
+				return dm, dm.DeleteMissingFiles()
 			}
-			return dm, dm.DeleteMissingFiles()
+			de, err := medorg.NewDirectoryEntry(dir, mkFk)
+			return de, err
 		}
-		de, err := medorg.NewDirectoryEntry(dir, mkFk)
-		return de, err
 	}
+	if retries != nil {
+		for dir, files := range retries {
+			err := retryDirectory(dir, files, walkOpts.Metadata, visitor)
+			if err != nil {
+				fmt.Println("Error received while retrying:", dir, err)
+				os.Exit(2)
+			}
+		}
+		fmt.Println("Finished retrying")
+		return
+	}
+	errs := medorg.ErrorCollector{Max: *maxerrorsflg}
+	var stats medorg.WalkStats
+	startTime := time.Now()
 	for _, dir := range directories {
 		if *conflg {
 			con = &medorg.Concentrator{BaseDir: dir}
 		}
-		errChan := medorg.NewDirTracker(false, dir, makerFunc).ErrChan()
+		dt := medorg.NewDirTrackerWithOptions(ctx, false, dir, makerFunc(dir), walkOpts)
+		warnDone := make(chan struct{})
+		go func() {
+			defer close(warnDone)
+			for err := range dt.WarnChan() {
+				fmt.Println("Warning while walking:", dir, err)
+			}
+		}()
 
-		for err := range errChan {
+		for err := range dt.ErrChan() {
+			if errors.Is(err, context.Canceled) {
+				fmt.Println("Interrupted while walking:", dir)
+				os.Exit(2)
+			}
 			fmt.Println("Error received while walking:", dir, err)
-			os.Exit(2)
+			if !*collectflg {
+				os.Exit(2)
+			}
+			if errs.Add(fmt.Errorf("%s::%w", dir, err)) != nil {
+				fmt.Println("Too many errors, giving up:")
+				fmt.Println(errs.Err())
+				os.Exit(2)
+			}
 		}
+		<-warnDone
+		stats = stats.Add(dt.Stats())
+	}
+	// The directories are walked one after the other
+	stats.Elapsed = time.Since(startTime)
+	if *verboseflg {
+		fmt.Println(stats)
+		fmt.Println("Visited", bytesize.New(float64(stats.BytesVisited)), "of files")
+	}
+	if len(stats.PermissionErrors) > 0 {
+		fmt.Println("Skipped", len(stats.PermissionErrors), "paths due to permission errors")
+	}
+	if errs.Len() > 0 {
+		fmt.Println("Finished walking, with", errs.Len(), "errors:")
+		fmt.Println(errs.Err())
+		os.Exit(2)
 	}
 	fmt.Println("Finished walking")
+	if *exportcsvflg != "" {
+		fh, err := os.Create(*exportcsvflg)
+		if err == nil {
+			err = medorg.ExportDirectoriesCSV(fh, directories, walkOpts.Metadata)
+			if cerr := fh.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			fmt.Println("Unable to export csv:", err)
+			os.Exit(6)
+		}
+	}
+	if *snapshotflg != "" {
+		if *diffflg {
+			diffs, err := medorg.DiffSnapshot(*snapshotflg, directories, walkOpts.Metadata)
+			if errors.Is(err, os.ErrNotExist) {
+				fmt.Println("No snapshot to compare with yet")
+			} else if err != nil {
+				fmt.Println("Unable to compare with snapshot:", err)
+				os.Exit(6)
+			} else {
+				printDiffs(diffs)
+			}
+		}
+		if err := medorg.TakeSnapshot(directories, *snapshotflg, walkOpts.Metadata); err != nil {
+			fmt.Println("Unable to save snapshot:", err)
+			os.Exit(6)
+		}
+	}
+}
+
+// printDiffs prints what has changed in each directory, in directory order
+func printDiffs(diffs map[string]medorg.DirectoryMapDiff) {
+	dirs := make([]string, 0, len(diffs))
+	for dir := range diffs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	var added, removed, modified int
+	for _, dir := range dirs {
+		diff := diffs[dir]
+		for _, fs := range diff.Added {
+			fmt.Println("Added:", filepath.Join(dir, fs.Name))
+		}
+		for _, fs := range diff.Removed {
+			fmt.Println("Removed:", filepath.Join(dir, fs.Name))
+		}
+		for _, fs := range diff.Modified {
+			fmt.Println("Modified:", filepath.Join(dir, fs.Name))
+		}
+		added += len(diff.Added)
+		removed += len(diff.Removed)
+		modified += len(diff.Modified)
+	}
+	fmt.Println(added, "added,", removed, "removed,", modified, "modified")
 }
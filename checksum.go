@@ -1,9 +1,12 @@
 package medorg
 
 import (
+	"context"
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"hash"
 	"io"
 	"log"
@@ -20,7 +23,8 @@ var Debug bool
 
 type DirectoryMapMod func(DirectoryMap, string)
 
-// Md5FileName is the filename we use to save the data in
+// Md5FileName is the default filename we use to save the data in
+// Use GetMetadataFilename for the name actually in use
 const Md5FileName = ".medorg.xml"
 
 //ErrSkipCheck Reports a checksum that we have skipped producing
@@ -103,19 +107,98 @@ func ReturnChecksumString(h hash.Hash) string {
 
 // CalcMd5File calculates the checksum for a specified filename
 func CalcMd5File(directory, fn string) (string, error) {
+	return CalcMd5FileCtx(context.Background(), directory, fn)
+}
+
+// cancelCheckInterval is how many bytes we hash between checking for cancellation
+const cancelCheckInterval = 8 << 20
+
+// CalcMd5FileCtx calculates the checksum for a specified filename
+// giving up with ctx.Err() if the context is cancelled part way through
+func CalcMd5FileCtx(ctx context.Context, directory, fn string) (string, error) {
+	return calcFileHashCtx(ctx, md5.New(), directory, fn)
+}
+
+// CalcSha256File calculates the SHA-256 checksum for a specified filename
+func CalcSha256File(directory, fn string) (string, error) {
+	return CalcSha256FileCtx(context.Background(), directory, fn)
+}
+
+// CalcSha256FileCtx is CalcSha256File, but gives up if the context is cancelled
+func CalcSha256FileCtx(ctx context.Context, directory, fn string) (string, error) {
+	return calcFileHashCtx(ctx, sha256.New(), directory, fn)
+}
+
+func calcFileHashCtx(ctx context.Context, h hash.Hash, directory, fn string) (string, error) {
 	fp := filepath.Join(directory, fn)
 	f, err := os.Open(fp)
 	if err != nil {
 		return "", err
 	}
 	defer func() { _ = f.Close() }()
-	h := md5.New()
-	if _, err := io.Copy(h, f); err != nil {
-		return "", err
+	for {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		_, err := io.CopyN(h, f, cancelCheckInterval)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
 	}
 	return ReturnChecksumString(h), nil
 }
 
+// ChecksumAlgo says which checksums we calculate for a file
+type ChecksumAlgo int
+
+const (
+	// ChecksumMD5 is the checksum everything else relies on
+	ChecksumMD5 ChecksumAlgo = iota
+	// ChecksumSHA256 adds the SHA-256. The MD5 is still calculated,
+	// as backups and duplicate detection are keyed on it
+	ChecksumSHA256
+	// ChecksumBoth calculates the MD5 and the SHA-256
+	ChecksumBoth
+)
+
+// ErrBadChecksumAlgo the algorithm string is not one we understand
+var ErrBadChecksumAlgo = errors.New("unknown checksum algorithm")
+
+func (ca ChecksumAlgo) String() string {
+	switch ca {
+	case ChecksumSHA256:
+		return "sha256"
+	case ChecksumBoth:
+		return "both"
+	default:
+		return "md5"
+	}
+}
+
+// UsesMD5 reports if the MD5 is calculated
+// It always is, a file without one would never be backed up
+func (ca ChecksumAlgo) UsesMD5() bool {
+	return true
+}
+
+// UsesSHA256 reports if the SHA-256 is calculated
+func (ca ChecksumAlgo) UsesSHA256() bool {
+	return ca != ChecksumMD5
+}
+
+// ParseChecksumAlgo turns "md5", "sha256" or "both" into an algorithm
+func ParseChecksumAlgo(str string) (ChecksumAlgo, error) {
+	for _, ca := range []ChecksumAlgo{ChecksumMD5, ChecksumSHA256, ChecksumBoth} {
+		if ca.String() == str {
+			return ca, nil
+		}
+	}
+	return ChecksumMD5, fmt.Errorf("%w::%s", ErrBadChecksumAlgo, str)
+}
+
 // Calculator is useful where we get streams of bytes in (e.g. from the network)
 // We expose an io.Writer
 // close the trigger chanel then wait for the writes to finish
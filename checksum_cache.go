@@ -0,0 +1,83 @@
+package medorg
+
+import (
+	"context"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// inodeKey identifies a file's contents, however many names it has
+// On windows it is the volume serial number and file index
+type inodeKey struct {
+	dev uint64
+	ino uint64
+}
+
+type checksumCacheEntry struct {
+	size     int64
+	mtime    int64
+	checksum string
+}
+
+// ChecksumCache remembers the checksums calculated during a run by inode,
+// so a file hard linked into several directories is only read once.
+// Only use one for a single walk, it is not safe to persist
+// as inodes get reused.
+type ChecksumCache struct {
+	lock sync.Mutex
+	mp   map[inodeKey]checksumCacheEntry
+	// Timeout, if set, is the longest we spend calculating any one checksum
+	Timeout time.Duration
+}
+
+// NewChecksumCache returns an empty cache
+func NewChecksumCache() *ChecksumCache {
+	return &ChecksumCache{mp: make(map[inodeKey]checksumCacheEntry)}
+}
+
+func (cc *ChecksumCache) get(key inodeKey, size, mtime int64) (string, bool) {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	entry, ok := cc.mp[key]
+	if !ok || entry.size != size || entry.mtime != mtime {
+		return "", false
+	}
+	return entry.checksum, true
+}
+
+func (cc *ChecksumCache) add(key inodeKey, size, mtime int64, checksum string) {
+	cc.lock.Lock()
+	cc.mp[key] = checksumCacheEntry{size: size, mtime: mtime, checksum: checksum}
+	cc.lock.Unlock()
+}
+
+// UpdateChecksum is FileStruct.UpdateChecksumCtx, except that if we have
+// already calculated the checksum of the same inode, with the same size
+// and mtime, we use that rather than reading the file again.
+func (cc *ChecksumCache) UpdateChecksum(ctx context.Context, fs *FileStruct, info fs.FileInfo, forceUpdate bool) error {
+	if !forceUpdate && fs.Checksum != "" {
+		return nil
+	}
+	key, ok := inodeKeyOf(string(fs.Path()), info)
+	if !ok {
+		return fs.updateChecksumTimeout(ctx, cc.Timeout, forceUpdate)
+	}
+	size, mtime := info.Size(), info.ModTime().Unix()
+	if cks, ok := cc.get(key, size, mtime); ok {
+		fs.setChecksum(cks)
+		return nil
+	}
+	err := fs.updateChecksumTimeout(ctx, cc.Timeout, forceUpdate)
+	if err != nil {
+		return err
+	}
+	cc.add(key, size, mtime, fs.Checksum)
+	return nil
+}
+
+// UpdateChecksum256 is FileStruct.UpdateChecksum256Ctx, giving up after the cache's Timeout
+// SHA-256 checksums are not cached
+func (cc *ChecksumCache) UpdateChecksum256(ctx context.Context, fs *FileStruct, forceUpdate bool) error {
+	return fs.updateChecksum256Timeout(ctx, cc.Timeout, forceUpdate)
+}
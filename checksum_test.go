@@ -1,12 +1,15 @@
 package medorg
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/xml"
+	"errors"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"testing"
 )
 
@@ -61,8 +64,8 @@ func makeFile(directory string) string {
 func TestMd5(t *testing.T) {
 	// Check the MD5 creation mechanism
 
-	tmp_filename := makeFile(".")
-	defer os.Remove(tmp_filename) // clean up
+	wkDir := t.TempDir()
+	tmp_filename := makeFile(wkDir)
 
 	// So get us a channel to send the files to be md5'd to
 	// This returns 2 channels, one that files to be checked should be sent to
@@ -75,7 +78,7 @@ func TestMd5(t *testing.T) {
 	// In the final application this will be the only thing that can update the xml files
 	wg := newXMLManager(toUpdateXML)
 
-	toMd5Chan <- FileStruct{Name: tmp_filename, directory: "."}
+	toMd5Chan <- FileStruct{Name: filepath.Base(tmp_filename), directory: wkDir}
 	log.Println("Sent the file to check")
 	close(toMd5Chan)
 	log.Println("Waiting for channel to close")
@@ -86,16 +89,20 @@ func TestMd5(t *testing.T) {
 }
 func TestSelfCompat(t *testing.T) {
 	fileToUse := "checksum_test.go"
-	_ = md5FileWrite(".", nil)
+	// Work on a copy, so as not to leave metadata in the source tree
+	wkDir := t.TempDir()
+	if err := copyFileContents(fileToUse, filepath.Join(wkDir, fileToUse), nil); err != nil {
+		t.Fatal(err)
+	}
 
 	dm := *NewDirectoryMap()
 	toMd5Chan, toUpdateXML, closedChan := NewChannels()
 	wg := newXMLManager(toUpdateXML)
-	toMd5Chan <- FileStruct{Name: fileToUse, directory: "."}
+	toMd5Chan <- FileStruct{Name: fileToUse, directory: wkDir}
 	close(toMd5Chan)
 	<-closedChan
 	wg.Wait()
-	dm, err := DirectoryMapFromDir(".")
+	dm, err := DirectoryMapFromDir(wkDir)
 	if err != nil {
 		t.Error(err)
 	}
@@ -115,8 +122,8 @@ func TestPerlCompat(t *testing.T) {
 	}
 
 	fileToUse := "checksum_test.go"
-	if _, err := os.Stat("./" + Md5FileName); os.IsExist(err) {
-		_ = os.Remove("./" + Md5FileName)
+	if _, err := os.Stat("./" + GetMetadataFilename()); os.IsExist(err) {
+		_ = os.Remove("./" + GetMetadataFilename())
 	}
 	log.Println("Running Command", perlScript)
 	cmd := exec.Command(perlScript, ".")
@@ -138,7 +145,7 @@ func TestPerlCompat(t *testing.T) {
 	if checksum == "" {
 		t.Error("Missing Checksum from perl version")
 	}
-	_ = os.Remove("./" + Md5FileName)
+	_ = os.Remove("./" + GetMetadataFilename())
 	dm = *NewDirectoryMap()
 	toMd5Chan, toUpdateXML, closedChan := NewChannels()
 	wg := newXMLManager(toUpdateXML)
@@ -162,3 +169,124 @@ func TestPerlCompat(t *testing.T) {
 		t.Error("checksums don't tally. New:", newChecksum, " Old:", checksum)
 	}
 }
+
+func TestUpdateChecksumCtxCancelled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cksCtx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Base(makeFile(dir))
+
+	fs := FileStruct{directory: dir, Name: fn, Checksum: "old"}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = fs.UpdateChecksumCtx(ctx, true)
+	if !errors.Is(err, context.Canceled) {
+		t.Error("Expected a cancelled error, got", err)
+	}
+	if fs.Checksum != "old" {
+		t.Error("Checksum should not change when cancelled, got", fs.Checksum)
+	}
+
+	err = fs.UpdateChecksumCtx(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := CalcMd5File(dir, fn)
+	if fs.Checksum != expected {
+		t.Error("Expected", expected, "got", fs.Checksum)
+	}
+}
+
+func TestChecksumCacheHardLinks(t *testing.T) {
+	wkDir, err := os.MkdirTemp("", "cksCache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wkDir)
+	fileA := filepath.Join(wkDir, "a")
+	fileB := filepath.Join(wkDir, "b")
+	if err := os.WriteFile(fileA, []byte("some content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(fileA, fileB); err != nil {
+		t.Skip("Hard links not supported:", err)
+	}
+	info, err := os.Stat(fileB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyB, ok := inodeKeyOf(fileB, info)
+	if !ok {
+		t.Skip("No inodes on this platform")
+	}
+	if keyA, _ := inodeKeyOf(fileA, info); keyA != keyB {
+		t.Error("Both names should have the same key", keyA, keyB)
+	}
+
+	cc := NewChecksumCache()
+	fsA := FileStruct{Name: "a", directory: wkDir}
+	if err := cc.UpdateChecksum(context.Background(), &fsA, info, false); err != nil {
+		t.Fatal(err)
+	}
+	// So looking up b finds what was calculated for a
+	cks, ok := cc.get(keyB, info.Size(), info.ModTime().Unix())
+	if !ok {
+		t.Fatal("Expected a cache hit")
+	}
+	if fsA.Checksum == "" || fsA.Checksum != cks {
+		t.Error("Checksums should match", fsA.Checksum, cks)
+	}
+}
+
+func TestChecksumCacheClearsStaleSha256(t *testing.T) {
+	wkDir := t.TempDir()
+	fileA := filepath.Join(wkDir, "a")
+	fileB := filepath.Join(wkDir, "b")
+	if err := os.WriteFile(fileA, []byte("new content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(fileA, fileB); err != nil {
+		t.Skip("Hard links not supported:", err)
+	}
+	info, err := os.Stat(fileB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := inodeKeyOf(fileB, info); !ok {
+		t.Skip("No inodes on this platform")
+	}
+
+	cc := NewChecksumCache()
+	fsA := FileStruct{Name: "a", directory: wkDir}
+	if err := cc.UpdateChecksum(context.Background(), &fsA, info, false); err != nil {
+		t.Fatal(err)
+	}
+	// b's record is from before the contents changed
+	fsB := FileStruct{
+		Name:        "b",
+		directory:   wkDir,
+		Checksum:    "stale",
+		Checksum256: "stale256",
+		BackupDest:  []string{"vol"},
+	}
+	if err := cc.UpdateChecksum(context.Background(), &fsB, info, true); err != nil {
+		t.Fatal(err)
+	}
+	if fsB.Checksum != fsA.Checksum {
+		t.Error("Expected", fsA.Checksum, "got", fsB.Checksum)
+	}
+	if fsB.Checksum256 != "" {
+		t.Error("The SHA-256 of the old contents should be cleared, got", fsB.Checksum256)
+	}
+	if len(fsB.BackupDest) != 0 {
+		t.Error("Backups of the old contents should be cleared, got", fsB.BackupDest)
+	}
+	if err := fsB.UpdateChecksum256(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsB.ValidateChecksum(); err != nil {
+		t.Error("Expected a valid checksum, got", err)
+	}
+}
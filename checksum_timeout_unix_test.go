@@ -0,0 +1,72 @@
+//go:build !windows
+
+package medorg
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestUpdateChecksumWithTimeout(t *testing.T) {
+	wkDir, err := os.MkdirTemp("", "cksTimeout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wkDir)
+	// Opening a fifo blocks until someone writes to it, just like a hung mount
+	fifo := filepath.Join(wkDir, "hung")
+	if err := syscall.Mkfifo(fifo, 0644); err != nil {
+		t.Skip("Unable to make a fifo:", err)
+	}
+
+	fs := FileStruct{Name: "hung", Checksum: "old", directory: wkDir}
+	err = fs.UpdateChecksumWithTimeout(50*time.Millisecond, true)
+	if !errors.Is(err, ErrChecksumTimeout) {
+		t.Error("Expected a timeout, got", err)
+	}
+	if fs.Checksum != "old" {
+		t.Error("Checksum should be untouched, got", fs.Checksum)
+	}
+	// Let the abandoned calculation finish
+	if f, err := os.OpenFile(fifo, os.O_WRONLY, 0); err == nil {
+		f.Close()
+	}
+
+	if err := os.WriteFile(filepath.Join(wkDir, "normal"), []byte("normal"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fs = FileStruct{Name: "normal", directory: wkDir}
+	if err := fs.UpdateChecksumWithTimeout(10*time.Second, false); err != nil {
+		t.Error(err)
+	}
+	if fs.Checksum == "" {
+		t.Error("Expected a checksum")
+	}
+}
+
+func TestChecksumCacheSha256Timeout(t *testing.T) {
+	wkDir := t.TempDir()
+	fifo := filepath.Join(wkDir, "hung")
+	if err := syscall.Mkfifo(fifo, 0644); err != nil {
+		t.Skip("Unable to make a fifo:", err)
+	}
+
+	cc := NewChecksumCache()
+	cc.Timeout = 50 * time.Millisecond
+	fs := FileStruct{Name: "hung", Checksum256: "old", directory: wkDir}
+	err := cc.UpdateChecksum256(context.Background(), &fs, true)
+	if !errors.Is(err, ErrChecksumTimeout) {
+		t.Error("Expected a timeout, got", err)
+	}
+	if fs.Checksum256 != "old" {
+		t.Error("Checksum should be untouched, got", fs.Checksum256)
+	}
+	if f, err := os.OpenFile(fifo, os.O_WRONLY, 0); err == nil {
+		f.Close()
+	}
+}
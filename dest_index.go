@@ -0,0 +1,120 @@
+package medorg
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// DestIndexFileName is where, at the top of a backup destination,
+// we keep an index of every file on it
+const DestIndexFileName = ".mdbackup-index.json"
+
+// destIndexEntry is one line of the index
+type destIndexEntry struct {
+	Checksum string `json:"checksum"`
+	Size     int64  `json:"size"`
+	// Path is relative to the destination, with forward slashes
+	Path string `json:"path"`
+}
+
+// BuildDestinationIndex walks destDir's metadata and writes an index of
+// every file on it, so later backups can find what is already there
+// without walking the whole destination.
+// Only the .medorg.xml files are read, so they should be up to date.
+func BuildDestinationIndex(destDir string, opts MetadataOptions) error {
+	fn := filepath.Join(destDir, DestIndexFileName)
+	// Write to a temporary file, then rename it into place
+	// so we never leave half an index behind
+	tmpFn := fn + ".tmp"
+	f, err := os.Create(tmpFn)
+	if err != nil {
+		return err
+	}
+	wr := bufio.NewWriter(f)
+	enc := json.NewEncoder(wr)
+	walker := func(dir string, dm DirectoryMap) error {
+		rel, err := filepath.Rel(destDir, dir)
+		if err != nil {
+			return err
+		}
+		return dm.rangeMap(func(name string, fs FileStruct) error {
+			if fs.Checksum == "" {
+				return nil
+			}
+			return enc.Encode(destIndexEntry{
+				Checksum: fs.Checksum,
+				Size:     fs.Size,
+				Path:     filepath.ToSlash(filepath.Join(rel, name)),
+			})
+		})
+	}
+	err = walkDirectoryMaps(destDir, opts, walker)
+	if err == nil {
+		err = wr.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmpFn)
+		return err
+	}
+	return os.Rename(tmpFn, fn)
+}
+
+// LoadDestinationIndex reads the index of destDir
+// Returns an os.ErrNotExist error if there is no index
+// Each file in the index is checked it is still there with the same size,
+// so that files that have gone from the destination are not thought backed up.
+func LoadDestinationIndex(destDir string) (*DuplicateIndex, error) {
+	f, err := os.Open(filepath.Join(destDir, DestIndexFileName))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	di := NewDuplicateIndex()
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for dec.More() {
+		var entry destIndexEntry
+		if err := dec.Decode(&entry); err != nil {
+			return nil, err
+		}
+		fs := FileStruct{
+			directory: destDir,
+			Name:      filepath.FromSlash(entry.Path),
+			Checksum:  entry.Checksum,
+			Size:      entry.Size,
+		}
+		info, err := os.Stat(string(fs.Path()))
+		if err != nil || info.Size() != fs.Size {
+			// Deleted, or changed, since it was indexed
+			continue
+		}
+		di.Add(fs)
+	}
+	return di, nil
+}
+
+// appendDestinationIndex records a file we have just copied to destDir
+// Nothing is done if the destination does not have an index
+func appendDestinationIndex(destDir, rel string, fs FileStruct) error {
+	f, err := os.OpenFile(filepath.Join(destDir, DestIndexFileName), os.O_APPEND|os.O_WRONLY, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(f).Encode(destIndexEntry{
+		Checksum: fs.Checksum,
+		Size:     fs.Size,
+		Path:     filepath.ToSlash(rel),
+	})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
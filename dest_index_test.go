@@ -0,0 +1,119 @@
+package medorg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDestinationIndex(t *testing.T) {
+	srcDir, err := os.MkdirTemp("", "indexSrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(srcDir)
+	destDir, err := os.MkdirTemp("", "indexDst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+	if err := os.WriteFile(filepath.Join(srcDir, "first"), []byte("first file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var callCount uint32
+	fc := func(src, dst Fpath) error {
+		atomic.AddUint32(&callCount, 1)
+		return CopyFile(src, dst)
+	}
+	xc := XMLCfg{}
+	opts := BackupOptions{CreateLabelIfMissing: true, Reindex: true}
+	err = BackupRunner(context.Background(), &xc, opts, 2, fc, srcDir, destDir, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The index is built before the copy, and the copy appended to it
+	di, err := LoadDestinationIndex(destDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := NewFileStruct(srcDir, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.UpdateChecksum(false); err != nil {
+		t.Fatal(err)
+	}
+	path, ok := di.Lookup(fs.Checksum, fs.Size)
+	if !ok || path != NewFpath(destDir, "first") {
+		t.Error("Copied file missing from the index", path, ok)
+	}
+
+	// Second time around we trust the index, and only copy the new file
+	if err := os.WriteFile(filepath.Join(srcDir, "second"), []byte("second file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts.Reindex = false
+	err = BackupRunner(context.Background(), &xc, opts, 2, fc, srcDir, destDir, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cc := atomic.LoadUint32(&callCount); cc != 2 {
+		t.Error("Expected 2 copies in total, got", cc)
+	}
+	di, err = LoadDestinationIndex(destDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err = NewFileStruct(srcDir, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.UpdateChecksum(false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := di.Lookup(fs.Checksum, fs.Size); !ok {
+		t.Error("Second copy missing from the index")
+	}
+}
+
+func TestDestinationIndexMissingFile(t *testing.T) {
+	srcDir := t.TempDir()
+	destDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(srcDir, "first"), []byte("first file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var callCount uint32
+	fc := func(src, dst Fpath) error {
+		atomic.AddUint32(&callCount, 1)
+		return copyFileContents(string(src), string(dst), nil)
+	}
+	xc := XMLCfg{}
+	opts := BackupOptions{CreateLabelIfMissing: true, Reindex: true}
+	if err := BackupRunner(context.Background(), &xc, opts, 2, fc, srcDir, destDir, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	// The copy goes missing from the destination, behind the index's back
+	if err := os.Remove(filepath.Join(destDir, "first")); err != nil {
+		t.Fatal(err)
+	}
+	di, err := LoadDestinationIndex(destDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if di.Len() != 0 {
+		t.Error("The missing file should not be in the index")
+	}
+	opts.Reindex = false
+	if err := BackupRunner(context.Background(), &xc, opts, 2, fc, srcDir, destDir, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if cc := atomic.LoadUint32(&callCount); cc != 2 {
+		t.Error("Expected the missing file to be copied again, got", cc, "copies")
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "first")); err != nil {
+		t.Error("The file should be back on the destination", err)
+	}
+}
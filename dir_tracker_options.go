@@ -0,0 +1,39 @@
+package medorg
+
+// DirTrackerOptions control how a DirTracker walks
+// Each tracker has its own, so one caller's choices do not affect another's.
+// Start from DefaultDirTrackerOptions, as a zero MaxDepth only walks the root.
+type DirTrackerOptions struct {
+	// SymlinkPolicy is what to do with symlinks to directories
+	SymlinkPolicy SymlinkPolicy
+	// ScanOrder is the order the directories are walked in
+	ScanOrder ScanOrder
+	// IgnorePatterns are ignored everywhere,
+	// as if in an ignore file at the top of the walk
+	IgnorePatterns []string
+	// MaxDepth is how far below the root to walk
+	// 0 is only the root itself, 1 its subdirectories too, and so on.
+	// A negative depth has no limit.
+	MaxDepth int
+	// SplitWarnings sends the warnings from the walk to WarnChan
+	// rather than ErrChan, and the walk carries on past them.
+	// Anyone who sets this should read WarnChan as well as ErrChan,
+	// or warnings will be dropped once its buffer is full.
+	SplitWarnings bool
+	// ContinueOnError sends the errors from the walk itself, such as a directory
+	// that cannot be read, to ErrChan and carries on past them,
+	// as the walk already does for errors visiting files.
+	ContinueOnError bool
+	// Metadata is how the metadata files met on the walk are read and written
+	Metadata MetadataOptions
+}
+
+// DefaultDirTrackerOptions are those NewDirTracker uses
+func DefaultDirTrackerOptions() DirTrackerOptions {
+	return DirTrackerOptions{
+		SymlinkPolicy: SymlinkReport,
+		ScanOrder:     ScanOrderName,
+		MaxDepth:      -1,
+		Metadata:      DefaultMetadataOptions(),
+	}
+}
@@ -0,0 +1,40 @@
+package medorg
+
+// progressChanSize is how many events can queue up before
+// we start dropping them for a slow reader of ProgressChan
+const progressChanSize = 32
+
+// DirTrackerProgress is an event sent as the DirTracker walks
+// Exactly one of the fields is set
+type DirTrackerProgress struct {
+	DirEntered  string
+	FileVisited string
+	Done        bool
+}
+
+// ProgressChan returns a channel of events describing the walk,
+// so you can drive your own progress display.
+// The walk never waits on the reader: events that do not fit in the
+// buffer are dropped, so use Stats for exact totals.
+// The Done event is always delivered, then the channel is closed.
+func (dt *DirTracker) ProgressChan() <-chan DirTrackerProgress {
+	return dt.progressChan
+}
+
+func (dt *DirTracker) sendProgress(ev DirTrackerProgress) {
+	for {
+		select {
+		case dt.progressChan <- ev:
+			return
+		default:
+		}
+		if !ev.Done {
+			return
+		}
+		// Make room for the Done event by discarding the oldest
+		select {
+		case <-dt.progressChan:
+		default:
+		}
+	}
+}
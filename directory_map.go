@@ -22,12 +22,22 @@ var ErrUnimplementedVisitor = errors.New("unimplemented visitor")
 
 var errSelfCheckProblem = errors.New("self check problem")
 
+// ErrDirectoryMapTooLarge the directory has too many files in it to be handled efficiently
+var ErrDirectoryMapTooLarge = errors.New("too many entries in directory map, use check_calc -conc to spread the files out")
+
+// MaxEntriesWarning we warn about directories with more files than this
+// as the .medorg.xml gets slow to parse
+var MaxEntriesWarning = 10000
+
 // DirectoryMap contains for the directory all the file structs
 type DirectoryMap struct {
 	mp    map[string]FileStruct
 	stale *bool
+	meta  *DirectoryMeta
 	// We want to copy the DirectoryMap elsewhere
 	lock *sync.RWMutex
+	// How we were read, and will be written
+	opts MetadataOptions
 
 	VisitFunc func(dm DirectoryMap, directory, file string, d fs.DirEntry) error
 }
@@ -37,7 +47,9 @@ func NewDirectoryMap() *DirectoryMap {
 	itm := new(DirectoryMap)
 	itm.mp = make(map[string]FileStruct)
 	itm.stale = new(bool)
+	itm.meta = new(DirectoryMeta)
 	itm.lock = new(sync.RWMutex)
+	itm.opts = DefaultMetadataOptions()
 	itm.VisitFunc = func(dm DirectoryMap, directory, file string, d fs.DirEntry) error {
 		return ErrUnimplementedVisitor
 	}
@@ -54,11 +66,21 @@ func (dm DirectoryMap) Len() int {
 // ToMd5File returns the dm as an md5 file
 // i.e. why the hell are we not just using that?
 func (dm DirectoryMap) ToMd5File(dir string) (*Md5File, error) {
-	m5f := Md5File{
-		Dir: dir,
-	}
 	dm.lock.RLock()
 	defer dm.lock.RUnlock()
+	return dm.toMd5File(dir)
+}
+
+// toMd5File is ToMd5File for when you already hold the lock
+func (dm DirectoryMap) toMd5File(dir string) (*Md5File, error) {
+	m5f := Md5File{
+		Dir:     dir,
+		Version: MetadataVersion,
+	}
+	if !dm.meta.empty() {
+		meta := *dm.meta
+		m5f.Meta = &meta
+	}
 
 	for key, value := range dm.mp {
 		if key == value.Name {
@@ -70,6 +92,33 @@ func (dm DirectoryMap) ToMd5File(dir string) (*Md5File, error) {
 	return &m5f, nil
 }
 
+// writeXML writes the dm to w as toMd5File would be marshalled,
+// but a file at a time, so we never hold the whole xml in memory.
+// Call with the lock held
+func (dm DirectoryMap) writeXML(w io.Writer, dir string) error {
+	m5f := Md5File{Dir: dir, Version: MetadataVersion}
+	if !dm.meta.empty() {
+		m5f.Meta = dm.meta
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err := m5f.encode(enc, func(yield func(FileStruct) error) error {
+		for key, value := range dm.mp {
+			if key != value.Name {
+				return ErrKey
+			}
+			if err := yield(value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
 //ToXML
 func (dm DirectoryMap) ToXML(dir string) (output []byte, err error) {
 	m5f, err := dm.ToMd5File(dir)
@@ -86,10 +135,23 @@ func (dm *DirectoryMap) FromXML(input []byte) (dir string, err error) {
 	if err != nil {
 		return "", err
 	}
+	if err := migrateMd5File(&m5f, dm.opts.MinVersion); err != nil {
+		return "", err
+	}
+	dm.fromMd5File(m5f)
+	return m5f.Dir, nil
+}
+
+// fromMd5File adds the contents of an md5 file to the dm
+func (dm *DirectoryMap) fromMd5File(m5f Md5File) {
 	for _, val := range m5f.Files {
 		dm.Add(val)
 	}
-	return m5f.Dir, nil
+	if m5f.Meta != nil {
+		dm.lock.Lock()
+		*dm.meta = *m5f.Meta
+		dm.lock.Unlock()
+	}
 }
 
 // Add adds a file struct to the dm
@@ -131,12 +193,25 @@ func (dm DirectoryMap) Get(fn string) (FileStruct, bool) {
 // DirectoryMapFromDir reads in the dirmap from the supplied dir
 // It does not check anything or compute anythiing
 func DirectoryMapFromDir(directory string) (dm DirectoryMap, err error) {
+	return DirectoryMapFromDirWithRoot(directory, "")
+}
+
+// DirectoryMapFromDirWithRoot is DirectoryMapFromDir for use during a walk
+// Tags are only inherited from directories at or below root
+func DirectoryMapFromDirWithRoot(directory, root string) (dm DirectoryMap, err error) {
+	return DirectoryMapFromDirWithOptions(directory, root, DefaultMetadataOptions())
+}
+
+// DirectoryMapFromDirWithOptions is DirectoryMapFromDirWithRoot, reading,
+// and later writing, the metadata as opts says
+func DirectoryMapFromDirWithOptions(directory, root string, opts MetadataOptions) (dm DirectoryMap, err error) {
 	// Read in the xml structure to a map/array
 	dm = *NewDirectoryMap()
 	if dm.mp == nil {
 		return dm, errors.New("initialize malfunction")
 	}
-	fn := filepath.Join(directory, Md5FileName)
+	dm.opts = opts
+	fn := filepath.Join(directory, GetMetadataFilename())
 	var f *os.File
 	_, err = os.Stat(fn)
 
@@ -160,20 +235,56 @@ func DirectoryMapFromDir(directory string) (dm DirectoryMap, err error) {
 		return
 	}
 	_, err = dm.FromXML(byteValue)
+	if errors.Is(err, ErrOldMetadata) || errors.Is(err, ErrNewMetadata) {
+		return dm, fmt.Errorf("%w on %s", err, directory)
+	}
 	err = supressXmlUnmarshallErrors(err)
 
 	if err != nil {
 		return dm, fmt.Errorf("FromXML error \"%w\" on %s", err, directory)
 	}
 
+	if MaxEntriesWarning > 0 && dm.Len() > MaxEntriesWarning {
+		log.Println("Warning:", directory, "has", dm.Len(), "entries, consider running check_calc -conc to spread them across subdirectories")
+	}
+	inherited := append(dm.InheritedTags(), parentInheritedTags(directory, root)...)
 	fc := func(fn string, fs FileStruct) (FileStruct, error) {
+		if IsMedorgFile(fn) {
+			// Some other tool has recorded one of our own files; its checksum
+			// would change every time we persist, so drop it
+			return fs, errDeleteThisEntry
+		}
 		fs.directory = directory
+		fs.inheritedTags = inherited
 		return fs, nil
 	}
 
 	return dm, dm.rangeMutate(fc)
 }
 
+// walkDirectoryMaps walks the directory tree calling fc with the
+// DirectoryMap for each directory. It only reads the .medorg.xml files,
+// nothing is calculated or written.
+func walkDirectoryMaps(directory string, opts MetadataOptions, fc func(dir string, dm DirectoryMap) error) error {
+	walker := func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if isHiddenDirectory(path) {
+			return filepath.SkipDir
+		}
+		dm, err := DirectoryMapFromDirWithOptions(path, directory, opts)
+		if err != nil {
+			return err
+		}
+		return fc(path, dm)
+	}
+	return filepath.WalkDir(directory, walker)
+}
+
 // Stale returns true if the dm has been modified since writted
 func (dm DirectoryMap) Stale() bool {
 	dm.lock.RLock()
@@ -182,14 +293,14 @@ func (dm DirectoryMap) Stale() bool {
 }
 
 // selfCheck the directory map for obvious errors
+// The caller must hold the lock
 func (dm DirectoryMap) selfCheck(directory string) error {
-	fc := func(fn string, fs FileStruct) error {
+	for fn, fs := range dm.mp {
 		if fs.Directory() != directory {
 			return fmt.Errorf("%w FS has directory of %s for %s/%s", errSelfCheckProblem, fs.Directory(), directory, fn)
 		}
-		return nil
 	}
-	return dm.rangeMap(fc)
+	return nil
 }
 
 func (dm DirectoryMap) pruneEmptyFile(directory, fn string, fs FileStruct, delete bool) error {
@@ -304,37 +415,99 @@ func (dm DirectoryMap) DeleteMissingFiles() error {
 	return dm.rangeMutate(fc)
 }
 
+// Compact removes the entries for files that are no longer in directory
+// Each file in the map is stat'ed, so there is no need to walk the directory
+// Returns how many entries were removed
+func (dm DirectoryMap) Compact(directory string) (int, error) {
+	removed := 0
+	fc := func(fileName string, fs FileStruct) (FileStruct, error) {
+		_, err := os.Lstat(filepath.Join(directory, fileName))
+		if errors.Is(err, os.ErrNotExist) {
+			removed++
+			return fs, errDeleteThisEntry
+		}
+		return fs, errIgnoreThisMutate
+	}
+	err := dm.rangeMutate(fc)
+	return removed, err
+}
+
+// DeleteMatchingFiles removes the entries whose name matches any of the patterns
+// for when files we used to checksum are now excluded
+func (dm DirectoryMap) DeleteMatchingFiles(patterns []string) error {
+	if len(patterns) == 0 {
+		return nil
+	}
+	fc := func(fileName string, fs FileStruct) (FileStruct, error) {
+		for _, pattern := range patterns {
+			matched, err := filepath.Match(pattern, fileName)
+			if err != nil {
+				return fs, err
+			}
+			if matched {
+				return fs, errDeleteThisEntry
+			}
+		}
+		return fs, errIgnoreThisMutate
+	}
+	return dm.rangeMutate(fc)
+}
+
+// DeleteIgnoredFiles removes the entries for files in dir that a walk from root
+// would ignore, through the global patterns or a .medorgignore file,
+// for when files we used to checksum are now ignored
+func (dm DirectoryMap) DeleteIgnoredFiles(root, dir string, patterns []string) error {
+	ir := ignoreRulesAt(root, dir, patterns)
+	fc := func(fileName string, fs FileStruct) (FileStruct, error) {
+		if ir.ignored(filepath.Join(filepath.Clean(dir), fileName), false) {
+			return fs, errDeleteThisEntry
+		}
+		return fs, errIgnoreThisMutate
+	}
+	return dm.rangeMutate(fc)
+}
+
 // Persist self to disk
+// With CompactOnWrite, entries for files that have gone are dropped first
 func (dm DirectoryMap) Persist(directory string) error {
+	if dm.opts.CompactOnWrite {
+		if _, err := dm.Compact(directory); err != nil {
+			return err
+		}
+	}
+	return dm.PersistWithLock(directory)
+}
+
+// PersistWithLock writes the dm to disk, holding the lock throughout
+// so that nothing can be added between us serialising the map and writing it out
+func (dm DirectoryMap) PersistWithLock(directory string) error {
+	dm.lock.Lock()
+	defer dm.lock.Unlock()
 	err := dm.selfCheck(directory)
 	if err != nil {
 		return err
 	}
-	prepare := func() (bool, error) {
-		dm.lock.Lock()
-		defer dm.lock.Unlock()
-		if !*dm.stale {
-			return true, nil
-		}
-		*dm.stale = false
-		if len(dm.mp) == 0 {
-			return true, md5FileWrite(directory, nil)
-		}
-		return false, nil
+	if !*dm.stale {
+		return nil
 	}
-
-	if ret, err := prepare(); ret { // sneaky trick to save messing with defer locks
+	if dm.opts.MaxEntries > 0 && len(dm.mp) > dm.opts.MaxEntries {
+		return fmt.Errorf("%w::%s", ErrDirectoryMapTooLarge, directory)
+	}
+	if err := checkMetadataOverwrite(directory); err != nil {
 		return err
 	}
+	if len(dm.mp) == 0 && dm.meta.empty() {
+		*dm.stale = false
+		return md5FileWrite(directory, false, nil)
+	}
 	// Write out a new Xml from the structure
-	ba, err := dm.ToXML(directory)
-	switch err {
-	case nil:
-	case io.EOF:
-	default:
-		return fmt.Errorf("unknown Error Marshalling Xml:%w", err)
+	err = md5FileWrite(directory, dm.opts.FsyncWrites, func(w io.Writer) error {
+		return dm.writeXML(w, directory)
+	})
+	if err == nil {
+		*dm.stale = false
 	}
-	return md5FileWrite(directory, ba)
+	return err
 }
 
 // Visitor satisfies DirectoryEntryInterface
@@ -355,7 +528,8 @@ func (dm DirectoryMap) UpdateValues(directory string, d fs.DirEntry) error {
 	}
 	file := d.Name()
 	fs, ok := dm.Get(file)
-	if changed, err := fs.Changed(info); ok && !changed {
+	kind, err := fs.ChangeType(info)
+	if ok && kind == NotChanged {
 		return err
 	}
 	_, err = fs.FromStat(directory, file, info)
@@ -370,6 +544,8 @@ func (dm DirectoryMap) Copy() DirectoryEntryJournalableInterface {
 	for k, v := range dm.mp {
 		cp.mp[k] = v
 	}
+	*cp.meta = *dm.meta
+	cp.opts = dm.opts
 	cp.VisitFunc = dm.VisitFunc
 	return cp
 }
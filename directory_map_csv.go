@@ -0,0 +1,128 @@
+package medorg
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrBadCSV the csv is not in the form ExportCSV writes
+var ErrBadCSV = errors.New("bad csv")
+
+// csvHeader is the header row, and the order of the columns, of our csv
+var csvHeader = []string{"directory", "name", "size", "checksum", "mtime", "backup_dests"}
+
+// csvBackupDestSeparator separates the backup destinations within their column
+const csvBackupDestSeparator = "|"
+
+// writeCSVRows writes a row for each file, in name order
+func (dm DirectoryMap) writeCSVRows(cw *csv.Writer) error {
+	var files []FileStruct
+	_ = dm.rangeMap(func(fn string, fs FileStruct) error {
+		files = append(files, fs)
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+	for _, fs := range files {
+		err := cw.Write([]string{
+			fs.Directory(),
+			fs.Name,
+			strconv.FormatInt(fs.Size, 10),
+			fs.Checksum,
+			strconv.FormatInt(fs.Mtime, 10),
+			strings.Join(fs.BackupDest, csvBackupDestSeparator),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportCSV writes a header, then a row for each file, to w
+// for feeding into spreadsheets and the like
+func (dm DirectoryMap) ExportCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	if err := dm.writeCSVRows(cw); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ExportDirectoriesCSV is ExportCSV for every directory below those given,
+// all in the one csv. It only reads the existing metadata files.
+func ExportDirectoriesCSV(w io.Writer, directories []string, opts MetadataOptions) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	dirFc := func(dir string, dm DirectoryMap) error {
+		return dm.writeCSVRows(cw)
+	}
+	for _, dir := range directories {
+		if err := walkDirectoryMaps(dir, opts, dirFc); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ImportCSV adds a file for each row of a csv, as written by ExportCSV
+// Files already in the dm are replaced
+func (dm DirectoryMap) ImportCSV(r io.Reader) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = len(csvHeader)
+	header, err := cr.Read()
+	if err != nil {
+		return fmt.Errorf("%w::%v", ErrBadCSV, err)
+	}
+	for i, col := range csvHeader {
+		if header[i] != col {
+			return fmt.Errorf("%w::expected column %s, got %s", ErrBadCSV, col, header[i])
+		}
+	}
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w::%v", ErrBadCSV, err)
+		}
+		fs := FileStruct{
+			directory: record[0],
+			Name:      record[1],
+			Checksum:  record[3],
+		}
+		if fs.Name == "" {
+			return fmt.Errorf("%w::file without a name", ErrBadCSV)
+		}
+		fs.Size, err = strconv.ParseInt(record[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w::size of %s, %v", ErrBadCSV, fs.Name, err)
+		}
+		fs.Mtime, err = strconv.ParseInt(record[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w::mtime of %s, %v", ErrBadCSV, fs.Name, err)
+		}
+		if record[5] != "" {
+			for _, dest := range strings.Split(record[5], csvBackupDestSeparator) {
+				if _, err := fs.AddTag(dest); err != nil {
+					return fmt.Errorf("%w::%s, %v", ErrBadCSV, fs.Name, err)
+				}
+			}
+		}
+		dm.Add(fs)
+	}
+}
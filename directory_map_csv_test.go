@@ -0,0 +1,87 @@
+package medorg
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testCSV = `directory,name,size,checksum,mtime,backup_dests
+/media/photos,a.jpg,100,abc,1700000000,vol1|vol2
+/media/photos,"b, with comma.jpg",200,def,1700000001,
+/media/photos,c.jpg,0,,0,vol1
+`
+
+func TestDirectoryMapCSVRoundTrip(t *testing.T) {
+	dm := NewDirectoryMap()
+	if err := dm.ImportCSV(strings.NewReader(testCSV)); err != nil {
+		t.Fatal(err)
+	}
+	if dm.Len() != 3 {
+		t.Fatal("Expected 3 files, got", dm.Len())
+	}
+	fs, ok := dm.Get("a.jpg")
+	if !ok || fs.Size != 100 || fs.Checksum != "abc" || !fs.HasTag("vol2") || fs.Directory() != "/media/photos" {
+		t.Error("Unexpected import", fs)
+	}
+	var buf bytes.Buffer
+	if err := dm.ExportCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != testCSV {
+		t.Error("Round trip changed the csv:\n", buf.String())
+	}
+}
+
+func TestDirectoryMapImportBadCSV(t *testing.T) {
+	for _, csv := range []string{
+		"",
+		"dir,name,size,checksum,mtime,backup_dests\n",
+		"directory,name,size,checksum,mtime,backup_dests\n/d,a.jpg,big,abc,0,\n",
+		"directory,name,size,checksum,mtime,backup_dests\n/d,a.jpg,1,abc,0\n",
+		"directory,name,size,checksum,mtime,backup_dests\n/d,,1,abc,0,\n",
+		"directory,name,size,checksum,mtime,backup_dests\n/d,a.jpg,1,abc,0,vol1||vol2\n",
+	} {
+		if err := NewDirectoryMap().ImportCSV(strings.NewReader(csv)); !errors.Is(err, ErrBadCSV) {
+			t.Errorf("Expected ErrBadCSV for %q, got %v", csv, err)
+		}
+	}
+}
+
+func TestExportDirectoriesCSV(t *testing.T) {
+	wkDir := t.TempDir()
+	subDir := filepath.Join(wkDir, "sub")
+	if err := os.Mkdir(subDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{wkDir, subDir} {
+		if err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte(dir), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := recalcTestDirectory(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := ExportDirectoriesCSV(&buf, []string{wkDir}, DefaultMetadataOptions()); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || lines[0] != strings.Join(csvHeader, ",") {
+		t.Fatal("Expected a header and a row per directory, got", lines)
+	}
+	if !strings.HasPrefix(lines[2], subDir+",file.txt,") {
+		t.Error("Unexpected row for the subdirectory", lines[2])
+	}
+	// Which reads back in, one directory at a time
+	dm := NewDirectoryMap()
+	if err := dm.ImportCSV(strings.NewReader(lines[0] + "\n" + lines[2] + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	if fs, _ := dm.Get("file.txt"); fs.Checksum == "" {
+		t.Error("Expected a checksum, got", fs)
+	}
+}
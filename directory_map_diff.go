@@ -0,0 +1,101 @@
+package medorg
+
+import (
+	"path/filepath"
+	"sort"
+)
+
+// DirectoryMapDiff is what has changed between two versions of a directory's metadata
+type DirectoryMapDiff struct {
+	Added    []FileStruct
+	Removed  []FileStruct
+	Modified []FileStruct
+}
+
+// Empty reports if nothing has changed
+func (dd DirectoryMapDiff) Empty() bool {
+	return len(dd.Added) == 0 && len(dd.Removed) == 0 && len(dd.Modified) == 0
+}
+
+// Diff reports what has been added, removed and modified going from dm to other
+// A file is modified if its checksum or size has changed,
+// so a touched file with the same contents is not reported.
+// Each list is sorted by name.
+func (dm DirectoryMap) Diff(other DirectoryMap) DirectoryMapDiff {
+	var diff DirectoryMapDiff
+	// Take a copy, rather than holding both locks at once
+	after := make(map[string]FileStruct, other.Len())
+	_ = other.rangeMap(func(fn string, fs FileStruct) error {
+		after[fn] = fs
+		return nil
+	})
+	_ = dm.rangeMap(func(fn string, bf FileStruct) error {
+		af, ok := after[fn]
+		if !ok {
+			diff.Removed = append(diff.Removed, bf)
+			return nil
+		}
+		delete(after, fn)
+		if af.Checksum != bf.Checksum || af.Size != bf.Size {
+			diff.Modified = append(diff.Modified, af)
+		}
+		return nil
+	})
+	for _, af := range after {
+		diff.Added = append(diff.Added, af)
+	}
+	for _, sl := range [][]FileStruct{diff.Added, diff.Removed, diff.Modified} {
+		sort.Slice(sl, func(i, j int) bool {
+			return sl[i].Name < sl[j].Name
+		})
+	}
+	return diff
+}
+
+// DiffSnapshot compares the metadata now in dirs with a snapshot taken
+// with TakeSnapshot, one directory at a time with DirectoryMap.Diff.
+// dirs should be those the snapshot was taken of, or the directories
+// missing from dirs are reported as removed.
+// Only the directories that have changed are returned.
+func DiffSnapshot(snapshotPath string, dirs []string, opts MetadataOptions) (map[string]DirectoryMapDiff, error) {
+	files, err := loadSnapshot(snapshotPath)
+	if err != nil {
+		return nil, err
+	}
+	before := make(map[string]DirectoryMap)
+	for path, f := range files {
+		dir, fn := filepath.Split(path)
+		dir = filepath.Clean(dir)
+		dm, ok := before[dir]
+		if !ok {
+			dm = *NewDirectoryMap()
+			before[dir] = dm
+		}
+		dm.Add(FileStruct{Name: fn, Checksum: f.Checksum, Size: f.Size, Mtime: f.Mtime, directory: dir})
+	}
+
+	diffs := make(map[string]DirectoryMapDiff)
+	dirFc := func(dir string, dm DirectoryMap) error {
+		bdm, ok := before[dir]
+		if !ok {
+			bdm = *NewDirectoryMap()
+		}
+		delete(before, dir)
+		if diff := bdm.Diff(dm); !diff.Empty() {
+			diffs[dir] = diff
+		}
+		return nil
+	}
+	for _, dir := range dirs {
+		if err := walkDirectoryMaps(dir, opts, dirFc); err != nil {
+			return nil, err
+		}
+	}
+	// Whole directories that have gone
+	for dir, bdm := range before {
+		if diff := bdm.Diff(*NewDirectoryMap()); !diff.Empty() {
+			diffs[dir] = diff
+		}
+	}
+	return diffs, nil
+}
@@ -0,0 +1,124 @@
+package medorg
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDirectoryMapDiff(t *testing.T) {
+	mkDm := func(fss ...FileStruct) DirectoryMap {
+		dm := NewDirectoryMap()
+		for _, fs := range fss {
+			dm.Add(fs)
+		}
+		return *dm
+	}
+	names := func(fss []FileStruct) []string {
+		var nms []string
+		for _, fs := range fss {
+			nms = append(nms, fs.Name)
+		}
+		return nms
+	}
+	a := FileStruct{Name: "a", Size: 10, Checksum: "aaa"}
+	b := FileStruct{Name: "b", Size: 20, Checksum: "bbb"}
+	c := FileStruct{Name: "c", Size: 30, Checksum: "ccc"}
+	bResized := FileStruct{Name: "b", Size: 21, Checksum: "bbb"}
+	bRehashed := FileStruct{Name: "b", Size: 20, Checksum: "BBB"}
+	bTouched := FileStruct{Name: "b", Size: 20, Checksum: "bbb", Mtime: 1234}
+
+	testCases := []struct {
+		name                     string
+		before, after            DirectoryMap
+		added, removed, modified []string
+	}{
+		{"empty", mkDm(), mkDm(), nil, nil, nil},
+		{"identical", mkDm(a, b), mkDm(a, b), nil, nil, nil},
+		{"added", mkDm(a), mkDm(c, a, b), []string{"b", "c"}, nil, nil},
+		{"removed", mkDm(a, b, c), mkDm(b), nil, []string{"a", "c"}, nil},
+		{"resized", mkDm(a, b), mkDm(a, bResized), nil, nil, []string{"b"}},
+		{"rehashed", mkDm(a, b), mkDm(a, bRehashed), nil, nil, []string{"b"}},
+		{"touched", mkDm(b), mkDm(bTouched), nil, nil, nil},
+		{"everything", mkDm(a, b), mkDm(bRehashed, c), []string{"c"}, []string{"a"}, []string{"b"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			diff := tc.before.Diff(tc.after)
+			if !reflect.DeepEqual(names(diff.Added), tc.added) {
+				t.Error("Added", names(diff.Added), "expected", tc.added)
+			}
+			if !reflect.DeepEqual(names(diff.Removed), tc.removed) {
+				t.Error("Removed", names(diff.Removed), "expected", tc.removed)
+			}
+			if !reflect.DeepEqual(names(diff.Modified), tc.modified) {
+				t.Error("Modified", names(diff.Modified), "expected", tc.modified)
+			}
+			if diff.Empty() != (tc.added == nil && tc.removed == nil && tc.modified == nil) {
+				t.Error("Empty is wrong for", diff)
+			}
+		})
+	}
+	if diff := mkDm(bResized).Diff(mkDm(b)); diff.Modified[0].Size != b.Size {
+		t.Error("Modified should report the new version, got", diff.Modified[0])
+	}
+}
+
+func TestDiffSnapshot(t *testing.T) {
+	wkDir := t.TempDir()
+	srcDir := filepath.Join(wkDir, "src")
+	subDir := filepath.Join(srcDir, "sub")
+	if err := os.MkdirAll(subDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	dm := NewDirectoryMap()
+	dm.Add(FileStruct{Name: "keep", Checksum: "abc", Size: 1, directory: srcDir})
+	dm.Add(FileStruct{Name: "change", Checksum: "def", Size: 2, directory: srcDir})
+	if err := dm.Persist(srcDir); err != nil {
+		t.Fatal(err)
+	}
+	subDm := NewDirectoryMap()
+	subDm.Add(FileStruct{Name: "gone", Checksum: "ghi", Size: 3, directory: subDir})
+	if err := subDm.Persist(subDir); err != nil {
+		t.Fatal(err)
+	}
+	snap := filepath.Join(wkDir, "snap.json")
+	if err := TakeSnapshot([]string{srcDir}, snap, DefaultMetadataOptions()); err != nil {
+		t.Fatal(err)
+	}
+
+	diffs, err := DiffSnapshot(snap, []string{srcDir}, DefaultMetadataOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 0 {
+		t.Error("Nothing has changed, got", diffs)
+	}
+
+	dm.Add(FileStruct{Name: "change", Checksum: "xyz", Size: 2, directory: srcDir})
+	dm.Add(FileStruct{Name: "add", Checksum: "jkl", Size: 4, directory: srcDir})
+	if err := dm.Persist(srcDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(subDir); err != nil {
+		t.Fatal(err)
+	}
+	diffs, err = DiffSnapshot(snap, []string{srcDir}, DefaultMetadataOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 2 {
+		t.Fatal("Expected src and sub to have changed, got", diffs)
+	}
+	diff := diffs[srcDir]
+	if len(diff.Added) != 1 || diff.Added[0].Name != "add" ||
+		len(diff.Modified) != 1 || diff.Modified[0].Name != "change" ||
+		len(diff.Removed) != 0 {
+		t.Error("Unexpected diff for src", diff)
+	}
+	diff = diffs[subDir]
+	if len(diff.Removed) != 1 || diff.Removed[0].Name != "gone" {
+		t.Error("Unexpected diff for sub", diff)
+	}
+}
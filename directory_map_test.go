@@ -0,0 +1,509 @@
+package medorg
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDirectoryMapTooLarge(t *testing.T) {
+	wkDir, err := os.MkdirTemp("", "dmLimit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wkDir)
+	dm := NewDirectoryMap()
+	dm.opts.MaxEntries = 5
+	for i := 0; i < dm.opts.MaxEntries+1; i++ {
+		dm.Add(FileStruct{Name: fmt.Sprint("file", i), Checksum: "abc", directory: wkDir})
+	}
+	err = dm.Persist(wkDir)
+	if !errors.Is(err, ErrDirectoryMapTooLarge) {
+		t.Error("Expected the directory to be too large, got", err)
+	}
+
+	dm.opts.MaxEntries = 0
+	err = dm.Persist(wkDir)
+	if err != nil {
+		t.Error("No limit should mean no error, got", err)
+	}
+}
+
+func TestDirectoryMapInheritedTags(t *testing.T) {
+	root, err := os.MkdirTemp("", "dmInherit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	photos := filepath.Join(root, "family-photos")
+	year := filepath.Join(photos, "2020")
+	if err := os.MkdirAll(year, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	dm := NewDirectoryMap()
+	dm.SetInheritedTags([]string{ImportantTag})
+	if err := dm.Persist(photos); err != nil {
+		t.Fatal(err)
+	}
+	dm = NewDirectoryMap()
+	dm.Add(FileStruct{Name: "beach.jpg", Checksum: "abc", Tags: []string{"holiday"}, directory: year})
+	dm.Add(FileStruct{Name: "snow.jpg", Checksum: "def", directory: year})
+	if err := dm.Persist(year); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := DirectoryMapFromDir(year)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, _ := loaded.Get("beach.jpg")
+	if fmt.Sprint(fs.EffectiveTags()) != fmt.Sprint([]string{"holiday", ImportantTag}) {
+		t.Error("Unexpected tags", fs.EffectiveTags())
+	}
+	if fmt.Sprint(fs.Tags) != fmt.Sprint([]string{"holiday"}) {
+		t.Error("Inherited tags should not become the file's own", fs.Tags)
+	}
+	// Writing the directory back out must not copy the inherited tags onto the files
+	if err := loaded.Persist(year); err != nil {
+		t.Fatal(err)
+	}
+	ba, err := os.ReadFile(filepath.Join(year, GetMetadataFilename()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(ba), ImportantTag) {
+		t.Error("Inherited tag was persisted:", string(ba))
+	}
+
+	// And the important file goes first, even though it is smaller
+	other := FileStruct{Name: "big.iso", Size: 1 << 30}
+	fss := []FileStruct{other, fs}
+	sortByPriority(fss, DefaultPriorityWeights)
+	if fss[0].Name != "beach.jpg" {
+		t.Error("Expected the important file first, got", fss[0].Name)
+	}
+}
+
+func TestDirectoryMapInheritedTagsRoot(t *testing.T) {
+	root := t.TempDir()
+	photos := filepath.Join(root, "family-photos")
+	year := filepath.Join(photos, "2020")
+	if err := os.MkdirAll(year, 0755); err != nil {
+		t.Fatal(err)
+	}
+	parent := NewDirectoryMap()
+	parent.SetInheritedTags([]string{ImportantTag})
+	if err := parent.Persist(root); err != nil {
+		t.Fatal(err)
+	}
+	dm := NewDirectoryMap()
+	dm.Add(FileStruct{Name: "beach.jpg", Checksum: "abc", directory: year})
+	if err := dm.Persist(year); err != nil {
+		t.Fatal(err)
+	}
+
+	tagsFor := func(walkRoot string) []string {
+		loaded, err := DirectoryMapFromDirWithRoot(year, walkRoot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fs, _ := loaded.Get("beach.jpg")
+		return fs.EffectiveTags()
+	}
+	if tags := tagsFor(root); len(tags) != 1 {
+		t.Error("Expected the tag from the walk root, got", tags)
+	}
+	if tags := tagsFor(photos); len(tags) != 0 {
+		t.Error("Tags from above the walk root should be ignored, got", tags)
+	}
+
+	// Changing the parent must not be hidden by the cache
+	parent.SetInheritedTags([]string{ImportantTag, "family"})
+	if err := parent.Persist(root); err != nil {
+		t.Fatal(err)
+	}
+	if tags := tagsFor(root); len(tags) != 2 {
+		t.Error("Expected the updated tags, got", tags)
+	}
+}
+
+func TestDirectoryMapPersistConcurrentAdd(t *testing.T) {
+	wkDir, err := os.MkdirTemp("", "dmConcurrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wkDir)
+	const numAdders = 50
+
+	dm := NewDirectoryMap()
+	var addWg sync.WaitGroup
+	addWg.Add(numAdders)
+	for i := 0; i < numAdders; i++ {
+		go func(i int) {
+			defer addWg.Done()
+			dm.Add(FileStruct{Name: fmt.Sprint("file", i), Checksum: fmt.Sprint("cks", i), directory: wkDir})
+		}(i)
+	}
+	addsDone := make(chan struct{})
+	persistDone := make(chan struct{})
+	go func() {
+		defer close(persistDone)
+		for {
+			if err := dm.Persist(wkDir); err != nil {
+				t.Error(err)
+			}
+			select {
+			case <-addsDone:
+				// One final write, now everyone has added
+				if err := dm.Persist(wkDir); err != nil {
+					t.Error(err)
+				}
+				return
+			default:
+			}
+		}
+	}()
+	addWg.Wait()
+	close(addsDone)
+	<-persistDone
+
+	if dm.Stale() {
+		t.Error("Map should be clean after the final persist")
+	}
+	loaded, err := DirectoryMapFromDir(wkDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < numAdders; i++ {
+		if _, ok := loaded.Get(fmt.Sprint("file", i)); !ok {
+			t.Error("Missing file", i, "from the written xml")
+		}
+	}
+}
+
+func TestDirectoryMapDropsSelfReference(t *testing.T) {
+	wkDir, err := os.MkdirTemp("", "dmSelf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wkDir)
+	if err := os.WriteFile(filepath.Join(wkDir, "file0"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dm := NewDirectoryMap()
+	dm.Add(FileStruct{Name: "file0", Checksum: "abc", directory: wkDir})
+	dm.Add(FileStruct{Name: GetMetadataFilename(), Checksum: "def", directory: wkDir})
+	if err := dm.Persist(wkDir); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := DirectoryMapFromDir(wkDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loaded.Get(GetMetadataFilename()); ok {
+		t.Error("Self reference should have been removed")
+	}
+	if _, ok := loaded.Get("file0"); !ok {
+		t.Error("Other entries should be kept")
+	}
+	if err := loaded.DeleteMissingFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(wkDir, GetMetadataFilename())); err != nil {
+		t.Error("Metadata file should still be there", err)
+	}
+	if err := loaded.Persist(wkDir); err != nil {
+		t.Fatal(err)
+	}
+	ba, err := os.ReadFile(filepath.Join(wkDir, GetMetadataFilename()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(ba), `fname="`+GetMetadataFilename()+`"`) {
+		t.Error("Self reference was written back out:", string(ba))
+	}
+}
+
+// persistChildEnv tells the test it is the child process that is to be killed
+const persistChildEnv = "MEDORG_PERSIST_CHILD_DIR"
+
+func TestDirectoryMapPersistSurvivesKill(t *testing.T) {
+	const numEntries = 5000
+	persistForever := func(wkDir string) {
+		dm := NewDirectoryMap()
+		for i := 0; ; i++ {
+			for j := 0; j < numEntries; j++ {
+				dm.Add(FileStruct{Name: fmt.Sprint("file", j), Checksum: fmt.Sprint("cks", i, "_", j), directory: wkDir})
+			}
+			if err := dm.Persist(wkDir); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if wkDir := os.Getenv(persistChildEnv); wkDir != "" {
+		persistForever(wkDir)
+	}
+
+	wkDir, err := os.MkdirTemp("", "dmKill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wkDir)
+	cmd := exec.Command(os.Args[0], "-test.run=^TestDirectoryMapPersistSurvivesKill$")
+	cmd.Env = append(os.Environ(), persistChildEnv+"="+wkDir)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// Wait for the first file, then kill it part way through writing another
+	for i := 0; i < 100 && !FileExist(wkDir, GetMetadataFilename()); i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := cmd.Process.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	_ = cmd.Wait()
+
+	dm, err := DirectoryMapFromDir(wkDir)
+	if err != nil {
+		t.Fatal("Metadata corrupted by the kill", err)
+	}
+	if dm.Len() != numEntries {
+		t.Error("Expected", numEntries, "entries, got", dm.Len())
+	}
+}
+
+func TestDirectoryMapDeleteMatchingFiles(t *testing.T) {
+	dm := NewDirectoryMap()
+	for _, fn := range []string{"photo.jpg", ".DS_Store", "Thumbs.db", "scratch.tmp", "notes.txt"} {
+		dm.Add(FileStruct{Name: fn, Size: 1})
+	}
+	*dm.stale = false
+	if err := dm.DeleteMatchingFiles(nil); err != nil || dm.Stale() {
+		t.Error("Nothing to exclude should change nothing", err)
+	}
+	if err := dm.DeleteMatchingFiles([]string{".DS_Store", "Thumbs.db", "*.tmp"}); err != nil {
+		t.Fatal(err)
+	}
+	for fn, expected := range map[string]bool{"photo.jpg": true, "notes.txt": true, ".DS_Store": false, "Thumbs.db": false, "scratch.tmp": false} {
+		if _, ok := dm.Get(fn); ok != expected {
+			t.Error("Expected", fn, "present to be", expected)
+		}
+	}
+	if !dm.Stale() {
+		t.Error("Removing entries should need a persist")
+	}
+	if err := dm.DeleteMatchingFiles([]string{"[bad"}); err == nil {
+		t.Error("Expected a bad pattern to be reported")
+	}
+}
+
+func TestDirectoryMapDeleteIgnoredFiles(t *testing.T) {
+	root := t.TempDir()
+	src := filepath.Join(root, "src")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, IgnoreFileName), []byte("*.tmp\n!keep.tmp\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, IgnoreFileName), []byte("*.py\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dm := NewDirectoryMap()
+	for _, fn := range []string{"photo.jpg", "mod.py", "scratch.tmp", "keep.tmp", "Thumbs.db"} {
+		dm.Add(FileStruct{Name: fn, Size: 1})
+	}
+	if err := dm.DeleteIgnoredFiles(root, src, []string{"Thumbs.db"}); err != nil {
+		t.Fatal(err)
+	}
+	for fn, expected := range map[string]bool{"photo.jpg": true, "keep.tmp": true, "mod.py": false, "scratch.tmp": false, "Thumbs.db": false} {
+		if _, ok := dm.Get(fn); ok != expected {
+			t.Error("Expected", fn, "present to be", expected)
+		}
+	}
+}
+
+func TestDirectoryMapCompact(t *testing.T) {
+	wkDir, err := os.MkdirTemp("", "dmCompact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wkDir)
+	names := []string{"keep", "gone0", "gone1", "gone2"}
+	dm := NewDirectoryMap()
+	for _, fn := range names {
+		if err := os.WriteFile(filepath.Join(wkDir, fn), []byte(fn), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fs, err := NewFileStruct(wkDir, fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dm.Add(fs)
+	}
+	if err := dm.Persist(wkDir); err != nil {
+		t.Fatal(err)
+	}
+	xmlSize := func() int64 {
+		info, err := os.Stat(filepath.Join(wkDir, GetMetadataFilename()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Size()
+	}
+	before := xmlSize()
+	for _, fn := range names[1:] {
+		if err := os.Remove(filepath.Join(wkDir, fn)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Nothing happens until asked
+	if err := dm.Persist(wkDir); err != nil {
+		t.Fatal(err)
+	}
+	if xmlSize() != before {
+		t.Error("Metadata changed without compacting")
+	}
+
+	removed, err := dm.Compact(wkDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 3 || dm.Len() != 1 {
+		t.Error("Expected 3 removed leaving 1, got", removed, dm.Len())
+	}
+	if _, ok := dm.Get("keep"); !ok {
+		t.Error("The file still there was removed")
+	}
+	if err := dm.Persist(wkDir); err != nil {
+		t.Fatal(err)
+	}
+	if after := xmlSize(); after >= before {
+		t.Error("Expected the metadata to shrink from", before, "got", after)
+	}
+	if removed, err := dm.Compact(wkDir); removed != 0 || err != nil {
+		t.Error("Nothing left to remove", removed, err)
+	}
+}
+
+func TestDirectoryMapCompactOnWrite(t *testing.T) {
+	wkDir, err := os.MkdirTemp("", "dmCompactOnWrite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wkDir)
+	dm := NewDirectoryMap()
+	dm.opts.CompactOnWrite = true
+	for _, fn := range []string{"keep", "gone"} {
+		if err := os.WriteFile(filepath.Join(wkDir, fn), []byte(fn), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fs, err := NewFileStruct(wkDir, fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dm.Add(fs)
+	}
+	if err := os.Remove(filepath.Join(wkDir, "gone")); err != nil {
+		t.Fatal(err)
+	}
+	if err := dm.Persist(wkDir); err != nil {
+		t.Fatal(err)
+	}
+	written, err := DirectoryMapFromDir(wkDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := written.Get("gone"); ok || written.Len() != 1 {
+		t.Error("Expected only the file still there to be written, got", written.Len())
+	}
+}
+
+func TestDirectoryMapWriteXMLMatchesMarshal(t *testing.T) {
+	// Persist writes the xml a file at a time
+	// it should read back the same as marshalling it all at once would
+	dm := NewDirectoryMap()
+	for i := 0; i < 10; i++ {
+		dm.Add(FileStruct{Name: fmt.Sprint("file", i), Checksum: fmt.Sprint("cks", i), Size: int64(i), Tags: []string{"tag"}})
+	}
+	dm.meta.InheritedTags = []string{"inherited"}
+
+	var sb strings.Builder
+	if err := dm.writeXML(&sb, "someDir"); err != nil {
+		t.Fatal(err)
+	}
+	var streamed Md5File
+	if err := xml.Unmarshal([]byte(sb.String()), &streamed); err != nil {
+		t.Fatal(err, sb.String())
+	}
+	m5f, err := dm.ToMd5File("someDir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ba, err := xml.MarshalIndent(m5f, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var marshalled Md5File
+	if err := xml.Unmarshal(ba, &marshalled); err != nil {
+		t.Fatal(err)
+	}
+	sort.Sort(streamed.Files)
+	sort.Sort(marshalled.Files)
+	if !reflect.DeepEqual(streamed, marshalled) {
+		t.Error("Streamed xml differs:\n", sb.String(), "\n", string(ba))
+	}
+}
+
+func TestDirectoryMapPersistSweepsTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	stale := filepath.Join(dir, GetMetadataFilename()+".tmp1234")
+	fresh := filepath.Join(dir, GetMetadataFilename()+".tmp5678")
+	for _, fn := range []string{stale, fresh} {
+		if err := os.WriteFile(fn, []byte("<dr>"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * metadataTempMaxAge)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatal(err)
+	}
+	// Reading the metadata should not change anything
+	dm, err := DirectoryMapFromDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stale); err != nil {
+		t.Error("Loading the metadata removed a temp file", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte("file"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := dm.UpdateChecksum(dir, "file", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := dm.Persist(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Error("Stale temp file left behind")
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Error("A temp file that might still be being written was removed", err)
+	}
+	if !IsMedorgFile(filepath.Base(fresh)) || IsMedorgFile("photo.jpg") {
+		t.Error("IsMedorgFile should only match our files")
+	}
+}
@@ -0,0 +1,129 @@
+package medorg
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DirectoryMeta holds the properties of a directory as a whole
+// rather than of any one file within it
+type DirectoryMeta struct {
+	// InheritedTags apply to every file in this directory
+	// and in all directories below it
+	InheritedTags []string `xml:"tag,omitempty"`
+}
+
+func (meta *DirectoryMeta) empty() bool {
+	return meta == nil || len(meta.InheritedTags) == 0
+}
+
+// InheritedTags returns the tags this directory passes on to its files
+func (dm DirectoryMap) InheritedTags() []string {
+	dm.lock.RLock()
+	defer dm.lock.RUnlock()
+	return append([]string{}, dm.meta.InheritedTags...)
+}
+
+// SetInheritedTags sets the tags this directory passes on to its files.
+// Files already loaded do not see the change until the directory is read in again
+func (dm DirectoryMap) SetInheritedTags(tags []string) {
+	dm.lock.Lock()
+	dm.meta.InheritedTags = append([]string{}, tags...)
+	*dm.stale = true
+	dm.lock.Unlock()
+}
+
+// readDirectoryMeta reads only the DirectoryMeta from a directory's xml
+// We stop as soon as we get to the files, which can be a long list
+func readDirectoryMeta(directory string) (DirectoryMeta, error) {
+	var meta DirectoryMeta
+	f, err := os.Open(filepath.Join(directory, GetMetadataFilename()))
+	if err != nil {
+		return meta, err
+	}
+	defer f.Close()
+	decoder := xml.NewDecoder(f)
+	for {
+		tok, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return meta, nil
+		}
+		if err != nil {
+			return meta, err
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch se.Name.Local {
+		case "DirectoryMeta":
+			return meta, decoder.DecodeElement(&meta, &se)
+		case "fr":
+			return meta, nil
+		}
+	}
+}
+
+type dirMetaCacheEntry struct {
+	modTime time.Time
+	size    int64
+	tags    []string
+}
+
+// dirMetaCache remembers the inherited tags of each parent directory we
+// have read, so a walk does not re-read the same xml for every subdirectory.
+// An entry is good until its xml file changes.
+var dirMetaCache = struct {
+	sync.Mutex
+	m map[string]dirMetaCacheEntry
+}{m: make(map[string]dirMetaCacheEntry)}
+
+// cachedInheritedTags returns the tags directory passes on to those below it
+func cachedInheritedTags(directory string) []string {
+	fi, err := os.Stat(filepath.Join(directory, GetMetadataFilename()))
+	if err != nil {
+		// Most directories above a walk will not have an xml file
+		return nil
+	}
+	dirMetaCache.Lock()
+	entry, ok := dirMetaCache.m[directory]
+	dirMetaCache.Unlock()
+	if ok && entry.size == fi.Size() && entry.modTime.Equal(fi.ModTime()) {
+		return entry.tags
+	}
+	meta, err := readDirectoryMeta(directory)
+	if err != nil {
+		// A broken file up the tree is for someone else to report
+		return nil
+	}
+	dirMetaCache.Lock()
+	dirMetaCache.m[directory] = dirMetaCacheEntry{modTime: fi.ModTime(), size: fi.Size(), tags: meta.InheritedTags}
+	dirMetaCache.Unlock()
+	return meta.InheritedTags
+}
+
+// parentInheritedTags collects the inherited tags of the
+// directories above this one, up to and including root.
+// An empty root means go all the way up.
+func parentInheritedTags(directory, root string) []string {
+	var tags []string
+	dir, err := filepath.Abs(directory)
+	if err != nil {
+		return nil
+	}
+	if root != "" {
+		root, err = filepath.Abs(root)
+		if err != nil {
+			return nil
+		}
+	}
+	for parent := filepath.Dir(dir); parent != dir && dir != root; dir, parent = parent, filepath.Dir(parent) {
+		tags = append(tags, cachedInheritedTags(parent)...)
+	}
+	return tags
+}
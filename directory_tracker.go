@@ -1,6 +1,7 @@
 package medorg
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/fs"
@@ -8,6 +9,7 @@ import (
 	"path/filepath"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 var errorMissingDe = errors.New("missing de when evaluating directory")
@@ -52,6 +54,28 @@ type DirTracker struct {
 	errChan   chan error
 	preserveStructs bool
 
+	ctx           context.Context
+	stats         WalkStats
+	started       time.Time
+	symlinkPolicy SymlinkPolicy
+	scanOrder     ScanOrder
+	// The walk that counts directories needs its own, as it runs alongside
+	ignore          *ignoreRules
+	countIgnore     *ignoreRules
+	splitWarnings   bool
+	warnChan        chan error
+	continueOnError bool
+	// Directories more than maxDepth below root are not walked
+	root     string
+	maxDepth int
+	// Directories whose entry we could not make, so whose files we skip
+	// Only used by the directory walker, so no lock
+	failedDirs map[string]struct{}
+	// The paths we were not allowed to read, for the stats
+	permLock     sync.Mutex
+	permErrors   []string
+	progressChan chan DirTrackerProgress
+
 	finished finishedB
 }
 
@@ -72,8 +96,22 @@ const NumTrackerOutstanding = 4
 // At some later time, we will then close the directory
 // There are no guaranetees about when this will happen
 func NewDirTracker(preserveStructs bool, dir string, newEntry func(string) (DirectoryTrackerInterface, error)) *DirTracker {
+	return NewDirTrackerWithContext(context.Background(), preserveStructs, dir, newEntry)
+}
+
+// NewDirTrackerWithContext is NewDirTracker, but will stop walking
+// new directories when the context is cancelled.
+// ctx.Err() is then returned on the ErrChan
+func NewDirTrackerWithContext(ctx context.Context, preserveStructs bool, dir string, newEntry func(string) (DirectoryTrackerInterface, error)) *DirTracker {
+	return NewDirTrackerWithOptions(ctx, preserveStructs, dir, newEntry, DefaultDirTrackerOptions())
+}
+
+// NewDirTrackerWithOptions is NewDirTrackerWithContext, walking as opts says
+func NewDirTrackerWithOptions(ctx context.Context, preserveStructs bool, dir string, newEntry func(string) (DirectoryTrackerInterface, error), opts DirTrackerOptions) *DirTracker {
 	numOutsanding := NumTrackerOutstanding // FIXME expose this
 	var dt DirTracker
+	dt.ctx = ctx
+	dt.started = time.Now()
 	dt.dm = make(map[string]DirectoryTrackerInterface)
 	dt.newEntry = newEntry
 	dt.tokenChan = makeTokenChan(numOutsanding)
@@ -82,9 +120,20 @@ func NewDirTracker(preserveStructs bool, dir string, newEntry func(string) (Dire
 	dt.wg.Add(1) // add one for populateDircount
 	dt.finished.Clear()
 	dt.preserveStructs = preserveStructs
+	dt.symlinkPolicy = opts.SymlinkPolicy
+	dt.scanOrder = opts.ScanOrder
+	dt.ignore = newIgnoreRules(dir, opts.IgnorePatterns)
+	dt.countIgnore = newIgnoreRules(dir, opts.IgnorePatterns)
+	dt.splitWarnings = opts.SplitWarnings
+	dt.continueOnError = opts.ContinueOnError
+	dt.root = dir
+	dt.maxDepth = opts.MaxDepth
+	dt.warnChan = make(chan error, warnChanSize)
+	dt.failedDirs = make(map[string]struct{})
+	dt.progressChan = make(chan DirTrackerProgress, progressChanSize)
 	go dt.populateDircount(dir)
 	go func() {
-		err := filepath.WalkDir(dir, dt.directoryWalker)
+		err := walkDirSymlinks(dir, dt.symlinkPolicy, dt.scanOrder, true, dt.directoryWalker)
 		if err != nil {
 			dt.errChan <- err
 		}
@@ -92,14 +141,19 @@ func NewDirTracker(preserveStructs bool, dir string, newEntry func(string) (Dire
 			val.Close()
 		}
 		dt.wg.Wait()
-		if dt.Total() != dt.Value() {
+		// If we stopped early then of course the counts won't match
+		if err == nil && dt.Total() != dt.Value() {
 			// FIXME I'm not sure panic is correct here
 			// If the file system changes while we are walking, we may not get the correct count
 			// Helpful for debugging though
 			panic("hadn't actually finished")
 		}
+		atomic.StoreInt64((*int64)(&dt.stats.Elapsed), int64(time.Since(dt.started)))
 		dt.finished.Set()
+		dt.sendProgress(DirTrackerProgress{Done: true})
+		close(dt.progressChan)
 		close(dt.errChan)
+		close(dt.warnChan)
 		close(dt.tokenChan)
 	}()
 
@@ -122,6 +176,26 @@ func (dt *DirTracker) Value() int64 {
 	return atomic.LoadInt64(&dt.directoryCountVisited)
 }
 
+// Stats returns how far the walk has got
+// Safe to call while the walk is in progress
+func (dt *DirTracker) Stats() WalkStats {
+	stats := dt.stats.load()
+	if stats.Elapsed == 0 {
+		stats.Elapsed = time.Since(dt.started)
+	}
+	dt.permLock.Lock()
+	stats.PermissionErrors = append([]string(nil), dt.permErrors...)
+	dt.permLock.Unlock()
+	return stats
+}
+
+// GetStats is the old name for Stats
+//
+// Deprecated: use Stats
+func (dt *DirTracker) GetStats() WalkStats {
+	return dt.Stats()
+}
+
 // Finished - have we finished yet?
 func (dt *DirTracker) Finished() bool {
 	return dt.finished.Get()
@@ -136,7 +210,8 @@ func (dt *DirTracker) runChild(de DirectoryTrackerInterface) {
 	// Start is allowed to consume significant time
 	// In fact it may directly be the main runner
 	err := de.Start()
-	if err != nil {
+	if err != nil && !dt.warn(err) {
+		atomic.AddInt64(&dt.stats.FilesErrored, 1)
 		dt.errChan <- err
 	}
 	dt.wg.Done()
@@ -144,13 +219,24 @@ func (dt *DirTracker) runChild(de DirectoryTrackerInterface) {
 // serviceChild - copy errors from the child to the parent
 func (dt *DirTracker) serviceChild(de DirectoryTrackerInterface) {
 	for err := range de.ErrChan() {
-		if err != nil {
+		if err != nil && !dt.warn(err) {
+			atomic.AddInt64(&dt.stats.FilesErrored, 1)
 			dt.errChan <- err
 		}
 	}
 	dt.wg.Done()
 }
 
+// carryOn sends err to the ErrChan, if we have been asked to continue past errors
+// Returns false if err should stop the walk
+func (dt *DirTracker) carryOn(err error) bool {
+	if !dt.continueOnError {
+		return false
+	}
+	dt.errChan <- err
+	return true
+}
+
 // getDirectoryEntry - get a directory entry
 // If it doesn't exist, create it
 func (dt *DirTracker) getDirectoryEntry(path string) (DirectoryTrackerInterface, error) {
@@ -175,7 +261,7 @@ func (dt *DirTracker) getDirectoryEntry(path string) (DirectoryTrackerInterface,
 // i.e. how many directories we have to visit
 func (dt *DirTracker) populateDircount(dir string) {
 	defer dt.wg.Done()
-	err := filepath.WalkDir(dir, dt.directoryWalkerPopulateDircount)
+	err := walkDirSymlinks(dir, dt.symlinkPolicy, dt.scanOrder, false, dt.directoryWalkerPopulateDircount)
 	if err != nil {
 		// FIXME Question: I did eveything else on this with atomics - is this correct?
 		dt.directoryCountTotal = -1
@@ -186,12 +272,17 @@ func (dt *DirTracker) populateDircount(dir string) {
 
 func (dt *DirTracker) directoryWalkerPopulateDircount(path string, d fs.DirEntry, err error) error {
 	if err != nil {
+		if (dt.splitWarnings && IsWarning(err)) || dt.continueOnError {
+			// The main walk will report it
+			return nil
+		}
 		return err
 	}
 	if d.IsDir() {
-		if isHiddenDirectory(path) {
+		if isHiddenDirectory(path) || dt.countIgnore.ignored(path, true) || dt.tooDeep(path) {
 			return filepath.SkipDir
 		}
+		dt.countIgnore.enterDirectory(path)
 		log.Println("populating dir", path, dt.Total())
 		atomic.AddInt64(&dt.directoryCountTotal, 1)
 	}
@@ -202,11 +293,14 @@ func (dt *DirTracker) directoryWalkerPopulateDircount(path string, d fs.DirEntry
 	return nil
 }
 func (dt *DirTracker) handleDirectory(path string) error{
-	if isHiddenDirectory(path) {
+	if isHiddenDirectory(path) || dt.ignore.ignored(path, true) || dt.tooDeep(path) {
 		return filepath.SkipDir
 	}
+	dt.ignore.enterDirectory(path)
 	log.Println("visiting dir", path, dt.Value(), "of", dt.Total())
 	atomic.AddInt64(&dt.directoryCountVisited, 1)
+	atomic.AddInt64(&dt.stats.DirsEntered, 1)
+	dt.sendProgress(DirTrackerProgress{DirEntered: path})
 	closerFunc := func(pt string) {
 		// FIXME we will want this back when we are not revisiting
 		de, ok := dt.dm[pt]
@@ -220,7 +314,13 @@ func (dt *DirTracker) handleDirectory(path string) error{
 	dt.lastPath.Closer(path, closerFunc)
 	de, err := dt.getDirectoryEntry(path)
 	if err != nil {
-		return fmt.Errorf("%w::%s", err, path)
+		err = fmt.Errorf("%w::%s", err, path)
+		if dt.warnAt(err, path) || dt.carryOn(err) {
+			// Carry on into the subdirectories, which may be fine
+			dt.failedDirs[path] = struct{}{}
+			return nil
+		}
+		return err
 	}
 	if de == nil {
 		return fmt.Errorf("%w::%s", errorMissingDe, path)
@@ -229,6 +329,16 @@ func (dt *DirTracker) handleDirectory(path string) error{
 }
 func (dt *DirTracker) directoryWalker(path string, d fs.DirEntry, err error) error {
 	if err != nil {
+		if dt.warn(err) {
+			return nil
+		}
+		atomic.AddInt64(&dt.stats.FilesErrored, 1)
+		if dt.carryOn(err) {
+			return nil
+		}
+		return err
+	}
+	if err := dt.ctx.Err(); err != nil {
 		return err
 	}
 	if d.IsDir() {
@@ -239,6 +349,9 @@ func (dt *DirTracker) directoryWalker(path string, d fs.DirEntry, err error) err
 		log.Println("Skipping:", dir)
 		return filepath.SkipDir
 	}
+	if dt.ignore.ignored(path, false) {
+		return nil
+	}
 	if dir == "" {
 		dir = "."
 	} else {
@@ -247,6 +360,15 @@ func (dt *DirTracker) directoryWalker(path string, d fs.DirEntry, err error) err
 		// but since we always have this suffix(Thanks filepath!), this is faster:
 		dir = dir[:len(dir)-1]
 	}
+	if _, failed := dt.failedDirs[dir]; failed {
+		return nil
+	}
+
+	atomic.AddInt64(&dt.stats.FilesVisited, 1)
+	dt.sendProgress(DirTrackerProgress{FileVisited: path})
+	if info, err := d.Info(); err == nil {
+		atomic.AddInt64(&dt.stats.BytesVisited, info.Size())
+	}
 
 	// Grab an IO token
 	<-dt.tokenChan
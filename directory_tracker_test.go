@@ -1,11 +1,15 @@
 package medorg
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/fs"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -91,9 +95,13 @@ func TestDirectoryTrackerAgainstMock(t *testing.T) {
 			makerFunc := func(dir string) (DirectoryTrackerInterface, error) {
 				return newMockDtType(), nil
 			}
-			errChan := NewDirTracker(false, root, makerFunc).ErrChan()
-			for err := range errChan {
-				t.Error(err)
+			for _, so := range []ScanOrder{ScanOrderName, ScanOrderMtimeDesc} {
+				opts := DefaultDirTrackerOptions()
+				opts.ScanOrder = so
+				errChan := NewDirTrackerWithOptions(context.Background(), false, root, makerFunc, opts).ErrChan()
+				for err := range errChan {
+					t.Error(so, err)
+				}
 			}
 		})
 	}
@@ -170,3 +178,507 @@ func TestDirectoryTrackerSpawning(t *testing.T) {
 		t.Log("Slept:", cnt, " times")
 	}
 }
+
+func TestDirectoryTrackerCancelled(t *testing.T) {
+	root, err := createTestMoveDetectDirectories(10, 1, 1)
+	if err != nil {
+		t.Error("Error creating test directories", err)
+	}
+	defer os.RemoveAll(root)
+
+	makerFunc := func(dir string) (DirectoryTrackerInterface, error) {
+		return newMockDtType(), nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	errCnt := 0
+	for err := range NewDirTrackerWithContext(ctx, false, root, makerFunc).ErrChan() {
+		if !errors.Is(err, context.Canceled) {
+			t.Error("Unexpected error", err)
+		}
+		errCnt++
+	}
+	if errCnt != 1 {
+		t.Error("Expected a single cancelled error, got", errCnt)
+	}
+}
+
+func TestDirectoryTrackerStats(t *testing.T) {
+	root, err := os.MkdirTemp("", "dtStats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	for _, sub := range []string{"a", "b"} {
+		dir := filepath.Join(root, sub)
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprint("file", i)), make([]byte, 100), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	makerFunc := func(dir string) (DirectoryTrackerInterface, error) {
+		return newMockDtType(), nil
+	}
+	dt := NewDirTracker(false, root, makerFunc)
+	for err := range dt.ErrChan() {
+		t.Error(err)
+	}
+	stats := dt.Stats()
+	if stats.Elapsed <= 0 {
+		t.Error("Expected an elapsed time, got", stats.Elapsed)
+	}
+	if dt.Stats().Elapsed != stats.Elapsed {
+		t.Error("Elapsed time should stop once the walk has finished")
+	}
+	if !reflect.DeepEqual(dt.GetStats(), stats) {
+		t.Error("GetStats should be the same as Stats, got", dt.GetStats())
+	}
+	stats.Elapsed = 0
+	expected := WalkStats{DirsEntered: 3, FilesVisited: 6, BytesVisited: 600, BytesHashed: 600}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Error("Expected", expected, "got", stats)
+	}
+	total := stats.Add(WalkStats{DirsEntered: 84, FilesVisited: 4225, FilesErrored: 1, Elapsed: 1500 * time.Millisecond})
+	if total.String() != "Scanned: 4,231 files in 87 directories, 1 errors in 1.5s" {
+		t.Error("Unexpected summary", total)
+	}
+}
+
+func TestDirectoryTrackerSymlinks(t *testing.T) {
+	root, err := os.MkdirTemp("", "dtLinks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	external, err := os.MkdirTemp("", "dtLinksExt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(external)
+
+	mustWrite := func(fn string) {
+		if err := os.WriteFile(fn, []byte(fn), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustLink := func(target, link string) {
+		if err := os.Symlink(target, link); err != nil {
+			t.Skip("Symlinks not supported:", err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(root, "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	mustWrite(filepath.Join(root, "a", "file"))
+	mustWrite(filepath.Join(external, "extfile"))
+	// A link to a file is always a file
+	mustLink(filepath.Join(root, "a", "file"), filepath.Join(root, "filelink"))
+	// A link to somewhere we'd walk anyway
+	mustLink(filepath.Join(root, "a"), filepath.Join(root, "alink"))
+	// A link outside, that has a cycle in it
+	mustLink(external, filepath.Join(root, "ext"))
+	mustLink(external, filepath.Join(external, "loop"))
+
+	testCases := []struct {
+		policy   SymlinkPolicy
+		expected []string
+	}{
+		{SymlinkSkip, []string{"a/file", "filelink"}},
+		{SymlinkReport, []string{"a/file", "filelink"}},
+		{SymlinkFollow, []string{"a/file", "ext/extfile", "filelink"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.policy.String(), func(t *testing.T) {
+			opts := DefaultDirTrackerOptions()
+			opts.SymlinkPolicy = tc.policy
+			var lk sync.Mutex
+			var visited []string
+			makerFunc := func(dir string) (DirectoryTrackerInterface, error) {
+				mdt := newMockDtType()
+				mdt.visiter = func(dir, file string) {
+					rel, _ := filepath.Rel(root, filepath.Join(dir, file))
+					lk.Lock()
+					visited = append(visited, filepath.ToSlash(rel))
+					lk.Unlock()
+				}
+				return mdt, nil
+			}
+			for err := range NewDirTrackerWithOptions(context.Background(), false, root, makerFunc, opts).ErrChan() {
+				t.Error(err)
+			}
+			sort.Strings(visited)
+			if fmt.Sprint(visited) != fmt.Sprint(tc.expected) {
+				t.Error("Expected", tc.expected, "got", visited)
+			}
+		})
+	}
+
+	// The link should be recorded as the file it points to, and follow it when it changes
+	checkLink := func(content []byte) {
+		t.Helper()
+		if err := recalcTestDirectory(root); err != nil {
+			t.Fatal(err)
+		}
+		dm, err := DirectoryMapFromDir(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fs, ok := dm.Get("filelink")
+		if !ok {
+			t.Fatal("filelink not recorded")
+		}
+		expected, err := CalcMd5File(filepath.Join(root, "a"), "file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fs.Size != int64(len(content)) || fs.Checksum != expected {
+			t.Error("Expected size", len(content), "checksum", expected, "got", fs.Size, fs.Checksum)
+		}
+	}
+	checkLink([]byte(filepath.Join(root, "a", "file")))
+	content := make([]byte, 5000)
+	if err := os.WriteFile(filepath.Join(root, "a", "file"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	checkLink(content)
+}
+
+func TestDirectoryTrackerMaxDepth(t *testing.T) {
+	// 5 levels: the root and 4 below it, each with a file in
+	root := t.TempDir()
+	dir := root
+	for i := 0; i < 5; i++ {
+		if i > 0 {
+			dir = filepath.Join(dir, fmt.Sprint("l", i))
+			if err := os.Mkdir(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.WriteFile(filepath.Join(dir, "file"), []byte(dir), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts := DefaultDirTrackerOptions()
+	opts.MaxDepth = 2
+
+	var lk sync.Mutex
+	var made, visited []string
+	makerFunc := func(dir string) (DirectoryTrackerInterface, error) {
+		rel, _ := filepath.Rel(root, dir)
+		lk.Lock()
+		made = append(made, filepath.ToSlash(rel))
+		lk.Unlock()
+		mdt := newMockDtType()
+		mdt.visiter = func(dir, file string) {
+			rel, _ := filepath.Rel(root, filepath.Join(dir, file))
+			lk.Lock()
+			visited = append(visited, filepath.ToSlash(rel))
+			lk.Unlock()
+		}
+		return mdt, nil
+	}
+	dt := NewDirTrackerWithOptions(context.Background(), false, root, makerFunc, opts)
+	for err := range dt.ErrChan() {
+		t.Error(err)
+	}
+	sort.Strings(made)
+	sort.Strings(visited)
+	if expected := []string{".", "l1", "l1/l2"}; fmt.Sprint(made) != fmt.Sprint(expected) {
+		t.Error("Expected entries for", expected, "got", made)
+	}
+	if expected := []string{"file", "l1/file", "l1/l2/file"}; fmt.Sprint(visited) != fmt.Sprint(expected) {
+		t.Error("Expected to visit", expected, "got", visited)
+	}
+	if dt.Total() != 3 {
+		t.Error("Only the directories we walk should be counted, got", dt.Total())
+	}
+}
+
+func TestDirectoryTrackerProgressChan(t *testing.T) {
+	root, err := createTestMoveDetectDirectories(5, 1, 1)
+	if err != nil {
+		t.Fatal("Error creating test directories", err)
+	}
+	defer os.RemoveAll(root)
+	expectedFiles := 0
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			expectedFiles++
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	makerFunc := func(dir string) (DirectoryTrackerInterface, error) {
+		return newMockDtType(), nil
+	}
+	dt := NewDirTracker(false, root, makerFunc)
+	progress := dt.ProgressChan()
+	var files, dirs int
+	var done bool
+	progressDone := make(chan struct{})
+	go func() {
+		for ev := range progress {
+			switch {
+			case ev.Done:
+				done = true
+			case ev.DirEntered != "":
+				dirs++
+			case ev.FileVisited != "":
+				files++
+			}
+		}
+		close(progressDone)
+	}()
+	for err := range dt.ErrChan() {
+		t.Error(err)
+	}
+	<-progressDone
+	// Events may be dropped, but never invented
+	if files > expectedFiles {
+		t.Error("Expected at most", expectedFiles, "files, got", files)
+	}
+	if int64(dirs) > dt.Stats().DirsEntered {
+		t.Error("Expected at most", dt.Stats().DirsEntered, "directories, got", dirs)
+	}
+	if !done {
+		t.Error("Never saw the done event")
+	}
+}
+
+func TestDirectoryTrackerProgressChanNoReader(t *testing.T) {
+	root, err := createTestMoveDetectDirectories(5, 4, 4)
+	if err != nil {
+		t.Fatal("Error creating test directories", err)
+	}
+	defer os.RemoveAll(root)
+
+	makerFunc := func(dir string) (DirectoryTrackerInterface, error) {
+		return newMockDtType(), nil
+	}
+	dt := NewDirTracker(false, root, makerFunc)
+	// Ask for the channel, but never read it until the walk is over
+	progress := dt.ProgressChan()
+	for err := range dt.ErrChan() {
+		t.Error(err)
+	}
+	var last DirTrackerProgress
+	for ev := range progress {
+		last = ev
+	}
+	if !last.Done {
+		t.Error("The done event was not the last event", last)
+	}
+}
+
+func TestDirectoryTrackerWarnings(t *testing.T) {
+	root, err := os.MkdirTemp("", "dtWarnings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	for _, sub := range []string{"good", "bad", filepath.Join("bad", "below")} {
+		dir := filepath.Join(root, sub)
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errBoom := errors.New("boom")
+	makerFunc := func(failWith error) func(string) (DirectoryTrackerInterface, error) {
+		return func(dir string) (DirectoryTrackerInterface, error) {
+			if filepath.Base(dir) == "bad" {
+				return nil, failWith
+			}
+			return newMockDtType(), nil
+		}
+	}
+	walk := func(split bool, failWith error) (warnings, errs []error, stats WalkStats) {
+		opts := DefaultDirTrackerOptions()
+		opts.SplitWarnings = split
+		dt := NewDirTrackerWithOptions(context.Background(), false, root, makerFunc(failWith), opts)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for w := range dt.WarnChan() {
+				warnings = append(warnings, w)
+			}
+		}()
+		for err := range dt.ErrChan() {
+			errs = append(errs, err)
+		}
+		wg.Wait()
+		return warnings, errs, dt.Stats()
+	}
+
+	// A permission problem is only a warning, and the walk carries on below it
+	warnings, errs, stats := walk(true, fs.ErrPermission)
+	if len(errs) != 0 {
+		t.Error("Unexpected errors", errs)
+	}
+	if len(warnings) != 1 || !errors.Is(warnings[0], fs.ErrPermission) {
+		t.Error("Expected a permission warning, got", warnings)
+	}
+	// The file in bad is skipped, the others are visited
+	if stats.FilesVisited != 2 || stats.DirsEntered != 4 || stats.Warnings != 1 {
+		t.Error("Unexpected stats", stats)
+	}
+
+	// Anything else is still fatal
+	warnings, errs, _ = walk(true, errBoom)
+	if len(warnings) != 0 {
+		t.Error("Unexpected warnings", warnings)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], errBoom) {
+		t.Error("Expected the fatal error, got", errs)
+	}
+
+	// Unless asked for, warnings are errors as they always were
+	warnings, errs, _ = walk(false, fs.ErrPermission)
+	if len(warnings) != 0 {
+		t.Error("Unexpected warnings", warnings)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], fs.ErrPermission) {
+		t.Error("Expected the permission error, got", errs)
+	}
+}
+
+func TestDirectoryTrackerContinueOnError(t *testing.T) {
+	root := t.TempDir()
+	for _, sub := range []string{"good", "bad", filepath.Join("good", "bad"), filepath.Join("bad", "below")} {
+		dir := filepath.Join(root, sub)
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errBoom := errors.New("boom")
+	makerFunc := func(dir string) (DirectoryTrackerInterface, error) {
+		if filepath.Base(dir) == "bad" {
+			return nil, errBoom
+		}
+		return newMockDtType(), nil
+	}
+	opts := DefaultDirTrackerOptions()
+	opts.ContinueOnError = true
+	dt := NewDirTrackerWithOptions(context.Background(), false, root, makerFunc, opts)
+	var errs []error
+	for err := range dt.ErrChan() {
+		errs = append(errs, err)
+	}
+	// Both bad directories are reported, rather than stopping at the first
+	if len(errs) != 2 || !errors.Is(errs[0], errBoom) || !errors.Is(errs[1], errBoom) {
+		t.Error("Expected both errors, got", errs)
+	}
+	// The files in the bad directories are skipped, the others are visited
+	stats := dt.Stats()
+	if stats.FilesVisited != 2 || stats.DirsEntered != 5 {
+		t.Error("Unexpected stats", stats)
+	}
+}
+
+func TestDirectoryTrackerWarningsNoReader(t *testing.T) {
+	root := t.TempDir()
+	const numDirs = warnChanSize + 10
+	for i := 0; i < numDirs; i++ {
+		if err := os.Mkdir(filepath.Join(root, fmt.Sprint("bad", i)), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	makerFunc := func(dir string) (DirectoryTrackerInterface, error) {
+		if dir != root {
+			return nil, fs.ErrPermission
+		}
+		return newMockDtType(), nil
+	}
+	opts := DefaultDirTrackerOptions()
+	opts.SplitWarnings = true
+	dt := NewDirTrackerWithOptions(context.Background(), false, root, makerFunc, opts)
+	// Never read the warnings until the walk is over
+	for err := range dt.ErrChan() {
+		t.Error(err)
+	}
+	var warnings int
+	for range dt.WarnChan() {
+		warnings++
+	}
+	if warnings != warnChanSize {
+		t.Error("Expected a full buffer of warnings, got", warnings)
+	}
+	if stats := dt.Stats(); stats.Warnings != numDirs {
+		t.Error("Expected every warning to be counted, got", stats.Warnings)
+	}
+}
+
+func TestDirectoryTrackerPermissionDenied(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can read anything")
+	}
+	root, err := os.MkdirTemp("", "dtPermission")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	for _, sub := range []string{"a", "denied", "z"} {
+		dir := filepath.Join(root, sub)
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	denied := filepath.Join(root, "denied")
+	if err := os.Chmod(denied, 0o000); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(denied, 0o755)
+
+	opts := DefaultDirTrackerOptions()
+	opts.SplitWarnings = true
+	makerFunc := func(dir string) (DirectoryTrackerInterface, error) {
+		mkFk := func(dir string) (DirectoryEntryInterface, error) {
+			dm, err := DirectoryMapFromDir(dir)
+			dm.VisitFunc = recalcForTest
+			return dm, err
+		}
+		return NewDirectoryEntry(dir, mkFk)
+	}
+	dt := NewDirTrackerWithOptions(context.Background(), false, root, makerFunc, opts)
+	var warnings []error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for w := range dt.WarnChan() {
+			warnings = append(warnings, w)
+		}
+	}()
+	for err := range dt.ErrChan() {
+		t.Error("Unexpected error", err)
+	}
+	wg.Wait()
+	stats := dt.Stats()
+	if len(warnings) == 0 {
+		t.Error("Expected a warning")
+	}
+	// The siblings either side are still walked
+	if stats.FilesVisited != 2 {
+		t.Error("Expected both readable files visited, got", stats)
+	}
+	if len(stats.PermissionErrors) != 1 || stats.PermissionErrors[0] != denied {
+		t.Error("Expected", denied, "to be recorded, got", stats.PermissionErrors)
+	}
+}
@@ -5,12 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"os"
 	"os/user"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 // FIXME - this is rubbish
@@ -18,20 +18,86 @@ import (
 // We should be able to use that to pace limit this
 var md5WriteTokenChan = makeTokenChan(4)
 
+// isMetadataTempFile reports if fn is a metadata file we were part way through writing
+func isMetadataTempFile(fn string) bool {
+	return strings.HasPrefix(fn, GetMetadataFilename()+".tmp")
+}
+
+// metadataTempMaxAge is how old a metadata temp file must be
+// before we decide whoever was writing it is not going to finish
+var metadataTempMaxAge = time.Hour
+
+// sweepMetadataTempFiles removes the temp files left in directory
+// by metadata writes that were interrupted
+// Only call it when about to write the metadata, as it is not free
+func sweepMetadataTempFiles(directory string) {
+	f, err := os.Open(directory)
+	if err != nil {
+		return
+	}
+	names, _ := f.Readdirnames(-1)
+	f.Close()
+	for _, name := range names {
+		if !isMetadataTempFile(name) {
+			continue
+		}
+		fn := filepath.Join(directory, name)
+		fi, err := os.Stat(fn)
+		if err != nil || time.Since(fi.ModTime()) < metadataTempMaxAge {
+			// Might still be being written
+			continue
+		}
+		if err := os.Remove(fn); err == nil {
+			log.Println("Removed stale temp file", fn)
+		}
+	}
+}
+
 // md5FileWrite write to the directory's file
-// deletes the file, if the ba to write is empty
-func md5FileWrite(directory string, ba []byte) error {
+// writeFunc is given somewhere to write the new contents to,
+// and the file is deleted if writeFunc is nil.
+// The new contents are written alongside, then renamed over the old,
+// so however we are stopped the old or the new file is left intact.
+// Any temp files left by writes that were stopped are tidied up first.
+// With fsync, the new file is on disk before it replaces the old.
+func md5FileWrite(directory string, fsync bool, writeFunc func(w io.Writer) error) error {
 	<-md5WriteTokenChan
 	defer func() { md5WriteTokenChan <- struct{}{} }()
 
-	fn := filepath.Join(directory, Md5FileName)
-	if _, err := os.Stat(fn); !errors.Is(err, os.ErrNotExist) {
-		_ = os.Remove(fn)
-	}
-	if ba == nil || (len(ba) == 0) {
+	fn := filepath.Join(directory, GetMetadataFilename())
+	if writeFunc == nil {
+		if _, err := os.Stat(fn); !errors.Is(err, os.ErrNotExist) {
+			_ = os.Remove(fn)
+		}
 		return nil
 	}
-	return ioutil.WriteFile(fn, ba, 0600)
+	sweepMetadataTempFiles(directory)
+	f, err := os.CreateTemp(directory, GetMetadataFilename()+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpFn := f.Name()
+	bw := bufio.NewWriter(f)
+	err = writeFunc(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil && fsync {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmpFn, 0600)
+	}
+	if err == nil {
+		err = os.Rename(tmpFn, fn)
+	}
+	if err != nil {
+		_ = os.Remove(tmpFn)
+	}
+	return err
 }
 
 // FileExist tests if a file exists in a convenient fashion
@@ -92,7 +158,15 @@ func createDestDirectoryAsNeeded(dst string) error {
 // CopyFile copies a file from src to dst. If src and dst files exist, and are
 // the same, then return success. Otherise, attempt to create a hard link
 // between the two files. If that fail, copy the file contents from src to dst.
-func CopyFile(src, dst Fpath) (err error) {
+func CopyFile(src, dst Fpath) error {
+	return CopyFileWithProgress(src, dst, nil)
+}
+
+// CopyFileWithProgress is CopyFile, but also calls progress with the number of bytes
+// copied each time another 64KB, or the end of the file, has been copied
+// so the caller can see how far through it is. progress may be nil.
+// progress is not called if the copy is done with a hard link.
+func CopyFileWithProgress(src, dst Fpath, progress func(n int64)) (err error) {
 	srcs := string(src)
 	dsts := string(dst)
 	sfi, err := os.Stat(srcs)
@@ -125,8 +199,74 @@ func CopyFile(src, dst Fpath) (err error) {
 	if err = os.Link(srcs, dsts); err == nil {
 		return nil
 	}
-	return copyFileContents(srcs, dsts)
+	return copyFileContents(srcs, dsts, progress)
+}
+
+// CopyFileBytes copies the contents of src to dst, creating dst's directory
+// as needed. Unlike CopyFile it never hard links, so dst is always
+// a separate file that can be changed without changing src.
+func CopyFileBytes(src, dst Fpath) error {
+	if err := createDestDirectoryAsNeeded(string(dst)); err != nil {
+		return fmt.Errorf("issue in CopyFileBytes creating directory tree %w", err)
+	}
+	return copyFileContents(string(src), string(dst), nil)
+}
+
+// progressInterval is how many bytes are copied between progress callbacks
+const progressInterval = 64 << 10
+
+// CopyFileWithCallback is CopyFileWithProgress, but calls progress with
+// the number of bytes copied so far, every 64KB and once more at the end.
+// progress is not called if the copy is done with a hard link.
+func CopyFileWithCallback(src, dst Fpath, progress func(bytesWritten int64)) error {
+	return CopyFileWithProgress(src, dst, runningTotal(progress))
+}
+
+// runningTotal turns a callback wanting the bytes copied so far
+// into one told the size of each chunk
+func runningTotal(progress func(bytesWritten int64)) func(n int64) {
+	var written int64
+	return func(n int64) {
+		written += n
+		progress(written)
+	}
+}
+
+// CopyFileWithXattr copies a file as CopyFile does, and then
+// copies across any extended attributes (on macOS only)
+func CopyFileWithXattr(src, dst Fpath) error {
+	err := CopyFile(src, dst)
+	if err != nil {
+		return err
+	}
+	err = copyXattr(string(src), string(dst))
+	if err != nil {
+		return fmt.Errorf("unable to copy extended attributes %w %s", err, dst)
+	}
+	return nil
+}
+
+// CopyPermissions gives dst the same permission bits, user and group as src
+// Not being allowed to change the owner, as we aren't root, is not an error.
+func CopyPermissions(src, dst Fpath) error {
+	info, err := os.Stat(string(src))
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(string(dst), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("unable to copy permissions %w %s", err, dst)
+	}
+	uid, gid, ok := fileOwnerOf(info)
+	if !ok {
+		return nil
+	}
+	err = setFileOwner(string(dst), uid, gid)
+	if err != nil && !errors.Is(err, os.ErrPermission) {
+		return fmt.Errorf("unable to copy ownership %w %s", err, dst)
+	}
+	return nil
 }
+
 func rmFilename(fn Fpath) error {
 	fns := string(fn)
 	if _, err := os.Stat(fns); err == nil {
@@ -135,6 +275,28 @@ func rmFilename(fn Fpath) error {
 	return nil
 }
 
+// ErrUnsafeDeletion we were asked to remove a file outside the directories we look after
+var ErrUnsafeDeletion = errors.New("refusing to remove a file outside the allowed directories")
+
+// SafeRmFilename removes the file, but only if it is within one of allowedRoots
+func SafeRmFilename(path Fpath, allowedRoots []string) error {
+	fns, err := filepath.Abs(filepath.Clean(string(path)))
+	if err != nil {
+		return err
+	}
+	for _, root := range allowedRoots {
+		absRoot, err := filepath.Abs(root)
+		if err != nil {
+			continue
+		}
+		// The root itself is a directory, never a file we should be removing
+		if fns != absRoot && isWithin(fns, absRoot) {
+			return rmFilename(Fpath(fns))
+		}
+	}
+	return fmt.Errorf("%w::%s", ErrUnsafeDeletion, path)
+}
+
 // MoveFile Implements a move function that works across file systems
 // The inbuilt functions can struggle if hard links won't work
 // i.e. you want to move between mount points
@@ -158,7 +320,7 @@ func MoveFile(src, dst Fpath) (err error) {
 // by dst. The file will be created if it does not already exist. If the
 // destination file exists, all it's contents will be replaced by the contents
 // of the source file.
-func copyFileContents(srcs, dsts string) (err error) {
+func copyFileContents(srcs, dsts string, progress func(n int64)) (err error) {
 	in, err := os.Open(srcs)
 	if err != nil {
 		return fmt.Errorf("info error on src in copyFileContents : %w", err)
@@ -174,13 +336,38 @@ func copyFileContents(srcs, dsts string) (err error) {
 			err = cerr
 		}
 	}()
-	if _, err = io.Copy(out, in); err != nil {
+	if progress == nil {
+		_, err = io.Copy(out, in)
+	} else {
+		err = copyInChunks(out, in, progress)
+	}
+	if err != nil {
 		return
 	}
 	err = out.Sync()
 	return
 }
 
+// copyInChunks copies in to out progressInterval bytes at a time,
+// calling progress with the size of each chunk.
+// Each chunk is still copied with ReadFrom, so where the OS can
+// copy between the files itself the bytes never pass through us.
+func copyInChunks(out *os.File, in io.Reader, progress func(n int64)) error {
+	for {
+		n, err := out.ReadFrom(&io.LimitedReader{R: in, N: progressInterval})
+		if n > 0 {
+			progress(n)
+		}
+		if err != nil {
+			return err
+		}
+		// A short chunk means we reached the end of in
+		if n < progressInterval {
+			return nil
+		}
+	}
+}
+
 // LoadFile load in a filename and return the data a line at a time in the channel
 // FIXME only needed by broken autofix init design
 func LoadFile(filename string) (theChan chan string) {
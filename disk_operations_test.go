@@ -0,0 +1,134 @@
+package medorg
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyFileContentsProgress(t *testing.T) {
+	wkDir, err := os.MkdirTemp("", "copyProgress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wkDir)
+	src := filepath.Join(wkDir, "src")
+	dst := filepath.Join(wkDir, "dst")
+	content := bytes.Repeat([]byte("medorg"), 10000)
+	if err := os.WriteFile(src, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var progress int64
+	if err := copyFileContents(src, dst, func(n int64) { progress += n }); err != nil {
+		t.Fatal(err)
+	}
+	if progress != int64(len(content)) {
+		t.Error("Progress saw", progress, "bytes, expected", len(content))
+	}
+	copied, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(copied, content) {
+		t.Error("Copy does not match source")
+	}
+}
+
+func TestCopyFileContentsRunningTotal(t *testing.T) {
+	wkDir, err := os.MkdirTemp("", "copyRunningTotal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wkDir)
+	src := filepath.Join(wkDir, "src")
+	dst := filepath.Join(wkDir, "dst")
+	if err := os.WriteFile(src, make([]byte, 160<<10), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var reports []int64
+	if err := copyFileContents(src, dst, runningTotal(func(n int64) { reports = append(reports, n) })); err != nil {
+		t.Fatal(err)
+	}
+	expected := []int64{64 << 10, 128 << 10, 160 << 10}
+	if len(reports) != len(expected) {
+		t.Fatal("Expected", expected, "got", reports)
+	}
+	for i := range expected {
+		if reports[i] != expected[i] {
+			t.Error("Expected", expected, "got", reports)
+		}
+	}
+}
+
+func TestSafeRmFilename(t *testing.T) {
+	wkDir, err := os.MkdirTemp("", "safeRm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wkDir)
+	destDir := filepath.Join(wkDir, "dest")
+	if err := os.Mkdir(destDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	inside := filepath.Join(destDir, "file")
+	outside := filepath.Join(wkDir, "precious")
+	for _, fn := range []string{inside, outside} {
+		if err := os.WriteFile(fn, []byte(fn), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, escape := range []Fpath{
+		NewFpath(destDir, "../precious"),
+		NewFpath(destDir, "../../etc/passwd"),
+		Fpath(destDir + "-sibling/file"),
+		Fpath(destDir),
+	} {
+		if err := SafeRmFilename(escape, []string{destDir}); !errors.Is(err, ErrUnsafeDeletion) {
+			t.Error("Expected", escape, "to be refused, got", err)
+		}
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Error("File outside the root was removed", err)
+	}
+
+	if err := SafeRmFilename(Fpath(inside), []string{destDir}); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(inside); !errors.Is(err, os.ErrNotExist) {
+		t.Error("File inside the root should have gone", err)
+	}
+}
+
+func TestCopyPermissions(t *testing.T) {
+	wkDir, err := os.MkdirTemp("", "copyPerms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wkDir)
+	src := filepath.Join(wkDir, "src")
+	dst := filepath.Join(wkDir, "dst")
+	if err := os.WriteFile(src, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(src, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyFileContents(src, dst, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := CopyPermissions(Fpath(src), Fpath(dst)); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("Expected mode 0640, got %o", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,37 @@
+package medorg
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrTooManyErrors an ErrorCollector has collected as many errors as it may
+var ErrTooManyErrors = errors.New("too many errors")
+
+// ErrorCollector gathers errors, such as those from a walk made with
+// ContinueOnError, so they can be reported together at the end
+type ErrorCollector struct {
+	// Max is how many errors to collect before giving up, zero for no limit
+	Max  int
+	errs []error
+}
+
+// Add records err
+// Returns ErrTooManyErrors once Max errors have been collected
+func (ec *ErrorCollector) Add(err error) error {
+	ec.errs = append(ec.errs, err)
+	if ec.Max > 0 && len(ec.errs) >= ec.Max {
+		return fmt.Errorf("%w::%d", ErrTooManyErrors, len(ec.errs))
+	}
+	return nil
+}
+
+// Len is how many errors have been collected
+func (ec *ErrorCollector) Len() int {
+	return len(ec.errs)
+}
+
+// Err joins the collected errors, nil if there are none
+func (ec *ErrorCollector) Err() error {
+	return errors.Join(ec.errs...)
+}
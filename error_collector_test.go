@@ -0,0 +1,58 @@
+package medorg
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestErrorCollector(t *testing.T) {
+	root := t.TempDir()
+	for _, sub := range []string{"bad1", "bad2", "bad3", "good"} {
+		if err := os.Mkdir(filepath.Join(root, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errBoom := errors.New("boom")
+	makerFunc := func(dir string) (DirectoryTrackerInterface, error) {
+		if filepath.Base(dir) != "good" && dir != root {
+			return nil, errBoom
+		}
+		return newMockDtType(), nil
+	}
+	walk := func(ec *ErrorCollector) error {
+		opts := DefaultDirTrackerOptions()
+		opts.ContinueOnError = true
+		dt := NewDirTrackerWithOptions(context.Background(), false, root, makerFunc, opts)
+		var tooMany error
+		for err := range dt.ErrChan() {
+			if tooMany == nil {
+				tooMany = ec.Add(err)
+			}
+		}
+		return tooMany
+	}
+
+	// Without a limit, every error from the walk is collected
+	var ec ErrorCollector
+	if err := walk(&ec); err != nil {
+		t.Error("Unexpected", err)
+	}
+	if ec.Len() != 3 || !errors.Is(ec.Err(), errBoom) {
+		t.Error("Expected all 3 errors, got", ec.Err())
+	}
+
+	ec = ErrorCollector{Max: 2}
+	if err := walk(&ec); !errors.Is(err, ErrTooManyErrors) {
+		t.Error("Expected ErrTooManyErrors, got", err)
+	}
+	if ec.Len() != 2 {
+		t.Error("Expected to stop collecting at 2, got", ec.Len())
+	}
+
+	if err := (&ErrorCollector{}).Err(); err != nil {
+		t.Error("Expected no error from an empty collector, got", err)
+	}
+}
@@ -0,0 +1,43 @@
+package medorg_test
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+
+	"github.com/cbehopkins/medorg"
+)
+
+// Build an index of what is already on a backup drive
+// and use it to see if a file needs backing up, before running the backup
+func ExampleDuplicateIndex() {
+	srcDir, destDir := "/home/me/photos", "/mnt/backup"
+
+	index := medorg.NewDuplicateIndex()
+	visitor := func(dm medorg.DirectoryMap, dir, fn string, d fs.DirEntry, fileStruct medorg.FileStruct, fileInfo fs.FileInfo) error {
+		index.Add(fileStruct)
+		return nil
+	}
+	for err := range medorg.VisitFilesInDirectories([]string{destDir}, nil, visitor) {
+		fmt.Println("Error indexing backup:", err)
+		return
+	}
+	photo, err := medorg.NewFileStruct(srcDir, "holiday.jpg")
+	if err == nil {
+		err = photo.UpdateChecksum(false)
+	}
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if path, ok := index.Lookup(photo.Checksum, photo.Size); ok {
+		fmt.Println("Already backed up as", path)
+		return
+	}
+
+	xc := medorg.NewXMLCfg(medorg.ConfigPath(".medorg.xml"))
+	err = medorg.BackupRunner(context.Background(), xc, medorg.BackupOptions{}, 2, medorg.CopyFile, srcDir, destDir, nil, nil, nil)
+	if err != nil {
+		fmt.Println("Backup failed:", err)
+	}
+}
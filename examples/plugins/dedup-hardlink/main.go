@@ -0,0 +1,66 @@
+//go:build linux || darwin
+
+// dedup-hardlink is an example copier plugin for mdbackup
+// When the destination is on the same filesystem as the source
+// it hardlinks rather than copying, otherwise it falls back to a normal copy.
+//
+// Build with:
+//
+//	go build -buildmode=plugin -o ~/.medorg/plugins/dedup-hardlink.so ./examples/plugins/dedup-hardlink
+//
+// then run: mdbackup -copier dedup-hardlink src dst
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/cbehopkins/medorg"
+)
+
+// deviceID returns the device the path is on
+// For a path that doesn't exist yet, we look at the nearest parent that does
+func deviceID(path string) (uint64, bool) {
+	for {
+		info, err := os.Stat(path)
+		if err == nil {
+			st, ok := info.Sys().(*syscall.Stat_t)
+			if !ok {
+				return 0, false
+			}
+			return uint64(st.Dev), true
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return 0, false
+		}
+		path = parent
+	}
+}
+
+func hardlinkCopier(src, dst medorg.Fpath) error {
+	srcDev, srcOk := deviceID(string(src))
+	dstDev, dstOk := deviceID(string(dst))
+	if !srcOk || !dstOk || srcDev != dstDev {
+		return medorg.CopyFile(src, dst)
+	}
+	err := os.MkdirAll(filepath.Dir(string(dst)), 0755)
+	if err != nil {
+		return err
+	}
+	err = os.Link(string(src), string(dst))
+	if err != nil {
+		// Some filesystems don't support hardlinks
+		return medorg.CopyFile(src, dst)
+	}
+	return nil
+}
+
+// NewFileCopier is the symbol mdbackup looks for
+func NewFileCopier() medorg.FileCopier {
+	return hardlinkCopier
+}
+
+// main is never run, but a plugin must be a main package
+func main() {}
@@ -0,0 +1,15 @@
+//go:build windows
+
+package medorg
+
+import "io/fs"
+
+// fileOwnerOf is not supported on windows, where ownership is not a uid and gid
+func fileOwnerOf(info fs.FileInfo) (uid, gid uint32, ok bool) {
+	return 0, 0, false
+}
+
+// setFileOwner does nothing on windows
+func setFileOwner(path string, uid, gid uint32) error {
+	return nil
+}
@@ -0,0 +1,23 @@
+//go:build !windows
+
+package medorg
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// fileOwnerOf returns the user and group that own the file
+func fileOwnerOf(info fs.FileInfo) (uid, gid uint32, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return st.Uid, st.Gid, true
+}
+
+// setFileOwner gives the file to uid and gid
+func setFileOwner(path string, uid, gid uint32) error {
+	return os.Lchown(path, int(uid), int(gid))
+}
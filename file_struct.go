@@ -1,13 +1,42 @@
 package medorg
 
 import (
+	"context"
+	"encoding/xml"
 	"errors"
+	"fmt"
 	"io/fs"
+	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 var ErrRecalced = errors.New("File checksum has been recalculated")
+
+// ErrChecksumTimeout calculating the checksum took too long
+var ErrChecksumTimeout = errors.New("checksum calculation timed out")
+
+// FileMode is a file's permission bits, written to the xml in octal
+// so that 0644 reads as 0644. Older files have them in decimal, which we still read.
+type FileMode uint32
+
+// MarshalXMLAttr writes the mode in octal
+func (fm FileMode) MarshalXMLAttr(name xml.Name) (xml.Attr, error) {
+	return xml.Attr{Name: name, Value: fmt.Sprintf("%#o", uint32(fm))}, nil
+}
+
+// UnmarshalXMLAttr reads an octal mode, with a leading 0, or an older decimal one
+func (fm *FileMode) UnmarshalXMLAttr(attr xml.Attr) error {
+	mode, err := strconv.ParseUint(attr.Value, 0, 32)
+	if err != nil {
+		return err
+	}
+	*fm = FileMode(mode)
+	return nil
+}
+
 // FileStruct contains all the properties associated with a file
 type FileStruct struct {
 	XMLName   struct{} `xml:"fr"`
@@ -15,11 +44,27 @@ type FileStruct struct {
 	// and that does not want to end up in the final xml file
 	Name     string `xml:"fname,attr"`
 	Checksum string `xml:"checksum,attr"`
+	// Checksum256 is the SHA-256 of the file, for those who want more than MD5
+	// Files only have one once check_calc -algo sha256 (or both) has been run on them
+	Checksum256 string `xml:"sha256,attr,omitempty"`
 
 	Mtime      int64    `xml:"mtime,attr,omitempty"`
 	Size       int64    `xml:"size,attr"`
-	Tags       []string `xml:"tag,omitempty"`
-	BackupDest []string `xml:"bd,omitempty"`
+	Mode       FileMode `xml:"mode,attr,omitempty"` // Permission bits; on Windows Go maps read-only onto these
+	Uid        uint32   `xml:"uid,attr,omitempty"`  // Owner and group; not recorded on Windows
+	Gid        uint32   `xml:"gid,attr,omitempty"`
+	OwnerKnown bool     `xml:"owner,attr,omitempty"` // Uid and Gid are recorded, as root's are both 0
+	Tags       []string `xml:"tag,omitempty"`        // The user's own tags, e.g. genre or rating
+	BackupDest []string `xml:"bd,omitempty"`         // Labels of the volumes the file is backed up to
+	// LastBackup is when the file was last copied to a backup, in unix seconds
+	LastBackup int64 `xml:"last_backup,attr,omitempty"`
+
+	// ChangeFrequency is how many times a day the file changes, as found from the journal
+	ChangeFrequency float32 `xml:"freq,attr,omitempty"`
+
+	// inheritedTags are those the directory (or its parents) says apply
+	// to every file within. They are never written to the file's own entry
+	inheritedTags []string
 }
 
 // FileStructArray declares an array of filestructs, explicitly for sorting
@@ -76,6 +121,22 @@ func (fs FileStruct) Equal(ca FileStruct) bool {
 	return (fs.Size == ca.Size) && (fs.Checksum == ca.Checksum)
 }
 
+// StrictEqual is Equal, but the name and modification time must match too
+func (fs FileStruct) StrictEqual(ca FileStruct) bool {
+	return fs.Equal(ca) && (fs.Name == ca.Name) && (fs.Mtime == ca.Mtime)
+}
+
+// LastBackupTime is when the file was last copied to a backup
+// The unix epoch if it never has been
+func (fs FileStruct) LastBackupTime() time.Time {
+	return time.Unix(fs.LastBackup, 0)
+}
+
+// BackupAge is how long it is since the file was last copied to a backup
+func (fs FileStruct) BackupAge() time.Duration {
+	return time.Since(fs.LastBackupTime())
+}
+
 // NewFileStruct returns a populated file struct with
 // the file properties set as read from file
 func NewFileStruct(directory string, fn string) (fs FileStruct, err error) {
@@ -91,12 +152,17 @@ func NewFileStruct(directory string, fn string) (fs FileStruct, err error) {
 // FromStat update the file struct from a supplied file structure
 func (fs *FileStruct) FromStat(directory string, fn string, fsi os.FileInfo) (FileStruct, error) {
 	if changed, err := fs.Changed(fsi); !changed {
+		if err == nil {
+			fs.setPermissions(fsi)
+		}
 		return *fs, err
 	}
 	fs.Name = fn
 	fs.Mtime = fsi.ModTime().Unix()
 	fs.Size = fsi.Size()
+	fs.setPermissions(fsi)
 	fs.Checksum = ""
+	fs.Checksum256 = ""
 	fs.BackupDest = []string{}
 	fs.directory = directory
 	return *fs, nil
@@ -111,33 +177,107 @@ func (fs FileStruct) indexTag(tag string) int {
 	return -1
 }
 
-// HasTag return true is the tag is already in ArchivedAt
-func (fs FileStruct) HasTag(tag string) bool {
+// HasBackupOn returns true if the file has been backed up to the volume labelled tag
+func (fs FileStruct) HasBackupOn(tag string) bool {
 	return fs.indexTag(tag) >= 0
 }
 
+// HasTag is the old name for HasBackupOn
+//
+// Deprecated: use HasBackupOn, or HasUserTag for the file's own tags
+func (fs FileStruct) HasTag(tag string) bool {
+	return fs.HasBackupOn(tag)
+}
+
+// ErrEmptyTag a backup destination tag must have a name
+var ErrEmptyTag = errors.New("empty tag")
+
+// ErrInvalidTagFormat the tag has an '@' but is not of the form label@RFC3339
+var ErrInvalidTagFormat = errors.New("invalid tag format, expected label or label@RFC3339")
+
+// validateTag checks a tag is safe to store in BackupDest
+// An '@' is reserved for giving the tag an expiry time
+func validateTag(tag string) error {
+	if len(tag) == 0 {
+		return ErrEmptyTag
+	}
+	label, expiry, found := strings.Cut(tag, "@")
+	if !found {
+		return nil
+	}
+	if label == "" || strings.Contains(expiry, "@") {
+		return fmt.Errorf("%w::%s", ErrInvalidTagFormat, tag)
+	}
+	if _, err := time.Parse(time.RFC3339, expiry); err != nil {
+		return fmt.Errorf("%w::%s", ErrInvalidTagFormat, tag)
+	}
+	return nil
+}
+
 // Add a tag to the fs, return true if it was modified
-func (fs *FileStruct) AddTag(tag string) bool {
-	if fs.HasTag(tag) {
-		return false
+func (fs *FileStruct) AddTag(tag string) (bool, error) {
+	if err := validateTag(tag); err != nil {
+		return false, err
+	}
+	if fs.HasBackupOn(tag) {
+		return false, nil
 	}
 	fs.BackupDest = append(fs.BackupDest, tag)
-	return true
+	return true, nil
 }
 
 // Remove a tag from the fs, return true if it was modified
-func (fs *FileStruct) RemoveTag(tag string) bool {
+func (fs *FileStruct) RemoveTag(tag string) (bool, error) {
+	if err := validateTag(tag); err != nil {
+		return false, err
+	}
 	index := fs.indexTag(tag)
 	if index < 0 {
-		return false
+		return false, nil
 	}
 	// Order is not important, so swap interesting element to the end and remove
 	fs.BackupDest[len(fs.BackupDest)-1], fs.BackupDest[index] = fs.BackupDest[index], fs.BackupDest[len(fs.BackupDest)-1]
 	fs.BackupDest = fs.BackupDest[:len(fs.BackupDest)-1]
-	return true
+	return true, nil
 }
 
-// Changed reports if the filestruct has changed from the supplied info
+// EffectiveTags returns the file's own tags along with any it inherits from its directories
+func (fs FileStruct) EffectiveTags() []string {
+	if len(fs.inheritedTags) == 0 {
+		return fs.Tags
+	}
+	tags := append([]string{}, fs.Tags...)
+	for _, tag := range fs.inheritedTags {
+		if !containsString(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// HasUserTag reports if the file itself has the tag, ignoring those it inherits
+func (fs FileStruct) HasUserTag(tag string) bool {
+	return containsString(fs.Tags, tag)
+}
+
+// HasEffectiveTag reports if the file has the tag, either its own or inherited
+func (fs FileStruct) HasEffectiveTag(tag string) bool {
+	return containsString(fs.Tags, tag) || containsString(fs.inheritedTags, tag)
+}
+
+func containsString(list []string, str string) bool {
+	for _, v := range list {
+		if v == str {
+			return true
+		}
+	}
+	return false
+}
+
+// Changed reports if the filestruct's content has changed from the supplied info
+// Deliberately only the mtime and size count, so that a chmod or chown
+// does not have us recalculate the checksum. Use ChangeType to also
+// notice a change in permissions.
 func (fs FileStruct) Changed(info fs.FileInfo) (bool, error) {
 	if info == nil {
 		return false, errors.New("changed called on nil fileinfo")
@@ -150,25 +290,219 @@ func (fs FileStruct) Changed(info fs.FileInfo) (bool, error) {
 	}
 	return false, nil
 }
+
+// ChangeKind describes how a file differs from its FileStruct
+type ChangeKind int
+
+const (
+	// NotChanged nothing we track has changed
+	NotChanged ChangeKind = iota
+	// ContentChanged the file itself has changed
+	ContentChanged
+	// PermChanged only the permissions have changed
+	PermChanged
+)
+
+// ChangeType reports how the filestruct has changed from the supplied info
+// Unlike Changed this also notices a change in permissions
+func (fs FileStruct) ChangeType(info fs.FileInfo) (ChangeKind, error) {
+	changed, err := fs.Changed(info)
+	if err != nil {
+		return NotChanged, err
+	}
+	if changed {
+		return ContentChanged, nil
+	}
+	// Files recorded before we tracked the mode have no mode
+	if fs.Mode != 0 && fs.Mode != FileMode(info.Mode().Perm()) {
+		return PermChanged, nil
+	}
+	if uid, gid, ok := fileOwnerOf(info); ok && fs.ownerKnown() && (fs.Uid != uid || fs.Gid != gid) {
+		return PermChanged, nil
+	}
+	return NotChanged, nil
+}
+
+// ApplyMode sets the file's permissions to those recorded
+func (fs FileStruct) ApplyMode() error {
+	if fs.Mode == 0 {
+		return nil
+	}
+	return os.Chmod(string(fs.Path()), os.FileMode(fs.Mode))
+}
+
+// ApplyOwner gives the file back to the user and group recorded
+// Only root can usually do this
+func (fs FileStruct) ApplyOwner() error {
+	if !fs.ownerKnown() {
+		return nil
+	}
+	return setFileOwner(string(fs.Path()), fs.Uid, fs.Gid)
+}
+
+// setPermissions records the mode and owner of the file
+func (fs *FileStruct) setPermissions(info os.FileInfo) {
+	fs.Mode = FileMode(info.Mode().Perm())
+	if uid, gid, ok := fileOwnerOf(info); ok {
+		fs.Uid, fs.Gid, fs.OwnerKnown = uid, gid, true
+	}
+}
+
+// ownerKnown reports if Uid and Gid say who owns the file
+// Files recorded before OwnerKnown only have an owner if it isn't root
+func (fs FileStruct) ownerKnown() bool {
+	return fs.OwnerKnown || fs.Uid != 0 || fs.Gid != 0
+}
+
 // UpdateChecksum makes the tea
 func (fs *FileStruct) UpdateChecksum(forceUpdate bool) error {
+	return fs.UpdateChecksumCtx(context.Background(), forceUpdate)
+}
+
+// UpdateChecksumCtx is UpdateChecksum, but gives up if the context is cancelled
+// The checksum is left as it was if we do not finish
+func (fs *FileStruct) UpdateChecksumCtx(ctx context.Context, forceUpdate bool) error {
 	if !forceUpdate && (fs.Checksum != "") {
 		return nil
 	}
-	cks, err := CalcMd5File(fs.directory, fs.Name)
+	cks, err := CalcMd5FileCtx(ctx, fs.directory, fs.Name)
 	if err != nil {
 		return err
 	}
+	fs.setChecksum(cks)
+	return nil
+}
+
+// setChecksum records a newly calculated checksum
+func (fs *FileStruct) setChecksum(cks string) {
 	if fs.Checksum == cks {
-		return nil
+		return
+	}
+	if fs.Checksum != "" {
+		// The SHA-256 was of the old contents
+		fs.Checksum256 = ""
 	}
 	fs.Checksum = cks
 	// If we've had to update the checksum, then any existing backups are invalid
 	fs.BackupDest = []string{}
+}
+
+// UpdateChecksum256 is UpdateChecksum, for the SHA-256 checksum
+func (fs *FileStruct) UpdateChecksum256(forceUpdate bool) error {
+	return fs.UpdateChecksum256Ctx(context.Background(), forceUpdate)
+}
+
+// UpdateChecksum256Ctx is UpdateChecksum256, but gives up if the context is cancelled
+func (fs *FileStruct) UpdateChecksum256Ctx(ctx context.Context, forceUpdate bool) error {
+	if !forceUpdate && (fs.Checksum256 != "") {
+		return nil
+	}
+	cks, err := CalcSha256FileCtx(ctx, fs.directory, fs.Name)
+	if err != nil {
+		return err
+	}
+	fs.setChecksum256(cks)
 	return nil
 }
+
+func (fs *FileStruct) setChecksum256(cks string) {
+	if fs.Checksum256 == cks {
+		return
+	}
+	if fs.Checksum256 != "" {
+		// The contents have changed, so the MD5 is wrong too
+		fs.Checksum = ""
+		fs.BackupDest = []string{}
+	}
+	fs.Checksum256 = cks
+}
+
+// UpdateChecksumWithTimeout is UpdateChecksum, but gives up after timeout
+// A read from a hung network mount may never return, so on timeout the
+// calculation is abandoned rather than waited for.
+// The checksum is left as it was, and ErrChecksumTimeout returned.
+// A timeout of 0 means wait as long as it takes.
+func (fs *FileStruct) UpdateChecksumWithTimeout(timeout time.Duration, forceUpdate bool) error {
+	return fs.updateChecksumTimeout(context.Background(), timeout, forceUpdate)
+}
+
+func (fs *FileStruct) updateChecksumTimeout(ctx context.Context, timeout time.Duration, forceUpdate bool) error {
+	if timeout <= 0 {
+		return fs.UpdateChecksumCtx(ctx, forceUpdate)
+	}
+	if !forceUpdate && (fs.Checksum != "") {
+		return nil
+	}
+	cks, err := fs.calcWithTimeout(ctx, timeout, CalcMd5FileCtx)
+	if err != nil {
+		return err
+	}
+	fs.setChecksum(cks)
+	return nil
+}
+
+// updateChecksum256Timeout is updateChecksumTimeout for the SHA-256
+func (fs *FileStruct) updateChecksum256Timeout(ctx context.Context, timeout time.Duration, forceUpdate bool) error {
+	if timeout <= 0 {
+		return fs.UpdateChecksum256Ctx(ctx, forceUpdate)
+	}
+	if !forceUpdate && (fs.Checksum256 != "") {
+		return nil
+	}
+	cks, err := fs.calcWithTimeout(ctx, timeout, CalcSha256FileCtx)
+	if err != nil {
+		return err
+	}
+	fs.setChecksum256(cks)
+	return nil
+}
+
+// calcWithTimeout runs calc on the file, abandoning it after timeout
+func (fs *FileStruct) calcWithTimeout(ctx context.Context, timeout time.Duration, calc func(ctx context.Context, directory, fn string) (string, error)) (string, error) {
+	// Should the reads still be making progress, this stops the abandoned calculation
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		cks string
+		err error
+	}
+	// Buffered, so an abandoned calculation can still finish
+	resChan := make(chan result, 1)
+	directory, name := fs.directory, fs.Name
+	go func() {
+		cks, err := calc(ctx, directory, name)
+		resChan <- result{cks, err}
+	}()
+	select {
+	case res := <-resChan:
+		return res.cks, res.err
+	case <-time.After(timeout):
+		log.Println("Checksum of", filepath.Join(directory, name), "took longer than", timeout, "giving up")
+		return "", fmt.Errorf("%w::%s", ErrChecksumTimeout, filepath.Join(directory, name))
+	}
+}
+
 // ValidateChecksum checks if the checksum is correct
+// Whichever of the MD5 and SHA-256 we have are checked.
+// If they are wrong they are recalculated, and ErrRecalced returned.
 func (fs *FileStruct) ValidateChecksum() error {
+	if fs.Checksum256 != "" {
+		cks, err := CalcSha256File(fs.directory, fs.Name)
+		if err != nil {
+			return err
+		}
+		if fs.Checksum256 != cks {
+			fs.setChecksum256(cks)
+			err = fs.UpdateChecksum(true)
+			if err != nil {
+				return err
+			}
+			return ErrRecalced
+		}
+		if fs.Checksum == "" {
+			return nil
+		}
+	}
 	cks, err := CalcMd5File(fs.directory, fs.Name)
 	if err != nil {
 		return err
@@ -176,8 +510,13 @@ func (fs *FileStruct) ValidateChecksum() error {
 	if fs.Checksum == cks {
 		return nil
 	}
-	fs.Checksum = cks
-	// If we've had to update the checksum, then any existing backups are invalid
-	fs.BackupDest = []string{}
+	hadSha256 := fs.Checksum256 != ""
+	fs.setChecksum(cks)
+	if hadSha256 {
+		err = fs.UpdateChecksum256(true)
+		if err != nil {
+			return err
+		}
+	}
 	return ErrRecalced
 }
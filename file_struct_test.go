@@ -1,10 +1,16 @@
 package medorg_test
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/xml"
+	"errors"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/cbehopkins/medorg"
 )
@@ -75,4 +81,351 @@ func TestFileStructXMLRead(t *testing.T) {
             t.Fatalf("Deserialized FileStruct Backup Dest does not match expected values")
         }
     }
-}
\ No newline at end of file
+}
+func TestFileStructPermChanged(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "filestruct_test")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	fn := filepath.Join(tempDir, "bob")
+	if err := os.WriteFile(fn, []byte("some content"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	fs, err := medorg.NewFileStruct(tempDir, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs.Mode != 0644 {
+		t.Errorf("Unexpected mode %o", fs.Mode)
+	}
+
+	if err := os.Chmod(fn, 0600); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Changed is only about the content, so a checksum isn't recalculated for a chmod
+	changed, err := fs.Changed(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed {
+		t.Error("A permission change should not count as the content changing")
+	}
+	kind, err := fs.ChangeType(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kind != medorg.PermChanged {
+		t.Error("Expected PermChanged, got", kind)
+	}
+
+	// Restoring the mode puts things back how they were
+	if err := fs.ApplyMode(); err != nil {
+		t.Fatal(err)
+	}
+	info, err = os.Stat(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kind, err = fs.ChangeType(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kind != medorg.NotChanged {
+		t.Error("Expected NotChanged after restoring mode, got", kind)
+	}
+}
+
+func TestFileStructTagValidation(t *testing.T) {
+	var fs medorg.FileStruct
+	if _, err := fs.AddTag(""); !errors.Is(err, medorg.ErrEmptyTag) {
+		t.Error("Expected ErrEmptyTag, got", err)
+	}
+	if _, err := fs.RemoveTag(""); !errors.Is(err, medorg.ErrEmptyTag) {
+		t.Error("Expected ErrEmptyTag on remove, got", err)
+	}
+	if len(fs.BackupDest) != 0 {
+		t.Error("Empty tag should not have been added", fs.BackupDest)
+	}
+	for _, tag := range []string{"@2026-01-02T15:04:05Z", "bob@", "bob@tomorrow", "bob@2026-01-02T15:04:05Z@x"} {
+		if _, err := fs.AddTag(tag); !errors.Is(err, medorg.ErrInvalidTagFormat) {
+			t.Error("Expected ErrInvalidTagFormat for", tag, "got", err)
+		}
+	}
+	for _, tag := range []string{"bob", "bob@2026-01-02T15:04:05Z"} {
+		modified, err := fs.AddTag(tag)
+		if err != nil || !modified {
+			t.Error("Unable to add", tag, modified, err)
+		}
+	}
+	if modified, err := fs.AddTag("bob"); err != nil || modified {
+		t.Error("Adding a tag twice should not modify", modified, err)
+	}
+	if modified, err := fs.RemoveTag("bob"); err != nil || !modified {
+		t.Error("Unable to remove tag", modified, err)
+	}
+}
+
+func TestFileStructChecksum256(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "filestruct_sha")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	content := []byte("some content")
+	if err := os.WriteFile(filepath.Join(tempDir, "bob"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := medorg.NewFileStruct(tempDir, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Files without a SHA-256 are written just as they always were
+	ba, err := xml.Marshal(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(ba), "sha256") {
+		t.Error("Empty SHA-256 should not be written", string(ba))
+	}
+
+	if err := fs.UpdateChecksum256(false); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(content)
+	expected := base64.StdEncoding.WithPadding(base64.NoPadding).EncodeToString(sum[:])
+	if fs.Checksum256 != expected {
+		t.Error("Expected", expected, "got", fs.Checksum256)
+	}
+	if fs.Checksum != "" {
+		t.Error("Only the SHA-256 should have been calculated")
+	}
+	if err := fs.ValidateChecksum(); err != nil {
+		t.Error("A correct SHA-256 should validate, got", err)
+	}
+
+	if err := fs.UpdateChecksum(false); err != nil {
+		t.Fatal(err)
+	}
+	fs.BackupDest = []string{"vol"}
+	fs.Checksum256 = "wrong"
+	if err := fs.ValidateChecksum(); !errors.Is(err, medorg.ErrRecalced) {
+		t.Error("Expected ErrRecalced, got", err)
+	}
+	if fs.Checksum256 != expected || fs.Checksum == "" {
+		t.Error("Both checksums should have been recalculated", fs)
+	}
+	if len(fs.BackupDest) != 0 {
+		t.Error("Backups of the wrong contents are no good", fs.BackupDest)
+	}
+
+	if _, err := medorg.ParseChecksumAlgo("crc32"); !errors.Is(err, medorg.ErrBadChecksumAlgo) {
+		t.Error("Expected ErrBadChecksumAlgo, got", err)
+	}
+}
+
+func TestFileStructModeAndOwnerXML(t *testing.T) {
+	fs := medorg.FileStruct{Name: "bob", Mode: 0640, Uid: 1000, Gid: 100}
+	ba, err := xml.Marshal(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, attr := range []string{`mode="0640"`, `uid="1000"`, `gid="100"`} {
+		if !strings.Contains(string(ba), attr) {
+			t.Error("Expected", attr, "in", string(ba))
+		}
+	}
+	var back medorg.FileStruct
+	if err := xml.Unmarshal(ba, &back); err != nil {
+		t.Fatal(err)
+	}
+	if back.Mode != 0640 || back.Uid != 1000 || back.Gid != 100 {
+		t.Error("Round trip lost permissions", back.Mode, back.Uid, back.Gid)
+	}
+
+	// Before we wrote modes in octal, they were in decimal
+	if err := xml.Unmarshal([]byte(`<fr fname="old" mode="420"></fr>`), &back); err != nil {
+		t.Fatal(err)
+	}
+	if back.Mode != 0644 {
+		t.Errorf("Expected 0644 from a decimal mode, got %o", back.Mode)
+	}
+	if err := xml.Unmarshal([]byte(`<fr fname="bad" mode="rw-r--r--"></fr>`), &back); err == nil {
+		t.Error("Expected an error for a nonsense mode")
+	}
+}
+
+func TestFileStructOwner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("No uid or gid on windows")
+	}
+	tempDir, err := os.MkdirTemp("", "filestruct_owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	fn := filepath.Join(tempDir, "bob")
+	if err := os.WriteFile(fn, []byte("some content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := medorg.NewFileStruct(tempDir, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs.Uid != uint32(os.Getuid()) || fs.Gid != uint32(os.Getgid()) {
+		t.Error("Expected to be owned by", os.Getuid(), os.Getgid(), "got", fs.Uid, fs.Gid)
+	}
+	// Giving a file to ourselves is always allowed
+	if err := fs.ApplyOwner(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFileStructRootOwner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("No uid or gid on windows")
+	}
+	if os.Getuid() != 0 {
+		t.Skip("Only root can give files away")
+	}
+	tempDir := t.TempDir()
+	fn := filepath.Join(tempDir, "bob")
+	if err := os.WriteFile(fn, []byte("some content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(fn, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := medorg.NewFileStruct(tempDir, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fs.OwnerKnown || fs.Uid != 0 || fs.Gid != 0 {
+		t.Fatal("Expected root's ownership to be recorded, got", fs.OwnerKnown, fs.Uid, fs.Gid)
+	}
+	// Root's ownership survives being written out
+	ba, err := xml.Marshal(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back medorg.FileStruct
+	if err := xml.Unmarshal(ba, &back); err != nil {
+		t.Fatal(err)
+	}
+	if !back.OwnerKnown {
+		t.Error("Round trip lost the ownership of", string(ba))
+	}
+
+	if err := os.Chown(fn, 1000, 1000); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kind, err := fs.ChangeType(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kind != medorg.PermChanged {
+		t.Error("Expected PermChanged after giving away a root owned file, got", kind)
+	}
+	// Giving it back puts things back how they were
+	if err := fs.ApplyOwner(); err != nil {
+		t.Fatal(err)
+	}
+	info, err = os.Stat(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kind, _ := fs.ChangeType(info); kind != medorg.NotChanged {
+		t.Error("Expected NotChanged after restoring the owner, got", kind)
+	}
+}
+
+func TestFileStructEqual(t *testing.T) {
+	base := medorg.FileStruct{Name: "a.jpg", Size: 100, Mtime: 1000, Checksum: "abc"}
+	tests := []struct {
+		name   string
+		other  medorg.FileStruct
+		equal  bool
+		strict bool
+	}{
+		{"identical", base, true, true},
+		{"renamed", medorg.FileStruct{Name: "b.jpg", Size: 100, Mtime: 1000, Checksum: "abc"}, true, false},
+		{"touched", medorg.FileStruct{Name: "a.jpg", Size: 100, Mtime: 2000, Checksum: "abc"}, true, false},
+		{"other checksum", medorg.FileStruct{Name: "a.jpg", Size: 100, Mtime: 1000, Checksum: "def"}, false, false},
+		{"other size", medorg.FileStruct{Name: "a.jpg", Size: 101, Mtime: 1000, Checksum: "abc"}, false, false},
+		{"no checksum", medorg.FileStruct{Name: "a.jpg", Size: 100, Mtime: 1000}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := base.Equal(tt.other); got != tt.equal {
+				t.Error("Equal expected", tt.equal, "got", got)
+			}
+			if got := tt.other.Equal(base); got != tt.equal {
+				t.Error("Equal should be symmetric")
+			}
+			if got := base.StrictEqual(tt.other); got != tt.strict {
+				t.Error("StrictEqual expected", tt.strict, "got", got)
+			}
+		})
+	}
+	// Two unknowns are not the same file
+	unknown := medorg.FileStruct{Name: "a.jpg", Size: 100, Mtime: 1000}
+	if unknown.Equal(unknown) || unknown.StrictEqual(unknown) {
+		t.Error("Files without checksums should never be equal")
+	}
+}
+
+func TestFileStructBackupAge(t *testing.T) {
+	fs := medorg.FileStruct{Name: "a.jpg", LastBackup: time.Now().Add(-time.Hour).Unix()}
+	if !fs.LastBackupTime().Equal(time.Unix(fs.LastBackup, 0)) {
+		t.Error("Unexpected last backup time", fs.LastBackupTime())
+	}
+	age := fs.BackupAge()
+	if age < time.Hour {
+		t.Error("Backed up an hour ago, but age is", age)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if later := fs.BackupAge(); later <= age {
+		t.Error("Backup age should increase, was", age, "now", later)
+	}
+	// It should survive being written out and read back
+	ba, err := xml.Marshal(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var readBack medorg.FileStruct
+	if err := xml.Unmarshal(ba, &readBack); err != nil {
+		t.Fatal(err)
+	}
+	if readBack.LastBackup != fs.LastBackup {
+		t.Error("LastBackup lost in xml", string(ba))
+	}
+}
+
+func TestFileStructUserTagsAndBackups(t *testing.T) {
+	input := `<fr fname="a.jpg" checksum="abc" size="1"><tag>favourite</tag><bd>vol1</bd></fr>`
+	var fs medorg.FileStruct
+	if err := xml.Unmarshal([]byte(input), &fs); err != nil {
+		t.Fatal(err)
+	}
+	if !fs.HasUserTag("favourite") || fs.HasUserTag("vol1") {
+		t.Error("User tags should only come from tag elements", fs.Tags)
+	}
+	if !fs.HasBackupOn("vol1") || fs.HasBackupOn("favourite") {
+		t.Error("Backups should only come from bd elements", fs.BackupDest)
+	}
+	if _, err := fs.AddTag("vol2"); err != nil {
+		t.Fatal(err)
+	}
+	if fs.HasUserTag("vol2") || !fs.HasBackupOn("vol2") {
+		t.Error("Recording a backup should not add a user tag")
+	}
+}
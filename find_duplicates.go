@@ -0,0 +1,185 @@
+package medorg
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// uniqueRoots makes the roots absolute, and drops any root that is
+// repeated or is within another, so no file is visited twice
+func uniqueRoots(roots []string) ([]string, error) {
+	abs := make([]string, 0, len(roots))
+	for _, root := range roots {
+		path, err := filepath.Abs(root)
+		if err != nil {
+			return nil, err
+		}
+		abs = append(abs, path)
+	}
+	// Shortest first, so a root is seen before anything within it
+	sort.Slice(abs, func(i, j int) bool { return len(abs[i]) < len(abs[j]) })
+	var unique []string
+	for _, path := range abs {
+		within := false
+		for _, root := range unique {
+			if isWithin(path, root) {
+				within = true
+				break
+			}
+		}
+		if !within {
+			unique = append(unique, path)
+		}
+	}
+	return unique, nil
+}
+
+// uniqueFiles drops the files that are the same file as one before them,
+// by path or because they are hard links to it
+func uniqueFiles(fss []FileStruct) []FileStruct {
+	var unique []FileStruct
+	var infos []os.FileInfo
+	for _, fs := range fss {
+		info, err := os.Stat(string(fs.Path()))
+		same := false
+		for i, kept := range unique {
+			if kept.Path() == fs.Path() || (err == nil && infos[i] != nil && os.SameFile(infos[i], info)) {
+				same = true
+				break
+			}
+		}
+		if same {
+			continue
+		}
+		if err != nil {
+			info = nil
+		}
+		unique = append(unique, fs)
+		infos = append(infos, info)
+	}
+	return unique
+}
+
+// FindDuplicates looks through the roots for files with the same contents
+// It returns, by checksum, each group of two or more files with the same
+// checksum and size, sorted by path. A group may span several roots.
+// Roots within other roots, and hard links to the same file, are only counted once.
+// Only the .medorg.xml files are read, so run UpdateChecksums (or check_calc)
+// first for up to date results
+func FindDuplicates(opts MetadataOptions, roots ...string) (map[string][]FileStruct, error) {
+	groups := make(map[backupKey][]FileStruct)
+	fc := func(dir string, dm DirectoryMap) error {
+		return dm.rangeMap(func(fn string, fs FileStruct) error {
+			if fs.Checksum == "" {
+				return nil
+			}
+			key := backupKey{fs.Size, fs.Checksum}
+			groups[key] = append(groups[key], fs)
+			return nil
+		})
+	}
+	roots, err := uniqueRoots(roots)
+	if err != nil {
+		return nil, err
+	}
+	for _, root := range roots {
+		if err := walkDirectoryMaps(root, opts, fc); err != nil {
+			return nil, err
+		}
+	}
+	duplicates := make(map[string][]FileStruct)
+	for key, fss := range groups {
+		if len(fss) < 2 {
+			continue
+		}
+		sort.Slice(fss, func(i, j int) bool { return fss[i].Path() < fss[j].Path() })
+		fss = uniqueFiles(fss)
+		if len(fss) < 2 {
+			continue
+		}
+		duplicates[key.checksum] = fss
+	}
+	return duplicates, nil
+}
+
+// ErrDuplicateChanged a duplicate is no longer what the metadata says it is
+var ErrDuplicateChanged = errors.New("duplicate has changed")
+
+// checkUnchanged returns an error unless the file still has the size, mtime
+// and, if withChecksum, the checksum the metadata records for it
+func checkUnchanged(fs FileStruct, withChecksum bool) (os.FileInfo, error) {
+	info, err := os.Stat(string(fs.Path()))
+	if err != nil {
+		return nil, err
+	}
+	changed, err := fs.Changed(info)
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		return nil, fmt.Errorf("%w::%s", ErrDuplicateChanged, fs.Path())
+	}
+	if !withChecksum {
+		return info, nil
+	}
+	cks, err := CalcMd5File(fs.directory, fs.Name)
+	if err != nil {
+		return nil, err
+	}
+	if cks != fs.Checksum {
+		return nil, fmt.Errorf("%w::%s has checksum %s", ErrDuplicateChanged, fs.Path(), cks)
+	}
+	return info, nil
+}
+
+// DeleteDuplicates removes all but the first file of each group found by FindDuplicates
+// and removes them from the .medorg.xml files. It returns the files removed.
+// Before removing anything, the file kept must still be there, and each file
+// removed must still match its size, mtime and checksum; any group
+// where that is not so is skipped, in case the metadata is out of date.
+func DeleteDuplicates(duplicates map[string][]FileStruct, opts MetadataOptions) ([]Fpath, error) {
+	var removed []Fpath
+	dirs := make(map[string]*DirectoryMap)
+	for _, fss := range duplicates {
+		keptInfo, err := checkUnchanged(fss[0], false)
+		if err != nil {
+			log.Println("Skipping duplicates of", fss[0].Path(), err)
+			continue
+		}
+		for _, fs := range fss[1:] {
+			info, err := checkUnchanged(fs, true)
+			if err == nil && os.SameFile(keptInfo, info) {
+				err = fmt.Errorf("%w::%s is the file kept", ErrDuplicateChanged, fs.Path())
+			}
+			if err != nil {
+				log.Println("Skipping duplicates of", fss[0].Path(), err)
+				break
+			}
+			dm, ok := dirs[fs.directory]
+			if !ok {
+				loaded, err := DirectoryMapFromDirWithOptions(fs.directory, "", opts)
+				if err != nil {
+					return removed, err
+				}
+				dm = &loaded
+				dirs[fs.directory] = dm
+			}
+			err = os.Remove(string(fs.Path()))
+			if err != nil && !os.IsNotExist(err) {
+				return removed, err
+			}
+			dm.Rm(fs.Name)
+			removed = append(removed, fs.Path())
+		}
+	}
+	for dir, dm := range dirs {
+		if err := dm.Persist(dir); err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,212 @@
+package medorg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	const numberOfFiles, numberOfDuplicates = 5, 2
+	dirs, err := createTestBackupDirectories(numberOfFiles, numberOfDuplicates)
+	if err != nil {
+		t.Fatal("Failed to create test Directories", err)
+	}
+	defer func() {
+		for i := range dirs {
+			os.RemoveAll(dirs[i])
+		}
+	}()
+	// Bring the copies into the same tree as the originals
+	copies := filepath.Join(dirs[0], "copies")
+	if err := os.Rename(dirs[1], copies); err != nil {
+		t.Fatal(err)
+	}
+	if err := recalcTestDirectory(dirs[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	duplicates, err := FindDuplicates(DefaultMetadataOptions(), dirs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(duplicates) != numberOfDuplicates {
+		t.Fatal("Expected", numberOfDuplicates, "groups, got", len(duplicates), duplicates)
+	}
+	for cks, fss := range duplicates {
+		if len(fss) != 2 || fss[0].Checksum != cks || fss[1].Checksum != cks {
+			t.Error("Unexpected group", cks, fss)
+		}
+		// Sorted by path, so the copy comes first
+		if fss[0].directory != copies {
+			t.Error("Expected the copy first, got", fss[0].Path())
+		}
+	}
+
+	removed, err := DeleteDuplicates(duplicates, DefaultMetadataOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != numberOfDuplicates {
+		t.Error("Expected", numberOfDuplicates, "removed, got", removed)
+	}
+	for _, fp := range removed {
+		if _, err := os.Stat(string(fp)); !os.IsNotExist(err) {
+			t.Error("File not removed", fp)
+		}
+	}
+	duplicates, err = FindDuplicates(DefaultMetadataOptions(), dirs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(duplicates) != 0 {
+		t.Error("Duplicates remain in the metadata", duplicates)
+	}
+}
+
+func TestFindDuplicatesAcrossRoots(t *testing.T) {
+	const numberOfFiles, numberOfDuplicates = 4, 3
+	dirs, err := createTestBackupDirectories(numberOfFiles, numberOfDuplicates)
+	if err != nil {
+		t.Fatal("Failed to create test Directories", err)
+	}
+	defer func() {
+		for i := range dirs {
+			os.RemoveAll(dirs[i])
+		}
+	}()
+	// Nothing has been checksummed yet, so there is nothing to find
+	duplicates, err := FindDuplicates(DefaultMetadataOptions(), dirs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(duplicates) != 0 {
+		t.Error("Found duplicates without checksums", duplicates)
+	}
+
+	if err := UpdateChecksums(context.Background(), dirs, DefaultMetadataOptions()); err != nil {
+		t.Fatal(err)
+	}
+	// Each root on its own has no duplicates
+	for _, dir := range dirs {
+		if duplicates, err := FindDuplicates(DefaultMetadataOptions(), dir); err != nil || len(duplicates) != 0 {
+			t.Error("Unexpected duplicates within", dir, duplicates, err)
+		}
+	}
+	duplicates, err = FindDuplicates(DefaultMetadataOptions(), dirs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(duplicates) != numberOfDuplicates {
+		t.Fatal("Expected", numberOfDuplicates, "groups, got", len(duplicates), duplicates)
+	}
+	var kept []Fpath
+	for _, fss := range duplicates {
+		kept = append(kept, fss[0].Path())
+	}
+	removed, err := DeleteDuplicates(duplicates, DefaultMetadataOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != numberOfDuplicates {
+		t.Error("Expected", numberOfDuplicates, "removed, got", removed)
+	}
+	// The smallest path of each group is kept
+	for _, fp := range kept {
+		if _, err := os.Stat(string(fp)); err != nil {
+			t.Error("The first of the group should be kept", fp, err)
+		}
+	}
+	if duplicates, err := FindDuplicates(DefaultMetadataOptions(), dirs...); err != nil || len(duplicates) != 0 {
+		t.Error("Duplicates remain", duplicates, err)
+	}
+}
+
+func TestFindDuplicatesOverlappingRoots(t *testing.T) {
+	wkDir := t.TempDir()
+	sub := filepath.Join(wkDir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	only := filepath.Join(sub, "only.txt")
+	if err := os.WriteFile(only, []byte("the only copy"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(only, filepath.Join(sub, "link.txt")); err != nil {
+		t.Skip("hard links not supported", err)
+	}
+	if err := UpdateChecksums(context.Background(), []string{wkDir}, DefaultMetadataOptions()); err != nil {
+		t.Fatal(err)
+	}
+	// The same directory twice, within another root, and through a relative path
+	rel, err := filepath.Rel(".", sub)
+	if err != nil {
+		rel = sub
+	}
+	duplicates, err := FindDuplicates(DefaultMetadataOptions(), sub, sub, wkDir, rel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(duplicates) != 0 {
+		t.Fatal("A file should not be a duplicate of itself", duplicates)
+	}
+	removed, err := DeleteDuplicates(duplicates, DefaultMetadataOptions())
+	if err != nil || len(removed) != 0 {
+		t.Error("Nothing should be removed, got", removed, err)
+	}
+	if _, err := os.Stat(only); err != nil {
+		t.Error("The only copy was removed", err)
+	}
+}
+
+func TestDeleteDuplicatesStaleMetadata(t *testing.T) {
+	wkDir := t.TempDir()
+	for _, fn := range []string{"a.txt", "b.txt", "c.txt"} {
+		if err := os.WriteFile(filepath.Join(wkDir, fn), []byte("same"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := UpdateChecksums(context.Background(), []string{wkDir}, DefaultMetadataOptions()); err != nil {
+		t.Fatal(err)
+	}
+	duplicates, err := FindDuplicates(DefaultMetadataOptions(), wkDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(duplicates) != 1 {
+		t.Fatal("Expected a group, got", duplicates)
+	}
+
+	// The file we would keep has gone since the metadata was written
+	if err := os.Remove(filepath.Join(wkDir, "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := DeleteDuplicates(duplicates, DefaultMetadataOptions())
+	if err != nil || len(removed) != 0 {
+		t.Error("Nothing should be removed without the kept file, got", removed, err)
+	}
+
+	// b.txt is now kept, and c.txt has changed, keeping its size and mtime
+	for cks, fss := range duplicates {
+		duplicates[cks] = fss[1:]
+	}
+	cFile := filepath.Join(wkDir, "c.txt")
+	info, err := os.Stat(cFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cFile, []byte("diff"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(cFile, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	removed, err = DeleteDuplicates(duplicates, DefaultMetadataOptions())
+	if err != nil || len(removed) != 0 {
+		t.Error("A changed file should not be removed, got", removed, err)
+	}
+	if _, err := os.Stat(cFile); err != nil {
+		t.Error("c.txt was removed", err)
+	}
+}
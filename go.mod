@@ -5,6 +5,7 @@ go 1.21
 require (
 	github.com/cbehopkins/pb/v3 v3.0.10
 	github.com/inhies/go-bytesize v0.0.0-20220417184213-4913239db9cf
+	golang.org/x/sys v0.25.0
 )
 
 require (
@@ -14,7 +15,6 @@ require (
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/mattn/go-runewidth v0.0.16 // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
-	golang.org/x/sys v0.25.0 // indirect
 )
 
 replace github.com/cbehopkins/pb/v3 => ../pb/v3
@@ -0,0 +1,143 @@
+package medorg
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreFileName holds patterns, one per line, of the files and
+// directories below it that are not to be checksummed or backed up
+// Lines starting with # are comments, and a leading ! un-ignores
+// anything an earlier pattern matched. A trailing / only matches directories.
+// Patterns use filepath.Match syntax, against the name unless they contain
+// a /, in which case against the path from the ignore file's directory.
+const IgnoreFileName = ".medorgignore"
+
+type ignorePattern struct {
+	// base is the directory of the ignore file, "" for a global pattern
+	base    string
+	pattern string
+	negate  bool
+	dirOnly bool
+}
+
+func parseIgnorePattern(base, line string) (ignorePattern, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignorePattern{}, false
+	}
+	ip := ignorePattern{base: base}
+	if strings.HasPrefix(line, "!") {
+		ip.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		ip.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	ip.pattern = strings.TrimPrefix(line, "/")
+	return ip, ip.pattern != ""
+}
+
+func (ip ignorePattern) match(path string, isDir bool) bool {
+	if ip.dirOnly && !isDir {
+		return false
+	}
+	if !strings.Contains(ip.pattern, "/") || ip.base == "" {
+		matched, _ := filepath.Match(ip.pattern, filepath.Base(path))
+		return matched
+	}
+	rel, err := filepath.Rel(ip.base, path)
+	if err != nil {
+		return false
+	}
+	matched, _ := filepath.Match(ip.pattern, filepath.ToSlash(rel))
+	return matched
+}
+
+// ignoreRules are the patterns in force during a walk
+// As the walk is depth first, the patterns of a directory's ignore file
+// come after those of its parents, so the closest file has the last word.
+// Not safe for concurrent use; each walk needs its own.
+type ignoreRules struct {
+	root     string
+	patterns []ignorePattern
+}
+
+func newIgnoreRules(root string, global []string) *ignoreRules {
+	ir := &ignoreRules{root: root}
+	for _, line := range global {
+		if ip, ok := parseIgnorePattern("", line); ok {
+			ir.patterns = append(ir.patterns, ip)
+		}
+	}
+	return ir
+}
+
+// isBelow reports if path is inside dir, but not dir itself
+// Unlike isWithin, it copes with dir being "."
+func isBelow(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// ignoreRulesAt are the rules a walk from root has in force once it reaches dir:
+// the global patterns, then the ignore files from root down to dir
+func ignoreRulesAt(root, dir string, global []string) *ignoreRules {
+	root, dir = filepath.Clean(root), filepath.Clean(dir)
+	ir := newIgnoreRules(root, global)
+	ir.enterDirectory(root)
+	if !isBelow(dir, root) {
+		return ir
+	}
+	rel, _ := filepath.Rel(root, dir)
+	path := root
+	for _, p := range strings.Split(rel, string(filepath.Separator)) {
+		path = filepath.Join(path, p)
+		ir.enterDirectory(path)
+	}
+	return ir
+}
+
+// ignored reports if path is to be skipped
+func (ir *ignoreRules) ignored(path string, isDir bool) bool {
+	if path == ir.root {
+		return false
+	}
+	ignored := false
+	for _, ip := range ir.patterns {
+		if ip.base != "" && !isBelow(path, ip.base) {
+			continue
+		}
+		if ip.match(path, isDir) {
+			ignored = !ip.negate
+		}
+	}
+	return ignored
+}
+
+// enterDirectory loads dir's ignore file, if it has one
+func (ir *ignoreRules) enterDirectory(dir string) {
+	f, err := os.Open(filepath.Join(dir, IgnoreFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		log.Println("Unable to read ignore file in", dir, err)
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if ip, ok := parseIgnorePattern(dir, scanner.Text()); ok {
+			ir.patterns = append(ir.patterns, ip)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Println("Unable to read ignore file in", dir, err)
+	}
+}
@@ -0,0 +1,80 @@
+package medorg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIgnoreFile(t *testing.T) {
+	root, err := os.MkdirTemp("", "ignoreFile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	opts := DefaultDirTrackerOptions()
+	opts.IgnorePatterns = []string{"__pycache__/"}
+
+	mustWrite := func(path, contents string) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustWrite(filepath.Join(root, IgnoreFileName), "# Build output\nnode_modules/\n*.tmp\n!keep.tmp\n")
+	mustWrite(filepath.Join(root, "photo.jpg"), "photo")
+	mustWrite(filepath.Join(root, "scratch.tmp"), "scratch")
+	mustWrite(filepath.Join(root, "keep.tmp"), "keep")
+	mustWrite(filepath.Join(root, "node_modules", "lib.js"), "lib")
+	mustWrite(filepath.Join(root, "src", "__pycache__", "mod.pyc"), "pyc")
+	mustWrite(filepath.Join(root, "src", "mod.py"), "py")
+	// A deeper ignore file only applies below it
+	mustWrite(filepath.Join(root, "src", IgnoreFileName), "*.py\n")
+	mustWrite(filepath.Join(root, "other.py"), "py")
+
+	if err := recalcTestDirectoryWithOptions(root, opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"node_modules", filepath.Join("src", "__pycache__")} {
+		if FileExist(filepath.Join(root, dir), GetMetadataFilename()) {
+			t.Error("Ignored directory has metadata", dir)
+		}
+	}
+	dm, err := DirectoryMapFromDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for fn, expected := range map[string]bool{"photo.jpg": true, "keep.tmp": true, "other.py": true, "scratch.tmp": false} {
+		if _, ok := dm.Get(fn); ok != expected {
+			t.Error("Expected", fn, "recorded to be", expected)
+		}
+	}
+	dm, err = DirectoryMapFromDir(filepath.Join(root, "src"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dm.Get("mod.py"); ok {
+		t.Error("mod.py should have been ignored")
+	}
+}
+
+func TestIsBelow(t *testing.T) {
+	for _, tc := range []struct {
+		path, dir string
+		expected  bool
+	}{
+		{"b.tmp", ".", true},
+		{filepath.Join("src", "b.tmp"), ".", true},
+		{".", ".", false},
+		{filepath.Join("root", "b.tmp"), "root", true},
+		{"root", "root", false},
+		{"rootless", "root", false},
+		{filepath.Join("..", "b.tmp"), ".", false},
+	} {
+		if got := isBelow(tc.path, tc.dir); got != tc.expected {
+			t.Error("isBelow", tc.path, tc.dir, "expected", tc.expected, "got", got)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package medorg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrImportChecksumMismatch the file on the destination is not the file we think was copied there
+var ErrImportChecksumMismatch = errors.New("destination checksum does not match source")
+
+// errImportNoRecord the source file has not had its checksum calculated yet
+var errImportNoRecord = errors.New("no checksum recorded for source file, run check_calc first")
+
+// ImportBackupRecords marks files as having been backed up to destDir
+// by some other tool (e.g. rsync).
+// paths are relative to srcDir, and the file is expected at the same
+// relative path in destDir. Before marking a file, we check that the file
+// on the destination really does have the checksum we expect.
+// Files that fail the check are reported to logFunc and skipped.
+// Returns the number of files marked as backed up.
+func ImportBackupRecords(xc *XMLCfg, srcDir, destDir string, paths []string, logFunc func(msg string)) (int, error) {
+	label, err := xc.getVolumeLabel(destDir)
+	if err != nil {
+		return 0, err
+	}
+	byDir := make(map[string][]string)
+	for _, path := range paths {
+		dir, fn := filepath.Split(filepath.Clean(path))
+		byDir[dir] = append(byDir[dir], fn)
+	}
+	opts := xc.MetadataOptions()
+	imported := 0
+	for dir, files := range byDir {
+		sd := filepath.Join(srcDir, dir)
+		dd := filepath.Join(destDir, dir)
+		dmSrc, err := DirectoryMapFromDirWithOptions(sd, "", opts)
+		if err != nil {
+			return imported, err
+		}
+		dmDst, err := DirectoryMapFromDirWithOptions(dd, "", opts)
+		if err != nil {
+			return imported, err
+		}
+		for _, fn := range files {
+			err := importBackupRecord(dmSrc, dmDst, dd, fn, label)
+			if err != nil {
+				logFunc(fmt.Sprint("Not importing ", filepath.Join(dir, fn), ": ", err))
+				continue
+			}
+			imported++
+		}
+		err = dmSrc.Persist(sd)
+		if err != nil {
+			return imported, err
+		}
+		err = dmDst.Persist(dd)
+		if err != nil {
+			return imported, err
+		}
+	}
+	return imported, nil
+}
+
+func importBackupRecord(dmSrc, dmDst DirectoryMap, destDir, fn, label string) error {
+	src, ok := dmSrc.Get(fn)
+	if !ok || src.Checksum == "" {
+		return errImportNoRecord
+	}
+	info, err := os.Stat(filepath.Join(destDir, fn))
+	if err != nil {
+		return err
+	}
+	cks, err := CalcMd5File(destDir, fn)
+	if err != nil {
+		return err
+	}
+	if cks != src.Checksum {
+		return ErrImportChecksumMismatch
+	}
+	if _, err := src.AddTag(label); err != nil {
+		return err
+	}
+	dmSrc.Add(src)
+
+	// The destination gets the same record, as doACopy would have left it
+	src.BackupDest = append([]string{}, src.BackupDest...)
+	_, _ = src.RemoveTag(label)
+	src.directory = destDir
+	src.Mtime = info.ModTime().Unix()
+	dmDst.Add(src)
+	return nil
+}
@@ -0,0 +1,74 @@
+package medorg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImportBackupRecords(t *testing.T) {
+	dirs, err := createTestBackupDirectories(5, 3)
+	if err != nil {
+		t.Fatal("Failed to create test Directories", err)
+	}
+	defer func() {
+		for i := range dirs {
+			os.RemoveAll(dirs[i])
+		}
+	}()
+	_ = recalcTestDirectory(dirs[0])
+
+	entries, err := os.ReadDir(dirs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, entry := range entries {
+		paths = append(paths, entry.Name())
+	}
+	if len(paths) != 3 {
+		t.Fatal("Expected 3 files on the destination, got", paths)
+	}
+	// Pretend one of them was damaged in transit
+	corrupt := paths[0]
+	err = os.WriteFile(filepath.Join(dirs[1], corrupt), []byte("not the same"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// and the log mentions a file that never arrived
+	paths = append(paths, "missing_file")
+
+	var xc XMLCfg
+	var logged []string
+	logFunc := func(msg string) {
+		logged = append(logged, msg)
+	}
+	imported, err := ImportBackupRecords(&xc, dirs[0], dirs[1], paths, logFunc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != 2 {
+		t.Error("Expected 2 files imported, got", imported, logged)
+	}
+	if len(logged) != 2 {
+		t.Error("Expected the corrupt and missing files to be reported, got", logged)
+	}
+
+	label, err := xc.getVolumeLabel(dirs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	dm, err := DirectoryMapFromDir(dirs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range paths[:3] {
+		fs, ok := dm.Get(fn)
+		if !ok {
+			t.Fatal("Missing source record for", fn)
+		}
+		if fs.HasTag(label) == (fn == corrupt) {
+			t.Error("Unexpected backup state for", fn, fs.BackupDest)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package medorg
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// inodeKeyOf returns the device and inode of the file
+func inodeKeyOf(path string, info fs.FileInfo) (inodeKey, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return inodeKey{}, false
+	}
+	return inodeKey{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
@@ -0,0 +1,34 @@
+//go:build windows
+
+package medorg
+
+import (
+	"io/fs"
+
+	"golang.org/x/sys/windows"
+)
+
+// inodeKeyOf returns the volume serial number and file index of the file
+// Windows doesn't keep these in the FileInfo, so we open the file to ask
+func inodeKeyOf(path string, info fs.FileInfo) (inodeKey, bool) {
+	pathp, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return inodeKey{}, false
+	}
+	// Asking for no access is enough to read the file's information
+	h, err := windows.CreateFile(pathp, 0,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
+		nil, windows.OPEN_EXISTING, windows.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return inodeKey{}, false
+	}
+	defer windows.CloseHandle(h)
+	var fi windows.ByHandleFileInformation
+	if err := windows.GetFileInformationByHandle(h, &fi); err != nil {
+		return inodeKey{}, false
+	}
+	return inodeKey{
+		dev: uint64(fi.VolumeSerialNumber),
+		ino: uint64(fi.FileIndexHigh)<<32 | uint64(fi.FileIndexLow),
+	}, true
+}
@@ -0,0 +1,63 @@
+package medorg
+
+import (
+	"errors"
+	"io/fs"
+	"syscall"
+)
+
+// IOErrorClass groups IO errors by what we should do about them
+type IOErrorClass int
+
+const (
+	// IOErrUnknown we don't know what to do with this
+	IOErrUnknown IOErrorClass = iota
+	// IOErrDiskFull we need a new destination
+	IOErrDiskFull
+	// IOErrBadSector the device is failing, retrying elsewhere may help
+	IOErrBadSector
+	// IOErrPermission needs the user to intervene
+	IOErrPermission
+	// IOErrNetwork probably transient, so worth a retry
+	IOErrNetwork
+)
+
+func (c IOErrorClass) String() string {
+	switch c {
+	case IOErrDiskFull:
+		return "disk full"
+	case IOErrBadSector:
+		return "bad sector"
+	case IOErrPermission:
+		return "permission denied"
+	case IOErrNetwork:
+		return "network error"
+	default:
+		return "unknown error"
+	}
+}
+
+// ClassifyIOError works out what sort of IO error we have been given
+func ClassifyIOError(err error) IOErrorClass {
+	if err == nil {
+		return IOErrUnknown
+	}
+	if errors.Is(err, fs.ErrPermission) {
+		return IOErrPermission
+	}
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return IOErrUnknown
+	}
+	switch errno {
+	case syscall.ENOSPC:
+		return IOErrDiskFull
+	case syscall.EIO:
+		return IOErrBadSector
+	case syscall.EACCES:
+		return IOErrPermission
+	case syscall.ENETUNREACH, syscall.ETIMEDOUT:
+		return IOErrNetwork
+	}
+	return IOErrUnknown
+}
@@ -0,0 +1,31 @@
+package medorg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestClassifyIOError(t *testing.T) {
+	type classifyTest struct {
+		err      error
+		expected IOErrorClass
+	}
+	tests := []classifyTest{
+		{nil, IOErrUnknown},
+		{errors.New("bob"), IOErrUnknown},
+		{ErrNoSpace, IOErrDiskFull},
+		{ErrIOError, IOErrBadSector},
+		{fmt.Errorf("wrapped %w", syscall.EIO), IOErrBadSector},
+		{&os.PathError{Op: "open", Path: "fred", Err: syscall.EACCES}, IOErrPermission},
+		{syscall.ENETUNREACH, IOErrNetwork},
+		{syscall.ETIMEDOUT, IOErrNetwork},
+	}
+	for _, tst := range tests {
+		if got := ClassifyIOError(tst.err); got != tst.expected {
+			t.Error("Classify", tst.err, "expected", tst.expected, "got", got)
+		}
+	}
+}
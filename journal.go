@@ -2,10 +2,14 @@ package medorg
 
 import (
 	"bufio"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Journal is a representation of our filesystem in a journaled fashion
@@ -15,11 +19,22 @@ import (
 type Journal struct {
 	// The file list as recorded on the disk journal
 	fl []DirectoryEntryJournalableInterface
+	// The directory each fl entry was recorded against
+	dirs []string
+	// When each fl entry was journaled, zero if written before we kept track
+	times []time.Time
 	// The  location in the file list of the most recent fl entry
 	location map[string]int
+	// The number of fl entries that are already on disk
+	written int
+	// AppendMode journals only record directories that have changed
+	// (including deletions) so that they can be appended to the existing file
+	// with AppendToWriter, rather than rewriting the whole journal
+	AppendMode bool
 }
 
-var errFileExistsInJournal = errors.New("file exists already")
+// ErrFileExistsInJournal the directory is already in the journal
+var ErrFileExistsInJournal = errors.New("file exists already")
 var errJournalSelfCheckFail = errors.New("journal self check fail")
 var errJournalValidLen = errors.New("valid Journal Length not equal")
 var errJournalMissingFile = errors.New("journal is missing file")
@@ -43,13 +58,59 @@ func (jo Journal) directoryExists(de DirectoryEntryJournalableInterface, dir str
 	}
 	// If they are the same, then say they are the same
 	// otherwise we will behave as if this entry does not already exist
-	return jo.fl[location].Equal(de)
+	return jo.fl[location].Equal(de) && sameBackupDests(jo.fl[location], de)
 }
 
-func (jo *Journal) appendItem(de DirectoryEntryJournalableInterface, dir string) error {
+var errBackupDestsDiffer = errors.New("backup destinations differ")
+
+// sameBackupDests is true if each file in the two entries has been backed up to the same volumes
+// Equal only compares the contents, but the journal also records where each file is archived
+func sameBackupDests(de0, de1 DirectoryEntryJournalableInterface) bool {
+	dm0, ok0 := de0.(*DirectoryMap)
+	dm1, ok1 := de1.(*DirectoryMap)
+	if !ok0 || !ok1 {
+		return true
+	}
+	err := dm0.rangeMap(func(fn string, fs0 FileStruct) error {
+		fs1, _ := dm1.Get(fn)
+		if len(fs0.BackupDest) != len(fs1.BackupDest) {
+			return errBackupDestsDiffer
+		}
+		for _, bd := range fs0.BackupDest {
+			if !containsString(fs1.BackupDest, bd) {
+				return errBackupDestsDiffer
+			}
+		}
+		return nil
+	})
+	return err == nil
+}
+
+// BackupDests returns the volumes the file at path had been backed up to
+// when it was last journaled. ok is false if the file is not in the journal.
+func (jo Journal) BackupDests(path string) (dests []string, ok bool) {
+	dir, fn := filepath.Split(path)
+	location, found := jo.location[filepath.Clean(dir)]
+	if !found {
+		return nil, false
+	}
+	dm, isDm := jo.fl[location].(*DirectoryMap)
+	if !isDm {
+		return nil, false
+	}
+	fs, ok := dm.Get(fn)
+	if !ok {
+		return nil, false
+	}
+	return append([]string{}, fs.BackupDest...), true
+}
+
+func (jo *Journal) appendItem(de DirectoryEntryJournalableInterface, dir string, when time.Time) error {
 	// log.Println("Adding Item to journal:", dir, *md5fp)
 	jo.location[dir] = len(jo.fl)
 	jo.fl = append(jo.fl, de.Copy())
+	jo.dirs = append(jo.dirs, dir)
+	jo.times = append(jo.times, when)
 	// FIXME when we implement the file handling for this
 	// do the append to the file, here.
 	// More likely, send it to a buffered channel.
@@ -59,6 +120,8 @@ func (jo *Journal) appendItem(de DirectoryEntryJournalableInterface, dir string)
 // AppendJournalFromDm adds changed dms to the journal
 // It's important to note that (for now) we journal the full directory contents.
 // Therefore to delete a directory in the journal, make it empty
+// Changes are tracked per directory, not per file: if any file in the
+// directory has changed, every file in it is journaled again.
 func (jo *Journal) AppendJournalFromDm(dm DirectoryEntryJournalableInterface, dir string) error {
 	err := jo.selfCheck()
 	if err != nil {
@@ -72,20 +135,30 @@ func (jo *Journal) AppendJournalFromDm(dm DirectoryEntryJournalableInterface, di
 	if dm.Len() == 0 {
 		_, ok := jo.location[dir]
 		if ok {
+			if jo.AppendMode {
+				// Record the deletion so it is seen when read back
+				err = jo.appendItem(dm, dir, time.Now())
+				if err != nil {
+					return err
+				}
+			}
 			delete(jo.location, dir)
-			return errFileExistsInJournal
+			return ErrFileExistsInJournal
 		}
 		return nil
 	}
 
 	dirExists := jo.directoryExists(dm, dir)
-	err = jo.appendItem(dm, dir)
+	if dirExists && jo.AppendMode {
+		return ErrFileExistsInJournal
+	}
+	err = jo.appendItem(dm, dir, time.Now())
 	if err != nil {
 		return err
 	}
 
 	if dirExists {
-		return errFileExistsInJournal
+		return ErrFileExistsInJournal
 	}
 	return nil
 }
@@ -106,25 +179,277 @@ func (jo Journal) Range(visitor func(DirectoryEntryJournalableInterface, string)
 	return nil
 }
 
+// Len is the number of entries in the journal, including history
+func (jo Journal) Len() int {
+	return len(jo.fl)
+}
+
+// DefaultJournalKeep is the number of recent entries per directory
+// we suggest keeping when compacting
+const DefaultJournalKeep = 10
+
+// Compact returns a journal where each directory's history has been trimmed
+// We keep the first entry (so we know when the directory appeared)
+// and the keepLatestN most recent entries. Everything in between is dropped.
+// Directories that have been deleted from the journal lose their history entirely.
+func (jo Journal) Compact(keepLatestN int) Journal {
+	if keepLatestN < 1 {
+		// The latest entry is the current state, so must always be kept
+		keepLatestN = 1
+	}
+	counts := make(map[string]int)
+	for _, dir := range jo.dirs {
+		counts[dir]++
+	}
+	seen := make(map[string]int)
+	compacted := Journal{location: make(map[string]int)}
+	for i, de := range jo.fl {
+		dir := jo.dirs[i]
+		if _, ok := jo.location[dir]; !ok {
+			continue
+		}
+		n := seen[dir]
+		seen[dir]++
+		if n != 0 && n < counts[dir]-keepLatestN {
+			continue
+		}
+		compacted.location[dir] = len(compacted.fl)
+		compacted.fl = append(compacted.fl, de)
+		compacted.dirs = append(compacted.dirs, dir)
+		compacted.times = append(compacted.times, jo.times[i])
+	}
+	return compacted
+}
+
+// JournalEntry is a directory as it was recorded in the journal
+type JournalEntry struct {
+	Dir       string
+	Entry     DirectoryEntryJournalableInterface
+	Journaled time.Time
+}
+
+// Since returns, oldest first, the entries journaled at or after t
+// i.e. the directories that have changed since then.
+// Entries recorded before the journal kept times are never returned.
+func (jo Journal) Since(t time.Time) []JournalEntry {
+	var entries []JournalEntry
+	for i, de := range jo.fl {
+		if jo.times[i].IsZero() || jo.times[i].Before(t) {
+			continue
+		}
+		entries = append(entries, JournalEntry{Dir: jo.dirs[i], Entry: de, Journaled: jo.times[i]})
+	}
+	return entries
+}
+
+// Filter returns a journal of the entries journaled at or after since,
+// as Since would. The entries are shared with jo, not copied.
+// ToWriter on the filtered journal writes only the directories in it.
+func (jo Journal) Filter(since time.Time) Journal {
+	filtered := Journal{location: make(map[string]int)}
+	for i, de := range jo.fl {
+		if jo.times[i].IsZero() || jo.times[i].Before(since) {
+			continue
+		}
+		dir := jo.dirs[i]
+		if _, ok := jo.location[dir]; ok {
+			filtered.location[dir] = len(filtered.fl)
+		}
+		filtered.fl = append(filtered.fl, de)
+		filtered.dirs = append(filtered.dirs, dir)
+		filtered.times = append(filtered.times, jo.times[i])
+	}
+	return filtered
+}
+
+// ErrBadSince we could not understand when something should be since
+var ErrBadSince = errors.New("expected an RFC3339 time, or a duration such as 24h, 7d or 2w")
+
+// ParseSince turns an RFC3339 time, or a duration before now, into a time
+// As well as the units time.ParseDuration takes, durations may be in days (7d) or weeks (2w)
+func ParseSince(str string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, str); err == nil {
+		return t, nil
+	}
+	var unit time.Duration
+	switch {
+	case strings.HasSuffix(str, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(str, "w"):
+		unit = 7 * 24 * time.Hour
+	}
+	if unit != 0 {
+		n, err := strconv.Atoi(str[:len(str)-1])
+		if err != nil || n < 0 {
+			return time.Time{}, fmt.Errorf("%w::%s", ErrBadSince, str)
+		}
+		return now.Add(-time.Duration(n) * unit), nil
+	}
+	ago, err := time.ParseDuration(str)
+	if err != nil || ago < 0 {
+		return time.Time{}, fmt.Errorf("%w::%s", ErrBadSince, str)
+	}
+	return now.Add(-ago), nil
+}
+
+// secondsPerDay for converting mtimes into change frequencies
+const secondsPerDay = 24 * 60 * 60
+
+// HighChurnFiles looks through the journal history for files whose
+// checksum has changed, and returns how many times a day each changes
+// The map is keyed by the full path of the file.
+// Files that have never changed are not included.
+func (jo Journal) HighChurnFiles() map[string]float32 {
+	type churn struct {
+		checksum    string
+		first, last int64
+		changes     int
+	}
+	churns := make(map[string]*churn)
+	for i, de := range jo.fl {
+		dm, ok := de.(*DirectoryMap)
+		if !ok {
+			continue
+		}
+		dir := jo.dirs[i]
+		_ = dm.rangeMap(func(fn string, fs FileStruct) error {
+			path := filepath.Join(dir, fn)
+			ch, ok := churns[path]
+			if !ok {
+				churns[path] = &churn{checksum: fs.Checksum, first: fs.Mtime, last: fs.Mtime}
+				return nil
+			}
+			if fs.Checksum != "" && fs.Checksum != ch.checksum {
+				ch.changes++
+				ch.checksum = fs.Checksum
+				ch.last = fs.Mtime
+			}
+			return nil
+		})
+	}
+	frequencies := make(map[string]float32)
+	for path, ch := range churns {
+		if ch.changes == 0 {
+			continue
+		}
+		days := float64(ch.last-ch.first) / secondsPerDay
+		if days < 1 {
+			days = 1
+		}
+		frequencies[path] = float32(float64(ch.changes) / days)
+	}
+	return frequencies
+}
+
+// SetChangeFrequencies records the supplied frequencies (as from HighChurnFiles)
+// into the directory maps on disk, so that the backup can make use of them
+func SetChangeFrequencies(frequencies map[string]float32, opts MetadataOptions) error {
+	byDir := make(map[string]map[string]float32)
+	for path, freq := range frequencies {
+		dir, fn := filepath.Split(path)
+		dir = filepath.Clean(dir)
+		if byDir[dir] == nil {
+			byDir[dir] = make(map[string]float32)
+		}
+		byDir[dir][fn] = freq
+	}
+	for dir, files := range byDir {
+		dm, err := DirectoryMapFromDirWithOptions(dir, "", opts)
+		if err != nil {
+			return err
+		}
+		err = dm.rangeMutate(func(fn string, fs FileStruct) (FileStruct, error) {
+			freq, ok := files[fn]
+			if !ok {
+				return fs, errIgnoreThisMutate
+			}
+			fs.ChangeFrequency = freq
+			return fs, nil
+		})
+		if err != nil {
+			return err
+		}
+		err = dm.Persist(dir)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 var errShortWrite = errors.New("short write in journal")
 
+func writeDe(fd io.Writer, de DirectoryEntryJournalableInterface, dir string, when time.Time) error {
+	var xm []byte
+	var err error
+	if dm, ok := de.(*DirectoryMap); ok && !when.IsZero() {
+		var m5f *Md5File
+		m5f, err = dm.ToMd5File(dir)
+		if err != nil {
+			return err
+		}
+		m5f.Journaled = when.Unix()
+		xm, err = xml.MarshalIndent(m5f, "", "  ")
+	} else {
+		xm, err = de.ToXML(dir)
+	}
+	if err != nil {
+		return err
+	}
+	n, err := fd.Write(xm)
+	if err != nil {
+		return err
+	}
+	if n != len(xm) {
+		return fmt.Errorf("%w with %s", errShortWrite, de)
+	}
+	return nil
+}
+
 // ToWriter dumps the whole journal to a writer
 func (jo Journal) ToWriter(fd io.Writer) error {
 	visitor := func(de DirectoryEntryJournalableInterface, dir string) error {
-		xm, err := de.ToXML(dir)
+		return writeDe(fd, de, dir, jo.times[jo.location[dir]])
+	}
+	return jo.Range(visitor)
+}
+
+// HistoryToWriter dumps every entry in the journal to a writer
+// in the order they were added, so that the history is preserved
+// Entries for deleted directories are not written
+func (jo Journal) HistoryToWriter(fd io.Writer) error {
+	err := jo.selfCheck()
+	if err != nil {
+		return err
+	}
+	for i, de := range jo.fl {
+		if _, ok := jo.location[jo.dirs[i]]; !ok {
+			continue
+		}
+		err := writeDe(fd, de, jo.dirs[i], jo.times[i])
 		if err != nil {
 			return err
 		}
-		n, err := fd.Write(xm)
+	}
+	return nil
+}
+
+// AppendToWriter writes the entries added since the journal was read in
+// (or last written) to a writer, which should be the journal file opened for append.
+// Entries for deleted directories are written as empty directories.
+func (jo *Journal) AppendToWriter(fd io.Writer) error {
+	err := jo.selfCheck()
+	if err != nil {
+		return err
+	}
+	for i := jo.written; i < len(jo.fl); i++ {
+		err := writeDe(fd, jo.fl[i], jo.dirs[i], jo.times[i])
 		if err != nil {
 			return err
 		}
-		if n != len(xm) {
-			return fmt.Errorf("%w with %s", errShortWrite, de)
-		}
-		return nil
+		jo.written = i + 1
 	}
-	return jo.Range(visitor)
+	return nil
 }
 
 // scanToken returns a token which for us is an xml token
@@ -195,15 +520,27 @@ func (jo *Journal) FromReader(fd io.Reader) error {
 		jo.location = make(map[string]int)
 	}
 	fc := func(ip string) error {
-		de := NewDirectoryMap()
-		dir, err := de.FromXML([]byte(ip))
+		var m5f Md5File
+		err := xml.Unmarshal([]byte(ip), &m5f)
 		if err != nil {
 			return err
 		}
-		jo.appendItem(de, dir)
+		de := NewDirectoryMap()
+		de.fromMd5File(m5f)
+		var when time.Time
+		if m5f.Journaled != 0 {
+			when = time.Unix(m5f.Journaled, 0)
+		}
+		jo.appendItem(de, m5f.Dir, when)
+		if de.Len() == 0 {
+			// An empty directory is how a deletion is recorded
+			delete(jo.location, m5f.Dir)
+		}
 		return nil
 	}
-	return slupReadFunc(fd, fc)
+	err := slupReadFunc(fd, fc)
+	jo.written = len(jo.fl)
+	return err
 }
 
 func (jo0 Journal) Equals(jo1 Journal, missingFunc func(DirectoryEntryJournalableInterface, string) error) error {
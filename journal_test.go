@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 type directoryTestStuff struct {
@@ -107,7 +108,7 @@ func createInitialJournal(t *testing.T, initialDirectoryStructure *directoryTest
 		tmp := de.dm.(DirectoryMap)
 		//dm := tst.(DirectoryEntryJournalableInterface)
 		err := journal.AppendJournalFromDm(&tmp, de.dir)
-		if err == errFileExistsInJournal {
+		if err == ErrFileExistsInJournal {
 			return fmt.Errorf("initial setup TestJournalDummyWalks %w,%s", err, de.dir)
 		}
 		return err
@@ -135,7 +136,7 @@ func TestJournalDummyWalk(t *testing.T) {
 	visitFuncRevisit := func(de DirectoryEntry) error {
 		tmp := de.dm.(DirectoryMap)
 		err := journal.AppendJournalFromDm(&tmp, de.dir)
-		if err != errFileExistsInJournal {
+		if err != ErrFileExistsInJournal {
 			t.Log("Got:", de.dm)
 			return fmt.Errorf("issue on revisit %w,%s", err, de.dir)
 		}
@@ -168,7 +169,7 @@ func TestJournalDummyAddFiles(t *testing.T) {
 	visitFuncInitial1 := func(de DirectoryEntry) error {
 		tmp := de.dm.(DirectoryMap)
 		err := journal.AppendJournalFromDm(&tmp, de.dir)
-		if err == errFileExistsInJournal {
+		if err == ErrFileExistsInJournal {
 			return fmt.Errorf("initial1 TestJournalBasicXml %w,%s", err, de.dir)
 		}
 		numDirsToAdd--
@@ -204,7 +205,7 @@ func TestJournalDummyModifyFiles(t *testing.T) {
 	visitFuncAdd0 := func(de DirectoryEntry) error {
 		tmp := de.dm.(DirectoryMap)
 		err := journal.AppendJournalFromDm(&tmp, de.dir)
-		if err == errFileExistsInJournal {
+		if err == ErrFileExistsInJournal {
 			return nil
 		}
 		if err == nil {
@@ -232,7 +233,7 @@ func deleteNDirectories(n int, t *testing.T, initialDirectoryStructure directory
 	visitFuncDeleter := func(de DirectoryEntry) error {
 		tmp := de.dm.(DirectoryMap)
 		err := journal.AppendJournalFromDm(&tmp, de.dir)
-		if err == errFileExistsInJournal {
+		if err == ErrFileExistsInJournal {
 			// All files should already exist
 			return nil
 		}
@@ -265,7 +266,7 @@ func TestJournalDummyRmDir(t *testing.T) {
 	visitFuncCheck := func(de DirectoryEntry) error {
 		tmp := de.dm.(DirectoryMap)
 		err := journal.AppendJournalFromDm(&tmp, de.dir)
-		if err == errFileExistsInJournal {
+		if err == ErrFileExistsInJournal {
 			return nil
 		}
 		if err == nil {
@@ -371,3 +372,328 @@ func TestJournalXmlIo(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestJournalCompact(t *testing.T) {
+	journal := Journal{}
+	dirs := []string{"a", "b"}
+	numEntries := 6
+	keep := 2
+	// Each revision of a directory has a different file in it
+	// so we can tell which entries survived
+	for i := 0; i < numEntries; i++ {
+		for _, dir := range dirs {
+			dm := NewDirectoryMap()
+			dm.Add(FileStruct{Name: fmt.Sprint("file", i), Checksum: "abc"})
+			_ = journal.AppendJournalFromDm(dm, dir)
+		}
+	}
+	if journal.Len() != numEntries*len(dirs) {
+		t.Fatal("Unexpected journal length", journal.Len())
+	}
+	compacted := journal.Compact(keep)
+	if compacted.Len() != (keep+1)*len(dirs) {
+		t.Fatal("Unexpected compacted length", compacted.Len())
+	}
+	expected := []int{0, numEntries - 2, numEntries - 1}
+	for _, dir := range dirs {
+		var found []int
+		for i, de := range compacted.fl {
+			if compacted.dirs[i] != dir {
+				continue
+			}
+			for j := 0; j < numEntries; j++ {
+				if _, ok := de.(*DirectoryMap).Get(fmt.Sprint("file", j)); ok {
+					found = append(found, j)
+				}
+			}
+		}
+		if fmt.Sprint(found) != fmt.Sprint(expected) {
+			t.Error("Expected entries", expected, "for", dir, "got", found)
+		}
+	}
+	// The most recent entry should still be the valid one
+	visitor := func(de DirectoryEntryJournalableInterface, dir string) error {
+		if _, ok := de.(*DirectoryMap).Get(fmt.Sprint("file", numEntries-1)); !ok {
+			t.Error("Latest entry not valid for", dir)
+		}
+		return nil
+	}
+	_ = compacted.Range(visitor)
+
+	var b bytes.Buffer
+	err := compacted.HistoryToWriter(&b)
+	if err != nil {
+		t.Error(err)
+	}
+	journalTo := Journal{}
+	err = journalTo.FromReader(&b)
+	if err != nil {
+		t.Error(err)
+	}
+	if journalTo.Len() != compacted.Len() {
+		t.Error("History not preserved through write", journalTo.Len())
+	}
+}
+
+func TestJournalHighChurnFiles(t *testing.T) {
+	journal := Journal{}
+	day := int64(secondsPerDay)
+	// "busy" changes every day, "static" never changes
+	for i := int64(0); i < 11; i++ {
+		dm := NewDirectoryMap()
+		dm.Add(FileStruct{Name: "busy", Checksum: fmt.Sprint("abc", i), Mtime: i * day})
+		dm.Add(FileStruct{Name: "static", Checksum: "def", Mtime: 0})
+		_ = journal.AppendJournalFromDm(dm, "dir")
+	}
+	frequencies := journal.HighChurnFiles()
+	if len(frequencies) != 1 {
+		t.Fatal("Expected only the busy file, got:", frequencies)
+	}
+	freq, ok := frequencies[filepath.Join("dir", "busy")]
+	if !ok {
+		t.Fatal("Busy file missing", frequencies)
+	}
+	if freq != 1.0 {
+		t.Error("Expected a change a day, got:", freq)
+	}
+}
+
+func TestJournalAppendMode(t *testing.T) {
+	journal := Journal{}
+	for _, dir := range []string{"a", "b"} {
+		dm := NewDirectoryMap()
+		dm.Add(FileStruct{Name: "file0", Checksum: "abc"})
+		_ = journal.AppendJournalFromDm(dm, dir)
+	}
+	var buf bytes.Buffer
+	err := journal.ToWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Read it back in and make some changes
+	readBack := Journal{AppendMode: true}
+	err = readBack.FromReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unchanged := NewDirectoryMap()
+	unchanged.Add(FileStruct{Name: "file0", Checksum: "abc"})
+	err = readBack.AppendJournalFromDm(unchanged, "a")
+	if !errors.Is(err, ErrFileExistsInJournal) {
+		t.Error("Expected the unchanged directory to already exist, got", err)
+	}
+	changed := NewDirectoryMap()
+	changed.Add(FileStruct{Name: "file1", Checksum: "def"})
+	err = readBack.AppendJournalFromDm(changed, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = readBack.AppendJournalFromDm(NewDirectoryMap(), "b")
+
+	// Only the changes should be appended
+	var appended bytes.Buffer
+	err = readBack.AppendToWriter(&appended)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(appended.String(), "<dr") != 2 {
+		t.Error("Expected just the change and the deletion, got", appended.String())
+	}
+	if strings.Contains(appended.String(), "file0") {
+		t.Error("Unchanged entries should not be appended", appended.String())
+	}
+
+	// And reading the lot back gives us the current state
+	buf.Write(appended.Bytes())
+	final := Journal{}
+	err = final.FromReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirs := 0
+	visitor := func(de DirectoryEntryJournalableInterface, dir string) error {
+		dirs++
+		if _, ok := de.(*DirectoryMap).Get("file1"); dir != "a" || !ok {
+			t.Error("Unexpected entry", dir, de)
+		}
+		return nil
+	}
+	_ = final.Range(visitor)
+	if dirs != 1 {
+		t.Error("Expected only directory a to remain, got", dirs)
+	}
+}
+
+func TestJournalBackupDests(t *testing.T) {
+	journal := Journal{AppendMode: true}
+	dm := NewDirectoryMap()
+	dm.Add(FileStruct{Name: "file0", Checksum: "abc", Size: 3})
+	if err := journal.AppendJournalFromDm(dm, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the backup state has changed, which still needs journaling
+	backedUp := NewDirectoryMap()
+	backedUp.Add(FileStruct{Name: "file0", Checksum: "abc", Size: 3, BackupDest: []string{"vol1"}})
+	if err := journal.AppendJournalFromDm(backedUp, "a"); err != nil {
+		t.Fatal("A change in backup destination should be journaled, got", err)
+	}
+
+	var buf bytes.Buffer
+	if err := journal.ToWriter(&buf); err != nil {
+		t.Fatal(err)
+	}
+	readBack := Journal{}
+	if err := readBack.FromReader(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dests, ok := readBack.BackupDests(filepath.Join("a", "file0"))
+	if !ok || fmt.Sprint(dests) != fmt.Sprint([]string{"vol1"}) {
+		t.Error("Unexpected backup destinations", dests, ok)
+	}
+	if _, ok := readBack.BackupDests(filepath.Join("a", "missing")); ok {
+		t.Error("Missing file should not be found")
+	}
+}
+
+func TestJournalSince(t *testing.T) {
+	journal := Journal{AppendMode: true}
+	addDir := func(dir, checksum string) {
+		dm := NewDirectoryMap()
+		dm.Add(FileStruct{Name: "file0", Checksum: checksum})
+		if err := journal.AppendJournalFromDm(dm, dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addDir("a", "abc")
+	addDir("b", "abc")
+	// Times are written to the journal in seconds
+	time.Sleep(1100 * time.Millisecond)
+	between := time.Now()
+	addDir("a", "def")
+
+	entries := journal.Since(between)
+	if len(entries) != 1 || entries[0].Dir != "a" {
+		t.Fatal("Expected just the change to a, got", entries)
+	}
+	if fs, _ := entries[0].Entry.(*DirectoryMap).Get("file0"); fs.Checksum != "def" {
+		t.Error("Expected the changed entry, got", fs)
+	}
+	if len(journal.Since(time.Time{})) != 3 {
+		t.Error("Everything is since the beginning of time")
+	}
+
+	// The times survive being written out and read back
+	var buf bytes.Buffer
+	if err := journal.HistoryToWriter(&buf); err != nil {
+		t.Fatal(err)
+	}
+	readBack := Journal{AppendMode: true}
+	if err := readBack.FromReader(&buf); err != nil {
+		t.Fatal(err)
+	}
+	entries = readBack.Since(between.Truncate(time.Second))
+	if len(entries) != 1 || entries[0].Dir != "a" {
+		t.Error("Expected just the change to a after reading back, got", entries)
+	}
+
+	// A second run over unchanged directories journals nothing new
+	for _, dir := range []string{"a", "b"} {
+		dm := NewDirectoryMap()
+		checksum := map[string]string{"a": "def", "b": "abc"}[dir]
+		dm.Add(FileStruct{Name: "file0", Checksum: checksum})
+		if err := readBack.AppendJournalFromDm(dm, dir); !errors.Is(err, ErrFileExistsInJournal) {
+			t.Error("Expected", dir, "to be unchanged, got", err)
+		}
+	}
+	var appended bytes.Buffer
+	if err := readBack.AppendToWriter(&appended); err != nil {
+		t.Fatal(err)
+	}
+	if appended.Len() != 0 {
+		t.Error("Nothing should have been appended, got", appended.String())
+	}
+
+	// Journals from before we kept times have none to report
+	old := Journal{}
+	if err := old.FromReader(strings.NewReader(`<dr dir="c"><fr fname="file0" checksum="abc"></fr></dr>`)); err != nil {
+		t.Fatal(err)
+	}
+	if len(old.Since(time.Time{})) != 0 {
+		t.Error("Entries without a time should not be reported")
+	}
+}
+
+func TestJournalFilter(t *testing.T) {
+	journal := Journal{}
+	numEntries := 10
+	start := time.Unix(1700000000, 0)
+	for i := 0; i < numEntries; i++ {
+		dm := NewDirectoryMap()
+		dm.Add(FileStruct{Name: "file0", Checksum: fmt.Sprint("cks", i)})
+		if err := journal.AppendJournalFromDm(dm, fmt.Sprint("dir", i)); err != nil {
+			t.Fatal(err)
+		}
+		// An hour between each, rather than waiting
+		journal.times[i] = start.Add(time.Duration(i) * time.Hour)
+	}
+	since := journal.times[numEntries-5]
+	filtered := journal.Filter(since)
+	if filtered.Len() != 5 {
+		t.Fatal("Expected 5 entries, got", filtered.Len())
+	}
+	if journal.Len() != numEntries {
+		t.Error("Filtering should not change the original journal")
+	}
+
+	var buf bytes.Buffer
+	if err := filtered.ToWriter(&buf); err != nil {
+		t.Fatal(err)
+	}
+	readBack := Journal{}
+	if err := readBack.FromReader(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if readBack.Len() != 5 {
+		t.Error("Expected only the filtered entries to be written, got", readBack.Len())
+	}
+	_ = readBack.Range(func(de DirectoryEntryJournalableInterface, dir string) error {
+		if dir < fmt.Sprint("dir", numEntries-5) {
+			t.Error("Unexpected entry written", dir)
+		}
+		return nil
+	})
+	if journal.Filter(start.Add(time.Duration(numEntries)*time.Hour)).Len() != 0 {
+		t.Error("Nothing should be after the last entry")
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		str      string
+		expected time.Time
+	}{
+		{"24h", now.Add(-24 * time.Hour)},
+		{"90m", now.Add(-90 * time.Minute)},
+		{"7d", now.Add(-7 * 24 * time.Hour)},
+		{"2w", now.Add(-14 * 24 * time.Hour)},
+		{"2024-03-01T00:00:00Z", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := ParseSince(tt.str, now)
+		if err != nil {
+			t.Error(tt.str, err)
+			continue
+		}
+		if !got.Equal(tt.expected) {
+			t.Error(tt.str, "expected", tt.expected, "got", got)
+		}
+	}
+	for _, str := range []string{"", "d", "1.5d", "-3d", "-1h", "yesterday"} {
+		if _, err := ParseSince(str, now); !errors.Is(err, ErrBadSince) {
+			t.Error("Expected", str, "to be rejected, got", err)
+		}
+	}
+}
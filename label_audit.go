@@ -0,0 +1,85 @@
+package medorg
+
+import (
+	"errors"
+	"sort"
+)
+
+// LabelStatus says what we know about a volume label a source file claims to be backed up to
+type LabelStatus int
+
+const (
+	// LabelUnknown no volume we have ever labelled has this label
+	LabelUnknown LabelStatus = iota
+	// LabelNotMounted we labelled this volume, but it is not mounted
+	LabelNotMounted
+	// LabelVerified the volume is mounted and has this label
+	LabelVerified
+)
+
+func (ls LabelStatus) String() string {
+	switch ls {
+	case LabelVerified:
+		return "verified"
+	case LabelNotMounted:
+		return "not mounted"
+	default:
+		return "unknown"
+	}
+}
+
+// LabelAudit is the status of one of the labels found in the sources
+type LabelAudit struct {
+	Label  string
+	Status LabelStatus
+	// Files is how many source files say they are backed up on this volume
+	Files int
+}
+
+// AuditBackupLabels collects every volume label in the BackupDest of the files
+// in srcDirs, and checks each against the labels of the mountedDirs
+// and the labels in the config.
+// Nothing is written, not even a label for a mounted directory that lacks one.
+func (xc *XMLCfg) AuditBackupLabels(srcDirs, mountedDirs []string) ([]LabelAudit, error) {
+	counts := make(map[string]int)
+	fc := func(dir string, dm DirectoryMap) error {
+		return dm.rangeMap(func(fn string, fs FileStruct) error {
+			for _, label := range fs.BackupDest {
+				counts[label]++
+			}
+			return nil
+		})
+	}
+	for _, srcDir := range srcDirs {
+		if err := walkDirectoryMaps(srcDir, xc.MetadataOptions(), fc); err != nil {
+			return nil, err
+		}
+	}
+
+	mounted := make(map[string]struct{})
+	for _, dir := range mountedDirs {
+		label, err := readVolumeLabel(dir)
+		if errors.Is(err, ErrNoVolumeLabel) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		mounted[label] = struct{}{}
+	}
+
+	audits := make([]LabelAudit, 0, len(counts))
+	for label, cnt := range counts {
+		la := LabelAudit{Label: label, Files: cnt}
+		if _, ok := mounted[label]; ok {
+			la.Status = LabelVerified
+		} else if xc.HasLabel(label) {
+			la.Status = LabelNotMounted
+		}
+		audits = append(audits, la)
+	}
+	sort.Slice(audits, func(i, j int) bool {
+		return audits[i].Label < audits[j].Label
+	})
+	return audits, nil
+}
@@ -0,0 +1,53 @@
+package medorg
+
+import (
+	"os"
+	"testing"
+)
+
+func TestAuditBackupLabels(t *testing.T) {
+	dirs := make([]string, 3)
+	for i := range dirs {
+		dir, err := os.MkdirTemp("", "labelAudit")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		dirs[i] = dir
+	}
+	src, mountedDir, unlabelledDir := dirs[0], dirs[1], dirs[2]
+	var xc XMLCfg
+	vc, err := xc.VolumeCfgFromDir(mountedDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xc.AddLabel("elsewhere")
+
+	dm := NewDirectoryMap()
+	dm.Add(FileStruct{Name: "a", Checksum: "a", BackupDest: []string{vc.Label, "elsewhere"}, directory: src})
+	dm.Add(FileStruct{Name: "b", Checksum: "b", BackupDest: []string{"lost", vc.Label}, directory: src})
+	if err := dm.Persist(src); err != nil {
+		t.Fatal(err)
+	}
+
+	audits, err := xc.AuditBackupLabels([]string{src}, []string{mountedDir, unlabelledDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]LabelAudit{
+		vc.Label:    {Label: vc.Label, Status: LabelVerified, Files: 2},
+		"elsewhere": {Label: "elsewhere", Status: LabelNotMounted, Files: 1},
+		"lost":      {Label: "lost", Status: LabelUnknown, Files: 1},
+	}
+	if len(audits) != len(expected) {
+		t.Error("Expected", len(expected), "labels, got", audits)
+	}
+	for _, la := range audits {
+		if la != expected[la.Label] {
+			t.Error("Expected", expected[la.Label], "got", la)
+		}
+	}
+	if hasVolumeLabel(unlabelledDir) {
+		t.Error("Auditing should not label a directory")
+	}
+}
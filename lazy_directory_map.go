@@ -0,0 +1,115 @@
+package medorg
+
+import (
+	"io/fs"
+	"sync"
+	"sync/atomic"
+)
+
+// LazyDirectoryMap delays reading in the directory's xml
+// until someone actually needs it.
+// On a walk over a large tree, loading every .medorg.xml up front
+// causes a long pause before anything useful happens
+type LazyDirectoryMap struct {
+	dir    string
+	mkF    EntryMaker
+	once   sync.Once
+	loaded uint32
+	de     DirectoryEntryInterface
+	err    error
+}
+
+// NewLazyDirectoryMap returns a lazy wrapper around DirectoryMapFromDir
+func NewLazyDirectoryMap(dir string) *LazyDirectoryMap {
+	mkF := func(dir string) (DirectoryEntryInterface, error) {
+		return DirectoryMapFromDir(dir)
+	}
+	return &LazyDirectoryMap{dir: dir, mkF: mkF}
+}
+
+// NewLazyDirectoryEntry is like NewDirectoryEntry, except
+// the maker function is not called until the entry is first used
+func NewLazyDirectoryEntry(dir string, mkF EntryMaker) (DirectoryEntry, error) {
+	mkL := func(dir string) (DirectoryEntryInterface, error) {
+		return &LazyDirectoryMap{dir: dir, mkF: mkF}, nil
+	}
+	return NewDirectoryEntry(dir, mkL)
+}
+
+func (ldm *LazyDirectoryMap) load() (DirectoryEntryInterface, error) {
+	ldm.once.Do(func() {
+		ldm.de, ldm.err = ldm.mkF(ldm.dir)
+		atomic.StoreUint32(&ldm.loaded, 1)
+	})
+	return ldm.de, ldm.err
+}
+
+// Loaded reports if we have needed to read from disk yet
+func (ldm *LazyDirectoryMap) Loaded() bool {
+	return atomic.LoadUint32(&ldm.loaded) > 0
+}
+
+func (ldm *LazyDirectoryMap) directoryMap() (DirectoryMap, error) {
+	de, err := ldm.load()
+	if err != nil {
+		return DirectoryMap{}, err
+	}
+	switch dm := de.(type) {
+	case DirectoryMap:
+		return dm, nil
+	case *DirectoryMap:
+		return *dm, nil
+	}
+	return DirectoryMap{}, errStructProblem
+}
+
+// Get the struct associated with a filename
+func (ldm *LazyDirectoryMap) Get(fn string) (FileStruct, bool, error) {
+	dm, err := ldm.directoryMap()
+	if err != nil {
+		return FileStruct{}, false, err
+	}
+	fs, ok := dm.Get(fn)
+	return fs, ok, nil
+}
+
+// Add adds a file struct to the dm
+func (ldm *LazyDirectoryMap) Add(fs FileStruct) error {
+	dm, err := ldm.directoryMap()
+	if err != nil {
+		return err
+	}
+	dm.Add(fs)
+	return nil
+}
+
+// Persist self to disk
+// If we have never been loaded, then there can be nothing to write
+func (ldm *LazyDirectoryMap) Persist(dir string) error {
+	if !ldm.Loaded() {
+		return nil
+	}
+	de, err := ldm.load()
+	if err != nil {
+		return err
+	}
+	return de.Persist(dir)
+}
+
+// Visitor satisfies DirectoryEntryInterface
+func (ldm *LazyDirectoryMap) Visitor(directory, file string, d fs.DirEntry) error {
+	de, err := ldm.load()
+	if err != nil {
+		return err
+	}
+	return de.Visitor(directory, file, d)
+}
+
+// Revisit satisfies DirectoryEntryInterface
+func (ldm *LazyDirectoryMap) Revisit(dir string, visitor func(dm DirectoryEntryInterface, directory string, file string, fileStruct FileStruct) error) {
+	de, err := ldm.load()
+	if err != nil {
+		return
+	}
+	de.Revisit(dir, visitor)
+}
@@ -0,0 +1,99 @@
+package medorg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLazyDirectoryMap(t *testing.T) {
+	wkDir, err := ioutil.TempDir("", "lazyTest")
+	if err != nil {
+		t.Error("TmpDir Error:", err)
+	}
+	defer os.RemoveAll(wkDir)
+
+	dm := NewDirectoryMap()
+	dm.Add(FileStruct{Name: "bob", Checksum: "abc", directory: wkDir})
+	err = dm.Persist(wkDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ldm := NewLazyDirectoryMap(wkDir)
+	if ldm.Loaded() {
+		t.Error("Loaded before use")
+	}
+	err = ldm.Persist(wkDir)
+	if err != nil {
+		t.Error(err)
+	}
+	if ldm.Loaded() {
+		t.Error("Persist of an unused map should not load it")
+	}
+	fs, ok, err := ldm.Get("bob")
+	if err != nil {
+		t.Error(err)
+	}
+	if !ok || fs.Checksum != "abc" {
+		t.Error("Failed to get bob", fs)
+	}
+	if !ldm.Loaded() {
+		t.Error("Get should have loaded the map")
+	}
+}
+
+func makeManyDirectories(t testing.TB, cnt int) string {
+	wkDir, err := ioutil.TempDir("", "lazyBench")
+	if err != nil {
+		t.Fatal("TmpDir Error:", err)
+	}
+	for i := 0; i < cnt; i++ {
+		dir := filepath.Join(wkDir, RandStringBytesMaskImprSrcSB(8))
+		err = os.Mkdir(dir, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dm := NewDirectoryMap()
+		dm.Add(FileStruct{Name: "bob", Checksum: "abc", directory: dir})
+		err = dm.Persist(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return wkDir
+}
+
+func benchmarkDirectoryEntryCreation(b *testing.B, lazy bool) {
+	wkDir := makeManyDirectories(b, 1000)
+	defer os.RemoveAll(wkDir)
+	entries, err := os.ReadDir(wkDir)
+	if err != nil {
+		b.Fatal(err)
+	}
+	mkFk := func(dir string) (DirectoryEntryInterface, error) {
+		return DirectoryMapFromDir(dir)
+	}
+	newEntry := NewDirectoryEntry
+	if lazy {
+		newEntry = NewLazyDirectoryEntry
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, e := range entries {
+			_, err := newEntry(filepath.Join(wkDir, e.Name()), mkFk)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// Startup latency for a tree of 1000 directories
+func BenchmarkDirectoryEntryCreation(b *testing.B) {
+	benchmarkDirectoryEntryCreation(b, false)
+}
+func BenchmarkLazyDirectoryEntryCreation(b *testing.B) {
+	benchmarkDirectoryEntryCreation(b, true)
+}
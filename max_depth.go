@@ -0,0 +1,20 @@
+package medorg
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// directoryDepth is how many directories path is below root
+func directoryDepth(root, path string) int {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
+
+// tooDeep reports if the directory path is below the depth we walk to
+func (dt *DirTracker) tooDeep(path string) bool {
+	return dt.maxDepth >= 0 && directoryDepth(dt.root, path) > dt.maxDepth
+}
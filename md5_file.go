@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"strconv"
 )
 
 // Md5File is the struct written into each directory
@@ -13,7 +14,12 @@ import (
 type Md5File struct {
 	XMLName struct{}        `xml:"dr"`
 	Dir     string          `xml:"dir,attr,omitempty"`
+	Version int             `xml:"version,attr,omitempty"` // Format of the file, see MetadataVersion
+	Meta    *DirectoryMeta  `xml:"DirectoryMeta,omitempty"`
 	Files   FileStructArray `xml:"fr"`
+	// Journaled is when the journal recorded this, in unix seconds
+	// Only set in the journal, not in the directory's own file
+	Journaled int64 `xml:"journaled,attr,omitempty"`
 }
 
 // append adds a struct to the struct
@@ -21,6 +27,51 @@ func (md *Md5File) append(fs FileStruct) {
 	md.Files = append(md.Files, fs)
 }
 
+// MarshalXML writes the file in the same shape as DirectoryMap.writeXML
+func (md Md5File) MarshalXML(enc *xml.Encoder, _ xml.StartElement) error {
+	return md.encode(enc, func(yield func(FileStruct) error) error {
+		for _, fs := range md.Files {
+			if err := yield(fs); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// encode writes md to enc, with the files supplied by files
+// so that they need not all be in memory at once
+func (md Md5File) encode(enc *xml.Encoder, files func(yield func(FileStruct) error) error) error {
+	start := xml.StartElement{Name: xml.Name{Local: "dr"}}
+	if md.Dir != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "dir"}, Value: md.Dir})
+	}
+	if md.Version != 0 {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "version"}, Value: strconv.Itoa(md.Version)})
+	}
+	if md.Journaled != 0 {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "journaled"}, Value: strconv.FormatInt(md.Journaled, 10)})
+	}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	if !md.Meta.empty() {
+		if err := enc.EncodeElement(md.Meta, xml.StartElement{Name: xml.Name{Local: "DirectoryMeta"}}); err != nil {
+			return fmt.Errorf("unknown Error Marshalling Xml:%w", err)
+		}
+	}
+	err := files(func(fs FileStruct) error {
+		if err := enc.Encode(fs); err != nil {
+			return fmt.Errorf("unknown Error Marshalling Xml:%w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return enc.EncodeToken(start.End())
+}
+
 // func (md Md5File) String() string {
 // 	txt, err := xml.MarshalIndent(md, "", "  ")
 // 	switch err {
@@ -31,6 +82,10 @@ func (md *Md5File) append(fs FileStruct) {
 // 	}
 // 	return string(txt)
 // }
+
+// ErrBadMetadata a metadata file could be read, but not understood
+var ErrBadMetadata = errors.New("unknown Error UnMarshalling")
+
 func supressXmlUnmarshallErrors(err error) error {
 	xse := &xml.SyntaxError{}
 	switch true {
@@ -42,7 +97,7 @@ func supressXmlUnmarshallErrors(err error) error {
 		// But still note that it happened
 		log.Println("Unmarshalling error:", err)
 	default:
-		return fmt.Errorf("unknown Error UnMarshalling:%w", err)
+		return fmt.Errorf("%w:%w", ErrBadMetadata, err)
 	}
 	return nil
 }
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"plugin"
+	"strings"
+
+	"github.com/cbehopkins/medorg"
+)
+
+// Copier plugins are loaded here, rather than in medorg, as importing plugin
+// needs cgo, and the other tools should stay static binaries
+
+// errBadPlugin the plugin does not provide what we need
+var errBadPlugin = errors.New("plugin does not export NewFileCopier() medorg.FileCopier")
+
+// copierPluginSymbol is the function each copier plugin must export
+const copierPluginSymbol = "NewFileCopier"
+
+const pluginExt = ".so"
+
+// pluginDir is where we look for copier plugins
+func pluginDir() string {
+	return filepath.Join(string(medorg.HomeDir()), ".medorg", "plugins")
+}
+
+// listCopierPlugins returns the names of the plugins in pluginDir
+// A missing plugin directory just means there are no plugins
+func listCopierPlugins() ([]string, error) {
+	entries, err := os.ReadDir(pluginDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != pluginExt {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(entry.Name(), pluginExt))
+	}
+	return names, nil
+}
+
+// loadCopierPlugin opens the named plugin from pluginDir
+// and returns the FileCopier it provides
+func loadCopierPlugin(name string) (medorg.FileCopier, error) {
+	fn := filepath.Join(pluginDir(), name+pluginExt)
+	p, err := plugin.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup(copierPluginSymbol)
+	if err != nil {
+		return nil, fmt.Errorf("%w::%s", errBadPlugin, fn)
+	}
+	newFileCopier, ok := sym.(func() medorg.FileCopier)
+	if !ok {
+		return nil, fmt.Errorf("%w::%s", errBadPlugin, fn)
+	}
+	return newFileCopier(), nil
+}
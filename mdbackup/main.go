@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
@@ -9,7 +10,9 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cbehopkins/medorg"
@@ -26,8 +29,22 @@ const (
 	ExitIncompleteBackup
 	ExitSuppliedDirNotFound
 	ExitBadVc
+	ExitBadPriorityWeights
+	ExitBadCopier
+	ExitVerifyFailed
+	ExitBadSymlinkPolicy
+	ExitBadMetadataFile
+	ExitBadRate
+	ExitBadSchedule
+	ExitBadDirectories
 )
 
+// ExitVerifyMismatch is what -verify-sample and -verify-all exit with when
+// a copy does not match its checksum. Scripts check for 2, so it shares its
+// value with ExitOneDirectoryOnly, which a verify run never returns.
+// ExitVerifyFailed is used when verification could not run at all.
+const ExitVerifyMismatch = 2
+
 // FIXME
 var MaxBackups = 2
 var AF *medorg.AutoFix
@@ -45,47 +62,63 @@ func isDir(fn string) bool {
 	return false
 }
 
-func sizeOf(fn string) int {
-	fi, err := os.Stat(fn)
-	if err != nil {
-		return 0
+// progressCopier copies a file, telling progress how many bytes each chunk copied
+type progressCopier func(src, dst medorg.Fpath, progress func(n int64)) error
+
+// withoutProgress adapts a copier that can't tell us how it is getting on
+func withoutProgress(fc medorg.FileCopier) progressCopier {
+	return func(src, dst medorg.Fpath, progress func(n int64)) error {
+		return fc(src, dst)
 	}
-	fs := fi.Size() / 1024
-	if fs > (1 << 31) {
-		return (1 << 31) - 1
+}
+
+// withPermissions adapts a copier to also copy the permissions and owner
+func withPermissions(fc progressCopier) progressCopier {
+	return func(src, dst medorg.Fpath, progress func(n int64)) error {
+		if err := fc(src, dst, progress); err != nil {
+			return err
+		}
+		return medorg.CopyPermissions(src, dst)
 	}
-	return int(fs)
 }
-func poolCopier(src, dst medorg.Fpath, pool *pb.Pool, wg *sync.WaitGroup) error {
+
+// withThrottle adapts a copier to be paced by th
+// Only copiers that report their progress can be throttled
+func withThrottle(fc progressCopier, th *medorg.Throttle) progressCopier {
+	return func(src, dst medorg.Fpath, progress func(n int64)) error {
+		return fc(src, dst, func(n int64) {
+			progress(n)
+			th.Wait(int(n))
+		})
+	}
+}
+
+func poolCopier(src, dst medorg.Fpath, pool *pb.Pool, fc progressCopier) error {
 	myBar := new(pb.ProgressBar)
 	myBar.Set("prefix", fmt.Sprint(string(src), ":"))
 	myBar.Set(pb.Bytes, true)
-	srcSize := sizeOf(string(src))
-	myBar.SetTotal(int64(srcSize))
+	if fi, err := os.Stat(string(src)); err == nil {
+		myBar.SetTotal(fi.Size())
+	}
 
 	pool.Add(myBar)
 	myBar.Start()
 	defer pool.Remove(myBar)
-	closeChan := make(chan struct{})
-	defer func() { close(closeChan) }()
-	wg.Add(1)
-	go func() {
-		for {
-			select {
-			case <-time.After(2 * time.Second):
-				dstSize := sizeOf(string(dst))
-				myBar.SetCurrent(int64(dstSize))
-			case <-closeChan:
-				myBar.Finish()
-				wg.Done()
-				return
-			}
-		}
-	}()
+	defer myBar.Finish()
 
-	return medorg.CopyFile(src, dst)
+	var count int64
+	return fc(src, dst, func(n int64) {
+		myBar.SetCurrent(atomic.AddInt64(&count, n))
+	})
 }
+
+// registerLock serialises topRegisterFunc, as the trees are walked,
+// and so registered, from several goroutines at once
+var registerLock sync.Mutex
+
 func topRegisterFunc(dt *medorg.DirTracker, pool *pb.Pool, wg *sync.WaitGroup) {
+	registerLock.Lock()
+	defer registerLock.Unlock()
 	removeFunc := func(pb *pb.ProgressBar) {
 		err := pool.Remove(pb)
 		if err != nil {
@@ -99,21 +132,21 @@ func topRegisterFunc(dt *medorg.DirTracker, pool *pb.Pool, wg *sync.WaitGroup) {
 	wg.Add(1)
 }
 
-func visitFilesUpdatingProgressBar(pool *pb.Pool, directories []string,
+func visitFilesUpdatingProgressBar(pool *pb.Pool, directories []string, opts medorg.DirTrackerOptions,
 	someVisitFunc func(dm medorg.DirectoryMap, dir, fn string, d fs.DirEntry, fileStruct medorg.FileStruct, fileInfo fs.FileInfo) error,
 ) {
 	var wg sync.WaitGroup
 	registerFunc := func(dt *medorg.DirTracker) {
 		topRegisterFunc(dt, pool, &wg)
 	}
-	errChan := medorg.VisitFilesInDirectories(directories, registerFunc, someVisitFunc)
+	errChan := medorg.VisitFilesInDirectoriesWithOptions(directories, opts, registerFunc, someVisitFunc)
 	for err := range errChan {
 		log.Println("Error Got...", err)
 	}
 	wg.Wait()
 }
 
-func runStats(pool *pb.Pool, messageBar *pb.ProgressBar, directories []string) {
+func runStats(pool *pb.Pool, messageBar *pb.ProgressBar, directories []string, opts medorg.DirTrackerOptions) {
 	messageBar.Set("msg", "Start Scanning")
 	var lk sync.Mutex
 	// I want to know the size of storage I need to buy to get the files backed
@@ -142,7 +175,7 @@ func runStats(pool *pb.Pool, messageBar *pb.ProgressBar, directories []string) {
 		lk.Unlock()
 		return nil
 	}
-	visitFilesUpdatingProgressBar(pool, directories, visitFunc)
+	visitFilesUpdatingProgressBar(pool, directories, opts, visitFunc)
 
 	for i, val := range totalArray {
 		// WTF why would you have a fraction number of bytes????
@@ -151,12 +184,108 @@ func runStats(pool *pb.Pool, messageBar *pb.ProgressBar, directories []string) {
 	}
 }
 
+func runAgeStats(directories []string, mdOpts medorg.MetadataOptions) {
+	buckets, err := medorg.CollectAgeStats(directories, mdOpts)
+	if err != nil {
+		fmt.Println("Error collecting age stats", err)
+	}
+	for _, bucket := range buckets {
+		fmt.Printf("%-12s %10s %5.1f%% backed up\n", bucket.Name, bytesize.New(float64(bucket.Bytes)), bucket.Coverage())
+	}
+}
+
+// sendWebhook reports the outcome of the backup, if the config asks for it
+func sendWebhook(xc *medorg.XMLCfg, opts medorg.BackupOptions, destDirs []string, filesCopied, bytesCopied int64, duration time.Duration, backupErr error) {
+	labels := make([]string, 0, len(destDirs))
+	if errors.Is(backupErr, medorg.ErrNoVolumeLabel) {
+		// Don't create a label just to report we didn't have one
+		destDirs = nil
+	}
+	for _, destDir := range destDirs {
+		if vc, err := xc.VolumeCfgFromDir(destDir); err == nil {
+			labels = append(labels, vc.Label)
+		}
+	}
+	br := medorg.NewBackupReport(strings.Join(labels, ","), filesCopied, bytesCopied, duration, backupErr)
+	if err := xc.SendWebhookWithOptions(br, opts); err != nil {
+		fmt.Println("Unable to send webhook:", err)
+		log.Println("Unable to send webhook:", err)
+	}
+}
+
+// logReports logs a table of what the backup did to each destination
+func logReports(reports []medorg.BackupReport) {
+	var sb strings.Builder
+	if err := medorg.WriteBackupReports(&sb, reports); err != nil {
+		log.Println("Unable to write report:", err)
+		return
+	}
+	log.Print("Backup report:\n", sb.String())
+}
+
+// summariseReports gives a one line summary of the backup
+func summariseReports(reports []medorg.BackupReport) string {
+	var copied, skipped, noSpace, errs int64
+	for _, report := range reports {
+		copied += report.FilesCopied
+		skipped += report.FilesSkipped
+		noSpace += report.SpaceSkipped
+		errs += report.Errors
+	}
+	return fmt.Sprint("Copied ", copied, " files, skipped ", skipped, ", ", noSpace, " did not fit, ", errs, " errors.")
+}
+
 var LOGFILENAME = "mdbackup.log"
 
 func main() {
 	retcode := 0
 	defer func() { os.Exit(retcode) }()
 
+	///////////////////////////////////
+	// Command line argument processing
+	var tagflg = flag.Bool("tag", false, "Locate and print the directory tag, create if needed")
+	var scanflg = flag.Bool("scan", false, "Only scan files in src & dst updating labels, don't run the backup")
+	var dummyflg = flag.Bool("dummy", false, "Don't copy, just tell me what you'd do")
+	var delflg = flag.Bool("delete", false, "Delete files on the destination that are not in the source")
+	var statsflg = flag.Bool("stats", false, "Generate backup statistics")
+	var agestatsflg = flag.Bool("age-stats", false, "Show how much data there is, and how much is backed up, by age")
+	var staleflg = flag.Int("stale-days", 60, "Warn if a source has had no changes in this many days")
+	var skipstaleflg = flag.Bool("skip-stale-sources", false, "Do not backup sources that are stale")
+	var maxrateflg = flag.String("max-rate", "", "Limit copying to this many bytes a second, e.g. 10MB (not with -copier or -preserve-xattr)")
+	var xattrflg = flag.Bool("preserve-xattr", false, "Copy extended attributes along with the file (macOS only)")
+	var permsflg = flag.Bool("preserve-perms", false, "Copy permissions, and if run as root the owner, along with the file")
+	plugins, err := listCopierPlugins()
+	if err != nil {
+		log.Println("Unable to list copier plugins:", err)
+	}
+	var copierflg = flag.String("copier", "", fmt.Sprint("Use a copier plugin from ", pluginDir(), " available:", plugins))
+	var verifyflg = flag.Bool("verify-sample", false, "Check a random sample of the files on the backup directories still match their checksums")
+	var verifyallflg = flag.Bool("verify-all", false, "Check every file on the backup directories still matches its checksum")
+	var symlinkflg = flag.String("symlinks", medorg.DefaultDirTrackerOptions().SymlinkPolicy.String(), "What to do with symlinks to directories: follow, skip or report")
+	var createlabelflg = flag.Bool("create-label-if-missing", false, "Give a destination without a volume label a new one, rather than stopping")
+	var ignoresizeflg = flag.Bool("ignore-size-limit", false, "Allow directories with more than "+fmt.Sprint(medorg.DefaultMaxEntries)+" files to be updated")
+	var versionflg = flag.Bool("version", false, "Print version")
+	var metadataflg = flag.String("metadata-file", medorg.GetMetadataFilename(), "Name of the file in each directory the metadata is kept in")
+	var webhookflg = flag.String("webhook", "", "Post a JSON report to this URL when the backup finishes")
+	var webhooksuccessflg = flag.Bool("webhook-on-success", false, "Post to the webhook when the backup succeeds")
+	var webhookfailureflg = flag.Bool("webhook-on-failure", false, "Post to the webhook when the backup fails")
+	var lowspaceflg = flag.Float64("low-space-threshold", 0, fmt.Sprint("Warn when a destination has less than this percentage free (default ", medorg.DefaultLowSpaceThresholdPct, ")"))
+	var headroomflg = flag.Float64("space-headroom", 0, fmt.Sprint("Percentage on top of the files to copy a destination needs free (default ", medorg.DefaultSpaceHeadroomPct, ")"))
+	var abortlowspaceflg = flag.Bool("abort-on-low-space", false, "Stop rather than warn when a destination is low on space, or the files to copy won't fit")
+	var verifycopyflg = flag.Bool("verify-copies", false, "Check the checksum of each copy before marking the source as backed up")
+	var detectmovesflg = flag.Bool("detect-moves", false, "Move files on the destination that have been moved in the source, rather than copying them again")
+	var reindexflg = flag.Bool("reindex", false, "Walk the destination rather than trusting its index, then rebuild the index")
+	var scheduleflg = flag.String("schedule", "", "With -tag, how often to back up to the volume: daily, weekly, monthly or a duration such as 36h; \"none\" to remove")
+	var ignorescheduleflg = flag.Bool("ignore-schedule", false, "Back up to every destination, even those not yet due")
+	var reportjsonflg = flag.Bool("report-json", false, "Write a report of the backup to "+medorg.BackupReportFileName+" on each destination")
+	var weightsflg = flag.String("priority-weights", "", "How to order the backup, e.g. \"dest=1.0,size=0.5,freq=2.0,tag=10000\"")
+
+	flag.Parse()
+	if *versionflg {
+		fmt.Println(medorg.VersionString("mdbackup"))
+		return
+	}
+
 	///////////////////////////////////
 	// Logging setup
 	os.Remove(LOGFILENAME)
@@ -194,15 +323,47 @@ func main() {
 		}
 	}()
 
-	///////////////////////////////////
-	// Command line argument processing
-	var tagflg = flag.Bool("tag", false, "Locate and print the directory tag, create if needed")
-	var scanflg = flag.Bool("scan", false, "Only scan files in src & dst updating labels, don't run the backup")
-	var dummyflg = flag.Bool("dummy", false, "Don't copy, just tell me what you'd do")
-	var delflg = flag.Bool("delete", false, "Delete duplicated Files")
-	var statsflg = flag.Bool("stats", false, "Generate backup statistics")
-
-	flag.Parse()
+	if err := medorg.SetMetadataFilename(*metadataflg); err != nil {
+		fmt.Println(err)
+		retcode = ExitBadMetadataFile
+		return
+	}
+	opts := medorg.BackupOptions{
+		CreateLabelIfMissing: *createlabelflg,
+		AbortOnLowSpace:      *abortlowspaceflg,
+		Reindex:              *reindexflg,
+		DetectMoves:          *detectmovesflg,
+		VerifyAfterCopy:      *verifycopyflg,
+		IgnoreSchedules:      *ignorescheduleflg,
+		IgnoreSizeLimit:      *ignoresizeflg,
+	}
+	if *maxrateflg != "" {
+		if *copierflg != "" || *xattrflg {
+			fmt.Println("-max-rate cannot be used with -copier or -preserve-xattr")
+			retcode = ExitBadRate
+			return
+		}
+		rate, err := bytesize.Parse(*maxrateflg)
+		if err != nil {
+			fmt.Println("Bad -max-rate:", err)
+			retcode = ExitBadRate
+			return
+		}
+		opts.MaxBytesPerSecond = int64(rate)
+	}
+	if *lowspaceflg > 0 {
+		opts.LowSpaceThresholdPct = *lowspaceflg
+	}
+	if *headroomflg > 0 {
+		opts.SpaceHeadroomPct = *headroomflg
+	}
+	if sp, err := medorg.ParseSymlinkPolicy(*symlinkflg); err == nil {
+		opts.SymlinkPolicy = sp
+	} else {
+		fmt.Println(err)
+		retcode = ExitBadSymlinkPolicy
+		return
+	}
 	if flag.NArg() > 0 {
 		for _, fl := range flag.Args() {
 			_, err := os.Stat(fl)
@@ -218,6 +379,22 @@ func main() {
 	} else {
 		directories = []string{"."}
 	}
+	if *weightsflg != "" {
+		_, err := medorg.ParsePriorityWeights(*weightsflg)
+		if err != nil {
+			fmt.Println("Invalid priority weights:", err)
+			retcode = ExitBadPriorityWeights
+			return
+		}
+		opts.PriorityWeights = *weightsflg
+	}
+	if *webhookflg != "" {
+		opts.WebhookURL = *webhookflg
+		// Without saying which, we want to hear about both
+		bothOff := !*webhooksuccessflg && !*webhookfailureflg
+		opts.WebhookOnSuccess = *webhooksuccessflg || bothOff
+		opts.WebhookOnFailure = *webhookfailureflg || bothOff
+	}
 
 	///////////////////////////////////
 	// Progress Bar init
@@ -239,14 +416,15 @@ func main() {
 	// Catch Ctrl-C sensibly!
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, os.Interrupt)
-	shutdownChan := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	go func() {
 		ccCnt := 0
 		for range signalChan {
 			ccCnt++
 			if ccCnt == 1 {
 				messageBar.Set("msg", "Ctrl-C Detected")
-				close(shutdownChan)
+				cancel()
 			} else {
 				os.Exit(1)
 			}
@@ -273,23 +451,102 @@ func main() {
 			return
 		}
 		fmt.Println("Config name is", vc.Label)
+		if err := vc.UpdateUsage(directories[0]); err == nil {
+			fmt.Println("Capacity", bytesize.New(float64(vc.TotalCapacityBytes)), "of which", bytesize.New(float64(vc.UsedBytes)), "used")
+		}
+		if *scheduleflg != "" {
+			every := *scheduleflg
+			if every == "none" {
+				every = ""
+			}
+			if err := xc.SetSchedule(vc.Label, every); err != nil {
+				fmt.Println("Bad -schedule:", err)
+				retcode = ExitBadSchedule
+				return
+			}
+		}
+		if every, ok := xc.Schedule(vc.Label); ok {
+			fmt.Println("Backed up to", every)
+		}
 		return
 	}
 
 	if *statsflg {
-		runStats(pool, messageBar, directories)
+		runStats(pool, messageBar, directories, opts.DirTrackerOptions(xc))
+		return
+	}
+	if *agestatsflg {
+		runAgeStats(directories, opts.DirTrackerOptions(xc).Metadata)
+		return
+	}
+
+	if *verifyflg || *verifyallflg {
+		vs := xc.VerifySchedule()
+		if *verifyallflg {
+			vs.SampleFraction = 1.0
+			vs.MaxSampleSize = 0
+		}
+		for _, dir := range directories {
+			messageBar.Set("msg", fmt.Sprint("Verifying ", dir))
+			mismatches, checked, err := medorg.VerifyBackup(dir, vs, opts.DirTrackerOptions(xc).Metadata)
+			if err != nil {
+				fmt.Println("Error verifying", dir, err)
+				retcode = ExitVerifyFailed
+				return
+			}
+			fmt.Println("Checked", checked, "files in", dir)
+			for _, mm := range mismatches {
+				fmt.Println("Mismatch:", mm)
+				log.Println("Mismatch:", mm)
+			}
+			if len(mismatches) > 0 {
+				retcode = ExitVerifyMismatch
+			}
+		}
+		schedule := xc.VerifySchedule()
+		schedule.LastVerifiedAt = time.Now().Unix()
+		xc.Verification = &schedule
 		return
 	}
 
 	///////////////////////////////////
 	// Main backup code starts
 	///////////////////////////////////
-	if len(directories) != 2 {
-		fmt.Println("Error, expected 2 directories!", directories)
+	if len(directories) < 2 {
+		fmt.Println("Error, expected at least 2 directories!", directories)
 		retcode = ExitTwoDirectoriesOnly
 		return
 	}
 
+	// Scanning, or a dummy run, copies nothing, so does not need to write
+	readOnly := *scanflg || *dummyflg
+	if problems := medorg.ValidateBackupDirs(directories[:1], directories[1:], readOnly); len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Println("Error:", problem)
+			log.Println("Error:", problem)
+		}
+		retcode = ExitBadDirectories
+		return
+	}
+
+	// Warn about sources that look like they are no longer in use
+	// before we spend a long time scanning them
+	stale := false
+	for _, hw := range medorg.SourceHealthCheck(directories[:1], *staleflg, opts.DirTrackerOptions(xc).Metadata) {
+		fmt.Println("Warning:", hw)
+		log.Println("Warning:", hw)
+		stale = stale || hw.Kind == medorg.HealthStale
+	}
+	if *skipstaleflg && stale {
+		fmt.Println("Skipping stale source", directories[0])
+		return
+	}
+	// Backing up a backup is allowed, but likely a mistake
+	for _, hw := range medorg.SourceLabelCheck(directories[:1]) {
+		fmt.Println("Warning:", hw)
+		log.Println("Warning:", hw)
+	}
+
 	// Setup the function that copies files
 	var wg sync.WaitGroup
 	var copyer func(src, dst medorg.Fpath) error
@@ -299,14 +556,37 @@ func main() {
 			return medorg.ErrDummyCopy
 		}
 	} else {
+		var fc progressCopier = medorg.CopyFileWithProgress
+		if *xattrflg {
+			fc = withoutProgress(medorg.CopyFileWithXattr)
+		}
+		if *copierflg != "" {
+			plugin, err := loadCopierPlugin(*copierflg)
+			if err != nil {
+				fmt.Println("Unable to load copier plugin:", err)
+				retcode = ExitBadCopier
+				return
+			}
+			fc = withoutProgress(plugin)
+		}
+		if *permsflg {
+			fc = withPermissions(fc)
+		}
+		if th := medorg.NewThrottle(opts.MaxRate(xc)); th != nil {
+			if *copierflg != "" || *xattrflg {
+				// Only the saved rate can get here, the flag was rejected above
+				fmt.Println("Warning: the configured max rate is ignored with -copier or -preserve-xattr")
+			} else {
+				fc = withThrottle(fc, th)
+			}
+		}
 		copyer = func(src, dst medorg.Fpath) error {
-			return poolCopier(src, dst, pool, &wg)
+			return poolCopier(src, dst, pool, fc)
 		}
 	}
 	if *scanflg {
 		copyer = nil
 	}
-
 	// Setup the function that deals with orphaned files
 	// i.e. files that are on the backup, but not the source
 	var orphanedFunc func(string) error
@@ -317,9 +597,9 @@ func main() {
 		}
 	} else if *delflg {
 		orphanedFunc = func(path string) error {
-			log.Println(path, "orphaned")
-			if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
-				_ = os.Remove(path)
+			log.Println(path, "orphaned, deleting")
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
 			}
 			return nil
 		}
@@ -334,13 +614,43 @@ func main() {
 	// the backup runner scanning through directory trees
 	// This can take quite some time as it has to load and possibly generate
 	// the xml descriptions for the files (md5 hash calculation)
+	// Trackers are registered again each time they are revisited
+	// so keep a set of them for the final statistics
+	var trackerLock sync.Mutex
+	trackers := make(map[*medorg.DirTracker]struct{})
 	registerFunc := func(dt *medorg.DirTracker) {
+		trackerLock.Lock()
+		trackers[dt] = struct{}{}
+		trackerLock.Unlock()
 		topRegisterFunc(dt, pool, &wg)
 	}
 
 	messageBar.Set("msg", "Starting Backup Run")
-	err = medorg.BackupRunner(xc, 2, copyer, directories[0], directories[1], orphanedFunc, logFunc, registerFunc, shutdownChan)
+	startTime := time.Now()
+	var reports []medorg.BackupReport
+	if len(directories) > 2 {
+		// More than one destination, so scan the source once and copy to each in turn
+		reports, err = medorg.BackupRunnerFanOutWithReport(ctx, xc, opts, 2, copyer, directories[0], directories[1:], orphanedFunc, logFunc, registerFunc)
+	} else {
+		var report medorg.BackupReport
+		report, err = medorg.BackupRunnerWithReport(ctx, xc, opts, 2, copyer, directories[0], directories[1], orphanedFunc, logFunc, registerFunc)
+		reports = []medorg.BackupReport{report}
+	}
 	messageBar.Set("msg", "Completed Backup Run")
+	logReports(reports)
+	if *reportjsonflg && copyer != nil {
+		for i, report := range reports {
+			if err := report.WriteJSON(directories[i+1]); err != nil {
+				log.Println("Unable to write report:", err)
+			}
+		}
+	}
+	var filesCopied, bytesCopied int64
+	for _, report := range reports {
+		filesCopied += report.FilesCopied
+		bytesCopied += report.BytesCopied
+	}
+	sendWebhook(xc, opts, directories[1:], filesCopied, bytesCopied, time.Since(startTime), err)
 
 	if err != nil {
 		messageBar.Set("msg", fmt.Sprint("Unable to complete backup:", err))
@@ -349,4 +659,10 @@ func main() {
 	}
 	messageBar.Set("msg", "Waiting for complete")
 	wg.Wait()
+	var stats medorg.WalkStats
+	for dt := range trackers {
+		stats = stats.Add(dt.Stats())
+	}
+	messageBar.Set("msg", fmt.Sprint(summariseReports(reports), " ", stats))
+	log.Println(stats)
 }
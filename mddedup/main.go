@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+
+	"github.com/cbehopkins/medorg"
+	bytesize "github.com/inhies/go-bytesize"
+)
+
+const (
+	ExitOk = iota
+	ExitBadArgs
+	ExitFindFailed
+	ExitDeleteFailed
+	ExitCalcFailed
+)
+
+func main() {
+	var reportflg = flag.Bool("report", false, "Report the groups of duplicated files")
+	var deleteflg = flag.Bool("delete", false, "Delete all but the first (by path) of each group of duplicated files")
+	var dryflg = flag.Bool("dry-run", false, "With -delete, say what would be deleted without deleting anything")
+	var calcflg = flag.Bool("calc", true, "Bring the checksums up to date first; turn off if check_calc has just been run")
+	var versionflg = flag.Bool("version", false, "Print version")
+	var metadataflg = flag.String("metadata-file", medorg.GetMetadataFilename(), "Name of the file in each directory the metadata is kept in")
+	flag.Parse()
+	if *versionflg {
+		fmt.Println(medorg.VersionString("mddedup"))
+		return
+	}
+	if err := medorg.SetMetadataFilename(*metadataflg); err != nil {
+		fmt.Println(err)
+		os.Exit(ExitBadArgs)
+	}
+	mdOpts := medorg.DefaultMetadataOptions()
+	if xmcf := medorg.XmConfig(); xmcf != "" {
+		mdOpts = medorg.NewXMLCfg(string(xmcf)).MetadataOptions()
+	}
+	if !*reportflg && !*deleteflg {
+		fmt.Println("Usage: mddedup [-report] [-delete [-dry-run]] [directories...]")
+		fmt.Println("Duplicates are found across all the directories given")
+		os.Exit(ExitBadArgs)
+	}
+	directories := flag.Args()
+	if len(directories) == 0 {
+		directories = []string{"."}
+	}
+
+	if *calcflg {
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+		err := medorg.UpdateChecksums(ctx, directories, mdOpts)
+		cancel()
+		if err != nil {
+			fmt.Println("Unable to update checksums", err)
+			os.Exit(ExitCalcFailed)
+		}
+	}
+	duplicates, err := medorg.FindDuplicates(mdOpts, directories...)
+	if err != nil {
+		fmt.Println("Unable to find duplicates", err)
+		os.Exit(ExitFindFailed)
+	}
+	if *reportflg {
+		report(duplicates)
+	}
+	if *deleteflg && *dryflg {
+		var paths []string
+		for _, fss := range duplicates {
+			for _, fs := range fss[1:] {
+				paths = append(paths, string(fs.Path()))
+			}
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			fmt.Println("Would remove", path)
+		}
+	} else if *deleteflg {
+		removed, err := medorg.DeleteDuplicates(duplicates, mdOpts)
+		for _, fp := range removed {
+			fmt.Println("Removed", fp)
+		}
+		if err != nil {
+			fmt.Println("Unable to delete duplicates", err)
+			os.Exit(ExitDeleteFailed)
+		}
+	}
+}
+
+func report(duplicates map[string][]medorg.FileStruct) {
+	checksums := make([]string, 0, len(duplicates))
+	for cks := range duplicates {
+		checksums = append(checksums, cks)
+	}
+	// Print the groups in the same order each time
+	sort.Slice(checksums, func(i, j int) bool {
+		return duplicates[checksums[i]][0].Path() < duplicates[checksums[j]][0].Path()
+	})
+	var wasted int64
+	for _, cks := range checksums {
+		fss := duplicates[cks]
+		fmt.Println(len(fss), "copies of", bytesize.New(float64(fss[0].Size)), cks)
+		for _, fs := range fss {
+			fmt.Println("  ", fs.Path())
+		}
+		wasted += int64(len(fss)-1) * fss[0].Size
+	}
+	fmt.Println(len(duplicates), "groups of duplicates,", bytesize.New(float64(wasted)), "could be reclaimed")
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// rsyncPrefix matches the date, time and pid that rsync puts
+// at the start of every line in its --log-file
+var rsyncPrefix = regexp.MustCompile(`^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2} \[\d+\] `)
+
+// parseRsyncLog finds the files rsync transferred
+// We understand the default itemized format ("<f+++++++++ path")
+// as well as "src -> dest" transfer lines.
+// Paths are returned relative to the source directory.
+func parseRsyncLog(rd io.Reader) ([]string, error) {
+	var paths []string
+	scanner := bufio.NewScanner(rd)
+	for scanner.Scan() {
+		line := rsyncPrefix.ReplaceAllString(scanner.Text(), "")
+		if src, _, ok := strings.Cut(line, " -> "); ok {
+			paths = append(paths, src)
+			continue
+		}
+		itemized, path, ok := strings.Cut(line, " ")
+		if !ok || len(itemized) != 11 {
+			continue
+		}
+		// We only care about regular files that were sent or received
+		if (itemized[0] != '<' && itemized[0] != '>') || itemized[1] != 'f' {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths, scanner.Err()
+}
+
+// rcloneRecord is the part of rclone's --use-json-log output we need
+type rcloneRecord struct {
+	Level  string `json:"level"`
+	Msg    string `json:"msg"`
+	Object string `json:"object"`
+}
+
+// parseRcloneLog finds the files rclone copied from its JSON log
+func parseRcloneLog(rd io.Reader) ([]string, error) {
+	var paths []string
+	scanner := bufio.NewScanner(rd)
+	for scanner.Scan() {
+		var rec rcloneRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// rclone mixes in non-json lines, e.g. the transfer summary
+			continue
+		}
+		if rec.Object == "" || !strings.HasPrefix(rec.Msg, "Copied") {
+			continue
+		}
+		paths = append(paths, rec.Object)
+	}
+	return paths, scanner.Err()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/cbehopkins/medorg"
+)
+
+const (
+	ExitOk = iota
+	ExitNoConfig
+	ExitTwoDirectoriesOnly
+	ExitBadLog
+	ExitImportFailed
+	ExitBadMetadataFile
+)
+
+func isDir(fn string) bool {
+	stat, err := os.Stat(fn)
+	if err != nil {
+		return false
+	}
+	return stat.IsDir()
+}
+
+func parseLog(fn string, parser func(io.Reader) ([]string, error)) ([]string, error) {
+	fh, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	return parser(fh)
+}
+
+func main() {
+	retcode := 0
+	defer func() { os.Exit(retcode) }()
+
+	var rsyncflg = flag.String("from-rsync-log", "", "rsync --log-file output to import")
+	var rcloneflg = flag.String("from-rclone-log", "", "rclone --use-json-log output to import")
+	var versionflg = flag.Bool("version", false, "Print version")
+	var metadataflg = flag.String("metadata-file", medorg.GetMetadataFilename(), "Name of the file in each directory the metadata is kept in")
+	flag.Parse()
+	if *versionflg {
+		fmt.Println(medorg.VersionString("mdimport"))
+		return
+	}
+	if err := medorg.SetMetadataFilename(*metadataflg); err != nil {
+		fmt.Println(err)
+		retcode = ExitBadMetadataFile
+		return
+	}
+
+	var directories []string
+	for _, fl := range flag.Args() {
+		if isDir(fl) {
+			directories = append(directories, fl)
+		}
+	}
+	if len(directories) != 2 {
+		fmt.Println("Please supply the source and destination directories of the transfer")
+		retcode = ExitTwoDirectoriesOnly
+		return
+	}
+
+	var xc *medorg.XMLCfg
+	if xmcf := medorg.XmConfig(); xmcf != "" {
+		xc = medorg.NewXMLCfg(string(xmcf))
+	} else {
+		fn := filepath.Join(string(medorg.HomeDir()), "/.medorg.xml")
+		xc = medorg.NewXMLCfg(fn)
+	}
+	if xc == nil {
+		fmt.Println("Unable to get config")
+		retcode = ExitNoConfig
+		return
+	}
+	defer func() {
+		err := xc.WriteXmlCfg()
+		if err != nil {
+			fmt.Println("Error while saving config file", err)
+		}
+	}()
+
+	var paths []string
+	var err error
+	switch {
+	case *rsyncflg != "":
+		paths, err = parseLog(*rsyncflg, parseRsyncLog)
+	case *rcloneflg != "":
+		paths, err = parseLog(*rcloneflg, parseRcloneLog)
+	default:
+		fmt.Println("Please specify a log to import from")
+		retcode = ExitBadLog
+		return
+	}
+	if err != nil {
+		fmt.Println("Unable to read log:", err)
+		retcode = ExitBadLog
+		return
+	}
+	fmt.Println("Found", len(paths), "transferred files, verifying")
+
+	logFunc := func(msg string) {
+		fmt.Println(msg)
+	}
+	imported, err := medorg.ImportBackupRecords(xc, directories[0], directories[1], paths, logFunc)
+	fmt.Println("Imported", imported, "of", len(paths), "files")
+	if err != nil {
+		fmt.Println("Import failed:", err)
+		retcode = ExitImportFailed
+	}
+}
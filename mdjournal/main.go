@@ -4,8 +4,11 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/cbehopkins/medorg"
 )
@@ -13,6 +16,8 @@ import (
 const (
 	ExitOk = iota
 	ExitSuppliedDirNotFound
+	ExitBadMetadataFile
+	ExitBadSince
 )
 
 func isDir(fn string) bool {
@@ -27,11 +32,82 @@ func isDir(fn string) bool {
 	}
 	return false
 }
+
+// stampName is the file whose mtime records when the journal was last compacted
+func stampName(fn string) string {
+	return fn + ".compacted"
+}
+
+func touchStamp(fn string) {
+	fh, err := os.Create(stampName(fn))
+	if err != nil {
+		fmt.Println("Unable to record compaction:", err)
+		os.Exit(3)
+	}
+	fh.Close()
+}
+
+// rewriteJournal replaces the journal at fn with what writeFunc writes
+// Written to a temporary file, then renamed into place,
+// so a failure part way through leaves the old journal intact
+func rewriteJournal(fn string, writeFunc func(w io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(fn), filepath.Base(fn)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpFn := f.Name()
+	err = writeFunc(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmpFn, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmpFn, fn)
+	}
+	if err != nil {
+		_ = os.Remove(tmpFn)
+	}
+	return err
+}
+
 func main() {
 	var directories []string
 	var scanflg = flag.Bool("scan", false, "Only scan files in src & dst updating labels, don't run the backup")
+	var compactflg = flag.Bool("compact", false, "Compact the journal, rather than walking directories")
+	var keepflg = flag.Int("keep", medorg.DefaultJournalKeep, "Number of recent entries per directory to keep when compacting")
+	var dryflg = flag.Bool("dry-run", false, "Report what compaction would remove without writing")
+	var compactafterflg = flag.Duration("compact-after", 7*24*time.Hour, "Append to the journal, unless it was last compacted longer ago than this")
+	var incrementalflg = flag.Bool("incremental", true, "Append the directories that have changed to the journal; a directory with any changed file is journaled again in full")
+	var fullflg = flag.Bool("full", false, "Rewrite the whole journal, rather than appending the directories that have changed; the same as -incremental=false")
+	var sinceflg = flag.String("since", "", "List the directories journaled as changed since this RFC3339 time, or within this long (e.g. 24h, 7d, 2w), rather than walking directories")
+	var churnflg = flag.Bool("churn", false, "Record how often files change, from the journal history, for the backup to use")
+	var versionflg = flag.Bool("version", false, "Print version")
+	var metadataflg = flag.String("metadata-file", medorg.GetMetadataFilename(), "Name of the file in each directory the metadata is kept in")
 
 	flag.Parse()
+	if *versionflg {
+		fmt.Println(medorg.VersionString("mdjournal"))
+		return
+	}
+	if err := medorg.SetMetadataFilename(*metadataflg); err != nil {
+		fmt.Println(err)
+		os.Exit(ExitBadMetadataFile)
+	}
+	mdOpts := medorg.DefaultMetadataOptions()
+	if xmcf := medorg.XmConfig(); xmcf != "" {
+		mdOpts = medorg.NewXMLCfg(string(xmcf)).MetadataOptions()
+	}
+	var since time.Time
+	if *sinceflg != "" {
+		var err error
+		since, err = medorg.ParseSince(*sinceflg, time.Now())
+		if err != nil {
+			fmt.Println("Bad -since:", err)
+			os.Exit(ExitBadSince)
+		}
+	}
 	if flag.NArg() > 0 {
 		for _, fl := range flag.Args() {
 			_, err := os.Stat(fl)
@@ -59,13 +135,17 @@ func main() {
 
 	makerFunc := func(dir string) (medorg.DirectoryTrackerInterface, error) {
 		mkFk := func(dir string) (medorg.DirectoryEntryInterface, error) {
-			dm, err := medorg.DirectoryMapFromDir(dir)
+			dm, err := medorg.DirectoryMapFromDirWithOptions(dir, "", mdOpts)
 			if err != nil {
 				return dm, err
 			}
 			dm.VisitFunc = visitor
 
-			return dm, journal.AppendJournalFromDm(&dm, dir)
+			err = journal.AppendJournalFromDm(&dm, dir)
+			if errors.Is(err, medorg.ErrFileExistsInJournal) {
+				return dm, nil
+			}
+			return dm, err
 		}
 		de, err := medorg.NewDirectoryEntry(dir, mkFk)
 		return de, err
@@ -81,12 +161,43 @@ func main() {
 		}
 	}
 
-	fh, err = os.Create(fn)
-	if err != nil {
-		fmt.Println("Unable to open journal for writing:", err, "::", fn)
-		os.Exit(3)
+	if *churnflg {
+		frequencies := journal.HighChurnFiles()
+		fmt.Println("Found", len(frequencies), "files that have changed")
+		err = medorg.SetChangeFrequencies(frequencies, mdOpts)
+		if err != nil {
+			fmt.Println("Error recording change frequencies:", err)
+			os.Exit(3)
+		}
+		return
 	}
-	defer fh.Close()
+
+	if *sinceflg != "" {
+		for _, je := range journal.Since(since) {
+			fmt.Println(je.Journaled.Format(time.RFC3339), je.Dir)
+		}
+		return
+	}
+
+	if *compactflg {
+		compacted := journal.Compact(*keepflg)
+		fmt.Println("Compaction removes", journal.Len()-compacted.Len(), "of", journal.Len(), "entries")
+		if *dryflg {
+			return
+		}
+		err = rewriteJournal(fn, compacted.HistoryToWriter)
+		if err != nil {
+			fmt.Println("Error writing Journal:", err)
+			os.Exit(3)
+		}
+		touchStamp(fn)
+		return
+	}
+
+	// Appending is much quicker than rewriting the whole journal
+	// but every now and then we compact it back down
+	stamp, err := os.Stat(stampName(fn))
+	journal.AppendMode = *incrementalflg && !*fullflg && err == nil && time.Since(stamp.ModTime()) < *compactafterflg
 	for _, dir := range directories {
 		errChan := medorg.NewDirTracker(false, dir, makerFunc).ErrChan()
 		for err := range errChan {
@@ -95,9 +206,26 @@ func main() {
 		}
 	}
 
-	err = journal.ToWriter(fh)
+	if journal.AppendMode {
+		fh, err = os.OpenFile(fn, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+		if err != nil {
+			fmt.Println("Unable to open journal for writing:", err, "::", fn)
+			os.Exit(3)
+		}
+		defer fh.Close()
+		err = journal.AppendToWriter(fh)
+		if err != nil {
+			fmt.Println("Error writing Journal:", err)
+			os.Exit(3)
+		}
+		return
+	}
+
+	fmt.Println("Compacting journal")
+	err = rewriteJournal(fn, journal.Compact(*keepflg).HistoryToWriter)
 	if err != nil {
 		fmt.Println("Error writing Journal:", err)
 		os.Exit(3)
 	}
+	touchStamp(fn)
 }
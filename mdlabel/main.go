@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cbehopkins/medorg"
+	bytesize "github.com/inhies/go-bytesize"
+)
+
+const (
+	ExitOk = iota
+	ExitUnknownLabels
+	ExitBadArgs
+	ExitNoConfig
+	ExitAuditFailed
+	ExitListFailed
+	ExitNoLabel
+)
+
+func usage() {
+	fmt.Println("Usage:")
+	fmt.Println("  mdlabel verify-all [-mounted dir,dir] <source directories...>")
+	fmt.Println("  mdlabel list")
+	fmt.Println("  mdlabel show <backup directory>")
+}
+
+// formatAge gives how long ago t was, to the nearest minute, or day if over a day
+func formatAge(t time.Time) string {
+	age := time.Since(t)
+	if age < 24*time.Hour {
+		return age.Round(time.Minute).String()
+	}
+	return fmt.Sprint(int(age/(24*time.Hour)), "d")
+}
+
+// listVolumes prints the labelled backup volumes that are mounted
+func listVolumes() {
+	volumes, err := medorg.ListMountedVolumes()
+	if err != nil {
+		fmt.Println("Unable to list volumes:", err)
+		os.Exit(ExitListFailed)
+	}
+	fmt.Printf("%-10s %-30s %-8s %s\n", "LABEL", "MOUNT", "AGE", "SIZE")
+	for _, mv := range volumes {
+		size := "?"
+		if mv.TotalBytes > 0 {
+			size = bytesize.New(float64(mv.TotalBytes)).String()
+		}
+		fmt.Printf("%-10s %-30s %-8s %s\n", mv.Label, mv.Mount, formatAge(mv.Labelled), size)
+	}
+}
+
+// showVolume prints the label of the volume dir is on, and when it was last backed up to
+func showVolume(xc *medorg.XMLCfg, dir string) {
+	vc, err := medorg.ReadVolumeCfg(dir)
+	if err != nil {
+		fmt.Println("Unable to read label:", err)
+		os.Exit(ExitNoLabel)
+	}
+	fmt.Println("Label:      ", vc.Label)
+	if vc.LastBackup == 0 {
+		fmt.Println("Last backup: never")
+	} else {
+		last := time.Unix(vc.LastBackup, 0)
+		fmt.Println("Last backup:", last.Format(time.RFC3339), "("+formatAge(last), "ago)")
+	}
+	if every, ok := xc.Schedule(vc.Label); ok {
+		fmt.Println("Schedule:   ", every)
+	}
+	if vc.TotalCapacityBytes > 0 {
+		fmt.Println("Capacity:   ", bytesize.New(float64(vc.TotalCapacityBytes)), "of which", bytesize.New(float64(vc.UsedBytes)), "used")
+	}
+}
+
+func main() {
+	var mountedflg = flag.String("mounted", "", "Comma separated list of backup volumes that are currently mounted")
+	var versionflg = flag.Bool("version", false, "Print version")
+	var metadataflg = flag.String("metadata-file", medorg.GetMetadataFilename(), "Name of the file in each directory the metadata is kept in")
+	flag.Parse()
+	if *versionflg {
+		fmt.Println(medorg.VersionString("mdlabel"))
+		return
+	}
+	if err := medorg.SetMetadataFilename(*metadataflg); err != nil {
+		fmt.Println(err)
+		os.Exit(ExitBadArgs)
+	}
+	args := flag.Args()
+	if len(args) == 1 && args[0] == "list" {
+		listVolumes()
+		return
+	}
+	// Read only, so we never write the config back
+	var xc *medorg.XMLCfg
+	if xmcf := medorg.XmConfig(); xmcf != "" {
+		xc = medorg.NewXMLCfg(string(xmcf))
+	} else {
+		fn := filepath.Join(string(medorg.HomeDir()), "/.medorg.xml")
+		xc = medorg.NewXMLCfg(fn)
+	}
+	if xc == nil {
+		fmt.Println("Unable to get config")
+		os.Exit(ExitNoConfig)
+	}
+	if len(args) == 2 && args[0] == "show" {
+		showVolume(xc, args[1])
+		return
+	}
+	if len(args) < 2 || args[0] != "verify-all" {
+		usage()
+		os.Exit(ExitBadArgs)
+	}
+	var mounted []string
+	if *mountedflg != "" {
+		mounted = strings.Split(*mountedflg, ",")
+	}
+
+	audits, err := xc.AuditBackupLabels(args[1:], mounted)
+	if err != nil {
+		fmt.Println("Unable to check labels:", err)
+		os.Exit(ExitAuditFailed)
+	}
+	retcode := ExitOk
+	for _, la := range audits {
+		fmt.Printf("%-10s %-12s %d files\n", la.Label, la.Status, la.Files)
+		if la.Status == medorg.LabelUnknown {
+			retcode = ExitUnknownLabels
+		}
+	}
+	os.Exit(retcode)
+}
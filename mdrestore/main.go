@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cbehopkins/medorg"
+)
+
+const (
+	ExitOk = iota
+	ExitBadArgs
+	ExitRestoreFailed
+	ExitProblemsFound
+)
+
+func main() {
+	var patternflg = flag.String("pattern", "", "Restore the files whose name matches this glob, e.g. \"*.jpg\"")
+	var flattenflg = flag.Bool("flatten", false, "Put every restored file directly in the restore directory")
+	var verboseflg = flag.Bool("v", false, "List each file that could not be restored")
+	var versionflg = flag.Bool("version", false, "Print version")
+	var metadataflg = flag.String("metadata-file", medorg.GetMetadataFilename(), "Name of the file in each directory the metadata is kept in")
+	flag.Parse()
+	if *versionflg {
+		fmt.Println(medorg.VersionString("mdrestore"))
+		return
+	}
+	if err := medorg.SetMetadataFilename(*metadataflg); err != nil {
+		fmt.Println(err)
+		os.Exit(ExitBadArgs)
+	}
+	mdOpts := medorg.DefaultMetadataOptions()
+	if xmcf := medorg.XmConfig(); xmcf != "" {
+		mdOpts = medorg.NewXMLCfg(string(xmcf)).MetadataOptions()
+	}
+	if *patternflg == "" || flag.NArg() != 2 {
+		fmt.Println("Usage: mdrestore -pattern <glob> [-flatten] [-v] <backup directory> <restore directory>")
+		os.Exit(ExitBadArgs)
+	}
+	backupDir, restoreDir := flag.Arg(0), flag.Arg(1)
+	report, err := medorg.RestoreMatching(backupDir, restoreDir, *patternflg, *flattenflg, medorg.CopyFileBytes, mdOpts)
+	if err != nil {
+		fmt.Println("Unable to restore from", backupDir, err)
+		os.Exit(ExitRestoreFailed)
+	}
+	if *verboseflg {
+		for _, vm := range report.Failed {
+			fmt.Println("FAILED", vm)
+		}
+	}
+	fmt.Printf("%s: RESTORED %d, FAILED %d\n", backupDir, report.Restored, len(report.Failed))
+	if len(report.Failed) > 0 {
+		os.Exit(ExitProblemsFound)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cbehopkins/medorg"
+)
+
+const (
+	ExitOk = iota
+	ExitBadArgs
+	ExitSnapshotFailed
+)
+
+func usage() {
+	fmt.Println("Usage:")
+	fmt.Println("  mdsnap take <snapshot file> [directories...]")
+	fmt.Println("  mdsnap diff <snapshot file> <snapshot file>")
+}
+
+func main() {
+	var versionflg = flag.Bool("version", false, "Print version")
+	var metadataflg = flag.String("metadata-file", medorg.GetMetadataFilename(), "Name of the file in each directory the metadata is kept in")
+	flag.Parse()
+	if *versionflg {
+		fmt.Println(medorg.VersionString("mdsnap"))
+		return
+	}
+	if err := medorg.SetMetadataFilename(*metadataflg); err != nil {
+		fmt.Println(err)
+		os.Exit(ExitBadArgs)
+	}
+	mdOpts := medorg.DefaultMetadataOptions()
+	if xmcf := medorg.XmConfig(); xmcf != "" {
+		mdOpts = medorg.NewXMLCfg(string(xmcf)).MetadataOptions()
+	}
+	if flag.NArg() < 2 {
+		usage()
+		os.Exit(ExitBadArgs)
+	}
+	args := flag.Args()
+	switch args[0] {
+	case "take":
+		directories := args[2:]
+		if len(directories) == 0 {
+			directories = []string{"."}
+		}
+		err := medorg.TakeSnapshot(directories, args[1], mdOpts)
+		if err != nil {
+			fmt.Println("Unable to take snapshot:", err)
+			os.Exit(ExitSnapshotFailed)
+		}
+	case "diff":
+		if len(args) != 3 {
+			usage()
+			os.Exit(ExitBadArgs)
+		}
+		diff, err := medorg.CompareSnapshots(args[1], args[2])
+		if err != nil {
+			fmt.Println("Unable to compare snapshots:", err)
+			os.Exit(ExitSnapshotFailed)
+		}
+		for _, f := range diff.Added {
+			fmt.Println("Added:", f.Path)
+		}
+		for _, f := range diff.Removed {
+			fmt.Println("Removed:", f.Path)
+		}
+		for _, f := range diff.Modified {
+			fmt.Println("Modified:", f.Path)
+		}
+		fmt.Println(len(diff.Added), "added,", len(diff.Removed), "removed,", len(diff.Modified), "modified")
+	default:
+		usage()
+		os.Exit(ExitBadArgs)
+	}
+}
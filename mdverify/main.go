@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cbehopkins/medorg"
+)
+
+const (
+	ExitOk = iota
+	ExitBadArgs
+	ExitVerifyFailed
+	ExitProblemsFound
+)
+
+func main() {
+	var verboseflg = flag.Bool("v", false, "List each missing, corrupt and untagged file")
+	var versionflg = flag.Bool("version", false, "Print version")
+	var metadataflg = flag.String("metadata-file", medorg.GetMetadataFilename(), "Name of the file in each directory the metadata is kept in")
+	flag.Parse()
+	if *versionflg {
+		fmt.Println(medorg.VersionString("mdverify"))
+		return
+	}
+	if err := medorg.SetMetadataFilename(*metadataflg); err != nil {
+		fmt.Println(err)
+		os.Exit(ExitBadArgs)
+	}
+	mdOpts := medorg.DefaultMetadataOptions()
+	if xmcf := medorg.XmConfig(); xmcf != "" {
+		mdOpts = medorg.NewXMLCfg(string(xmcf)).MetadataOptions()
+	}
+	if flag.NArg() < 2 {
+		fmt.Println("Usage: mdverify [-v] <source directory> <destination directories...>")
+		fmt.Println("Run check_calc on the source first, so its checksums are up to date")
+		os.Exit(ExitBadArgs)
+	}
+	srcDir := flag.Arg(0)
+	retcode := ExitOk
+	for _, destDir := range flag.Args()[1:] {
+		report, err := medorg.VerifyAgainstSource(srcDir, destDir, mdOpts)
+		if err != nil {
+			fmt.Println("Unable to verify", destDir, err)
+			os.Exit(ExitVerifyFailed)
+		}
+		if *verboseflg {
+			for _, fp := range report.Missing {
+				fmt.Println("MISSING", fp)
+			}
+			for _, vm := range report.Corrupt {
+				fmt.Println("CORRUPT", vm)
+			}
+			for _, fp := range report.Untagged {
+				fmt.Println("UNTAGGED", fp)
+			}
+		}
+		fmt.Printf("%s: OK %d, MISSING %d, CORRUPT %d, UNTAGGED %d\n",
+			destDir, report.OK, len(report.Missing), len(report.Corrupt), len(report.Untagged))
+		if len(report.Missing) > 0 || len(report.Corrupt) > 0 {
+			retcode = ExitProblemsFound
+		}
+	}
+	os.Exit(retcode)
+}
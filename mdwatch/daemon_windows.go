@@ -0,0 +1,11 @@
+//go:build windows
+
+package main
+
+import "syscall"
+
+// detachedProcAttr gives the background process no console of its own
+func detachedProcAttr() *syscall.SysProcAttr {
+	const detachedProcess = 0x00000008
+	return &syscall.SysProcAttr{CreationFlags: detachedProcess}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/cbehopkins/medorg"
+)
+
+const (
+	ExitOk = iota
+	ExitSuppliedDirNotFound
+	ExitBadMetadataFile
+	ExitDaemonFailed
+	ExitWatchFailed
+)
+
+// daemonEnv is set in the environment of the detached copy of ourselves
+const daemonEnv = "MDWATCH_DAEMON"
+
+func isDir(fn string) bool {
+	stat, err := os.Stat(fn)
+	if err != nil {
+		return false
+	}
+	return stat.IsDir()
+}
+
+// daemonise starts a detached copy of ourselves, with the same arguments,
+// and records its pid in pidFile
+func daemonise(pidFile, logFile string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if logFile != "" {
+		out, err = os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	}
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonEnv+"=1")
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.SysProcAttr = detachedProcAttr()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(cmd.Process.Pid)+"\n"), 0644); err != nil {
+		_ = cmd.Process.Kill()
+		return err
+	}
+	fmt.Println("mdwatch started, pid", cmd.Process.Pid)
+	return cmd.Process.Release()
+}
+
+func main() {
+	var directories []string
+	var debounceflg = flag.Duration("debounce", medorg.DefaultWatchDebounce, "How long a file must be left alone before it is checksummed")
+	var daemonflg = flag.Bool("daemon", false, "Detach and carry on watching in the background")
+	var pidflg = flag.String("pid-file", filepath.Join(string(medorg.HomeDir()), ".mdwatch.pid"), "With -daemon, where to write the pid of the background process")
+	var logflg = flag.String("log-file", "", "With -daemon, where to write what we are doing (default discard)")
+	var versionflg = flag.Bool("version", false, "Print version")
+	var metadataflg = flag.String("metadata-file", medorg.GetMetadataFilename(), "Name of the file in each directory the metadata is kept in")
+	flag.Parse()
+	if *versionflg {
+		fmt.Println(medorg.VersionString("mdwatch"))
+		return
+	}
+	if err := medorg.SetMetadataFilename(*metadataflg); err != nil {
+		fmt.Println(err)
+		os.Exit(ExitBadMetadataFile)
+	}
+	watchOpts := medorg.DefaultDirTrackerOptions()
+	if xmcf := medorg.XmConfig(); xmcf != "" {
+		xc := medorg.NewXMLCfg(string(xmcf))
+		watchOpts.IgnorePatterns = xc.IgnorePatterns
+		watchOpts.Metadata = xc.MetadataOptions()
+	}
+	if flag.NArg() > 0 {
+		for _, fl := range flag.Args() {
+			if !isDir(fl) {
+				fmt.Println(fl, "is not a directory")
+				os.Exit(ExitSuppliedDirNotFound)
+			}
+			directories = append(directories, fl)
+		}
+	} else {
+		directories = []string{"."}
+	}
+
+	daemonChild := os.Getenv(daemonEnv) != ""
+	if *daemonflg && !daemonChild {
+		if err := daemonise(*pidflg, *logflg); err != nil {
+			fmt.Println("Unable to start in the background:", err)
+			os.Exit(ExitDaemonFailed)
+		}
+		return
+	}
+	if daemonChild {
+		defer os.Remove(*pidflg)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	logFunc := func(msg string) {
+		log.Println(msg)
+	}
+	logFunc(fmt.Sprint("Watching ", directories))
+	if err := medorg.WatchDirectoriesWithOptions(ctx, directories, *debounceflg, watchOpts, logFunc); err != nil {
+		fmt.Println("Stopped watching:", err)
+		// os.Exit skips the deferred remove
+		if daemonChild {
+			_ = os.Remove(*pidflg)
+		}
+		os.Exit(ExitWatchFailed)
+	}
+}
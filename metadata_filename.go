@@ -0,0 +1,60 @@
+package medorg
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// MetadataFileEnv names the environment variable that
+// can be used to change the metadata filename
+const MetadataFileEnv = "MEDORG_METADATA_FILE"
+
+// ErrBadMetadataFilename the requested metadata filename cannot be used
+var ErrBadMetadataFilename = errors.New("bad metadata filename")
+
+// metadataFilename is the file in each directory we keep the FileStructs in
+// Only change it (through SetMetadataFilename) before starting work
+var metadataFilename = Md5FileName
+
+func init() {
+	if name := os.Getenv(MetadataFileEnv); name != "" {
+		if err := SetMetadataFilename(name); err != nil {
+			log.Println("Ignoring", MetadataFileEnv, err)
+		}
+	}
+}
+
+// GetMetadataFilename returns the name of the file in each directory
+// that holds the metadata
+func GetMetadataFilename() string {
+	return metadataFilename
+}
+
+// SetMetadataFilename changes the name of the metadata file,
+// so that independent sets of metadata can live side by side.
+// It must be a plain filename that isn't used by anything else we write
+func SetMetadataFilename(name string) error {
+	switch {
+	case name == "", name == ".", name == "..":
+		return fmt.Errorf("%w::\"%s\"", ErrBadMetadataFilename, name)
+	case filepath.Base(name) != name:
+		return fmt.Errorf("%w::%s must not contain a directory", ErrBadMetadataFilename, name)
+	case name == RetryQueueFileName, name == DestIndexFileName, name == BackupReportFileName, name == volumeLabelFileName, name == ".mdSkipDir":
+		return fmt.Errorf("%w::%s is already used by medorg", ErrBadMetadataFilename, name)
+	}
+	metadataFilename = name
+	return nil
+}
+
+// IsMedorgFile reports if fn is one of the files we keep alongside the user's,
+// so should be skipped by anything walking the user's files
+func IsMedorgFile(fn string) bool {
+	switch fn {
+	case GetMetadataFilename(), RetryQueueFileName, DestIndexFileName, BackupReportFileName:
+		return true
+	}
+	return isMetadataTempFile(fn)
+}
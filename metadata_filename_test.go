@@ -0,0 +1,40 @@
+package medorg
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetMetadataFilename(t *testing.T) {
+	defer func() { _ = SetMetadataFilename(Md5FileName) }()
+	for _, bad := range []string{"", "..", "sub/.work.xml", RetryQueueFileName, volumeLabelFileName} {
+		if err := SetMetadataFilename(bad); !errors.Is(err, ErrBadMetadataFilename) {
+			t.Error("Expected", bad, "to be refused, got", err)
+		}
+	}
+	if GetMetadataFilename() != Md5FileName {
+		t.Error("A refused name should leave the name alone, got", GetMetadataFilename())
+	}
+
+	wkDir, err := os.MkdirTemp("", "mdName")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wkDir)
+	if err := SetMetadataFilename(".work.xml"); err != nil {
+		t.Fatal(err)
+	}
+	dm := NewDirectoryMap()
+	dm.Add(FileStruct{Name: "file", Checksum: "abc", directory: wkDir})
+	if err := dm.Persist(wkDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(wkDir, ".work.xml")); err != nil {
+		t.Error("Expected the metadata in the new file", err)
+	}
+	if _, err := os.Stat(filepath.Join(wkDir, Md5FileName)); !errors.Is(err, os.ErrNotExist) {
+		t.Error("Nothing should be written to the default file", err)
+	}
+}
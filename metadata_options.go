@@ -0,0 +1,26 @@
+package medorg
+
+// DefaultMaxEntries is the most files a directory may have before we refuse to write its metadata
+const DefaultMaxEntries = 100000
+
+// MetadataOptions say how the metadata files are read and written
+// A DirectoryMap keeps those it was loaded with, and writes itself out with them.
+type MetadataOptions struct {
+	// FsyncWrites makes sure each metadata file is on disk before
+	// it replaces the old one. Slower, but safer against power loss.
+	FsyncWrites bool
+	// CompactOnWrite drops the entries for files that have been deleted
+	// each time a metadata file is written, so they don't build up
+	CompactOnWrite bool
+	// MinVersion is the oldest metadata file we are prepared to load,
+	// older files are refused rather than migrated. Zero loads everything.
+	MinVersion int
+	// MaxEntries we refuse to write changes to directories with more files than this
+	// Zero removes the limit
+	MaxEntries int
+}
+
+// DefaultMetadataOptions are those used when none are given
+func DefaultMetadataOptions() MetadataOptions {
+	return MetadataOptions{MaxEntries: DefaultMaxEntries}
+}
@@ -0,0 +1,129 @@
+package medorg
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// MetadataVersion is the version of the metadata file format we write
+// Files written before there was a version are version 1
+const MetadataVersion = 2
+
+// ErrOldMetadata the metadata file is older than the MinVersion we were asked to load
+var ErrOldMetadata = errors.New("metadata version too old")
+
+// ErrNewMetadata the metadata file was written by a newer version of medorg
+// We can neither understand it, nor overwrite it without losing what we don't understand
+var ErrNewMetadata = errors.New("metadata version too new")
+
+// metadataMigrations take an Md5File from the version they are keyed by
+// to the one after
+var metadataMigrations = map[int]func(m5f *Md5File) error{
+	1: migrateMetadataV1,
+}
+
+// migrateMetadataV1 only adds the version, which the caller does
+func migrateMetadataV1(m5f *Md5File) error {
+	return nil
+}
+
+// version is the format the file was written in
+func (md Md5File) version() int {
+	if md.Version == 0 {
+		return 1
+	}
+	return md.Version
+}
+
+// migrateMd5File brings m5f up to MetadataVersion
+// Files older than minVersion are refused
+func migrateMd5File(m5f *Md5File, minVersion int) error {
+	version := m5f.version()
+	if version > MetadataVersion {
+		return fmt.Errorf("%w::version %d, we only understand up to %d", ErrNewMetadata, version, MetadataVersion)
+	}
+	if version < minVersion {
+		return fmt.Errorf("%w::version %d, need at least %d", ErrOldMetadata, version, minVersion)
+	}
+	for ; version < MetadataVersion; version++ {
+		migrate, ok := metadataMigrations[version]
+		if !ok {
+			return fmt.Errorf("%w::no migration from version %d", ErrBadMetadata, version)
+		}
+		if err := migrate(m5f); err != nil {
+			return err
+		}
+		m5f.Version = version + 1
+	}
+	return nil
+}
+
+// checkMetadataOverwrite refuses to let us replace a directory's
+// metadata file written by a newer version than ours
+func checkMetadataOverwrite(directory string) error {
+	f, err := os.Open(filepath.Join(directory, GetMetadataFilename()))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	decoder := xml.NewDecoder(f)
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			// Not even the start of an xml file, so nothing to lose
+			return nil
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		m5f := Md5File{}
+		for _, attr := range se.Attr {
+			if attr.Name.Local == "version" {
+				m5f.Version, _ = strconv.Atoi(attr.Value)
+			}
+		}
+		if m5f.version() > MetadataVersion {
+			return fmt.Errorf("%w::version %d in %s", ErrNewMetadata, m5f.version(), directory)
+		}
+		return nil
+	}
+}
+
+// MigrateMetadata rewrites every metadata file below the directories
+// in the current format, as opts says. Returns how many were rewritten.
+// The old files are what we are here for, so opts.MinVersion is ignored.
+func MigrateMetadata(directories []string, opts MetadataOptions) (int, error) {
+	opts.MinVersion = 0
+	var count int
+	dirFc := func(dir string, dm DirectoryMap) error {
+		_, err := os.Stat(filepath.Join(dir, GetMetadataFilename()))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		dm.lock.Lock()
+		*dm.stale = true
+		dm.lock.Unlock()
+		if err := dm.PersistWithLock(dir); err != nil {
+			return err
+		}
+		count++
+		return nil
+	}
+	for _, dir := range directories {
+		if err := walkDirectoryMaps(dir, opts, dirFc); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
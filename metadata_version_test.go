@@ -0,0 +1,110 @@
+package medorg
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fixtureDir makes a directory whose metadata file is the fixture
+func fixtureDir(t *testing.T, fixture string) string {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, GetMetadataFilename()), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestMetadataVersionLoad(t *testing.T) {
+	for _, tc := range []struct {
+		fixture string
+		files   int
+	}{
+		{"metadata_v1.xml", 2},
+		{"metadata_v2.xml", 1},
+	} {
+		t.Run(tc.fixture, func(t *testing.T) {
+			dm, err := DirectoryMapFromDir(fixtureDir(t, tc.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dm.Len() != tc.files {
+				t.Error("Expected", tc.files, "files, got", dm.Len())
+			}
+			fs, ok := dm.Get("beach.jpg")
+			if !ok || fs.Checksum != "jJWfDxNcCGx7dHTpRAXVnQ" {
+				t.Error("beach.jpg not loaded correctly", fs)
+			}
+		})
+	}
+}
+
+func TestMetadataVersionMinimum(t *testing.T) {
+	opts := DefaultMetadataOptions()
+	opts.MinVersion = 2
+	if _, err := DirectoryMapFromDirWithOptions(fixtureDir(t, "metadata_v1.xml"), "", opts); !errors.Is(err, ErrOldMetadata) {
+		t.Error("Expected the v1 file to be refused, got", err)
+	}
+	if _, err := DirectoryMapFromDirWithOptions(fixtureDir(t, "metadata_v2.xml"), "", opts); err != nil {
+		t.Error("Expected the v2 file to load, got", err)
+	}
+}
+
+func TestMigrateMetadata(t *testing.T) {
+	dir := fixtureDir(t, "metadata_v1.xml")
+	opts := DefaultMetadataOptions()
+	opts.MinVersion = MetadataVersion
+	count, err := MigrateMetadata([]string{dir}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Error("Expected one file rewritten, got", count)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, GetMetadataFilename()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), `version="2"`) {
+		t.Error("Expected the rewritten file to be version 2, got", string(content))
+	}
+
+	// Now it will load, however new a version is required
+	dm, err := DirectoryMapFromDirWithOptions(dir, "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dm.Len() != 2 {
+		t.Error("Expected both files to survive the migration, got", dm.Len())
+	}
+}
+
+func TestMetadataVersionNewer(t *testing.T) {
+	dir := fixtureDir(t, "metadata_v99.xml")
+	if _, err := DirectoryMapFromDir(dir); !errors.Is(err, ErrNewMetadata) {
+		t.Error("Expected the newer file to be refused, got", err)
+	}
+	before, err := os.ReadFile(filepath.Join(dir, GetMetadataFilename()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dm := NewDirectoryMap()
+	dm.Add(FileStruct{Name: "beach.jpg", Checksum: "abc", directory: dir})
+	if err := dm.Persist(dir); !errors.Is(err, ErrNewMetadata) {
+		t.Error("Expected the newer file not to be overwritten, got", err)
+	}
+	after, err := os.ReadFile(filepath.Join(dir, GetMetadataFilename()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(before) != string(after) {
+		t.Error("The newer file was changed")
+	}
+}
@@ -0,0 +1,21 @@
+//go:build darwin
+
+package medorg
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// mountPoints lists the root volume, and the external volumes under /Volumes
+func mountPoints() ([]string, error) {
+	entries, err := os.ReadDir("/Volumes")
+	if err != nil {
+		return nil, err
+	}
+	mps := []string{"/"}
+	for _, e := range entries {
+		mps = append(mps, filepath.Join("/Volumes", e.Name()))
+	}
+	return mps, nil
+}
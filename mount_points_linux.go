@@ -0,0 +1,45 @@
+//go:build linux
+
+package medorg
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// mountPoints lists what is mounted, from /proc/mounts
+func mountPoints() ([]string, error) {
+	f, err := os.Open("/proc/mounts")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var mps []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		mps = append(mps, unescapeMountPoint(fields[1]))
+	}
+	return mps, scanner.Err()
+}
+
+// unescapeMountPoint undoes the octal escaping of spaces etc. in /proc/mounts
+func unescapeMountPoint(mp string) string {
+	var sb strings.Builder
+	for i := 0; i < len(mp); i++ {
+		if mp[i] == '\\' && i+3 < len(mp) {
+			if b, err := strconv.ParseUint(mp[i+1:i+4], 8, 8); err == nil {
+				sb.WriteByte(byte(b))
+				i += 3
+				continue
+			}
+		}
+		sb.WriteByte(mp[i])
+	}
+	return sb.String()
+}
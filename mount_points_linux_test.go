@@ -0,0 +1,20 @@
+//go:build linux
+
+package medorg
+
+import "testing"
+
+func TestUnescapeMountPoint(t *testing.T) {
+	testCases := map[string]string{
+		"/":                        "/",
+		"/media/backup":            "/media/backup",
+		`/media/My\040Passport`:    "/media/My Passport",
+		`/mnt/tab\011and\134slash`: "/mnt/tab\tand\\slash",
+		`/mnt/trailing\04`:         `/mnt/trailing\04`,
+	}
+	for escaped, expected := range testCases {
+		if got := unescapeMountPoint(escaped); got != expected {
+			t.Error("Unescaping", escaped, "got", got, "expected", expected)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !windows
+
+package medorg
+
+import "errors"
+
+// mountPoints is not yet implemented on this platform
+func mountPoints() ([]string, error) {
+	return nil, errors.New("listing mount points not supported on this platform")
+}
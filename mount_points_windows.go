@@ -0,0 +1,17 @@
+//go:build windows
+
+package medorg
+
+import "os"
+
+// mountPoints lists the root of every drive letter in use
+func mountPoints() ([]string, error) {
+	var mps []string
+	for drive := 'A'; drive <= 'Z'; drive++ {
+		root := string(drive) + `:\`
+		if _, err := os.Stat(root); err == nil {
+			mps = append(mps, root)
+		}
+	}
+	return mps, nil
+}
@@ -0,0 +1,71 @@
+package medorg
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// MountedVolume is a labelled backup volume that is currently mounted
+type MountedVolume struct {
+	Label string
+	Mount string
+	// Labelled is when the volume's label file was last written
+	// i.e. roughly when it was last backed up to
+	Labelled time.Time
+	// TotalBytes is the size of the volume, 0 if we don't know
+	TotalBytes int64
+}
+
+// mountPointsFunc lists the mount points; tests swap it out
+var mountPointsFunc = mountPoints
+
+// ListMountedVolumes looks at the top of every mount point for a volume label
+// returning the labelled volumes sorted by label
+func ListMountedVolumes() ([]MountedVolume, error) {
+	mps, err := mountPointsFunc()
+	if err != nil {
+		return nil, err
+	}
+	var volumes []MountedVolume
+	for _, mp := range mps {
+		fn := formVolumeName(mp)
+		info, err := os.Stat(fn)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			log.Println("Unable to check for a label on", mp, err)
+			continue
+		}
+		ba, err := os.ReadFile(fn)
+		if err != nil {
+			log.Println("Unable to read label on", mp, err)
+			continue
+		}
+		var vc VolumeCfg
+		if err := vc.FromXML(ba); err != nil {
+			log.Println("Unable to read label on", mp, err)
+			continue
+		}
+		mv := MountedVolume{
+			Label:      vc.Label,
+			Mount:      mp,
+			Labelled:   info.ModTime(),
+			TotalBytes: vc.TotalCapacityBytes,
+		}
+		if total, _, err := volumeUsage(mp); err == nil && total > 0 {
+			mv.TotalBytes = total
+		}
+		volumes = append(volumes, mv)
+	}
+	sort.Slice(volumes, func(i, j int) bool {
+		if volumes[i].Label != volumes[j].Label {
+			return volumes[i].Label < volumes[j].Label
+		}
+		return volumes[i].Mount < volumes[j].Mount
+	})
+	return volumes, nil
+}
@@ -0,0 +1,63 @@
+package medorg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListMountedVolumes(t *testing.T) {
+	root, err := os.MkdirTemp("", "mounted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	var mps []string
+	for _, name := range []string{"usb", "unlabelled", "nas"} {
+		mp := filepath.Join(root, name)
+		if err := os.Mkdir(mp, 0755); err != nil {
+			t.Fatal(err)
+		}
+		mps = append(mps, mp)
+	}
+	for i, label := range map[int]string{0: "zebra", 2: "aardvark"} {
+		vc := VolumeCfg{Label: label, TotalCapacityBytes: 1234, fn: formVolumeName(mps[i])}
+		if err := vc.Persist(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A label further down is not the top of a volume
+	if err := os.Mkdir(filepath.Join(mps[1], "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	vc := VolumeCfg{Label: "nested", fn: formVolumeName(filepath.Join(mps[1], "sub"))}
+	if err := vc.Persist(); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(orig func() ([]string, error)) { mountPointsFunc = orig }(mountPointsFunc)
+	mountPointsFunc = func() ([]string, error) {
+		return append(mps, filepath.Join(root, "gone")), nil
+	}
+	volumes, err := ListMountedVolumes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes) != 2 {
+		t.Fatal("Expected 2 volumes, got", volumes)
+	}
+	if volumes[0].Label != "aardvark" || volumes[0].Mount != mps[2] {
+		t.Error("Unexpected first volume", volumes[0])
+	}
+	if volumes[1].Label != "zebra" || volumes[1].Mount != mps[0] {
+		t.Error("Unexpected second volume", volumes[1])
+	}
+	for _, mv := range volumes {
+		if mv.Labelled.IsZero() {
+			t.Error("Expected to know when", mv.Label, "was labelled")
+		}
+		if mv.TotalBytes == 0 {
+			t.Error("Expected to know the size of", mv.Label)
+		}
+	}
+}
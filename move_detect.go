@@ -21,6 +21,7 @@ type moveKey struct {
 type moveDetect struct {
 	sync.RWMutex
 	dupeMap map[moveKey]FileStruct
+	opts    MetadataOptions
 }
 
 // runMoveDetectFindDeleted will run through the directory
@@ -42,7 +43,7 @@ func (mvd *moveDetect) runMoveDetectFindDeleted(directory string) error {
 	}
 	makerFunc := func(dir string) (DirectoryTrackerInterface, error) {
 		mkFk := func(dir string) (DirectoryEntryInterface, error) {
-			dm, err := DirectoryMapFromDir(dir)
+			dm, err := DirectoryMapFromDirWithOptions(dir, directory, mvd.opts)
 			dm.VisitFunc = visitFunc
 			if err != nil {
 				return dm, err
@@ -64,7 +65,7 @@ func (mvd *moveDetect) runMoveDetectFindDeleted(directory string) error {
 // then populate the entry withou a calculation
 func (mvd *moveDetect) runMoveDetectFindNew(directory string) error {
 	visitFunc := func(dm DirectoryMap, dir, fn string, d fs.DirEntry) error {
-		if fn == Md5FileName {
+		if IsMedorgFile(fn) {
 			return nil
 		}
 		v, err := mvd.query(d)
@@ -81,7 +82,7 @@ func (mvd *moveDetect) runMoveDetectFindNew(directory string) error {
 	}
 	makerFunc := func(dir string) (DirectoryTrackerInterface, error) {
 		mkFk := func(dir string) (DirectoryEntryInterface, error) {
-			dm, err := DirectoryMapFromDir(dir)
+			dm, err := DirectoryMapFromDirWithOptions(dir, directory, mvd.opts)
 			dm.VisitFunc = visitFunc
 			return dm, err
 		}
@@ -103,7 +104,13 @@ func (mvd *moveDetect) runMoveDetectFindNew(directory string) error {
 // Then a second pass to see if new files with matching
 // properties have been added
 func RunMoveDetect(dirs []string) error {
-	var mvd moveDetect
+	return RunMoveDetectWithOptions(dirs, DefaultMetadataOptions())
+}
+
+// RunMoveDetectWithOptions is RunMoveDetect, reading and writing
+// the metadata as opts says
+func RunMoveDetectWithOptions(dirs []string, opts MetadataOptions) error {
+	mvd := moveDetect{opts: opts}
 	for _, dir := range dirs {
 		// FIXME we should be able to run this in parallel
 		err := mvd.runMoveDetectFindDeleted(dir)
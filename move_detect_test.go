@@ -9,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func createTestDirectories(root string, cnt int) ([]string, error) {
@@ -73,7 +74,7 @@ func gatherFilesAndDirectories(root string) (files, directories []string) {
 			return err
 		}
 		_, file := filepath.Split(path)
-		if file == Md5FileName {
+		if file == GetMetadataFilename() {
 			return nil
 		}
 		if d.IsDir() {
@@ -90,7 +91,7 @@ func gatherFilesAndDirectories(root string) (files, directories []string) {
 var errMissingChecksum = errors.New("missing checksum")
 
 func checkChecksums(dm DirectoryMap, directory, fn string, d fs.DirEntry) error {
-	if fn == Md5FileName {
+	if fn == GetMetadataFilename() {
 		return nil
 	}
 	_, ok := dm.Get(fn)
@@ -228,3 +229,20 @@ func TestMoveDetect(t *testing.T) {
 		})
 	}
 }
+
+func TestMoveDetectBadMetadata(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, GetMetadataFilename()), []byte("<dr><fr fname="), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "file"), []byte("file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- RunMoveDetect([]string{root}) }()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Move detection did not finish")
+	}
+}
@@ -1,6 +1,13 @@
 package medorg
 
-import "sort"
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
 
 func prioritizeFiles(candidates []FileStruct, label string) []FileStruct {
 	filterFunc := func(can FileStruct) bool {
@@ -31,3 +38,81 @@ func prioritizeFiles(candidates []FileStruct, label string) []FileStruct {
 	})
 	return newCands
 }
+
+// ErrBadPriorityWeights the weights string could not be parsed
+var ErrBadPriorityWeights = errors.New("bad priority weights")
+
+// PriorityWeights controls how much each property of a file
+// matters when deciding what to back up first
+type PriorityWeights struct {
+	Dest float64 // Files with fewer backups go first
+	Size float64 // Larger files go first
+	Freq float64 // Files that change often go first
+	Tag  float64 // Files tagged as important go first
+}
+
+// ImportantTag marks a file (or, inherited, a directory of files) as
+// deserving to be backed up ahead of the rest
+const ImportantTag = "important=true"
+
+// DefaultPriorityWeights are used if the user does not specify any
+// The tag weight is large enough to put important files ahead of anything else
+var DefaultPriorityWeights = PriorityWeights{Dest: 1.0, Size: 0.5, Freq: 2.0, Tag: 10000}
+
+// minChangeFrequency is used in place of a zero frequency
+// i.e. a file that has never changed is treated as changing once a decade
+const minChangeFrequency = 1.0 / 3650
+
+// ParsePriorityWeights parses a string of the form "dest=1.0,size=0.5,freq=2.0,tag=10000"
+// Any weight not mentioned keeps its default value
+func ParsePriorityWeights(str string) (PriorityWeights, error) {
+	pw := DefaultPriorityWeights
+	if str == "" {
+		return pw, nil
+	}
+	for _, field := range strings.Split(str, ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) != 2 {
+			return pw, fmt.Errorf("%w::%s", ErrBadPriorityWeights, field)
+		}
+		val, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil {
+			return pw, fmt.Errorf("%w::%s", ErrBadPriorityWeights, field)
+		}
+		switch kv[0] {
+		case "dest":
+			pw.Dest = val
+		case "size":
+			pw.Size = val
+		case "freq":
+			pw.Freq = val
+		case "tag":
+			pw.Tag = val
+		default:
+			return pw, fmt.Errorf("%w::%s", ErrBadPriorityWeights, field)
+		}
+	}
+	return pw, nil
+}
+
+// buildPriorityKey returns a score for the file, lower scores should be backed up first
+func buildPriorityKey(fs FileStruct, pw PriorityWeights) float64 {
+	freq := float64(fs.ChangeFrequency)
+	if freq < minChangeFrequency {
+		freq = minChangeFrequency
+	}
+	key := pw.Dest*float64(len(fs.BackupDest)) -
+		pw.Size*math.Log(float64(fs.Size)+1) +
+		pw.Freq*(1/freq)
+	if fs.HasEffectiveTag(ImportantTag) {
+		key -= pw.Tag
+	}
+	return key
+}
+
+// sortByPriority sorts the files so the most important come first
+func sortByPriority(fss []FileStruct, pw PriorityWeights) {
+	sort.SliceStable(fss, func(i, j int) bool {
+		return buildPriorityKey(fss[i], pw) < buildPriorityKey(fss[j], pw)
+	})
+}
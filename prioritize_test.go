@@ -1,6 +1,7 @@
 package medorg
 
 import (
+	"errors"
 	"testing"
 )
 
@@ -259,3 +260,43 @@ func TestPrioritize(t *testing.T) {
 		})
 	}
 }
+
+func TestParsePriorityWeights(t *testing.T) {
+	pw, err := ParsePriorityWeights("dest=2.0, freq=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pw.Dest != 2.0 || pw.Size != DefaultPriorityWeights.Size || pw.Freq != 0 {
+		t.Error("Unexpected weights", pw)
+	}
+	for _, bad := range []string{"dest", "dest=bob", "colour=1.0"} {
+		_, err := ParsePriorityWeights(bad)
+		if !errors.Is(err, ErrBadPriorityWeights) {
+			t.Error("Expected an error for", bad, "got", err)
+		}
+	}
+}
+
+func TestPrioritizeChangeFrequency(t *testing.T) {
+	fss := []FileStruct{
+		{Name: "large_rarely_changed.mp4", Size: 1 << 30, ChangeFrequency: 1.0 / 1000},
+		{Name: "small_daily.txt", Size: 1024, ChangeFrequency: 1.0},
+		{Name: "medium_never_changed.doc", Size: 1 << 20},
+	}
+	sortByPriority(fss, DefaultPriorityWeights)
+	expected := []string{"small_daily.txt", "large_rarely_changed.mp4", "medium_never_changed.doc"}
+	for i, name := range expected {
+		if fss[i].Name != name {
+			t.Error("Expected", name, "at", i, "got", fss[i].Name)
+		}
+	}
+
+	// With the frequency ignored, we are back to larger files first
+	sortByPriority(fss, PriorityWeights{Dest: 1.0, Size: 1.0})
+	expected = []string{"large_rarely_changed.mp4", "medium_never_changed.doc", "small_daily.txt"}
+	for i, name := range expected {
+		if fss[i].Name != name {
+			t.Error("Expected", name, "at", i, "got", fss[i].Name)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package medorg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrRestoreExists there is already a file where we would restore to
+var ErrRestoreExists = errors.New("restore destination exists")
+
+// RestoreReport is the result of restoring files from a backup
+type RestoreReport struct {
+	// Restored is how many files were copied and found to match their checksum
+	Restored int
+	// Failed are the files we could not restore, or whose copy does not match
+	Failed []VerifyMismatch
+}
+
+// restoreMatch reports whether the file at rel, relative to the backup, matches pattern.
+// A pattern with a separator in is matched against the whole path, otherwise just the name.
+func restoreMatch(pattern, rel string) (bool, error) {
+	if strings.ContainsRune(pattern, filepath.Separator) {
+		return filepath.Match(pattern, rel)
+	}
+	return filepath.Match(pattern, filepath.Base(rel))
+}
+
+// RestoreMatching copies the files in backupDir whose name matches the glob pattern
+// to restoreDir, keeping the directory structure below backupDir, unless flatten,
+// in which case they all go directly in restoreDir.
+// Only the .medorg.xml files are used to find the files, so no journal is needed,
+// and each copy is checked against the checksum recorded for it.
+// Existing files in restoreDir are never overwritten.
+// fc should copy the bytes, as CopyFileBytes does; a hard link to the backup
+// would let changes to the restored file change the backup too.
+func RestoreMatching(backupDir, restoreDir, pattern string, flatten bool, fc FileCopier, opts MetadataOptions) (RestoreReport, error) {
+	var report RestoreReport
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return report, err
+	}
+	dirFc := func(dir string, dm DirectoryMap) error {
+		return dm.rangeMap(func(fn string, fs FileStruct) error {
+			if fn == volumeLabelFileName || fn == DestIndexFileName || fn == BackupReportFileName {
+				return nil
+			}
+			rel, err := filepath.Rel(backupDir, filepath.Join(dir, fn))
+			if err != nil {
+				return err
+			}
+			match, err := restoreMatch(pattern, rel)
+			if err != nil || !match {
+				return err
+			}
+			if flatten {
+				rel = fn
+			}
+			dst := filepath.Join(restoreDir, rel)
+			if mismatch := restoreFile(NewFpath(dir, fn), Fpath(dst), fs.Checksum, fc); mismatch != nil {
+				report.Failed = append(report.Failed, *mismatch)
+				return nil
+			}
+			report.Restored++
+			return nil
+		})
+	}
+	err := walkDirectoryMaps(backupDir, opts, dirFc)
+	return report, err
+}
+
+// restoreFile copies src to dst and checks the copy against checksum
+// Returns nil if all went well
+func restoreFile(src, dst Fpath, checksum string, fc FileCopier) (mismatch *VerifyMismatch) {
+	if _, err := os.Stat(string(dst)); err == nil {
+		return &VerifyMismatch{Path: dst, Expected: checksum, Err: fmt.Errorf("%w::%s", ErrRestoreExists, dst)}
+	}
+	// Anything at dst now is ours, and if it is not a good copy it must go,
+	// or running the restore again would refuse to overwrite it
+	defer func() {
+		if mismatch != nil {
+			_ = os.Remove(string(dst))
+		}
+	}()
+	if err := fc(src, dst); err != nil {
+		return &VerifyMismatch{Path: src, Expected: checksum, Err: err}
+	}
+	if checksum == "" {
+		// Nothing to check against
+		return nil
+	}
+	dir, fn := filepath.Split(string(dst))
+	cks, err := CalcMd5File(dir, fn)
+	if err != nil {
+		return &VerifyMismatch{Path: dst, Expected: checksum, Err: err}
+	}
+	if cks != checksum {
+		return &VerifyMismatch{Path: dst, Expected: checksum, Actual: cks}
+	}
+	return nil
+}
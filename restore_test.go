@@ -0,0 +1,116 @@
+package medorg
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRestoreMatching(t *testing.T) {
+	backupDir := t.TempDir()
+	files := map[string]string{
+		filepath.Join("2023", "beach.jpg"):  "sand",
+		filepath.Join("2024", "beach.jpg"):  "more sand",
+		filepath.Join("2024", "notes.txt"):  "notes",
+		filepath.Join("2024", "x", "a.jpg"): "deep",
+	}
+	for rel, content := range files {
+		path := filepath.Join(backupDir, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := recalcTestDirectory(backupDir); err != nil {
+		t.Fatal(err)
+	}
+
+	restoreDir := t.TempDir()
+	report, err := RestoreMatching(backupDir, restoreDir, "*.jpg", false, CopyFileBytes, DefaultMetadataOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Restored != 3 || len(report.Failed) != 0 {
+		t.Error("Expected 3 files restored, got", report)
+	}
+	for rel, content := range files {
+		got, err := os.ReadFile(filepath.Join(restoreDir, rel))
+		if filepath.Ext(rel) != ".jpg" {
+			if !errors.Is(err, os.ErrNotExist) {
+				t.Error(rel, "should not have been restored")
+			}
+			continue
+		}
+		if err != nil || string(got) != content {
+			t.Error("Bad restore of", rel, err)
+		}
+		restored, _ := os.Stat(filepath.Join(restoreDir, rel))
+		backedUp, _ := os.Stat(filepath.Join(backupDir, rel))
+		if restored != nil && backedUp != nil && os.SameFile(restored, backedUp) {
+			t.Error(rel, "was linked to the backup, not copied")
+		}
+	}
+
+	// Flattened, the two beach.jpg collide, and we keep the first
+	flatDir := t.TempDir()
+	report, err = RestoreMatching(backupDir, flatDir, "*.jpg", true, CopyFileBytes, DefaultMetadataOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Restored != 2 || len(report.Failed) != 1 || !errors.Is(report.Failed[0].Err, ErrRestoreExists) {
+		t.Error("Expected one collision, got", report)
+	}
+	if _, err := os.Stat(filepath.Join(flatDir, "a.jpg")); err != nil {
+		t.Error("a.jpg should be at the top of the restore", err)
+	}
+
+	// A copier that mangles the file is caught by the checksum
+	badCopy := func(src, dst Fpath) error {
+		if err := os.MkdirAll(filepath.Dir(string(dst)), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(string(dst), []byte("mangled"), 0644)
+	}
+	badDir := t.TempDir()
+	report, err = RestoreMatching(backupDir, badDir, "notes.*", false, badCopy, DefaultMetadataOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Restored != 0 || len(report.Failed) != 1 || report.Failed[0].Actual == "" {
+		t.Error("Expected a checksum mismatch, got", report)
+	}
+	notes := filepath.Join(badDir, "2024", "notes.txt")
+	if _, err := os.Stat(notes); !errors.Is(err, os.ErrNotExist) {
+		t.Error("The bad copy should have been removed", err)
+	}
+
+	// As is a copy that fails part way
+	failedCopy := func(src, dst Fpath) error {
+		if err := badCopy(src, dst); err != nil {
+			return err
+		}
+		return errors.New("disk went away")
+	}
+	report, err = RestoreMatching(backupDir, badDir, "notes.*", false, failedCopy, DefaultMetadataOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Restored != 0 || len(report.Failed) != 1 || report.Failed[0].Err == nil {
+		t.Error("Expected the copy to fail, got", report)
+	}
+	if _, err := os.Stat(notes); !errors.Is(err, os.ErrNotExist) {
+		t.Error("The partial copy should have been removed", err)
+	}
+
+	// So running the restore again works
+	report, err = RestoreMatching(backupDir, badDir, "notes.*", false, CopyFileBytes, DefaultMetadataOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Restored != 1 || len(report.Failed) != 0 {
+		t.Error("Expected the restore to work second time, got", report)
+	}
+}
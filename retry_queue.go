@@ -0,0 +1,146 @@
+package medorg
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// RetryQueueFileName is where, in the source directory, we keep copies that failed
+const RetryQueueFileName = ".mdbackup-retry.json"
+
+// maxRetryAttempts is how many times we try a copy before giving up on it
+const maxRetryAttempts = 3
+
+// retryBackoff is how long we wait before the first retry
+// each subsequent retry waits twice as long as the last
+const retryBackoff = time.Minute
+
+// RetryEntry is a copy that failed, to be tried again next time
+type RetryEntry struct {
+	Path            Fpath  `json:"path"`
+	Dest            string `json:"dest"`
+	Checksum        string `json:"checksum"`
+	AttemptCount    int    `json:"attempt_count"`
+	LastAttemptTime int64  `json:"last_attempt_time"`
+}
+
+// due returns true if we have waited long enough to try again
+func (re RetryEntry) due(now time.Time) bool {
+	wait := retryBackoff << (re.AttemptCount - 1)
+	return now.Sub(time.Unix(re.LastAttemptTime, 0)) >= wait
+}
+
+// RetryQueue is the list of copies that have failed
+type RetryQueue struct {
+	sync.Mutex
+	Entries []RetryEntry
+	fn      string
+}
+
+// NewRetryQueue reads in the retry queue for srcDir
+// A missing queue is the same as an empty one
+func NewRetryQueue(srcDir string) (*RetryQueue, error) {
+	rq := &RetryQueue{fn: filepath.Join(srcDir, RetryQueueFileName)}
+	data, err := os.ReadFile(rq.fn)
+	if errors.Is(err, os.ErrNotExist) {
+		return rq, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &rq.Entries)
+	if err != nil {
+		return nil, err
+	}
+	return rq, nil
+}
+
+func (rq *RetryQueue) index(path Fpath, dest string) int {
+	for i, re := range rq.Entries {
+		if re.Path == path && re.Dest == dest {
+			return i
+		}
+	}
+	return -1
+}
+
+// Add records a failed copy of path to dest
+// returning true if it has now failed too many times and been dropped
+func (rq *RetryQueue) Add(path Fpath, dest, checksum string) bool {
+	rq.Lock()
+	defer rq.Unlock()
+	i := rq.index(path, dest)
+	if i < 0 {
+		rq.Entries = append(rq.Entries, RetryEntry{Path: path, Dest: dest})
+		i = len(rq.Entries) - 1
+	}
+	re := &rq.Entries[i]
+	re.Checksum = checksum
+	re.AttemptCount++
+	re.LastAttemptTime = time.Now().Unix()
+	if re.AttemptCount >= maxRetryAttempts {
+		rq.Entries = append(rq.Entries[:i], rq.Entries[i+1:]...)
+		return true
+	}
+	return false
+}
+
+// Remove an entry, e.g. because the copy has now worked
+func (rq *RetryQueue) Remove(path Fpath, dest string) {
+	rq.Lock()
+	defer rq.Unlock()
+	if i := rq.index(path, dest); i >= 0 {
+		rq.Entries = append(rq.Entries[:i], rq.Entries[i+1:]...)
+	}
+}
+
+// Due returns the entries for dest that are ready to be tried again
+func (rq *RetryQueue) Due(dest string) []RetryEntry {
+	rq.Lock()
+	defer rq.Unlock()
+	now := time.Now()
+	var due []RetryEntry
+	for _, re := range rq.Entries {
+		if re.Dest == dest && re.due(now) {
+			due = append(due, re)
+		}
+	}
+	return due
+}
+
+// Len is the number of entries in the queue
+func (rq *RetryQueue) Len() int {
+	rq.Lock()
+	defer rq.Unlock()
+	return len(rq.Entries)
+}
+
+// Persist writes the queue back to disk
+// An empty queue removes the file
+func (rq *RetryQueue) Persist() error {
+	rq.Lock()
+	defer rq.Unlock()
+	if len(rq.Entries) == 0 {
+		err := os.Remove(rq.fn)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	data, err := json.MarshalIndent(rq.Entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file, then rename it into place
+	// so we never leave half a queue behind
+	tmpFn := rq.fn + ".tmp"
+	err = os.WriteFile(tmpFn, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFn, rq.fn)
+}
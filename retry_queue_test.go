@@ -0,0 +1,192 @@
+package medorg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryQueue(t *testing.T) {
+	wkDir, err := os.MkdirTemp("", "retryQueue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wkDir)
+
+	rq, err := NewRetryQueue(wkDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp := NewFpath(wkDir, "bob")
+	for i := 1; i < maxRetryAttempts; i++ {
+		if rq.Add(fp, "dest", "abc") {
+			t.Fatal("Dropped entry after", i, "attempts")
+		}
+	}
+	if len(rq.Due("dest")) != 0 {
+		t.Error("Entry should not be due straight away")
+	}
+	rq.Entries[0].LastAttemptTime = time.Now().Add(-time.Hour).Unix()
+	if len(rq.Due("dest")) != 1 || len(rq.Due("other")) != 0 {
+		t.Error("Expected the entry to be due for dest only")
+	}
+
+	err = rq.Persist()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rq, err = NewRetryQueue(wkDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rq.Len() != 1 || rq.Entries[0].AttemptCount != maxRetryAttempts-1 {
+		t.Fatal("Queue not read back correctly", rq.Entries)
+	}
+	if !rq.Add(fp, "dest", "abc") {
+		t.Error("Expected the entry to be dropped")
+	}
+	err = rq.Persist()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(wkDir, RetryQueueFileName)); !os.IsNotExist(err) {
+		t.Error("An empty queue should remove the file", err)
+	}
+}
+
+func TestBackupRetriesFailedCopies(t *testing.T) {
+	dirs, err := createTestBackupDirectories(5, 0)
+	if err != nil {
+		t.Fatal("Failed to create test Directories", err)
+	}
+	defer func() {
+		for i := range dirs {
+			os.RemoveAll(dirs[i])
+		}
+	}()
+	_ = recalcTestDirectory(dirs[0])
+
+	// The first copy fails with a network error (even when retried), everything else works
+	xc := XMLCfg{}
+	opts := BackupOptions{CreateLabelIfMissing: true}
+	failures := int32(1 + copyRetries)
+	fc := func(src, dst Fpath) error {
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			return syscall.ENETUNREACH
+		}
+		return CopyFile(src, dst)
+	}
+	err = BackupRunner(context.Background(), &xc, opts, 2, fc, dirs[0], dirs[1], nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rq, err := NewRetryQueue(dirs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rq.Len() != 1 {
+		t.Fatal("Expected a single failed copy to be queued, got", rq.Entries)
+	}
+	failed := rq.Entries[0].Path
+
+	// Pretend we waited long enough, and go again
+	rq.Entries[0].LastAttemptTime = time.Now().Add(-time.Hour).Unix()
+	err = rq.Persist()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = BackupRunner(context.Background(), &xc, opts, 2, fc, dirs[0], dirs[1], nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, _ := filepath.Rel(dirs[0], string(failed))
+	if _, err := os.Stat(filepath.Join(dirs[1], rel)); err != nil {
+		t.Error("Failed file was not retried", err)
+	}
+	if _, err := os.Stat(filepath.Join(dirs[0], RetryQueueFileName)); !os.IsNotExist(err) {
+		t.Error("Retry queue should be empty", err)
+	}
+}
+
+func TestBackupFanOutRetriesOnlyDueDestinations(t *testing.T) {
+	dirs, err := createTestBackupDirectories(3, 0)
+	if err != nil {
+		t.Fatal("Failed to create test Directories", err)
+	}
+	notDue, err := os.MkdirTemp("", "tstDir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirs = append(dirs, notDue)
+	defer func() {
+		for i := range dirs {
+			os.RemoveAll(dirs[i])
+		}
+	}()
+	_ = recalcTestDirectory(dirs[0])
+
+	xc := XMLCfg{}
+	opts := BackupOptions{CreateLabelIfMissing: true}
+	vc, err := xc.VolumeCfgFromDir(notDue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := xc.SetSchedule(vc.Label, "daily"); err != nil {
+		t.Fatal(err)
+	}
+	if err := xc.recordLastBackup(notDue, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// A copy that failed last time to each destination
+	entries, err := os.ReadDir(dirs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var failed Fpath
+	for _, entry := range entries {
+		if !IsMedorgFile(entry.Name()) {
+			failed = NewFpath(dirs[0], entry.Name())
+			break
+		}
+	}
+	rq, err := NewRetryQueue(dirs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, destDir := range dirs[1:] {
+		rq.Add(failed, destDir, checksumOf(failed, DefaultMetadataOptions()))
+	}
+	for i := range rq.Entries {
+		rq.Entries[i].LastAttemptTime = time.Now().Add(-time.Hour).Unix()
+	}
+	if err := rq.Persist(); err != nil {
+		t.Fatal(err)
+	}
+
+	var notDueCopies int32
+	fc := func(src, dst Fpath) error {
+		if isWithin(string(dst), notDue) {
+			atomic.AddInt32(&notDueCopies, 1)
+		}
+		return CopyFile(src, dst)
+	}
+	reports, err := BackupRunnerFanOutWithReport(context.Background(), &xc, opts, 2, fc, dirs[0], dirs[1:], nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&notDueCopies) != 0 {
+		t.Error("Copied to a destination that was not due")
+	}
+	if reports[1].Status != BackupReportNotDue {
+		t.Error("Expected the destination to be reported not due, got", reports[1].Status)
+	}
+	// The retried copy is counted along with the rest
+	if reports[0].FilesCopied != 3 {
+		t.Error("Expected 3 files copied, got", reports[0].FilesCopied)
+	}
+}
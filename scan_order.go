@@ -0,0 +1,222 @@
+package medorg
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ScanOrder says in which order the directories of a tree are walked
+type ScanOrder int
+
+const (
+	// ScanOrderName walks depth first in lexical order, as filepath.WalkDir does
+	ScanOrderName ScanOrder = iota
+	// ScanOrderMtimeDesc walks the most recently modified directories found so far first,
+	// wherever they are in the tree, so that (say) today's photo import is scanned
+	// before the old archives. Several directories are read at once.
+	ScanOrderMtimeDesc
+)
+
+// ErrBadScanOrder the scan order string is not one we understand
+var ErrBadScanOrder = errors.New("unknown scan order")
+
+func (so ScanOrder) String() string {
+	switch so {
+	case ScanOrderMtimeDesc:
+		return "mtime-desc"
+	default:
+		return "name"
+	}
+}
+
+// ParseScanOrder turns "name" or "mtime-desc" into a scan order
+func ParseScanOrder(str string) (ScanOrder, error) {
+	for _, so := range []ScanOrder{ScanOrderName, ScanOrderMtimeDesc} {
+		if so.String() == str {
+			return so, nil
+		}
+	}
+	return ScanOrderName, fmt.Errorf("%w::%s", ErrBadScanOrder, str)
+}
+
+// scanWorkers is how many directories a mtime-desc walk reads at once
+var scanWorkers = 4
+
+// dirQueueItem is a directory waiting to be walked
+type dirQueueItem struct {
+	path  string
+	d     fs.DirEntry
+	mtime int64
+}
+
+// dirQueue is a heap of the directories waiting to be walked,
+// the most recently modified on top
+type dirQueue []dirQueueItem
+
+func (dq dirQueue) Len() int           { return len(dq) }
+func (dq dirQueue) Less(i, j int) bool { return dq[i].mtime > dq[j].mtime }
+func (dq dirQueue) Swap(i, j int)      { dq[i], dq[j] = dq[j], dq[i] }
+func (dq *dirQueue) Push(x any)        { *dq = append(*dq, x.(dirQueueItem)) }
+func (dq *dirQueue) Pop() any {
+	old := *dq
+	item := old[len(old)-1]
+	*dq = old[:len(old)-1]
+	return item
+}
+
+// priorityWalk walks a tree most recently modified directory first,
+// wherever in the tree it is, with scanWorkers reading directories at once
+type priorityWalk struct {
+	fn fs.WalkDirFunc
+	// lock protects the queue, and everything after it
+	lock    sync.Mutex
+	cond    *sync.Cond
+	queue   dirQueue
+	pending int // Directories queued, or being walked
+	stopped bool
+	err     error
+	// fnLock means fn is only called for one directory at a time, so it
+	// need not be safe for concurrent use, and sees each directory's files together
+	fnLock sync.Mutex
+}
+
+func (pw *priorityWalk) push(path string, d fs.DirEntry) {
+	var mtime int64
+	if info, err := d.Info(); err == nil {
+		mtime = info.ModTime().UnixNano()
+	}
+	pw.lock.Lock()
+	heap.Push(&pw.queue, dirQueueItem{path: path, d: d, mtime: mtime})
+	pw.pending++
+	pw.lock.Unlock()
+	pw.cond.Signal()
+}
+
+// pop the most recently modified directory, waiting for one if need be
+// Returns false once there is nothing left to walk
+func (pw *priorityWalk) pop() (dirQueueItem, bool) {
+	pw.lock.Lock()
+	defer pw.lock.Unlock()
+	for len(pw.queue) == 0 && pw.pending > 0 && !pw.stopped {
+		pw.cond.Wait()
+	}
+	if pw.stopped || len(pw.queue) == 0 {
+		return dirQueueItem{}, false
+	}
+	return heap.Pop(&pw.queue).(dirQueueItem), true
+}
+
+// done marks a directory as walked, stopping the walk on an error
+func (pw *priorityWalk) done(err error) {
+	pw.lock.Lock()
+	defer pw.lock.Unlock()
+	pw.pending--
+	if err != nil && !pw.stopped {
+		pw.stopped = true
+		pw.err = err
+	}
+	if pw.pending == 0 || pw.stopped {
+		pw.cond.Broadcast()
+	}
+}
+
+func (pw *priorityWalk) isStopped() bool {
+	pw.lock.Lock()
+	defer pw.lock.Unlock()
+	return pw.stopped
+}
+
+// walkDir passes the directory then its files to fn, in name order,
+// so that a .mdSkipDir is seen before anything below it is walked.
+// The subdirectories go on the queue.
+func (pw *priorityWalk) walkDir(item dirQueueItem) error {
+	entries, readErr := os.ReadDir(item.path)
+	var dirs []fs.DirEntry
+	err := func() error {
+		pw.fnLock.Lock()
+		defer pw.fnLock.Unlock()
+		if pw.isStopped() {
+			return nil
+		}
+		if err := pw.fn(item.path, item.d, nil); err != nil {
+			return err
+		}
+		if readErr != nil {
+			// Second call, to report the ReadDir error
+			if err := pw.fn(item.path, item.d, readErr); err != nil {
+				return err
+			}
+		}
+		for _, d := range entries {
+			if d.IsDir() {
+				dirs = append(dirs, d)
+				continue
+			}
+			if err := pw.fn(filepath.Join(item.path, d.Name()), d, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+	if err == filepath.SkipDir {
+		// Skip the rest of the directory, and everything below it
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, d := range dirs {
+		pw.push(filepath.Join(item.path, d.Name()), d)
+	}
+	return nil
+}
+
+// walkDirPriority is filepath.WalkDir, but walking the most recently
+// modified directories first, wherever they are in the tree
+func walkDirPriority(root string, fn fs.WalkDirFunc) error {
+	info, err := os.Lstat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	if !info.IsDir() {
+		return fn(root, fs.FileInfoToDirEntry(info), nil)
+	}
+	pw := &priorityWalk{fn: fn}
+	pw.cond = sync.NewCond(&pw.lock)
+	pw.push(root, fs.FileInfoToDirEntry(info))
+	var wg sync.WaitGroup
+	for i := 0; i < scanWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				item, ok := pw.pop()
+				if !ok {
+					return
+				}
+				pw.done(pw.walkDir(item))
+			}
+		}()
+	}
+	wg.Wait()
+	return pw.err
+}
+
+// walkDirOrdered is filepath.WalkDir, but walking the directories in the requested order
+func walkDirOrdered(root string, order ScanOrder, fn fs.WalkDirFunc) error {
+	var err error
+	if order == ScanOrderMtimeDesc {
+		err = walkDirPriority(root, fn)
+	} else {
+		err = filepath.WalkDir(root, fn)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,163 @@
+package medorg
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestWalkDirOrderedMtimeDesc(t *testing.T) {
+	// One worker, so the order is exact
+	defer func(workers int) { scanWorkers = workers }(scanWorkers)
+	scanWorkers = 1
+	root, err := os.MkdirTemp("", "scanOrder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	now := time.Now()
+	ages := map[string]time.Duration{"archive": 10 * 365 * 24 * time.Hour, "lastyear": 365 * 24 * time.Hour, "today": 0}
+	for dir := range ages {
+		if err := os.WriteFile(filepath.Join(root, dir+".txt"), []byte(dir), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Mkdir(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for dir, age := range ages {
+		if err := os.Chtimes(filepath.Join(root, dir), now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	walk := func(order ScanOrder) []string {
+		var visited []string
+		err := walkDirOrdered(root, order, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if path != root {
+				rel, _ := filepath.Rel(root, path)
+				visited = append(visited, rel)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return visited
+	}
+	expected := []string{"archive", "archive.txt", "lastyear", "lastyear.txt", "today", "today.txt"}
+	if got := walk(ScanOrderName); fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Error("Name order expected", expected, "got", got)
+	}
+	// Files first, then the newest directories
+	expected = []string{"archive.txt", "lastyear.txt", "today.txt", "today", "lastyear", "archive"}
+	if got := walk(ScanOrderMtimeDesc); fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Error("Mtime order expected", expected, "got", got)
+	}
+
+	if _, err := ParseScanOrder("backwards"); err == nil {
+		t.Error("Expected an error for an unknown order")
+	}
+}
+
+// mkdirAged makes the directories, each modified age ago
+func mkdirAged(t *testing.T, root string, ages map[string]time.Duration) {
+	t.Helper()
+	now := time.Now()
+	for dir := range ages {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for dir, age := range ages {
+		if err := os.Chtimes(filepath.Join(root, dir), now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWalkDirOrderedMtimeDescDeep(t *testing.T) {
+	defer func(workers int) { scanWorkers = workers }(scanWorkers)
+	scanWorkers = 1
+	root := t.TempDir()
+	year := 365 * 24 * time.Hour
+	// A new directory under an old one, beside a newer one with old directories under it
+	mkdirAged(t, root, map[string]time.Duration{
+		"a":         5 * year,
+		"a/today":   0,
+		"a/ancient": 10 * year,
+		"b":         year,
+		"b/old":     20 * year,
+	})
+	var visited []string
+	err := walkDirOrdered(root, ScanOrderMtimeDesc, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != root {
+			rel, _ := filepath.Rel(root, path)
+			visited = append(visited, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Depth first, b/old would be walked before anything in a
+	expected := []string{"b", "a", "a/today", "a/ancient", "b/old"}
+	if fmt.Sprint(visited) != fmt.Sprint(expected) {
+		t.Error("Expected", expected, "got", visited)
+	}
+}
+
+func TestWalkDirOrderedMtimeDescParallel(t *testing.T) {
+	root := t.TempDir()
+	var expected []string
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 10; j++ {
+			dir := filepath.Join(root, fmt.Sprint("d", i), fmt.Sprint("d", j))
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "file"), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			expected = append(expected, filepath.Join(dir, "file"))
+		}
+	}
+	// fn is never called for two directories at once, so needs no lock
+	var visited []string
+	err := walkDirOrdered(root, ScanOrderMtimeDesc, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			visited = append(visited, path)
+		}
+		if d.Name() == "d5" && d.IsDir() && filepath.Dir(path) == root {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(visited)
+	var notSkipped []string
+	for _, path := range expected {
+		if !isWithin(path, filepath.Join(root, "d5")) {
+			notSkipped = append(notSkipped, path)
+		}
+	}
+	sort.Strings(notSkipped)
+	if fmt.Sprint(visited) != fmt.Sprint(notSkipped) {
+		t.Error("Expected", len(notSkipped), "files, got", len(visited))
+	}
+}
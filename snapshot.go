@@ -0,0 +1,108 @@
+package medorg
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+)
+
+// SnapshotFile is a single file as recorded in a snapshot
+type SnapshotFile struct {
+	Path     string `json:"path"`
+	Checksum string `json:"checksum"`
+	Size     int64  `json:"size"`
+	Mtime    int64  `json:"mtime"`
+}
+
+// SnapshotDiff is what has changed between two snapshots
+type SnapshotDiff struct {
+	Added    []SnapshotFile
+	Removed  []SnapshotFile
+	Modified []SnapshotFile
+}
+
+// TakeSnapshot records the metadata for every file in the directories
+// into a single file, so that we can later see what has changed.
+// This only reads the existing .medorg.xml files, so run a
+// check_calc first if you want the checksums to be up to date
+func TakeSnapshot(dirs []string, snapshotPath string, opts MetadataOptions) error {
+	var files []SnapshotFile
+	dirFc := func(dir string, dm DirectoryMap) error {
+		fc := func(fn string, fs FileStruct) error {
+			files = append(files, SnapshotFile{
+				Path:     string(NewFpath(dir, fn)),
+				Checksum: fs.Checksum,
+				Size:     fs.Size,
+				Mtime:    fs.Mtime,
+			})
+			return nil
+		}
+		return dm.rangeMap(fc)
+	}
+	for _, dir := range dirs {
+		err := walkDirectoryMaps(dir, opts, dirFc)
+		if err != nil {
+			return err
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	data, err := json.MarshalIndent(files, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(snapshotPath, data, 0600)
+}
+
+func loadSnapshot(snapshotPath string) (map[string]SnapshotFile, error) {
+	data, err := os.ReadFile(snapshotPath)
+	if err != nil {
+		return nil, err
+	}
+	var files []SnapshotFile
+	err = json.Unmarshal(data, &files)
+	if err != nil {
+		return nil, err
+	}
+	mp := make(map[string]SnapshotFile, len(files))
+	for _, f := range files {
+		mp[f.Path] = f
+	}
+	return mp, nil
+}
+
+// CompareSnapshots loads two snapshots and reports
+// what has been added, removed and modified going from snap1 to snap2
+func CompareSnapshots(snap1, snap2 string) (SnapshotDiff, error) {
+	var diff SnapshotDiff
+	before, err := loadSnapshot(snap1)
+	if err != nil {
+		return diff, err
+	}
+	after, err := loadSnapshot(snap2)
+	if err != nil {
+		return diff, err
+	}
+	for path, af := range after {
+		bf, ok := before[path]
+		if !ok {
+			diff.Added = append(diff.Added, af)
+			continue
+		}
+		if af.Checksum != bf.Checksum || af.Size != bf.Size || af.Mtime != bf.Mtime {
+			diff.Modified = append(diff.Modified, af)
+		}
+	}
+	for path, bf := range before {
+		if _, ok := after[path]; !ok {
+			diff.Removed = append(diff.Removed, bf)
+		}
+	}
+	for _, sl := range [][]SnapshotFile{diff.Added, diff.Removed, diff.Modified} {
+		sort.Slice(sl, func(i, j int) bool {
+			return sl[i].Path < sl[j].Path
+		})
+	}
+	return diff, nil
+}
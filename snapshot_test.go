@@ -0,0 +1,61 @@
+package medorg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotCompare(t *testing.T) {
+	wkDir, err := ioutil.TempDir("", "snapTest")
+	if err != nil {
+		t.Error("TmpDir Error:", err)
+	}
+	defer os.RemoveAll(wkDir)
+	srcDir := filepath.Join(wkDir, "src")
+	err = os.Mkdir(srcDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dm := NewDirectoryMap()
+	dm.Add(FileStruct{Name: "keep", Checksum: "abc", Size: 1, directory: srcDir})
+	dm.Add(FileStruct{Name: "change", Checksum: "def", Size: 2, directory: srcDir})
+	dm.Add(FileStruct{Name: "remove", Checksum: "ghi", Size: 3, directory: srcDir})
+	err = dm.Persist(srcDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap1 := filepath.Join(wkDir, "snap1.json")
+	err = TakeSnapshot([]string{srcDir}, snap1, DefaultMetadataOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dm.Rm("remove")
+	dm.Add(FileStruct{Name: "change", Checksum: "xyz", Size: 2, directory: srcDir})
+	dm.Add(FileStruct{Name: "add", Checksum: "jkl", Size: 4, directory: srcDir})
+	err = dm.Persist(srcDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap2 := filepath.Join(wkDir, "snap2.json")
+	err = TakeSnapshot([]string{srcDir}, snap2, DefaultMetadataOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := CompareSnapshots(snap1, snap2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(name string, files []SnapshotFile, expected string) {
+		if len(files) != 1 || files[0].Path != filepath.Join(srcDir, expected) {
+			t.Error("Unexpected", name, files)
+		}
+	}
+	check("added", diff.Added, "add")
+	check("removed", diff.Removed, "remove")
+	check("modified", diff.Modified, "change")
+}
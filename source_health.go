@@ -0,0 +1,102 @@
+package medorg
+
+import (
+	"time"
+)
+
+// HealthWarningKind is what sort of problem a HealthWarning is about
+type HealthWarningKind int
+
+const (
+	// HealthUnreadable the source's metadata could not be read
+	HealthUnreadable HealthWarningKind = iota
+	// HealthStale nothing in the source has been modified in a long time
+	HealthStale
+	// HealthOnDestination the source is on a labelled backup destination
+	HealthOnDestination
+)
+
+// HealthWarning describes a problem found with a source directory
+// before we start a backup from it
+type HealthWarning struct {
+	Kind         HealthWarningKind
+	Dir          string
+	LastModified time.Time
+	Reason       string
+}
+
+func (hw HealthWarning) String() string {
+	if hw.LastModified.IsZero() {
+		return hw.Dir + ": " + hw.Reason
+	}
+	return hw.Dir + ": " + hw.Reason + ", last modified " + hw.LastModified.Format("2006-01-02")
+}
+
+// newestMtime looks at the recorded Mtime of every file
+// Note this does not calculate anything, so a directory that has
+// never been scanned will look like it has nothing in it
+func newestMtime(directory string, opts MetadataOptions) (int64, error) {
+	var newest int64
+	dirFc := func(dir string, dm DirectoryMap) error {
+		fc := func(fn string, fs FileStruct) error {
+			if fs.Mtime > newest {
+				newest = fs.Mtime
+			}
+			return nil
+		}
+		return dm.rangeMap(fc)
+	}
+	err := walkDirectoryMaps(directory, opts, dirFc)
+	return newest, err
+}
+
+// SourceHealthCheck looks at each source directory and warns
+// if nothing in it has been modified in the last maxStaleDays
+// A source that has not changed in a long time may no longer be in use
+// or may have been accidentally unmounted. A source with no recorded
+// mtimes, because it is empty or has never been scanned, is not warned about,
+// as we cannot tell how old it is.
+func SourceHealthCheck(srcDirs []string, maxStaleDays int, opts MetadataOptions) []HealthWarning {
+	var warnings []HealthWarning
+	cutoff := time.Now().AddDate(0, 0, -maxStaleDays)
+	for _, dir := range srcDirs {
+		newest, err := newestMtime(dir, opts)
+		if err != nil {
+			warnings = append(warnings, HealthWarning{Kind: HealthUnreadable, Dir: dir, Reason: err.Error()})
+			continue
+		}
+		if newest == 0 {
+			continue
+		}
+		lastModified := time.Unix(newest, 0)
+		if lastModified.Before(cutoff) {
+			warnings = append(warnings, HealthWarning{
+				Kind:         HealthStale,
+				Dir:          dir,
+				LastModified: lastModified,
+				Reason:       "stale source",
+			})
+		}
+	}
+	return warnings
+}
+
+// SourceLabelCheck warns about each source directory that is on a labelled
+// backup destination, i.e. has a volume label in it or a directory above.
+// Backing up a backup may be a mistake, and can end up in a loop,
+// though some do mean to back up from a drive that was once a destination.
+func SourceLabelCheck(srcDirs []string) []HealthWarning {
+	var warnings []HealthWarning
+	for _, dir := range srcDirs {
+		vc, err := ReadVolumeCfg(dir)
+		if err != nil {
+			continue
+		}
+		warnings = append(warnings, HealthWarning{
+			Kind:   HealthOnDestination,
+			Dir:    dir,
+			Reason: "path appears to be on a labeled backup destination volume (label: " + vc.Label + ")",
+		})
+	}
+	return warnings
+}
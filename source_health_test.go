@@ -0,0 +1,82 @@
+package medorg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSourceHealthCheck(t *testing.T) {
+	wkDir, err := ioutil.TempDir("", "healthTest")
+	if err != nil {
+		t.Error("TmpDir Error:", err)
+	}
+	defer os.RemoveAll(wkDir)
+
+	// Nothing scanned yet, so we cannot say it is stale
+	if warnings := SourceHealthCheck([]string{wkDir}, 60, DefaultMetadataOptions()); len(warnings) != 0 {
+		t.Error("Expected no warnings for an unscanned source, got:", warnings)
+	}
+
+	dm := NewDirectoryMap()
+	old := time.Now().AddDate(0, 0, -100).Unix()
+	dm.Add(FileStruct{Name: "old", Checksum: "abc", Mtime: old, directory: wkDir})
+	err = dm.Persist(wkDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	warnings := SourceHealthCheck([]string{wkDir}, 60, DefaultMetadataOptions())
+	if len(warnings) != 1 {
+		t.Fatal("Expected a single warning, got:", warnings)
+	}
+	if warnings[0].Kind != HealthStale || warnings[0].Reason != "stale source" {
+		t.Error("Unexpected reason:", warnings[0].Reason)
+	}
+	if warnings[0].LastModified.Unix() != old {
+		t.Error("Wrong last modified time", warnings[0].LastModified)
+	}
+
+	dm.Add(FileStruct{Name: "new", Checksum: "def", Mtime: time.Now().Unix(), directory: wkDir})
+	err = dm.Persist(wkDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	warnings = SourceHealthCheck([]string{wkDir}, 60, DefaultMetadataOptions())
+	if len(warnings) != 0 {
+		t.Error("Expected no warnings, got:", warnings)
+	}
+}
+
+func TestSourceLabelCheck(t *testing.T) {
+	wkDir := t.TempDir()
+	srcDir := filepath.Join(wkDir, "photos", "2024")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if warnings := SourceLabelCheck([]string{srcDir}); len(warnings) != 0 {
+		t.Error("Expected no warnings before labelling, got:", warnings)
+	}
+	// Label a directory above the source, as a backup destination would be
+	xc := XMLCfg{}
+	vc, err := xc.VolumeCfgFromDir(wkDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vc.Persist(); err != nil {
+		t.Fatal(err)
+	}
+	warnings := SourceLabelCheck([]string{srcDir})
+	if len(warnings) != 1 {
+		t.Fatal("Expected a single warning, got:", warnings)
+	}
+	if warnings[0].Kind != HealthOnDestination {
+		t.Error("Unexpected kind:", warnings[0].Kind)
+	}
+	if !strings.Contains(warnings[0].String(), "(label: "+vc.Label+")") {
+		t.Error("Expected the warning to name the label, got:", warnings[0])
+	}
+}
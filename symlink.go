@@ -0,0 +1,120 @@
+package medorg
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SymlinkPolicy says what to do with a symlink to a directory
+// Symlinks to files are always treated as the file they point to.
+//
+// Note filepath.WalkDir never follows symlinks itself, on any platform,
+// so without SymlinkFollow a symlinked directory is never descended into.
+// On Windows, junctions and directory symlinks are both treated as symlinks.
+type SymlinkPolicy int
+
+const (
+	// SymlinkReport logs the symlink, but does not descend into it
+	SymlinkReport SymlinkPolicy = iota
+	// SymlinkSkip silently ignores the symlink
+	SymlinkSkip
+	// SymlinkFollow descends into the directory as if it were a normal directory
+	// Directories we have already visited are not visited again, so cycles are safe
+	SymlinkFollow
+)
+
+// ErrBadSymlinkPolicy the policy string is not one we understand
+var ErrBadSymlinkPolicy = errors.New("unknown symlink policy")
+
+func (sp SymlinkPolicy) String() string {
+	switch sp {
+	case SymlinkSkip:
+		return "skip"
+	case SymlinkFollow:
+		return "follow"
+	default:
+		return "report"
+	}
+}
+
+// ParseSymlinkPolicy turns "follow", "skip" or "report" into a policy
+func ParseSymlinkPolicy(str string) (SymlinkPolicy, error) {
+	for _, sp := range []SymlinkPolicy{SymlinkReport, SymlinkSkip, SymlinkFollow} {
+		if sp.String() == str {
+			return sp, nil
+		}
+	}
+	return SymlinkReport, fmt.Errorf("%w::%s", ErrBadSymlinkPolicy, str)
+}
+
+// isWithin returns true if path is dir, or below it
+func isWithin(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// walkDirSymlinks is filepath.WalkDir, but handling symlinks to directories
+// according to the policy. When following, the paths passed to fn are
+// those through the symlink, rather than where the directory really is.
+// report controls whether we log the symlinks we do not follow.
+// Each directory's entries are walked in the requested order.
+func walkDirSymlinks(root string, policy SymlinkPolicy, order ScanOrder, report bool, fn fs.WalkDirFunc) error {
+	// The real directories we have walked, so that we don't walk them twice
+	var visited []string
+	if realRoot, err := filepath.EvalSymlinks(root); err == nil {
+		visited = append(visited, realRoot)
+	}
+	var walker func(linkPath, realPath string) fs.WalkDirFunc
+	walker = func(linkPath, realPath string) fs.WalkDirFunc {
+		return func(path string, d fs.DirEntry, err error) error {
+			if linkPath != realPath {
+				rel, relErr := filepath.Rel(realPath, path)
+				if relErr != nil {
+					return relErr
+				}
+				path = filepath.Join(linkPath, rel)
+			}
+			if err != nil || d.Type()&fs.ModeSymlink == 0 {
+				return fn(path, d, err)
+			}
+			info, statErr := os.Stat(path)
+			if statErr != nil {
+				return fn(path, d, err)
+			}
+			if !info.IsDir() {
+				// A symlink to a file is just a file, so describe it by the
+				// file, else we'd record the link's own size and mode
+				return fn(path, fs.FileInfoToDirEntry(info), nil)
+			}
+			switch policy {
+			case SymlinkSkip:
+				return nil
+			case SymlinkReport:
+				if report {
+					log.Println("Not following symlink to directory:", path)
+				}
+				return nil
+			}
+			target, err := filepath.EvalSymlinks(path)
+			if err != nil {
+				return fn(path, d, err)
+			}
+			for _, v := range visited {
+				// If either contains the other, we'd be walking the same files again
+				if isWithin(target, v) || isWithin(v, target) {
+					if report {
+						log.Println("Already visited, not following symlink:", path)
+					}
+					return nil
+				}
+			}
+			visited = append(visited, target)
+			return walkDirOrdered(target, order, walker(path, target))
+		}
+	}
+	return walkDirOrdered(root, order, walker(root, root))
+}
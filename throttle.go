@@ -0,0 +1,60 @@
+package medorg
+
+import (
+	"sync"
+	"time"
+)
+
+// Throttle paces copies to a maximum number of bytes a second
+// It is a token bucket holding up to a second's worth of bytes, shared
+// by everything writing to it, so two copies in parallel get half each.
+// Wait on it from the progress callback of CopyFileWithProgress.
+type Throttle struct {
+	lock   sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// NewThrottle returns a throttle to bytesPerSecond
+// or nil, which does not throttle, if bytesPerSecond is not positive
+func NewThrottle(bytesPerSecond int64) *Throttle {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &Throttle{
+		rate:   float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until we are allowed to move n more bytes
+func (th *Throttle) Wait(n int) {
+	if th == nil {
+		return
+	}
+	th.lock.Lock()
+	now := time.Now()
+	th.tokens += now.Sub(th.last).Seconds() * th.rate
+	if th.tokens > th.rate {
+		th.tokens = th.rate
+	}
+	th.last = now
+	// Take the bytes now, going into debt if need be,
+	// and sleep (without the lock) until the debt is paid off
+	th.tokens -= float64(n)
+	var delay time.Duration
+	if th.tokens < 0 {
+		delay = time.Duration(-th.tokens / th.rate * float64(time.Second))
+	}
+	th.lock.Unlock()
+	time.Sleep(delay)
+}
+
+// ThrottledCopier is CopyFile, paced by th
+func ThrottledCopier(th *Throttle) FileCopier {
+	return func(src, dst Fpath) error {
+		return CopyFileWithProgress(src, dst, func(n int64) { th.Wait(int(n)) })
+	}
+}
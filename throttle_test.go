@@ -0,0 +1,43 @@
+package medorg
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	if NewThrottle(0) != nil {
+		t.Error("A zero rate should not throttle")
+	}
+	// A nil throttle lets everything straight through
+	var th *Throttle
+	start := time.Now()
+	th.Wait(1 << 30)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("A nil throttle held us up for", elapsed)
+	}
+
+	// 1MB/s, with a second's worth available straight away
+	th = NewThrottle(1 << 20)
+	start = time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 24; j++ {
+				th.Wait(32 << 10)
+			}
+		}()
+	}
+	wg.Wait()
+	// 1.5MB between the two of them, so the last 0.5MB takes 0.5s
+	elapsed := time.Since(start)
+	if elapsed < 450*time.Millisecond {
+		t.Error("Throttle let 1.5MB through in", elapsed)
+	}
+	if elapsed > 2*time.Second {
+		t.Error("Throttle too slow, took", elapsed)
+	}
+}
@@ -0,0 +1,262 @@
+package medorg
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"math/rand"
+	"os"
+	"sync"
+)
+
+// VerificationSchedule controls how much of a backup we re-check
+// each time we are asked to verify it
+type VerificationSchedule struct {
+	// Fraction of the files on the destination to check
+	SampleFraction float32 `xml:"fraction,attr,omitempty"`
+	// Never check more than this many files, 0 for no limit
+	MaxSampleSize int `xml:"max,attr,omitempty"`
+	// When we last did a verification
+	LastVerifiedAt int64 `xml:"last,attr,omitempty"`
+}
+
+// DefaultVerificationSchedule is used if the config does not specify one
+var DefaultVerificationSchedule = VerificationSchedule{SampleFraction: 0.01, MaxSampleSize: 1000}
+
+// VerifyMismatch is a file on a backup whose contents are not what we recorded
+type VerifyMismatch struct {
+	Path     Fpath
+	Expected string
+	Actual   string // Empty if the file could not be read
+	Err      error
+}
+
+func (vm VerifyMismatch) String() string {
+	if vm.Err != nil {
+		return string(vm.Path) + ": " + vm.Err.Error()
+	}
+	return string(vm.Path) + ": expected " + vm.Expected + " got " + vm.Actual
+}
+
+// sampleSize is how many of numFiles we should check
+func (vs VerificationSchedule) sampleSize(numFiles int) int {
+	n := int(vs.SampleFraction * float32(numFiles))
+	if n < 1 && vs.SampleFraction > 0 && numFiles > 0 {
+		// However small the sample, check something
+		n = 1
+	}
+	if vs.MaxSampleSize > 0 && n > vs.MaxSampleSize {
+		n = vs.MaxSampleSize
+	}
+	if n > numFiles {
+		n = numFiles
+	}
+	return n
+}
+
+// VerifyBackup re-calculates the checksum of a random sample of the files
+// in destDir and compares them against the checksum recorded in the .medorg.xml
+// A schedule with a SampleFraction of 1 and no MaxSampleSize checks everything.
+// Returns any mismatches found and the number of files checked.
+func VerifyBackup(destDir string, vs VerificationSchedule, opts MetadataOptions) ([]VerifyMismatch, int, error) {
+	var candidates []FileStruct
+	dirFc := func(dir string, dm DirectoryMap) error {
+		fc := func(fn string, fs FileStruct) error {
+			if fs.Checksum == "" {
+				return nil
+			}
+			fs.directory = dir
+			candidates = append(candidates, fs)
+			return nil
+		}
+		return dm.rangeMap(fc)
+	}
+	err := walkDirectoryMaps(destDir, opts, dirFc)
+	if err != nil {
+		return nil, 0, err
+	}
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	candidates = candidates[:vs.sampleSize(len(candidates))]
+
+	var mismatches []VerifyMismatch
+	for _, fs := range candidates {
+		fp := NewFpath(fs.directory, fs.Name)
+		cks, err := CalcMd5File(fs.directory, fs.Name)
+		if errors.Is(err, os.ErrNotExist) {
+			// Not a mismatch as such, but the backup is not what we thought it was
+			mismatches = append(mismatches, VerifyMismatch{Path: fp, Expected: fs.Checksum, Err: err})
+			continue
+		}
+		if err != nil {
+			return mismatches, len(candidates), err
+		}
+		if cks != fs.Checksum {
+			mismatches = append(mismatches, VerifyMismatch{Path: fp, Expected: fs.Checksum, Actual: cks})
+		}
+	}
+	return mismatches, len(candidates), nil
+}
+
+// VerifySchedule returns the verification schedule from the config
+// or the default if none has been set
+func (xc *XMLCfg) VerifySchedule() VerificationSchedule {
+	if xc.Verification == nil {
+		return DefaultVerificationSchedule
+	}
+	return *xc.Verification
+}
+
+// SourceVerifyReport is the result of checking a backup against the source it was made from
+type SourceVerifyReport struct {
+	// OK is how many source files have an intact copy on the destination
+	OK int
+	// Missing are the source files tagged as on the destination, that are not there
+	Missing []Fpath
+	// Corrupt are the copies on the destination that do not match the source
+	Corrupt []VerifyMismatch
+	// Untagged are the files on the destination no source file says are backed up there
+	Untagged []Fpath
+}
+
+// VerifyAgainstSource checks every file in srcDir tagged with destDir's volume label
+// has an intact copy on destDir.
+// The checksum of every file on destDir is validated first, as with
+// check_calc -validate, so its .medorg.xml files are up to date.
+// Only the .medorg.xml files are used to find the source files, so run
+// check_calc on srcDir first. destDir must already be labelled.
+func VerifyAgainstSource(srcDir, destDir string, opts MetadataOptions) (SourceVerifyReport, error) {
+	var report SourceVerifyReport
+	label, err := readVolumeLabel(destDir)
+	if err != nil {
+		return report, err
+	}
+	dv, err := validateDestination(context.Background(), destDir, opts)
+	if err != nil {
+		return report, err
+	}
+	destIndex := NewDuplicateIndex()
+	var destFiles []FileStruct
+	destFc := func(dir string, dm DirectoryMap) error {
+		return dm.rangeMap(func(fn string, fs FileStruct) error {
+			if fn == volumeLabelFileName || fn == DestIndexFileName || fn == BackupReportFileName || fs.Checksum == "" {
+				return nil
+			}
+			destIndex.Add(fs)
+			destFiles = append(destFiles, fs)
+			return nil
+		})
+	}
+	if err := walkDirectoryMaps(destDir, opts, destFc); err != nil {
+		return report, err
+	}
+
+	// Several source files may share a copy, so only check each copy once
+	checked := make(map[backupKey]*VerifyMismatch)
+	srcFc := func(dir string, dm DirectoryMap) error {
+		return dm.rangeMap(func(fn string, fs FileStruct) error {
+			if !fs.HasBackupOn(label) {
+				return nil
+			}
+			key := backupKey{fs.Size, fs.Checksum}
+			mismatch, done := checked[key]
+			if !done {
+				mismatch = dv.verifyCopy(destIndex, fs)
+				checked[key] = mismatch
+			}
+			switch {
+			case mismatch == nil:
+				report.OK++
+			case mismatch.Err != nil:
+				report.Missing = append(report.Missing, fs.Path())
+			default:
+				report.Corrupt = append(report.Corrupt, *mismatch)
+			}
+			return nil
+		})
+	}
+	if err := walkDirectoryMaps(srcDir, opts, srcFc); err != nil {
+		return report, err
+	}
+	// A corrupt copy no longer matches anything, but is not untagged
+	corrupt := make(map[Fpath]struct{}, len(report.Corrupt))
+	for _, vm := range report.Corrupt {
+		corrupt[vm.Path] = struct{}{}
+	}
+	for _, fs := range destFiles {
+		if _, ok := corrupt[fs.Path()]; ok {
+			continue
+		}
+		if _, ok := checked[backupKey{fs.Size, fs.Checksum}]; !ok {
+			report.Untagged = append(report.Untagged, fs.Path())
+		}
+	}
+	return report, nil
+}
+
+// destValidation is what we found validating a destination's checksums
+type destValidation struct {
+	lock sync.Mutex
+	// validated are the files whose checksum we have just checked
+	validated map[Fpath]struct{}
+	// rotted are the files whose checksum had changed, by what it was before
+	rotted map[backupKey]VerifyMismatch
+}
+
+// validateDestination validates the checksum of every file in destDir
+// updating its metadata, and noting the files whose contents had changed
+func validateDestination(ctx context.Context, destDir string, opts MetadataOptions) (*destValidation, error) {
+	dv := &destValidation{
+		validated: make(map[Fpath]struct{}),
+		rotted:    make(map[backupKey]VerifyMismatch),
+	}
+	visitor := func(dm DirectoryMap, dir, fn string, d fs.DirEntry, fileStruct FileStruct, fileInfo fs.FileInfo) error {
+		fc := func(fs *FileStruct) error {
+			before := backupKey{fs.Size, fs.Checksum}
+			err := fs.ValidateChecksum()
+			if errors.Is(err, ErrRecalced) {
+				err = nil
+				if before.checksum != "" {
+					dv.lock.Lock()
+					dv.rotted[before] = VerifyMismatch{Path: fs.Path(), Expected: before.checksum, Actual: fs.Checksum}
+					dv.lock.Unlock()
+				}
+			}
+			if err != nil {
+				return err
+			}
+			dv.lock.Lock()
+			dv.validated[fs.Path()] = struct{}{}
+			dv.lock.Unlock()
+			return nil
+		}
+		return dm.RunFsFc(dir, fn, fc)
+	}
+	walkOpts := DefaultDirTrackerOptions()
+	walkOpts.Metadata = opts
+	var firstErr error
+	for err := range errHandler(autoVisitFilesInDirectories(ctx, []string{destDir}, walkOpts, visitor), nil) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return dv, firstErr
+}
+
+// verifyCopy finds the copy of fs on the validated destination
+// Returns nil if the copy is intact. A missing copy is reported with an Err.
+func (dv *destValidation) verifyCopy(destIndex *DuplicateIndex, fs FileStruct) *VerifyMismatch {
+	key := backupKey{fs.Size, fs.Checksum}
+	if fp, ok := destIndex.Lookup(fs.Checksum, fs.Size); ok {
+		if _, ok := dv.validated[fp]; ok {
+			return nil
+		}
+		// Recorded, but the file was not there to validate
+		return &VerifyMismatch{Path: fs.Path(), Expected: fs.Checksum, Err: os.ErrNotExist}
+	}
+	if mismatch, ok := dv.rotted[key]; ok {
+		return &mismatch
+	}
+	return &VerifyMismatch{Path: fs.Path(), Expected: fs.Checksum, Err: os.ErrNotExist}
+}
@@ -0,0 +1,132 @@
+package medorg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyBackup(t *testing.T) {
+	dirs, err := createTestBackupDirectories(10, 0)
+	if err != nil {
+		t.Fatal("Failed to create test Directories", err)
+	}
+	defer func() {
+		for i := range dirs {
+			os.RemoveAll(dirs[i])
+		}
+	}()
+	_ = recalcTestDirectory(dirs[0])
+
+	all := VerificationSchedule{SampleFraction: 1.0}
+	mismatches, checked, err := VerifyBackup(dirs[0], all, DefaultMetadataOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checked != 10 || len(mismatches) != 0 {
+		t.Error("Expected 10 good files, got", checked, mismatches)
+	}
+
+	// Rot a file behind our back
+	entries, err := os.ReadDir(dirs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotten := ""
+	for _, entry := range entries {
+		if entry.Name() != GetMetadataFilename() {
+			rotten = filepath.Join(dirs[0], entry.Name())
+			break
+		}
+	}
+	err = os.WriteFile(rotten, []byte("bit rot"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatches, _, err = VerifyBackup(dirs[0], all, DefaultMetadataOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 1 || string(mismatches[0].Path) != rotten {
+		t.Error("Expected just", rotten, "to mismatch, got", mismatches)
+	}
+
+	// A sample only looks at some of the files
+	_, checked, err = VerifyBackup(dirs[0], VerificationSchedule{SampleFraction: 0.5, MaxSampleSize: 3}, DefaultMetadataOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checked != 3 {
+		t.Error("Expected the sample to be capped at 3, got", checked)
+	}
+}
+
+func TestVerifyAgainstSource(t *testing.T) {
+	dirs, err := createTestBackupDirectories(5, 0)
+	if err != nil {
+		t.Fatal("Failed to create test Directories", err)
+	}
+	defer func() {
+		for i := range dirs {
+			os.RemoveAll(dirs[i])
+		}
+	}()
+	srcDir, destDir := dirs[0], dirs[1]
+	// Copy the contents, rather than hard link, so we can rot the copies alone
+	copier := func(src, dst Fpath) error {
+		return copyFileContents(string(src), string(dst), nil)
+	}
+	xc := XMLCfg{}
+	opts := BackupOptions{CreateLabelIfMissing: true}
+	err = BackupRunner(context.Background(), &xc, opts, 2, copier, srcDir, destDir, nil, func(string) {}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := VerifyAgainstSource(srcDir, destDir, DefaultMetadataOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK != 5 || len(report.Missing)+len(report.Corrupt)+len(report.Untagged) != 0 {
+		t.Fatal("Expected a clean backup, got", report)
+	}
+
+	// Something else puts a file on the backup
+	makeFile(destDir)
+	if err := recalcTestDirectory(destDir); err != nil {
+		t.Fatal(err)
+	}
+	// The destination is validated, so one it has no record of is found too
+	makeFile(destDir)
+	var copies []string
+	entries, err := os.ReadDir(destDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dm, err := DirectoryMapFromDir(srcDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if _, ok := dm.Get(entry.Name()); ok {
+			copies = append(copies, filepath.Join(destDir, entry.Name()))
+		}
+	}
+	if err := os.WriteFile(copies[0], []byte("bit rot"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(copies[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = VerifyAgainstSource(srcDir, destDir, DefaultMetadataOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK != 3 || len(report.Missing) != 1 || len(report.Corrupt) != 1 || len(report.Untagged) != 2 {
+		t.Error("Unexpected report", report)
+	}
+	if len(report.Corrupt) == 1 && string(report.Corrupt[0].Path) != copies[0] {
+		t.Error("Expected", copies[0], "to be corrupt, got", report.Corrupt[0])
+	}
+}
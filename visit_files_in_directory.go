@@ -1,6 +1,7 @@
 package medorg
 
 import (
+	"context"
 	"io/fs"
 	"log"
 	"sync"
@@ -16,7 +17,17 @@ func VisitFilesInDirectories(
 	registerFunc func(dt *DirTracker),
 	someVisitFunc func(dm DirectoryMap, dir, fn string, d fs.DirEntry, fileStruct FileStruct, fileInfo fs.FileInfo) error,
 ) <-chan error {
-	dts := AutoVisitFilesInDirectories(directories, someVisitFunc)
+	return VisitFilesInDirectoriesWithOptions(directories, DefaultDirTrackerOptions(), registerFunc, someVisitFunc)
+}
+
+// VisitFilesInDirectoriesWithOptions is VisitFilesInDirectories, walking as opts says
+func VisitFilesInDirectoriesWithOptions(
+	directories []string,
+	opts DirTrackerOptions,
+	registerFunc func(dt *DirTracker),
+	someVisitFunc func(dm DirectoryMap, dir, fn string, d fs.DirEntry, fileStruct FileStruct, fileInfo fs.FileInfo) error,
+) <-chan error {
+	dts := autoVisitFilesInDirectories(context.Background(), directories, opts, someVisitFunc)
 	return errHandler(dts, registerFunc)
 }
 
@@ -32,6 +43,12 @@ func errHandler(
 	wg.Add(len(dts))
 	for _, ndt := range dts {
 		registerFunc(ndt)
+		go func(ndt *DirTracker) {
+			// Nothing is sent here unless the tracker was made with SplitWarnings
+			for warning := range ndt.WarnChan() {
+				log.Println("Warning received", warning)
+			}
+		}(ndt)
 		go func(ndt *DirTracker) {
 			for err := range ndt.ErrChan() {
 				log.Println("Error received", err)
@@ -52,6 +69,15 @@ func errHandler(
 func AutoVisitFilesInDirectories(
 	directories []string,
 	someVisitFunc func(dm DirectoryMap, dir, fn string, d fs.DirEntry, fileStruct FileStruct, fileInfo fs.FileInfo) error,
+) []*DirTracker {
+	return autoVisitFilesInDirectories(context.Background(), directories, DefaultDirTrackerOptions(), someVisitFunc)
+}
+
+func autoVisitFilesInDirectories(
+	ctx context.Context,
+	directories []string,
+	opts DirTrackerOptions,
+	someVisitFunc func(dm DirectoryMap, dir, fn string, d fs.DirEntry, fileStruct FileStruct, fileInfo fs.FileInfo) error,
 ) []*DirTracker {
 	if someVisitFunc == nil {
 		someVisitFunc = func(dm DirectoryMap, dir, fn string, d fs.DirEntry, fileStruct FileStruct, fileInfo fs.FileInfo) error {
@@ -59,7 +85,7 @@ func AutoVisitFilesInDirectories(
 		}
 	}
 	visitFunc := func(dm DirectoryMap, dir, fn string, d fs.DirEntry) error {
-		if fn == Md5FileName {
+		if IsMedorgFile(fn) {
 			return nil
 		}
 		fileStruct, ok := dm.Get(fn)
@@ -80,17 +106,41 @@ func AutoVisitFilesInDirectories(
 		return someVisitFunc(dm, dir, fn, d, fileStruct, fileInfo)
 	}
 
-	makerFunc := func(dir string) (DirectoryTrackerInterface, error) {
-		mkFk := func(dir string) (DirectoryEntryInterface, error) {
-			dm, err := DirectoryMapFromDir(dir)
-			dm.VisitFunc = visitFunc
-			return dm, err
+	makerFunc := func(root string) func(dir string) (DirectoryTrackerInterface, error) {
+		return func(dir string) (DirectoryTrackerInterface, error) {
+			mkFk := func(dir string) (DirectoryEntryInterface, error) {
+				dm, err := DirectoryMapFromDirWithOptions(dir, root, opts.Metadata)
+				dm.VisitFunc = visitFunc
+				return dm, err
+			}
+			return NewDirectoryEntry(dir, mkFk)
 		}
-		return NewDirectoryEntry(dir, mkFk)
 	}
 	retArray := make([]*DirTracker, len(directories))
 	for i, targetDir := range directories {
-		retArray[i] = NewDirTracker(true, targetDir, makerFunc)
+		retArray[i] = NewDirTrackerWithOptions(ctx, true, targetDir, makerFunc(targetDir), opts)
 	}
 	return retArray
 }
+
+// UpdateChecksums walks the directories, bringing the checksum of every file up to date
+// It is the core of what check_calc does, for tools that need current checksums
+func UpdateChecksums(ctx context.Context, directories []string, opts MetadataOptions) error {
+	visitor := func(dm DirectoryMap, dir, fn string, d fs.DirEntry, fileStruct FileStruct, fileInfo fs.FileInfo) error {
+		return dm.UpdateChecksum(dir, fn, false)
+	}
+	// Walking the same directory twice at once would race on its metadata
+	directories, err := uniqueRoots(directories)
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	walkOpts := DefaultDirTrackerOptions()
+	walkOpts.Metadata = opts
+	for err := range errHandler(autoVisitFilesInDirectories(ctx, directories, walkOpts, visitor), nil) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -24,7 +24,12 @@ import (
 type VolumeCfg struct {
 	XMLName struct{} `xml:"vol"`
 	Label   string   `xml:"label"`
-	fn      string
+	// Size of the volume, and how much was in use, when we last saw it mounted
+	TotalCapacityBytes int64 `xml:"capacity,omitempty"`
+	UsedBytes          int64 `xml:"used,omitempty"`
+	// LastBackup is when a backup to the volume last finished, in unix seconds
+	LastBackup int64 `xml:"last_backup,omitempty"`
+	fn         string
 }
 
 // NewVolumeCfg reads the config from an xml file
@@ -134,8 +139,12 @@ func (vc *VolumeCfg) GenerateNewVolumeLabel(xc *XMLCfg) error {
 		}
 	}
 }
+
+// volumeLabelFileName holds the VolumeCfg at the top of each backup volume
+const volumeLabelFileName = ".mdbackup.xml"
+
 func formVolumeName(dir string) string {
-	return filepath.Join(dir, ".mdbackup.xml")
+	return filepath.Join(dir, volumeLabelFileName)
 }
 func findVolumeConfig(dir string) string {
 	dir, err := filepath.Abs(dir)
@@ -177,6 +186,47 @@ func findVolumeConfig(dir string) string {
 	return filepath.Join(filepath.VolumeName(dir), formVolumeName(d))
 }
 
+// ErrNoVolumeLabel the backup destination has not been given a label
+var ErrNoVolumeLabel = errors.New("destination has no volume label, run mdbackup -tag on it (or use -create-label-if-missing)")
+
+// hasVolumeLabel returns true if dir (or a parent) already has a volume label
+func hasVolumeLabel(dir string) bool {
+	fn := findVolumeConfig(dir)
+	if fn == "" {
+		return false
+	}
+	_, err := os.Stat(fn)
+	return err == nil
+}
+
+// ReadVolumeCfg returns the config of the volume dir is on, without creating one
+// Returns ErrNoVolumeLabel if it has not been labelled
+func ReadVolumeCfg(dir string) (*VolumeCfg, error) {
+	if !hasVolumeLabel(dir) {
+		return nil, fmt.Errorf("%w::%s", ErrNoVolumeLabel, dir)
+	}
+	vc := new(VolumeCfg)
+	vc.fn = findVolumeConfig(dir)
+	ba, err := os.ReadFile(vc.fn)
+	if err != nil {
+		return nil, err
+	}
+	if err := vc.FromXML(ba); err != nil {
+		return nil, err
+	}
+	return vc, nil
+}
+
+// readVolumeLabel returns the label of the volume dir is on, without creating one
+// Returns ErrNoVolumeLabel if it has not been labelled
+func readVolumeLabel(dir string) (string, error) {
+	vc, err := ReadVolumeCfg(dir)
+	if err != nil {
+		return "", err
+	}
+	return vc.Label, nil
+}
+
 // VolumeCfgFromDir get volume config appropriate for the requested directory
 func (xc *XMLCfg) VolumeCfgFromDir(dir string) (*VolumeCfg, error) {
 	fn := findVolumeConfig(dir)
@@ -190,5 +240,23 @@ func (xc *XMLCfg) getVolumeLabel(destDir string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	// Not knowing how full the volume is, is no reason to stop
+	_ = vc.UpdateUsage(destDir)
 	return vc.Label, err
 }
+
+// UpdateUsage records the capacity and usage of the volume dir is on
+func (vc *VolumeCfg) UpdateUsage(dir string) error {
+	total, used, err := volumeUsage(dir)
+	if err != nil {
+		return err
+	}
+	vc.TotalCapacityBytes = total
+	vc.UsedBytes = used
+	return vc.Persist()
+}
+
+// FreeBytes is the space left on the volume when we last looked
+func (vc VolumeCfg) FreeBytes() int64 {
+	return vc.TotalCapacityBytes - vc.UsedBytes
+}
@@ -1,9 +1,12 @@
 package medorg
 
 import (
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -57,3 +60,166 @@ func TestVolumeCfgFromDir1(t *testing.T) {
 		t.Error("Bang:", label0, label1)
 	}
 }
+
+func TestVolumeCfgUsage(t *testing.T) {
+	wkDir, err := ioutil.TempDir("", "volLabTest")
+	if err != nil {
+		t.Error("TmpDir Error:", err)
+	}
+	defer os.RemoveAll(wkDir)
+
+	xc := XMLCfg{}
+	_, err = xc.getVolumeLabel(wkDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Reading it back in should give us the usage recorded by getVolumeLabel
+	vc, err := xc.VolumeCfgFromDir(wkDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vc.TotalCapacityBytes <= 0 {
+		t.Error("Expected a capacity, got", vc.TotalCapacityBytes)
+	}
+	if vc.UsedBytes < 0 || vc.UsedBytes > vc.TotalCapacityBytes {
+		t.Error("Unexpected used bytes", vc.UsedBytes, "of", vc.TotalCapacityBytes)
+	}
+	if vc.FreeBytes() != vc.TotalCapacityBytes-vc.UsedBytes {
+		t.Error("Free bytes mismatch", vc.FreeBytes())
+	}
+}
+
+func TestCheckLowSpace(t *testing.T) {
+	wkDir, err := os.MkdirTemp("", "lowSpace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wkDir)
+	if _, _, err := volumeUsage(wkDir); err != nil {
+		t.Skip("Volume usage not available:", err)
+	}
+	var warnings []string
+	logFunc := func(msg string) { warnings = append(warnings, msg) }
+
+	// Nothing is 100% free, so this always trips
+	xc := XMLCfg{LowSpaceThresholdPct: 100}
+	var opts BackupOptions
+	if err := checkLowSpace(&xc, opts, wkDir, logFunc); err != nil {
+		t.Error("Should only warn, got", err)
+	}
+	if len(warnings) != 1 {
+		t.Error("Expected a warning, got", warnings)
+	}
+	opts.AbortOnLowSpace = true
+	if err := checkLowSpace(&xc, opts, wkDir, logFunc); !errors.Is(err, ErrLowSpace) {
+		t.Error("Expected ErrLowSpace, got", err)
+	}
+	// and nothing is less than 0% free
+	xc.LowSpaceThresholdPct = 0.0000001
+	if err := checkLowSpace(&xc, opts, wkDir, logFunc); err != nil {
+		t.Error("Unexpected", err)
+	}
+}
+
+func TestCheckLowSpaceStubbed(t *testing.T) {
+	var free int64
+	var usageErr error
+	defer func(orig func(string) (int64, int64, error)) { volumeUsageFunc = orig }(volumeUsageFunc)
+	volumeUsageFunc = func(dir string) (int64, int64, error) {
+		return 1000, 1000 - free, usageErr
+	}
+	var warnings []string
+	logFunc := func(msg string) { warnings = append(warnings, msg) }
+
+	xc := XMLCfg{}
+	var opts BackupOptions
+	// The default threshold is 10%
+	free = 100
+	if err := checkLowSpace(&xc, opts, "dest", logFunc); err != nil || len(warnings) != 0 {
+		t.Error("Should be enough space, got", err, warnings)
+	}
+	free = 99
+	if err := checkLowSpace(&xc, opts, "dest", logFunc); err != nil {
+		t.Error("Should only warn, got", err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "9.9% free") {
+		t.Error("Expected a warning, got", warnings)
+	}
+	opts.AbortOnLowSpace = true
+	if err := checkLowSpace(&xc, opts, "dest", logFunc); !errors.Is(err, ErrLowSpace) {
+		t.Error("Expected ErrLowSpace, got", err)
+	}
+	opts.LowSpaceThresholdPct = 5
+	if err := checkLowSpace(&xc, opts, "dest", logFunc); err != nil {
+		t.Error("Should be above the lower threshold, got", err)
+	}
+	// Not knowing the free space is no reason to stop
+	opts.LowSpaceThresholdPct = 0
+	usageErr = errors.New("no usage")
+	if err := checkLowSpace(&xc, opts, "dest", logFunc); err != nil {
+		t.Error("Unexpected", err)
+	}
+	if len(warnings) != 1 {
+		t.Error("Expected no more warnings, got", warnings)
+	}
+}
+
+func TestCheckBackupWillFit(t *testing.T) {
+	wkDir, err := os.MkdirTemp("", "willFit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wkDir)
+	var files fpathList
+	for i := 0; i < 4; i++ {
+		fn := filepath.Join(wkDir, fmt.Sprint("file", i))
+		if err := os.WriteFile(fn, make([]byte, 250), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, Fpath(fn))
+	}
+	copyFilesArray := fpathListList{files}
+
+	var free int64
+	defer func(orig func(string) (int64, int64, error)) { volumeUsageFunc = orig }(volumeUsageFunc)
+	volumeUsageFunc = func(dir string) (int64, int64, error) {
+		return 1 << 20, 1<<20 - free, nil
+	}
+	var warnings []string
+	logFunc := func(msg string) { warnings = append(warnings, msg) }
+
+	xc := XMLCfg{}
+	opts := BackupOptions{AbortOnLowSpace: true}
+	// 1000 bytes to copy, plus 10%
+	free = 1100
+	if err := checkBackupWillFit(&xc, opts, wkDir, copyFilesArray, 1, logFunc); err != nil {
+		t.Error("Should fit, got", err)
+	}
+	free = 1099
+	err = checkBackupWillFit(&xc, opts, wkDir, copyFilesArray, 1, logFunc)
+	if !errors.Is(err, ErrNoSpace) {
+		t.Error("Expected ErrNoSpace, got", err)
+	}
+	if err != nil && !strings.Contains(err.Error(), "free, need") {
+		t.Error("Expected to be told how much space is needed, got", err)
+	}
+	// Beyond the files we're limited to, nothing counts
+	if err := checkBackupWillFit(&xc, opts, wkDir, copyFilesArray, 0, logFunc); err != nil {
+		t.Error("Nothing to copy should fit, got", err)
+	}
+	xc.SpaceHeadroomPct = 5
+	if err := checkBackupWillFit(&xc, opts, wkDir, copyFilesArray, 1, logFunc); err != nil {
+		t.Error("Should fit with less headroom, got", err)
+	}
+	if len(warnings) != 0 {
+		t.Error("Should not have warned when aborting", warnings)
+	}
+	xc = XMLCfg{}
+	opts = BackupOptions{}
+	if err := checkBackupWillFit(&xc, opts, wkDir, copyFilesArray, 1, logFunc); err != nil {
+		t.Error("Should only warn, got", err)
+	}
+	if len(warnings) != 1 {
+		t.Error("Expected a warning, got", warnings)
+	}
+}
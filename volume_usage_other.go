@@ -0,0 +1,10 @@
+//go:build windows
+
+package medorg
+
+import "errors"
+
+// volumeUsage is not yet implemented on windows
+func volumeUsage(dir string) (total, used int64, err error) {
+	return 0, 0, errors.New("volume usage not supported on this platform")
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+
+package medorg
+
+import "syscall"
+
+// volumeUsage returns the size of the volume dir is on, and how much of it is in use
+// Blocks reserved for root are left out of the total, as we cannot write to them,
+// so total - used is the space we can actually use, as df reports it
+func volumeUsage(dir string) (total, used int64, err error) {
+	var st syscall.Statfs_t
+	err = syscall.Statfs(dir, &st)
+	if err != nil {
+		return 0, 0, err
+	}
+	blockSize := int64(st.Bsize)
+	used = (int64(st.Blocks) - int64(st.Bfree)) * blockSize
+	total = used + int64(st.Bavail)*blockSize
+	return total, used, nil
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+
+package medorg
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestVolumeUsageAvailable(t *testing.T) {
+	wkDir := t.TempDir()
+	total, used, err := volumeUsage(wkDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(wkDir, &st); err != nil {
+		t.Fatal(err)
+	}
+	// What is free must be what we can write, not counting the blocks reserved for root
+	// Allow for the volume changing between the two calls
+	free, avail := total-used, int64(st.Bavail)*int64(st.Bsize)
+	if diff := free - avail; diff > 1<<20 || diff < -(1<<20) {
+		t.Error("Expected", avail, "bytes free, got", free)
+	}
+}
@@ -0,0 +1,92 @@
+package medorg
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// WalkStats are the statistics gathered as a DirTracker walks a directory tree
+type WalkStats struct {
+	DirsEntered  int64
+	FilesVisited int64
+	FilesErrored int64
+	// Warnings are the errors the walk carried on past
+	// only counted with SplitWarnings
+	Warnings int64
+	// PermissionErrors are the paths we were not allowed to read
+	// only collected with SplitWarnings
+	PermissionErrors []string
+	// BytesVisited is the total size of the files visited
+	// i.e. the most we could have needed to hash, not what we did
+	BytesVisited int64
+	// BytesHashed is the old name for BytesVisited, and always the same
+	//
+	// Deprecated: use BytesVisited
+	BytesHashed int64
+	// Elapsed is how long the walk has been running
+	// or took, once it has finished
+	Elapsed time.Duration
+}
+
+// Add returns the sum of two sets of stats
+// Useful when more than one tracker is in use
+// As trackers usually run side by side, Elapsed is the longer of the two
+func (ws WalkStats) Add(other WalkStats) WalkStats {
+	elapsed := ws.Elapsed
+	if other.Elapsed > elapsed {
+		elapsed = other.Elapsed
+	}
+	return WalkStats{
+		DirsEntered:      ws.DirsEntered + other.DirsEntered,
+		FilesVisited:     ws.FilesVisited + other.FilesVisited,
+		FilesErrored:     ws.FilesErrored + other.FilesErrored,
+		Warnings:         ws.Warnings + other.Warnings,
+		PermissionErrors: append(append([]string(nil), ws.PermissionErrors...), other.PermissionErrors...),
+		BytesVisited:     ws.BytesVisited + other.BytesVisited,
+		BytesHashed:      ws.BytesVisited + other.BytesVisited,
+		Elapsed:          elapsed,
+	}
+}
+
+func (ws WalkStats) String() string {
+	str := fmt.Sprint("Scanned: ", commaSeparate(ws.FilesVisited), " files in ", commaSeparate(ws.DirsEntered), " directories")
+	if ws.FilesErrored > 0 {
+		str += fmt.Sprint(", ", commaSeparate(ws.FilesErrored), " errors")
+	}
+	if ws.Warnings > 0 {
+		str += fmt.Sprint(", ", commaSeparate(ws.Warnings), " warnings")
+	}
+	if ws.Elapsed > 0 {
+		str += fmt.Sprint(" in ", ws.Elapsed.Round(time.Millisecond))
+	}
+	return str
+}
+
+// load takes a consistent-ish copy of stats that are being updated atomically
+func (ws *WalkStats) load() WalkStats {
+	bytesVisited := atomic.LoadInt64(&ws.BytesVisited)
+	return WalkStats{
+		DirsEntered:  atomic.LoadInt64(&ws.DirsEntered),
+		FilesVisited: atomic.LoadInt64(&ws.FilesVisited),
+		FilesErrored: atomic.LoadInt64(&ws.FilesErrored),
+		Warnings:     atomic.LoadInt64(&ws.Warnings),
+		BytesVisited: bytesVisited,
+		BytesHashed:  bytesVisited,
+		Elapsed:      time.Duration(atomic.LoadInt64((*int64)(&ws.Elapsed))),
+	}
+}
+
+// commaSeparate formats 4231 as 4,231
+func commaSeparate(n int64) string {
+	str := strconv.FormatInt(n, 10)
+	start := 0
+	if n < 0 {
+		start = 1
+	}
+	for i := len(str) - 3; i > start; i -= 3 {
+		str = str[:i] + "," + str[i:]
+	}
+	return str
+}
@@ -0,0 +1,72 @@
+package medorg
+
+import (
+	"errors"
+	"io/fs"
+	"sync/atomic"
+)
+
+// IsWarning reports if err only affects the file or directory it came from
+// so that a walk can note it and carry on: permission denied,
+// a file that has vanished mid-walk, or metadata we can't make sense of
+func IsWarning(err error) bool {
+	return errors.Is(err, fs.ErrPermission) ||
+		errors.Is(err, fs.ErrNotExist) ||
+		errors.Is(err, ErrBadMetadata)
+}
+
+// warnChanSize is how many warnings can queue up before
+// we start dropping them for a slow reader of WarnChan
+const warnChanSize = 64
+
+// WarnChan returns the warnings from the walk, if the DirTracker was made
+// with SplitWarnings. It is closed along with ErrChan.
+// Read it alongside ErrChan: the walk never waits on the reader,
+// so warnings that do not fit in the buffer are dropped,
+// though they are still counted in the Stats.
+func (dt *DirTracker) WarnChan() <-chan error {
+	return dt.warnChan
+}
+
+// warn sends err to the WarnChan, if it is a warning and we are splitting them out
+// Returns false if err is still the caller's to deal with
+func (dt *DirTracker) warn(err error) bool {
+	return dt.warnAt(err, "")
+}
+
+// warnAt is warn, where path is what a permission error should be recorded against
+// rather than the path in err, e.g. the directory, not the file in it we tripped over
+func (dt *DirTracker) warnAt(err error, path string) bool {
+	if !dt.splitWarnings || !IsWarning(err) {
+		return false
+	}
+	atomic.AddInt64(&dt.stats.Warnings, 1)
+	if errors.Is(err, fs.ErrPermission) {
+		dt.notePermissionError(err, path)
+	}
+	select {
+	case dt.warnChan <- err:
+	default:
+	}
+	return true
+}
+
+// notePermissionError records the path we were denied, once
+func (dt *DirTracker) notePermissionError(err error, path string) {
+	var pathErr *fs.PathError
+	switch {
+	case path != "":
+	case errors.As(err, &pathErr):
+		path = pathErr.Path
+	default:
+		path = err.Error()
+	}
+	dt.permLock.Lock()
+	defer dt.permLock.Unlock()
+	for _, p := range dt.permErrors {
+		if p == path {
+			return
+		}
+	}
+	dt.permErrors = append(dt.permErrors, path)
+}
@@ -0,0 +1,214 @@
+package medorg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrWatchOverflow the OS dropped events, so some files may have been missed
+var ErrWatchOverflow = errors.New("too many changes at once, some were missed, run check_calc")
+
+// DefaultWatchDebounce is how long a file must be left alone
+// before we checksum it
+const DefaultWatchDebounce = 500 * time.Millisecond
+
+// WatchDirectories keeps the checksums in dirs up to date as files
+// are created there, or moved in, until ctx is cancelled.
+// Several events for a file are coalesced until it has been left alone for debounce.
+// Only the affected file is checksummed, for a full check run check_calc.
+func WatchDirectories(ctx context.Context, dirs []string, debounce time.Duration, logFunc func(msg string)) error {
+	return WatchDirectoriesWithOptions(ctx, dirs, debounce, DefaultDirTrackerOptions(), logFunc)
+}
+
+// WatchDirectoriesWithOptions is WatchDirectories, but files that
+// opts.IgnorePatterns match, or that are in a directory they match, are
+// not checksummed. The other options only apply to walks, so are not used.
+func WatchDirectoriesWithOptions(ctx context.Context, dirs []string, debounce time.Duration, opts DirTrackerOptions, logFunc func(msg string)) error {
+	if debounce <= 0 {
+		debounce = DefaultWatchDebounce
+	}
+	if logFunc == nil {
+		logFunc = func(msg string) {
+			log.Println(msg)
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events := make(chan string, 64)
+	errs := make(chan error, 1)
+	stop, err := startWatching(dirs, events, errs, ctx.Done())
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	ignore := newWatchIgnoreRules(dirs, opts.IgnorePatterns)
+	db := newDebouncer(debounce, ctx.Done())
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case path := <-db.ready:
+				if err := updateWatchedFile(path, opts.Metadata); err != nil {
+					logFunc(fmt.Sprint("Unable to update ", path, ": ", err))
+					continue
+				}
+				logFunc(fmt.Sprint("Updated ", path))
+			}
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case path := <-events:
+			if !watchIgnored(path) && !ignore.ignored(path) {
+				db.add(path)
+			}
+		case err := <-errs:
+			if errors.Is(err, ErrWatchOverflow) {
+				logFunc(err.Error())
+				continue
+			}
+			return err
+		}
+	}
+}
+
+// watchIgnored are the files we should not checksum when they change
+// i.e. our own files, and anything in a hidden directory, as the walk skips them
+func watchIgnored(path string) bool {
+	dir, fn := filepath.Split(path)
+	if IsMedorgFile(fn) ||
+		strings.HasPrefix(fn, ".mdbackup") ||
+		fn == IgnoreFileName {
+		return true
+	}
+	for _, p := range strings.Split(filepath.Clean(dir), string(filepath.Separator)) {
+		if strings.HasPrefix(p, ".") && p != "." && p != ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// watchIgnoreRules are the global ignore patterns, for each directory watched
+// Only used by the event loop, so no lock
+type watchIgnoreRules []*ignoreRules
+
+func newWatchIgnoreRules(dirs []string, patterns []string) watchIgnoreRules {
+	if len(patterns) == 0 {
+		return nil
+	}
+	wr := make(watchIgnoreRules, 0, len(dirs))
+	for _, dir := range dirs {
+		wr = append(wr, newIgnoreRules(filepath.Clean(dir), patterns))
+	}
+	return wr
+}
+
+// ignored reports if the walk would have skipped path,
+// either itself or one of the directories on the way down to it
+func (wr watchIgnoreRules) ignored(path string) bool {
+	for _, ir := range wr {
+		rel, err := filepath.Rel(ir.root, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		parts := strings.Split(rel, string(filepath.Separator))
+		for i := range parts {
+			isDir := i < len(parts)-1
+			if ir.ignored(filepath.Join(ir.root, filepath.Join(parts[:i+1]...)), isDir) {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// updateWatchedFile brings the metadata of a single file up to date
+func updateWatchedFile(path string, opts MetadataOptions) error {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		// Gone again before we got to it
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	dir, fn := filepath.Split(path)
+	dir = filepath.Clean(dir)
+	dm, err := DirectoryMapFromDirWithOptions(dir, "", opts)
+	if err != nil {
+		return err
+	}
+	fc := func(fs *FileStruct) error {
+		changed, err := fs.Changed(info)
+		if err != nil {
+			return err
+		}
+		if changed {
+			if _, err := fs.FromStat(dir, fn, info); err != nil {
+				return err
+			}
+		}
+		return fs.UpdateChecksum(changed)
+	}
+	if err := dm.RunFsFc(dir, fn, fc); err != nil {
+		return err
+	}
+	return dm.Persist(dir)
+}
+
+// debouncer holds back paths until they have been left alone for delay
+type debouncer struct {
+	lk     sync.Mutex
+	delay  time.Duration
+	timers map[string]*time.Timer
+	ready  chan string
+	done   <-chan struct{}
+}
+
+func newDebouncer(delay time.Duration, done <-chan struct{}) *debouncer {
+	return &debouncer{
+		delay:  delay,
+		timers: make(map[string]*time.Timer),
+		ready:  make(chan string),
+		done:   done,
+	}
+}
+
+// add path, or if we already have it, start its wait again
+func (db *debouncer) add(path string) {
+	db.lk.Lock()
+	defer db.lk.Unlock()
+	if t, ok := db.timers[path]; ok && t.Stop() {
+		t.Reset(db.delay)
+		return
+	}
+	db.timers[path] = time.AfterFunc(db.delay, func() {
+		db.lk.Lock()
+		delete(db.timers, path)
+		db.lk.Unlock()
+		select {
+		case db.ready <- path:
+		case <-db.done:
+		}
+	})
+}
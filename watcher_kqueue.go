@@ -0,0 +1,202 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package medorg
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// kqueueFflags are the changes to a directory we are told about
+// kqueue does not say what in the directory changed, so we scan it again
+const kqueueFflags = unix.NOTE_WRITE | unix.NOTE_DELETE | unix.NOTE_RENAME
+
+// kqueueWatcher watches directory trees with kqueue
+// Every directory needs its own open file, and only the goroutine
+// running the watcher touches the maps, so there is no lock.
+type kqueueWatcher struct {
+	kq      int
+	dirs    map[int]string
+	fds     map[string]int
+	scanner *dirScanner
+	events  chan<- string
+	done    <-chan struct{}
+}
+
+// startWatching reports files created or moved into dirs on events
+// until done is closed, or the returned stop is called
+func startWatching(dirs []string, events chan<- string, errs chan<- error, done <-chan struct{}) (stop func(), err error) {
+	kq, err := unix.Kqueue()
+	if err != nil {
+		return nil, err
+	}
+	kw := &kqueueWatcher{
+		kq:      kq,
+		dirs:    make(map[int]string),
+		fds:     make(map[string]int),
+		scanner: newDirScanner(),
+		events:  events,
+		done:    done,
+	}
+	// A file written in place doesn't change its directory,
+	// so keep an eye on the new ones until they are finished
+	kw.scanner.trackRecent = true
+	for _, dir := range dirs {
+		if err := kw.addTree(dir, false); err != nil {
+			kw.close()
+			return nil, err
+		}
+	}
+	stopChan := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		if err := kw.run(stopChan); err != nil {
+			select {
+			case errs <- err:
+			case <-done:
+			case <-stopChan:
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stopChan)
+			<-finished
+			kw.close()
+		})
+	}, nil
+}
+
+func (kw *kqueueWatcher) close() {
+	for fd := range kw.dirs {
+		unix.Close(fd)
+	}
+	unix.Close(kw.kq)
+}
+
+// addTree watches root and every directory below it
+// With sendFiles, the files found are sent as events too,
+// as they may have arrived before the watch was in place
+func (kw *kqueueWatcher) addTree(root string, sendFiles bool) error {
+	var send func(string)
+	if sendFiles {
+		send = kw.send
+	}
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path != root && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != root && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if err := kw.addWatch(path); err != nil {
+			return err
+		}
+		// Scan after the watch is in place, so nothing slips between
+		if _, err := kw.scanner.scan(path, send); err != nil {
+			if path != root && errors.Is(err, fs.ErrNotExist) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+func (kw *kqueueWatcher) addWatch(dir string) error {
+	if _, ok := kw.fds[dir]; ok {
+		return nil
+	}
+	fd, err := unix.Open(dir, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return &fs.PathError{Op: "open", Path: dir, Err: err}
+	}
+	var change unix.Kevent_t
+	unix.SetKevent(&change, fd, unix.EVFILT_VNODE, unix.EV_ADD|unix.EV_CLEAR|unix.EV_ENABLE)
+	change.Fflags = kqueueFflags
+	if _, err := unix.Kevent(kw.kq, []unix.Kevent_t{change}, nil, nil); err != nil {
+		unix.Close(fd)
+		return &fs.PathError{Op: "kevent", Path: dir, Err: err}
+	}
+	kw.dirs[fd] = dir
+	kw.fds[dir] = fd
+	return nil
+}
+
+// removeWatch stops watching dir, which has gone
+func (kw *kqueueWatcher) removeWatch(fd int) {
+	dir := kw.dirs[fd]
+	// Closing the file removes it from the kqueue
+	unix.Close(fd)
+	delete(kw.dirs, fd)
+	delete(kw.fds, dir)
+	kw.scanner.forget(dir)
+}
+
+func (kw *kqueueWatcher) send(path string) {
+	select {
+	case kw.events <- path:
+	case <-kw.done:
+	}
+}
+
+func (kw *kqueueWatcher) run(stop <-chan struct{}) error {
+	evs := make([]unix.Kevent_t, 64)
+	// Wait with a timeout, so we notice being stopped
+	timeout := unix.NsecToTimespec(int64(100 * 1000 * 1000))
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-kw.done:
+			return nil
+		default:
+		}
+		n, err := unix.Kevent(kw.kq, nil, evs, &timeout)
+		if errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, ev := range evs[:n] {
+			fd := int(ev.Ident)
+			dir, ok := kw.dirs[fd]
+			if !ok {
+				continue
+			}
+			if ev.Fflags&(unix.NOTE_DELETE|unix.NOTE_RENAME) != 0 {
+				// Anything moved in with it is found by scanning its new parent
+				kw.removeWatch(fd)
+				continue
+			}
+			newDirs, err := kw.scanner.scan(dir, kw.send)
+			if errors.Is(err, fs.ErrNotExist) {
+				kw.removeWatch(fd)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			for _, newDir := range newDirs {
+				if err := kw.addTree(newDir, true); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					return err
+				}
+			}
+		}
+		kw.scanner.checkRecent(kw.send)
+	}
+}
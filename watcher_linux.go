@@ -0,0 +1,183 @@
+//go:build linux
+
+package medorg
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// inotifyMask are the events that mean a file may need checksumming
+// IN_CREATE also tells us about new directories to watch
+const inotifyMask = unix.IN_CREATE | unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO
+
+// inotifyWatcher watches directory trees with inotify
+// inotify is not recursive, so every directory needs its own watch
+type inotifyWatcher struct {
+	fd     int
+	lk     sync.Mutex
+	dirs   map[int]string
+	events chan<- string
+	errs   chan<- error
+	done   <-chan struct{}
+}
+
+// startWatching reports files created or moved into dirs on events
+// until done is closed, or the returned stop is called
+func startWatching(dirs []string, events chan<- string, errs chan<- error, done <-chan struct{}) (stop func(), err error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	iw := &inotifyWatcher{
+		fd:     fd,
+		dirs:   make(map[int]string),
+		events: events,
+		errs:   errs,
+		done:   done,
+	}
+	for _, dir := range dirs {
+		if err := iw.addTree(dir, false); err != nil {
+			unix.Close(fd)
+			return nil, err
+		}
+	}
+	stopChan := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		if err := iw.run(stopChan); err != nil {
+			select {
+			case errs <- err:
+			case <-done:
+			case <-stopChan:
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stopChan)
+			<-finished
+			unix.Close(fd)
+		})
+	}, nil
+}
+
+// addTree watches root and every directory below it
+// With sendFiles, the files found are sent as events too,
+// as they may have arrived before the watch was in place
+func (iw *inotifyWatcher) addTree(root string, sendFiles bool) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path != root && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			if sendFiles {
+				iw.send(path)
+			}
+			return nil
+		}
+		if path != root && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		wd, err := unix.InotifyAddWatch(iw.fd, path, inotifyMask)
+		if err != nil {
+			return &fs.PathError{Op: "inotify_add_watch", Path: path, Err: err}
+		}
+		iw.lk.Lock()
+		iw.dirs[wd] = path
+		iw.lk.Unlock()
+		return nil
+	})
+}
+
+func (iw *inotifyWatcher) send(path string) {
+	select {
+	case iw.events <- path:
+	case <-iw.done:
+	}
+}
+
+func (iw *inotifyWatcher) run(stop <-chan struct{}) error {
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	pfds := []unix.PollFd{{Fd: int32(iw.fd), Events: unix.POLLIN}}
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-iw.done:
+			return nil
+		default:
+		}
+		// Poll with a timeout, so we notice being stopped
+		n, err := unix.Poll(pfds, 100)
+		if errors.Is(err, unix.EINTR) || n == 0 {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		n, err = unix.Read(iw.fd, buf)
+		if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := iw.handleEvents(buf[:n]); err != nil {
+			return err
+		}
+	}
+}
+
+func (iw *inotifyWatcher) handleEvents(buf []byte) error {
+	for offset := 0; offset+unix.SizeofInotifyEvent <= len(buf); {
+		ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		start := offset + unix.SizeofInotifyEvent
+		offset = start + int(ev.Len)
+		if offset > len(buf) {
+			break
+		}
+		name := strings.TrimRight(string(buf[start:offset]), "\x00")
+		if ev.Mask&unix.IN_Q_OVERFLOW != 0 {
+			// Not fatal, we just can't be sure we've seen everything
+			select {
+			case iw.errs <- ErrWatchOverflow:
+			default:
+			}
+			continue
+		}
+		iw.lk.Lock()
+		dir, ok := iw.dirs[int(ev.Wd)]
+		if ev.Mask&unix.IN_IGNORED != 0 {
+			// The directory has gone, so has the watch
+			delete(iw.dirs, int(ev.Wd))
+		}
+		iw.lk.Unlock()
+		if !ok || name == "" {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if ev.Mask&unix.IN_ISDIR == 0 {
+			iw.send(path)
+			continue
+		}
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		if err := iw.addTree(path, true); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
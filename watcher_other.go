@@ -0,0 +1,8 @@
+//go:build !linux && !windows && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package medorg
+
+// startWatching has nothing to be told about changes with here, so polls
+func startWatching(dirs []string, events chan<- string, errs chan<- error, done <-chan struct{}) (stop func(), err error) {
+	return startPolling(dirs, events, errs, done)
+}
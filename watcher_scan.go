@@ -0,0 +1,207 @@
+package medorg
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// watchPollInterval is how often the polling watcher looks for changes
+const watchPollInterval = time.Second
+
+// watchRecentWindow is how long after a file last changed
+// we keep checking it for more writes
+const watchRecentWindow = 30 * time.Second
+
+// watchFileState is what we compare to see if a file has changed
+type watchFileState struct {
+	size  int64
+	mtime time.Time
+	isDir bool
+}
+
+type watchRecentFile struct {
+	state   watchFileState
+	changed time.Time
+}
+
+// dirScanner remembers what was in each directory when last scanned
+// so that we can tell which files are new, or have changed.
+// It is for the platforms that only tell us a directory has changed, not what in it.
+// Not safe for concurrent use.
+type dirScanner struct {
+	dirs map[string]map[string]watchFileState
+	// recent are the files that have changed lately, and may not
+	// have finished being written yet. Only kept with trackRecent.
+	recent      map[string]watchRecentFile
+	trackRecent bool
+}
+
+func newDirScanner() *dirScanner {
+	return &dirScanner{
+		dirs:   make(map[string]map[string]watchFileState),
+		recent: make(map[string]watchRecentFile),
+	}
+}
+
+// scan dir, passing the files that are new or have changed since the
+// last scan to send. Returns the subdirectories not seen before.
+// With send nil, the files are only recorded.
+func (ds *dirScanner) scan(dir string, send func(path string)) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	before, known := ds.dirs[dir]
+	after := make(map[string]watchFileState, len(entries))
+	var newDirs []string
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			// Gone again already
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		state := watchFileState{size: info.Size(), mtime: info.ModTime(), isDir: entry.IsDir()}
+		after[entry.Name()] = state
+		prev, ok := before[entry.Name()]
+		if state.isDir {
+			if !ok || !prev.isDir {
+				newDirs = append(newDirs, filepath.Join(dir, entry.Name()))
+			}
+			continue
+		}
+		if send != nil && (!known || !ok || prev != state) {
+			path := filepath.Join(dir, entry.Name())
+			if ds.trackRecent {
+				ds.recent[path] = watchRecentFile{state: state, changed: time.Now()}
+			}
+			send(path)
+		}
+	}
+	for name, state := range before {
+		if _, ok := after[name]; !ok && state.isDir {
+			ds.forget(filepath.Join(dir, name))
+		}
+	}
+	ds.dirs[dir] = after
+	return newDirs, nil
+}
+
+// forget dir, and everything below it
+func (ds *dirScanner) forget(dir string) {
+	for d := range ds.dirs {
+		if isWithin(d, dir) {
+			delete(ds.dirs, d)
+		}
+	}
+}
+
+// checkRecent sends the files that have been written to again since
+// they were last sent, so they are not checksummed half written
+func (ds *dirScanner) checkRecent(send func(path string)) {
+	now := time.Now()
+	for path, rf := range ds.recent {
+		info, err := os.Stat(path)
+		if err != nil {
+			delete(ds.recent, path)
+			continue
+		}
+		state := watchFileState{size: info.Size(), mtime: info.ModTime()}
+		if state != rf.state {
+			ds.recent[path] = watchRecentFile{state: state, changed: now}
+			// So the next scan of its directory doesn't send it again
+			if files, ok := ds.dirs[filepath.Dir(path)]; ok {
+				files[filepath.Base(path)] = state
+			}
+			send(path)
+			continue
+		}
+		if now.Sub(rf.changed) > watchRecentWindow {
+			delete(ds.recent, path)
+		}
+	}
+}
+
+// scanTree scans root and every directory below it
+func (ds *dirScanner) scanTree(root string, send func(path string)) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path != root && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != root && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		_, err = ds.scan(path, send)
+		if errors.Is(err, fs.ErrNotExist) && path != root {
+			return filepath.SkipDir
+		}
+		return err
+	})
+}
+
+// startPolling is startWatching for the platforms we have no way to be
+// told about changes on. Every watchPollInterval the directories are
+// scanned again, so it is slower to notice, and much more work.
+func startPolling(dirs []string, events chan<- string, errs chan<- error, done <-chan struct{}) (stop func(), err error) {
+	ds := newDirScanner()
+	for _, dir := range dirs {
+		if err := ds.scanTree(dir, nil); err != nil {
+			return nil, err
+		}
+	}
+	send := func(path string) {
+		select {
+		case events <- path:
+		case <-done:
+		}
+	}
+	stopChan := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(watchPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopChan:
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			for _, dir := range dirs {
+				if err := ds.scanTree(dir, send); err != nil {
+					select {
+					case errs <- err:
+					case <-done:
+					case <-stopChan:
+					}
+					return
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stopChan)
+			<-finished
+		})
+	}, nil
+}
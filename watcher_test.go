@@ -0,0 +1,257 @@
+package medorg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDebouncer(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	db := newDebouncer(50*time.Millisecond, done)
+	// A file being written generates a burst of events
+	for i := 0; i < 5; i++ {
+		db.add("a")
+		db.add("b")
+		time.Sleep(10 * time.Millisecond)
+	}
+	got := map[string]int{}
+	timeout := time.After(time.Second)
+	for len(got) < 2 {
+		select {
+		case path := <-db.ready:
+			got[path]++
+		case <-timeout:
+			t.Fatal("Timed out, got", got)
+		}
+	}
+	select {
+	case path := <-db.ready:
+		t.Error("Expected each path once, got", path, "again")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestWatchIgnored(t *testing.T) {
+	tests := []struct {
+		path    string
+		ignored bool
+	}{
+		{filepath.Join("photos", "a.jpg"), false},
+		{filepath.Join("photos", ".profile"), false},
+		{filepath.Join("photos", Md5FileName), true},
+		{filepath.Join("photos", Md5FileName+".tmp1234"), true},
+		{filepath.Join("photos", volumeLabelFileName), true},
+		{filepath.Join("photos", IgnoreFileName), true},
+		{filepath.Join("photos", ".git", "config"), true},
+	}
+	for _, tt := range tests {
+		if got := watchIgnored(tt.path); got != tt.ignored {
+			t.Error(tt.path, "expected ignored", tt.ignored, "got", got)
+		}
+	}
+}
+
+func TestWatchIgnoreRules(t *testing.T) {
+	root := filepath.Join("photos")
+	wr := newWatchIgnoreRules([]string{root}, []string{"*.tmp", "cache/"})
+	tests := []struct {
+		path    string
+		ignored bool
+	}{
+		{filepath.Join(root, "a.jpg"), false},
+		{filepath.Join(root, "a.tmp"), true},
+		{filepath.Join(root, "cache", "a.jpg"), true},
+		{filepath.Join(root, "2020", "cache", "a.jpg"), true},
+		// cache/ only matches directories
+		{filepath.Join(root, "2020", "cache"), false},
+		// Not below a watched directory
+		{filepath.Join("music", "a.tmp"), false},
+	}
+	for _, tt := range tests {
+		if got := wr.ignored(tt.path); got != tt.ignored {
+			t.Error(tt.path, "expected ignored", tt.ignored, "got", got)
+		}
+	}
+	if newWatchIgnoreRules([]string{root}, nil).ignored(filepath.Join(root, "a.tmp")) {
+		t.Error("Nothing should be ignored without patterns")
+	}
+}
+
+func TestWatchDirectories(t *testing.T) {
+	root, err := os.MkdirTemp("", "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	sub := filepath.Join(root, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- WatchDirectories(ctx, []string{root}, 100*time.Millisecond, func(string) {})
+	}()
+	defer func() {
+		cancel()
+		if err := <-watchErr; err != nil {
+			t.Error(err)
+		}
+	}()
+	// Give the watches a moment to be in place
+	time.Sleep(100 * time.Millisecond)
+
+	waitForEntry := func(dir, fn string) FileStruct {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			dm, err := DirectoryMapFromDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fs, ok := dm.Get(fn); ok && fs.Checksum != "" {
+				return fs
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatal("No checksum for", filepath.Join(dir, fn), "within 2s")
+		return FileStruct{}
+	}
+
+	if err := os.WriteFile(filepath.Join(sub, "new"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fs := waitForEntry(sub, "new")
+	cks, err := CalcMd5File(sub, "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs.Checksum != cks || fs.Size != 4 {
+		t.Error("Unexpected entry", fs)
+	}
+
+	// A file moved in from elsewhere
+	outside, err := os.MkdirTemp("", "watchOutside")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+	if err := os.WriteFile(filepath.Join(outside, "moved"), []byte("moved in"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(outside, "moved"), filepath.Join(root, "moved")); err != nil {
+		t.Fatal(err)
+	}
+	waitForEntry(root, "moved")
+
+	// A directory made after we started is watched too
+	later := filepath.Join(root, "later")
+	if err := os.Mkdir(later, 0755); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := os.WriteFile(filepath.Join(later, "file"), []byte("later"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitForEntry(later, "file")
+}
+
+func TestStartPolling(t *testing.T) {
+	root := t.TempDir()
+	events := make(chan string, 16)
+	errs := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	stop, err := startPolling([]string{root}, events, errs, done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	waitForEvent := func(want string) {
+		t.Helper()
+		timeout := time.After(2 * time.Second)
+		for {
+			select {
+			case path := <-events:
+				if path == want {
+					return
+				}
+			case err := <-errs:
+				t.Fatal(err)
+			case <-timeout:
+				t.Fatal("No event for", want, "within 2s")
+			}
+		}
+	}
+	newFile := filepath.Join(root, "new")
+	if err := os.WriteFile(newFile, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent(newFile)
+
+	// A directory moved in, with a file already in it
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "file"), []byte("moved in"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	movedDir := filepath.Join(root, "moved")
+	if err := os.Rename(outside, movedDir); err != nil {
+		t.Skip("Unable to move a directory in:", err)
+	}
+	waitForEvent(filepath.Join(movedDir, "file"))
+}
+
+func TestDirScannerRecent(t *testing.T) {
+	root := t.TempDir()
+	ds := newDirScanner()
+	ds.trackRecent = true
+	if _, err := ds.scan(root, nil); err != nil {
+		t.Fatal(err)
+	}
+	var sent []string
+	send := func(path string) {
+		sent = append(sent, path)
+	}
+	fn := filepath.Join(root, "file")
+	if err := os.WriteFile(fn, []byte("start"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	subDir := filepath.Join(root, "sub")
+	if err := os.Mkdir(subDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	newDirs, err := ds.scan(root, send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 1 || sent[0] != fn {
+		t.Error("Expected the new file to be sent, got", sent)
+	}
+	if len(newDirs) != 1 || newDirs[0] != subDir {
+		t.Error("Expected the new directory, got", newDirs)
+	}
+
+	// Written to in place, which does not change the directory
+	sent = nil
+	if err := os.WriteFile(fn, []byte("start and more"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ds.checkRecent(send)
+	if len(sent) != 1 || sent[0] != fn {
+		t.Error("Expected the rewritten file to be sent again, got", sent)
+	}
+	sent = nil
+	ds.checkRecent(send)
+	if _, err := ds.scan(root, send); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 0 {
+		t.Error("Nothing has changed, got", sent)
+	}
+}
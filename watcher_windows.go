@@ -0,0 +1,201 @@
+//go:build windows
+
+package medorg
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// readDirectoryChangesMask are the changes that mean a file may need checksumming
+const readDirectoryChangesMask = windows.FILE_NOTIFY_CHANGE_FILE_NAME |
+	windows.FILE_NOTIFY_CHANGE_DIR_NAME |
+	windows.FILE_NOTIFY_CHANGE_SIZE |
+	windows.FILE_NOTIFY_CHANGE_LAST_WRITE
+
+// windowsWatcher watches a directory tree with ReadDirectoryChangesW
+// Unlike inotify one watch covers the whole tree.
+type windowsWatcher struct {
+	root   string
+	handle windows.Handle
+	ov     windows.Overlapped
+	events chan<- string
+	done   <-chan struct{}
+}
+
+// startWatching reports files created or moved into dirs on events
+// until done is closed, or the returned stop is called
+func startWatching(dirs []string, events chan<- string, errs chan<- error, done <-chan struct{}) (stop func(), err error) {
+	var watchers []*windowsWatcher
+	closeAll := func() {
+		for _, ww := range watchers {
+			ww.close()
+		}
+	}
+	for _, dir := range dirs {
+		ww, err := newWindowsWatcher(dir, events, done)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		watchers = append(watchers, ww)
+	}
+	stopChan := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, ww := range watchers {
+		wg.Add(1)
+		go func(ww *windowsWatcher) {
+			defer wg.Done()
+			if err := ww.run(stopChan, errs); err != nil {
+				select {
+				case errs <- err:
+				case <-done:
+				case <-stopChan:
+				}
+			}
+		}(ww)
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stopChan)
+			wg.Wait()
+			closeAll()
+		})
+	}, nil
+}
+
+func newWindowsWatcher(dir string, events chan<- string, done <-chan struct{}) (*windowsWatcher, error) {
+	name, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := windows.CreateFile(name, windows.FILE_LIST_DIRECTORY,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
+		nil, windows.OPEN_EXISTING,
+		windows.FILE_FLAG_BACKUP_SEMANTICS|windows.FILE_FLAG_OVERLAPPED, 0)
+	if err != nil {
+		return nil, &fs.PathError{Op: "CreateFile", Path: dir, Err: err}
+	}
+	event, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		windows.CloseHandle(handle)
+		return nil, err
+	}
+	ww := &windowsWatcher{
+		root:   dir,
+		handle: handle,
+		events: events,
+		done:   done,
+	}
+	ww.ov.HEvent = event
+	return ww, nil
+}
+
+func (ww *windowsWatcher) close() {
+	windows.CloseHandle(ww.handle)
+	windows.CloseHandle(ww.ov.HEvent)
+}
+
+func (ww *windowsWatcher) send(path string) {
+	select {
+	case ww.events <- path:
+	case <-ww.done:
+	}
+}
+
+func (ww *windowsWatcher) run(stop <-chan struct{}, errs chan<- error) error {
+	// 64k is the most that can be used on a network share
+	buf := make([]byte, 64*1024)
+	for {
+		err := windows.ReadDirectoryChanges(ww.handle, &buf[0], uint32(len(buf)), true,
+			readDirectoryChangesMask, nil, &ww.ov, 0)
+		if err != nil {
+			return &fs.PathError{Op: "ReadDirectoryChanges", Path: ww.root, Err: err}
+		}
+		var n uint32
+		for {
+			// Wait with a timeout, so we notice being stopped
+			ev, err := windows.WaitForSingleObject(ww.ov.HEvent, 100)
+			if err != nil {
+				return err
+			}
+			if ev == windows.WAIT_OBJECT_0 {
+				break
+			}
+			select {
+			case <-stop:
+			case <-ww.done:
+			default:
+				continue
+			}
+			_ = windows.CancelIoEx(ww.handle, &ww.ov)
+			_ = windows.GetOverlappedResult(ww.handle, &ww.ov, &n, true)
+			return nil
+		}
+		if err := windows.GetOverlappedResult(ww.handle, &ww.ov, &n, false); err != nil {
+			return &fs.PathError{Op: "ReadDirectoryChanges", Path: ww.root, Err: err}
+		}
+		if err := windows.ResetEvent(ww.ov.HEvent); err != nil {
+			return err
+		}
+		if n == 0 {
+			// Not fatal, we just can't be sure we've seen everything
+			select {
+			case errs <- ErrWatchOverflow:
+			default:
+			}
+			continue
+		}
+		ww.handleEvents(buf[:n])
+	}
+}
+
+func (ww *windowsWatcher) handleEvents(buf []byte) {
+	for offset := 0; offset+int(unsafe.Sizeof(windows.FileNotifyInformation{})) <= len(buf); {
+		fni := (*windows.FileNotifyInformation)(unsafe.Pointer(&buf[offset]))
+		nameLen := int(fni.FileNameLength / 2)
+		name := windows.UTF16ToString(unsafe.Slice(&fni.FileName, nameLen))
+		switch fni.Action {
+		case windows.FILE_ACTION_ADDED, windows.FILE_ACTION_MODIFIED, windows.FILE_ACTION_RENAMED_NEW_NAME:
+			ww.handlePath(filepath.Join(ww.root, name))
+		}
+		if fni.NextEntryOffset == 0 {
+			break
+		}
+		offset += int(fni.NextEntryOffset)
+	}
+}
+
+// handlePath sends path, or if it is a directory the files in it,
+// as there are no events for those moved in with it
+func (ww *windowsWatcher) handlePath(path string) {
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		ww.send(path)
+		return
+	}
+	_ = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			if p != path && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		ww.send(p)
+		return nil
+	})
+}
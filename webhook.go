@@ -0,0 +1,97 @@
+package medorg
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ErrWebhookFailed the webhook server did not accept our post
+var ErrWebhookFailed = errors.New("webhook failed")
+
+// webhookTimeout is how long we give the webhook server to respond
+const webhookTimeout = 10 * time.Second
+
+// BackupReport is what a backup to one destination did
+// It is also what we send to the webhook once a backup finishes
+type BackupReport struct {
+	Status      string `json:"status"`
+	DestLabel   string `json:"dest_label"`
+	FilesCopied int64  `json:"files_copied"`
+	BytesCopied int64  `json:"bytes_copied"`
+	// FilesSkipped were already backed up to the destination
+	FilesSkipped int64 `json:"files_skipped"`
+	// OrphansFound are on the destination, but no longer in the source
+	OrphansFound int64 `json:"orphans_found"`
+	// OrphansDeleted are the orphans the orphan callback removed
+	OrphansDeleted int64 `json:"orphans_deleted"`
+	// SpaceSkipped were not copied as the destination did not have room for them
+	SpaceSkipped int64 `json:"space_skipped"`
+	// Errors is how many copies failed
+	Errors          int64   `json:"errors"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	ErrorMessage    string  `json:"error_message"`
+}
+
+// NewBackupReport fills in a report from the result of a backup run
+func NewBackupReport(destLabel string, filesCopied, bytesCopied int64, duration time.Duration, err error) BackupReport {
+	br := BackupReport{
+		Status:          "success",
+		DestLabel:       destLabel,
+		FilesCopied:     filesCopied,
+		BytesCopied:     bytesCopied,
+		DurationSeconds: duration.Seconds(),
+	}
+	if err != nil {
+		br.Status = "failure"
+		br.ErrorMessage = err.Error()
+	}
+	return br
+}
+
+// SendWebhook posts the report to the configured webhook,
+// if the config asks for reports with this outcome
+func (xc *XMLCfg) SendWebhook(br BackupReport) error {
+	return xc.SendWebhookWithOptions(br, BackupOptions{})
+}
+
+// SendWebhookWithOptions is SendWebhook, with the webhook in opts,
+// if any, used in place of the configured one
+func (xc *XMLCfg) SendWebhookWithOptions(br BackupReport, opts BackupOptions) error {
+	url, onSuccess, onFailure := xc.WebhookURL, xc.WebhookOnSuccess, xc.WebhookOnFailure
+	if opts.WebhookURL != "" {
+		url, onSuccess, onFailure = opts.WebhookURL, opts.WebhookOnSuccess, opts.WebhookOnFailure
+	}
+	if url == "" {
+		return nil
+	}
+	if br.Status == "success" && !onSuccess {
+		return nil
+	}
+	if br.Status != "success" && !onFailure {
+		return nil
+	}
+	body, err := json.Marshal(br)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: webhookTimeout}
+	if xc.WebhookTLSSkipVerify {
+		client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%w::%s returned %s", ErrWebhookFailed, url, resp.Status)
+	}
+	return nil
+}
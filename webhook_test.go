@@ -0,0 +1,40 @@
+package medorg
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSendWebhook(t *testing.T) {
+	var received []BackupReport
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var br BackupReport
+		if err := json.NewDecoder(r.Body).Decode(&br); err != nil {
+			t.Error(err)
+		}
+		received = append(received, br)
+	}))
+	defer ts.Close()
+
+	xc := XMLCfg{WebhookURL: ts.URL, WebhookOnFailure: true}
+	if err := xc.SendWebhook(NewBackupReport("vol", 3, 300, time.Second, nil)); err != nil {
+		t.Error(err)
+	}
+	if len(received) != 0 {
+		t.Error("Success should not be reported", received)
+	}
+	if err := xc.SendWebhook(NewBackupReport("vol", 1, 100, time.Second, errors.New("disk full"))); err != nil {
+		t.Error(err)
+	}
+	if len(received) != 1 {
+		t.Fatal("Failure should be reported", received)
+	}
+	expected := BackupReport{Status: "failure", DestLabel: "vol", FilesCopied: 1, BytesCopied: 100, DurationSeconds: 1, ErrorMessage: "disk full"}
+	if received[0] != expected {
+		t.Error("Expected", expected, "got", received[0])
+	}
+}
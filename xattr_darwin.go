@@ -0,0 +1,51 @@
+//go:build darwin
+
+package medorg
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyXattr copies all the extended attributes from src to dst
+// e.g. Finder tags, quarantine flags, Spotlight status
+func copyXattr(src, dst string) error {
+	size, err := unix.Listxattr(src, nil)
+	if err != nil {
+		return err
+	}
+	if size == 0 {
+		return nil
+	}
+	buf := make([]byte, size)
+	size, err = unix.Listxattr(src, buf)
+	if err != nil {
+		return err
+	}
+	// The list is a set of null terminated names
+	for _, name := range strings.Split(string(buf[:size]), "\x00") {
+		if name == "" {
+			continue
+		}
+		vSize, err := unix.Getxattr(src, name, nil)
+		if errors.Is(err, unix.ENOATTR) {
+			// Removed while we were looking
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		val := make([]byte, vSize)
+		vSize, err = unix.Getxattr(src, name, val)
+		if err != nil {
+			return err
+		}
+		err = unix.Setxattr(dst, name, val[:vSize], 0)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build !darwin
+
+package medorg
+
+// copyXattr is only implemented on darwin
+func copyXattr(src, dst string) error {
+	return nil
+}
@@ -2,13 +2,55 @@ package medorg
 
 import (
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"time"
 )
 
+// ErrLockTimeout we could not get the lock on the config file
+var ErrLockTimeout = errors.New("timed out waiting for config lock")
+
+// lockRetries and lockBackoff control how long we wait for the lock
+// with the backoff doubling each time, this is a little over 10s
+const lockRetries = 10
+const lockBackoff = 10 * time.Millisecond
+
+// lockConfig takes an advisory lock on the config file
+// so that multiple processes do not write to it at once.
+// Readers share the lock, writers have it to themselves.
+// The lock belongs to the open file, so it goes if the process dies
+// and a crash cannot leave it locked.
+// Call the returned function to release the lock.
+func lockConfig(fn string, exclusive bool) (func(), error) {
+	lockFn := fn + ".lock"
+	f, err := os.OpenFile(lockFn, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	backoff := lockBackoff
+	for i := 0; i < lockRetries; i++ {
+		locked, err := tryLockFile(f, exclusive)
+		if err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		if locked {
+			return func() {
+				_ = unlockFile(f)
+				_ = f.Close()
+			}, nil
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	_ = f.Close()
+	return nil, fmt.Errorf("%w::%s", ErrLockTimeout, lockFn)
+}
+
 // XMLCfg structure used to specify the detailed config
 type XMLCfg struct {
 	XMLName struct{} `xml:"xc"`
@@ -17,6 +59,35 @@ type XMLCfg struct {
 	Af []string `xml:"af"`
 	// Volume Labels we have encountered
 	VolumeLabels []string `xml:"vl"`
+	// Weights used to decide which files to back up first
+	// in the form "dest=1.0,size=0.5,freq=2.0"
+	PriorityWeights string `xml:"pw,omitempty"`
+	// How often each destination, by volume label, should be backed up to
+	Schedules []DestSchedule `xml:"schedule,omitempty"`
+	// How we check backups have not rotted
+	Verification *VerificationSchedule `xml:"verify,omitempty"`
+	// Where to post a report when a backup finishes
+	WebhookURL           string `xml:"webhook,omitempty"`
+	WebhookOnSuccess     bool   `xml:"webhook_success,omitempty"`
+	WebhookOnFailure     bool   `xml:"webhook_failure,omitempty"`
+	WebhookTLSSkipVerify bool   `xml:"webhook_insecure,omitempty"`
+	// Warn when a destination has less than this percentage free
+	// zero means use DefaultLowSpaceThresholdPct
+	LowSpaceThresholdPct float64 `xml:"low_space,omitempty"`
+	// SpaceHeadroomPct is the margin, as a percentage of the files to copy,
+	// the destination needs free on top. zero means use DefaultSpaceHeadroomPct
+	SpaceHeadroomPct float64 `xml:"space_headroom,omitempty"`
+	// MaxBytesPerSecond limits how fast the backup copies, zero for no limit
+	MaxBytesPerSecond int64 `xml:"max_rate,omitempty"`
+	// FsyncWrites syncs each metadata file to disk before replacing the old one
+	FsyncWrites bool `xml:"fsync_writes,omitempty"`
+	// CompactOnWrite drops the entries for deleted files whenever metadata is written
+	CompactOnWrite bool `xml:"compact_on_write,omitempty"`
+	// IgnorePatterns are ignored in every directory, as if in a .medorgignore
+	IgnorePatterns []string `xml:"ignore,omitempty"`
+	// MinXMLVersion refuses metadata files older than this version,
+	// rather than migrating them. Zero loads them all.
+	MinXMLVersion int `xml:"min_xml_version,omitempty"`
 
 	fn string
 }
@@ -29,6 +100,11 @@ func NewXMLCfg(fn string) *XMLCfg {
 	_, err := os.Stat(fn)
 
 	if !os.IsNotExist(err) {
+		unlock, err := lockConfig(fn, false)
+		if err != nil {
+			log.Fatalf("error locking NewXMLCfg file: %T,%v\n", err, err)
+		}
+		defer unlock()
 		f, err = os.Open(fn)
 
 		if err != nil {
@@ -46,13 +122,27 @@ func NewXMLCfg(fn string) *XMLCfg {
 	}
 	return itm
 }
+
+// WriteXmlCfg writes the config back to disk
+// holding the lock so other processes do not corrupt it
 func (xc *XMLCfg) WriteXmlCfg() error {
 	data, err := xml.MarshalIndent(xc, "", "  ")
 	if err != nil {
 		return err
 	}
-	err = os.WriteFile(xc.fn, data, 0600)
-	return err
+	unlock, err := lockConfig(xc.fn, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	// Write to a temporary file, then rename it into place
+	// so a reader never sees half a file
+	tmpFn := xc.fn + ".tmp"
+	err = os.WriteFile(tmpFn, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFn, xc.fn)
 }
 
 // FromXML populate from an ba
@@ -68,6 +158,38 @@ func (xc *XMLCfg) FromXML(input []byte) (err error) {
 	}
 	return
 }
+
+// MetadataOptions are how the config says metadata files are read and written
+func (xc *XMLCfg) MetadataOptions() MetadataOptions {
+	opts := DefaultMetadataOptions()
+	opts.FsyncWrites = xc.FsyncWrites
+	opts.CompactOnWrite = xc.CompactOnWrite
+	opts.MinVersion = xc.MinXMLVersion
+	return opts
+}
+
+// DefaultLowSpaceThresholdPct is the free space percentage we warn below
+const DefaultLowSpaceThresholdPct = 10.0
+
+// LowSpaceThreshold returns the percentage free space we warn below
+func (xc *XMLCfg) LowSpaceThreshold() float64 {
+	if xc.LowSpaceThresholdPct <= 0 {
+		return DefaultLowSpaceThresholdPct
+	}
+	return xc.LowSpaceThresholdPct
+}
+
+// DefaultSpaceHeadroomPct is the margin we want free on top of what we copy
+const DefaultSpaceHeadroomPct = 10.0
+
+// SpaceHeadroom returns the percentage margin a destination needs on top of the files to copy
+func (xc *XMLCfg) SpaceHeadroom() float64 {
+	if xc.SpaceHeadroomPct <= 0 {
+		return DefaultSpaceHeadroomPct
+	}
+	return xc.SpaceHeadroomPct
+}
+
 func (xc *XMLCfg) HasLabel(label string) bool {
 	for _, v := range xc.VolumeLabels {
 		if label == v {
@@ -0,0 +1,112 @@
+package medorg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestXMLCfgConcurrentWrite(t *testing.T) {
+	wkDir, err := ioutil.TempDir("", "cfgTest")
+	if err != nil {
+		t.Error("TmpDir Error:", err)
+	}
+	defer os.RemoveAll(wkDir)
+	fn := filepath.Join(wkDir, "cfg.xml")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			xc := NewXMLCfg(fn)
+			for j := 0; j < 10; j++ {
+				xc.VolumeLabels = append(xc.VolumeLabels, fmt.Sprint("label", i, "_", j))
+			}
+			err := xc.WriteXmlCfg()
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xc := XMLCfg{}
+	// FromXML is fatal on a corrupt file
+	err = xc.FromXML(data)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(xc.VolumeLabels) < 10 {
+		t.Error("Unexpected label count", len(xc.VolumeLabels))
+	}
+}
+
+func TestXMLCfgStaleLock(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "cfg.xml")
+	// A lock file left behind by a process that crashed
+	if err := ioutil.WriteFile(fn+".lock", []byte("1234"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	xc := NewXMLCfg(fn)
+	xc.VolumeLabels = []string{"label"}
+	if err := xc.WriteXmlCfg(); err != nil {
+		t.Error("Stale lock file blocked the write", err)
+	}
+}
+
+func TestXMLCfgSharedLock(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "cfg.xml")
+	unlockA, err := lockConfig(fn, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlockB, err := lockConfig(fn, false)
+	if err != nil {
+		t.Fatal("Readers should share the lock", err)
+	}
+	unlockB()
+
+	done := make(chan error)
+	go func() {
+		unlock, err := lockConfig(fn, true)
+		if err == nil {
+			unlock()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Error("Writer got the lock while a reader held it", err)
+	case <-time.After(lockBackoff):
+	}
+	unlockA()
+	if err := <-done; err != nil {
+		t.Error("Writer never got the lock", err)
+	}
+}
+
+func TestXMLCfgMetadataOptions(t *testing.T) {
+	xc := XMLCfg{FsyncWrites: true, CompactOnWrite: true, MinXMLVersion: 2}
+	opts := xc.MetadataOptions()
+	if !opts.FsyncWrites {
+		t.Error("FsyncWrites was not used")
+	}
+	if !opts.CompactOnWrite {
+		t.Error("CompactOnWrite was not used")
+	}
+	if opts.MinVersion != 2 {
+		t.Error("MinXMLVersion was not used, got", opts.MinVersion)
+	}
+	if opts.MaxEntries != DefaultMaxEntries {
+		t.Error("Expected the default entry limit, got", opts.MaxEntries)
+	}
+}